@@ -0,0 +1,140 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/engine"
+	"main/logging"
+	"main/webhook"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var logger = logging.For("api")
+
+// DefaultAddr is where the API listens unless configured otherwise
+const DefaultAddr = "unix:/run/ips.sock"
+
+// Server exposes the engine and the webhooks over HTTP
+type Server struct {
+	engine   *engine.Engine
+	webhooks *webhook.Manager
+	token    string
+	mux      *http.ServeMux
+}
+
+func New(e *engine.Engine, webhooks *webhook.Manager, token string) *Server {
+	s := &Server{engine: e, webhooks: webhooks, token: token, mux: http.NewServeMux()}
+	s.routes()
+	return s
+}
+
+// Serve listens on addr, either "unix:/path/to.sock" or "host:port", until the listener fails
+func (s *Server) Serve(addr string) error {
+	listener, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	logger.Info("management API listening", "addr", addr)
+	return server.Serve(listener)
+}
+
+// Listen opens a unix socket (replacing a stale one) or a TCP listener
+func Listen(addr string) (net.Listener, error) {
+	path, isUnix := strings.CutPrefix(addr, "unix:")
+	if !isUnix {
+		return net.Listen("tcp", addr)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// LoadToken reads the API token from path, generating a random one if the file doesn't exist
+func LoadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("API token file %s is empty", path)
+		}
+		return token, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read API token: %w", err)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create token directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write API token: %w", err)
+	}
+	logger.Info("generated API token", "path", path)
+	return token, nil
+}
+
+// ServeHTTP authenticates the request with "Authorization: Bearer <token>", or
+// a token query parameter for clients like EventSource that can't set headers
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid or missing token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
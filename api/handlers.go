@@ -0,0 +1,497 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/engine"
+	"main/iptables"
+	"main/logging"
+	"main/metrics"
+	"main/native"
+	"main/policy"
+	"main/store"
+	"main/suppress"
+	"main/webhook"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventBuffer is how many events a slow stream client may fall behind before events are dropped
+const eventBuffer = 256
+
+const heartbeatInterval = 15 * time.Second
+
+type enabledRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type blockRequest struct {
+	IP     string `json:"ip"`
+	TTL    int    `json:"ttl,omitempty"` // seconds, 0 blocks permanently
+	Reason string `json:"reason,omitempty"`
+}
+
+type levelRequest struct {
+	Level string `json:"level"`
+}
+
+type allowlistRequest struct {
+	CIDR string `json:"cidr"`
+}
+
+type dryRunRequest struct {
+	Config policy.Config `json:"config"`
+	Filter store.Filter  `json:"filter"`
+}
+
+func (s *Server) routes() {
+	s.mux.HandleFunc("GET /api/v1/status", s.getStatus)
+
+	s.mux.HandleFunc("GET /api/v1/detectors", s.getDetectors)
+	s.mux.HandleFunc("PUT /api/v1/detectors/{name}", s.setDetector)
+	s.mux.HandleFunc("PUT /api/v1/capture/{proto}", s.setCapture)
+	s.mux.HandleFunc("GET /api/v1/log-levels", s.getLogLevels)
+	s.mux.HandleFunc("PUT /api/v1/log-levels/{component}", s.setLogLevel)
+
+	s.mux.HandleFunc("GET /api/v1/alerts", s.getAlerts)
+	s.mux.HandleFunc("GET /api/v1/incidents", s.getIncidents)
+
+	s.mux.HandleFunc("GET /api/v1/blocks", s.getBlocks)
+	s.mux.HandleFunc("POST /api/v1/blocks", s.addBlock)
+	s.mux.HandleFunc("DELETE /api/v1/blocks/{ip...}", s.removeBlock)
+
+	s.mux.HandleFunc("GET /api/v1/allowlist", s.getAllowlist)
+	s.mux.HandleFunc("PUT /api/v1/allowlist", s.setAllowlist)
+	s.mux.HandleFunc("POST /api/v1/allowlist", s.addAllowlist)
+	s.mux.HandleFunc("DELETE /api/v1/allowlist/{cidr...}", s.removeAllowlist)
+
+	s.mux.HandleFunc("GET /api/v1/config/suppress", s.getSuppression)
+	s.mux.HandleFunc("PUT /api/v1/config/suppress", s.setSuppression)
+	s.mux.HandleFunc("GET /api/v1/config/policy", s.getPolicy)
+	s.mux.HandleFunc("PUT /api/v1/config/policy", s.setPolicy)
+	s.mux.HandleFunc("POST /api/v1/config/policy/dry-run", s.dryRunPolicy)
+	s.mux.HandleFunc("GET /api/v1/config/firewall", s.getFirewall)
+	s.mux.HandleFunc("PUT /api/v1/config/firewall", s.setFirewall)
+	s.mux.HandleFunc("GET /api/v1/config/native", s.getNative)
+	s.mux.HandleFunc("PUT /api/v1/config/native", s.setNative)
+
+	s.mux.HandleFunc("GET /api/v1/webhooks", s.getWebhooks)
+	s.mux.HandleFunc("POST /api/v1/webhooks/{name}/test", s.testWebhook)
+	s.mux.HandleFunc("GET /api/v1/webhooks/dead-letters", s.getDeadLetters)
+	s.mux.HandleFunc("POST /api/v1/webhooks/dead-letters/retry", s.retryDeadLetters)
+
+	s.mux.HandleFunc("GET /api/v1/events", s.streamEvents)
+	s.mux.Handle("GET /api/v1/metrics", metrics.Handler())
+}
+
+func (s *Server) getStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.Status())
+}
+
+func (s *Server) getDetectors(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.Status().Detectors)
+}
+
+func (s *Server) setDetector(w http.ResponseWriter, r *http.Request) {
+	var req enabledRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.engine.SetDetectorEnabled(r.PathValue("name"), req.Enabled); err != nil {
+		status := http.StatusConflict
+		if errors.Is(err, engine.ErrUnknownDetector) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.engine.Status())
+}
+
+func (s *Server) setCapture(w http.ResponseWriter, r *http.Request) {
+	var req enabledRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.engine.SetCSVCapture(r.PathValue("proto"), req.Enabled); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.engine.Status())
+}
+
+func (s *Server) getLogLevels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logging.Levels())
+}
+
+func (s *Server) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req levelRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := logging.SetLevel(r.PathValue("component"), req.Level); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, logging.Levels())
+}
+
+func (s *Server) getAlerts(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(s.engine.Alerts(filter)))
+}
+
+func (s *Server) getIncidents(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, nonNil(s.engine.Incidents()))
+}
+
+func (s *Server) getBlocks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, nonNil(s.engine.Blocked()))
+}
+
+func (s *Server) addBlock(w http.ResponseWriter, r *http.Request) {
+	var req blockRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.engine.Block(req.IP, time.Duration(req.TTL)*time.Second, req.Reason); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeBlock lifts everything against an address, or a single response when
+// kind (and optionally protocol, port and direction) are given as query parameters
+func (s *Server) removeBlock(w http.ResponseWriter, r *http.Request) {
+	ip := r.PathValue("ip")
+	query := r.URL.Query()
+
+	var err error
+	if kind := query.Get("kind"); kind != "" {
+		err = s.engine.UnblockResponse(iptables.Response{
+			IP:        ip,
+			Kind:      kind,
+			Protocol:  query.Get("protocol"),
+			Port:      query.Get("port"),
+			Direction: query.Get("direction"),
+		})
+	} else {
+		err = s.engine.Unblock(ip)
+	}
+	if err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, iptables.ErrCovered) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getAllowlist(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.FirewallConfig().Allowlist)
+}
+
+func (s *Server) setAllowlist(w http.ResponseWriter, r *http.Request) {
+	var allowlist []string
+	if err := readJSON(w, r, &allowlist); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	config := s.engine.FirewallConfig()
+	config.Allowlist = allowlist
+	s.saveFirewall(w, config)
+}
+
+func (s *Server) addAllowlist(w http.ResponseWriter, r *http.Request) {
+	var req allowlistRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cidr := req.CIDR
+	if prefix, ok := allowlistPrefix(cidr); ok {
+		cidr = prefix.String()
+	}
+
+	config := s.engine.FirewallConfig()
+	if !slices.ContainsFunc(config.Allowlist, func(entry string) bool { return sameAllowlistEntry(entry, cidr) }) {
+		config.Allowlist = append(config.Allowlist, cidr)
+	}
+	s.saveFirewall(w, config)
+}
+
+func (s *Server) removeAllowlist(w http.ResponseWriter, r *http.Request) {
+	cidr := r.PathValue("cidr")
+
+	config := s.engine.FirewallConfig()
+	index := slices.IndexFunc(config.Allowlist, func(entry string) bool {
+		return sameAllowlistEntry(entry, cidr)
+	})
+	if index == -1 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not allowlisted", cidr))
+		return
+	}
+	config.Allowlist = slices.Delete(config.Allowlist, index, index+1)
+	s.saveFirewall(w, config)
+}
+
+// allowlistPrefix parses an allowlist entry, a bare address being the prefix
+// of just that address, e.g. "2001:db8::1" is "2001:db8::1/128"
+func allowlistPrefix(entry string) (netip.Prefix, bool) {
+	if addr, err := netip.ParseAddr(entry); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), true
+	}
+	prefix, err := netip.ParsePrefix(entry)
+	return prefix.Masked(), err == nil
+}
+
+// sameAllowlistEntry compares two allowlist entries as prefixes, falling back
+// to the text for entries that don't parse
+func sameAllowlistEntry(a, b string) bool {
+	pa, okA := allowlistPrefix(a)
+	pb, okB := allowlistPrefix(b)
+	if okA && okB {
+		return pa == pb
+	}
+	return a == b
+}
+
+func (s *Server) saveFirewall(w http.ResponseWriter, config iptables.Config) {
+	if err := s.engine.SetFirewallConfig(config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.engine.FirewallConfig())
+}
+
+func (s *Server) getSuppression(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.Suppression())
+}
+
+func (s *Server) setSuppression(w http.ResponseWriter, r *http.Request) {
+	var config suppress.Config
+	if err := readJSON(w, r, &config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.engine.SetSuppression(config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.engine.Suppression())
+}
+
+func (s *Server) getPolicy(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.Policy())
+}
+
+func (s *Server) setPolicy(w http.ResponseWriter, r *http.Request) {
+	var config policy.Config
+	if err := readJSON(w, r, &config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.engine.SetPolicy(config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.engine.Policy())
+}
+
+func (s *Server) dryRunPolicy(w http.ResponseWriter, r *http.Request) {
+	var req dryRunRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	results, err := s.engine.DryRunPolicy(req.Config, req.Filter)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(results))
+}
+
+func (s *Server) getFirewall(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.FirewallConfig())
+}
+
+func (s *Server) setFirewall(w http.ResponseWriter, r *http.Request) {
+	var config iptables.Config
+	if err := readJSON(w, r, &config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.saveFirewall(w, config)
+}
+
+func (s *Server) getNative(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.NativeConfig())
+}
+
+func (s *Server) setNative(w http.ResponseWriter, r *http.Request) {
+	var config native.Config
+	if err := readJSON(w, r, &config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.engine.SetNativeConfig(config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.engine.NativeConfig())
+}
+
+func (s *Server) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.webhooks.Endpoints())
+}
+
+func (s *Server) testWebhook(w http.ResponseWriter, r *http.Request) {
+	result, err := s.webhooks.Test(r.PathValue("name"))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, webhook.ErrUnknownEndpoint) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) getDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := s.webhooks.DeadLetters()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, letters)
+}
+
+func (s *Server) retryDeadLetters(w http.ResponseWriter, r *http.Request) {
+	queued, err := s.webhooks.RetryDeadLetters()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"queued": queued})
+}
+
+// streamEvents sends engine events as Server-Sent Events, optionally limited
+// to a comma separated list of event types
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	var types []string
+	if t := r.URL.Query().Get("types"); t != "" {
+		types = strings.Split(t, ",")
+	}
+
+	events, unsubscribe := s.engine.Subscribe("api", eventBuffer)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if types != nil && !slices.Contains(types, event.Type) {
+				continue
+			}
+			// Detections arrive as incidents, and they and flows are too
+			// frequent to stream unless asked for
+			if types == nil && (event.Type == engine.EventDetection || event.Type == engine.EventFlow) {
+				continue
+			}
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// parseFilter reads a store.Filter from the query string, times are RFC 3339
+func parseFilter(r *http.Request) (store.Filter, error) {
+	query := r.URL.Query()
+	filter := store.Filter{
+		AttackerIP:  query.Get("attacker_ip"),
+		TargetIP:    query.Get("target_ip"),
+		Detector:    query.Get("detector"),
+		SignatureID: query.Get("signature_id"),
+	}
+
+	var err error
+	if v := query.Get("min_severity"); v != "" {
+		if filter.MinSeverity, err = strconv.Atoi(v); err != nil {
+			return filter, fmt.Errorf("invalid min_severity: %w", err)
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return filter, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+	if v := query.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+// nonNil makes empty lists encode as [] instead of null
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
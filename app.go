@@ -3,19 +3,26 @@ package main
 import (
 	"context"
 	"fmt"
+	"main/engine"
 	"main/iptables"
-	"main/service"
-	"slices"
+	"main/model"
+	"main/native"
+	"main/policy"
+	"main/store"
+	"main/suppress"
+	"sync/atomic"
+	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 
-var blockedIPs []string
-
 // Global App instance
 var appInstance *App
 
+// ipsEngine is set by StartSystem once the engine is loaded
+var ipsEngine atomic.Pointer[engine.Engine]
+
 // App struct
 type App struct {
 	ctx context.Context
@@ -30,97 +37,188 @@ func NewApp() *App {
 // startup is called when the app starts
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
+}
 
-	runtime.EventsOn(ctx, "csv", func(args ...interface{}) {
-		if len(args) > 0 {
-			if str, ok := args[0].(string); ok {
-				if str == "icmp" {
-					service.CsvToggleICMP()
-				} else if str == "tcp" {
-					// tcp csv toggle
-					service.CsvToggleTCP()
-				} else if str == "udp" {
-					service.CsvToggleUDP()
-				}
-			}
-		}
-	})
-
-	runtime.EventsOn(ctx, "unblock", func(args ...interface{}) {
-		if len(args) > 0 {
-			if ip, ok := args[0].(string); ok {
-				if idx := slices.Index(blockedIPs, ip); idx != -1 { 
-					fmt.Println(blockedIPs)
-					blockedIPs = append(blockedIPs[:idx], blockedIPs[idx+1:]...)
-					fmt.Println(blockedIPs)
-					iptables.UnblockIP(ip)
-				}
-			}
-		}
-	})
-
-
-	runtime.EventsOn(ctx, "avoidBlocking", func(args ...interface{}){
-		fmt.Println("avoid blocking: ", args)
-		if len(args) > 0 { 
-			if avoid, ok := args[0].(string); ok{ 
-				if avoid == "true"{ 
-					iptables.AvoidBlocking = true
-				}else if avoid == "false"{ 
-					iptables.AvoidBlocking = false
-				}
-			}
-		}
-	})
-
-	runtime.EventsOn(ctx, "detector", func(args ...interface{}){
-		if len(args) > 0 { 
-			if detector, ok := args[0].(string); ok{ 
-				if detector == "unswb"{
-					if ToggleUNSW { 
-						ToggleUNSW = false
-					}else {
-						ToggleUNSW = true
-					}
-				}else if detector == "own"{
-					if ToggleOwn{
-						ToggleOwn = false
-					}else{
-						ToggleOwn = true
-						StartOwn = true
-					}
-					fmt.Println("toggle own : ", ToggleOwn)
-				}else if detector == "snort"{ 
-					if ToggleSnort { 
-						ToggleSnort = false
-					}else{ 
-						ToggleSnort = true
-					}
-
-					fmt.Println("toggle snort")
-				}
-			}
-		}
-	})
+// shutdown is called when the app is closing
+func (a *App) shutdown(ctx context.Context) {
+	if e := ipsEngine.Load(); e != nil {
+		e.Stop()
+	}
+}
 
+func getEngine() (*engine.Engine, error) {
+	e := ipsEngine.Load()
+	if e == nil {
+		return nil, fmt.Errorf("engine not started")
+	}
+	return e, nil
 }
 
-// Greet returns a greeting for the given name
-func (a *App) Greet(name string) string {
-	return fmt.Sprintf("Hello %s, It's show time!", name)
+// GetStatus returns the current engine state
+func (a *App) GetStatus() (engine.Status, error) {
+	e, err := getEngine()
+	if err != nil {
+		return engine.Status{}, err
+	}
+	return e.Status(), nil
 }
 
-// EmitAlertFromAnywhere allows emission from other packages/files
-func EmitAlert(data any) {
-	if appInstance != nil && appInstance.ctx != nil {
-		runtime.EventsEmit(appInstance.ctx, "alert", data)
+// SetDetectorEnabled starts or stops "own", "snort" or "unswb" and returns the new state
+func (a *App) SetDetectorEnabled(name string, enabled bool) (engine.Status, error) {
+	e, err := getEngine()
+	if err != nil {
+		return engine.Status{}, err
 	}
+	err = e.SetDetectorEnabled(name, enabled)
+	return e.Status(), err
 }
 
-func EmitBlockIP(data any) { 
-	if appInstance != nil && appInstance.ctx != nil {
+// SetCSVCapture turns dataset capture for "tcp", "udp" or "icmp" on or off and returns the new state
+func (a *App) SetCSVCapture(proto string, enabled bool) (engine.Status, error) {
+	e, err := getEngine()
+	if err != nil {
+		return engine.Status{}, err
+	}
+	err = e.SetCSVCapture(proto, enabled)
+	return e.Status(), err
+}
 
-		blockedIPs = append(blockedIPs, data.(string))
-		runtime.EventsEmit(appInstance.ctx, "block", data)
+// ListBlocked returns the installed blocks and throttles ordered by address
+func (a *App) ListBlocked() ([]iptables.Response, error) {
+	e, err := getEngine()
+	if err != nil {
+		return nil, err
+	}
+	return e.Blocked(), nil
+}
+
+// BlockManual blocks an address or CIDR for ttl seconds, or permanently when ttl is 0
+func (a *App) BlockManual(ip string, ttl int, reason string) error {
+	e, err := getEngine()
+	if err != nil {
+		return err
+	}
+	return e.Block(ip, time.Duration(ttl)*time.Second, reason)
+}
+
+// Unblock lifts every block and throttle against ip
+func (a *App) Unblock(ip string) error {
+	e, err := getEngine()
+	if err != nil {
+		return err
+	}
+	return e.Unblock(ip)
+}
+
+// UnblockResponse lifts a single scoped block or throttle
+func (a *App) UnblockResponse(r iptables.Response) error {
+	e, err := getEngine()
+	if err != nil {
+		return err
+	}
+	return e.UnblockResponse(r)
+}
+
+// ListAlerts returns the stored alerts matching filter, oldest first
+func (a *App) ListAlerts(filter store.Filter) ([]model.Detection, error) {
+	e, err := getEngine()
+	if err != nil {
+		return nil, err
+	}
+	return e.Alerts(filter), nil
+}
+
+// GetSuppression returns the active suppression, threshold and override rules
+func (a *App) GetSuppression() (suppress.Config, error) {
+	e, err := getEngine()
+	if err != nil {
+		return suppress.Config{}, err
+	}
+	return e.Suppression(), nil
+}
+
+// SetSuppression replaces the suppression rules and persists them
+func (a *App) SetSuppression(config suppress.Config) error {
+	e, err := getEngine()
+	if err != nil {
+		return err
+	}
+	return e.SetSuppression(config)
+}
+
+// GetPolicy returns the active response policies
+func (a *App) GetPolicy() (policy.Config, error) {
+	e, err := getEngine()
+	if err != nil {
+		return policy.Config{}, err
+	}
+	return e.Policy(), nil
+}
+
+// SetPolicy replaces the response policies and persists them
+func (a *App) SetPolicy(config policy.Config) error {
+	e, err := getEngine()
+	if err != nil {
+		return err
+	}
+	return e.SetPolicy(config)
+}
+
+// DryRunPolicy shows what config would have done against the stored alerts matching filter
+func (a *App) DryRunPolicy(config policy.Config, filter store.Filter) ([]policy.Result, error) {
+	e, err := getEngine()
+	if err != nil {
+		return nil, err
+	}
+	return e.DryRunPolicy(config, filter)
+}
+
+// GetFirewallConfig returns the allowlist and block aggregation settings
+func (a *App) GetFirewallConfig() (iptables.Config, error) {
+	e, err := getEngine()
+	if err != nil {
+		return iptables.Config{}, err
+	}
+	return e.FirewallConfig(), nil
+}
+
+// SetFirewallConfig replaces the allowlist and block aggregation settings and persists them
+func (a *App) SetFirewallConfig(config iptables.Config) error {
+	e, err := getEngine()
+	if err != nil {
+		return err
+	}
+	return e.SetFirewallConfig(config)
+}
+
+// GetNativeConfig returns the thresholds of the native detectors
+func (a *App) GetNativeConfig() (native.Config, error) {
+	e, err := getEngine()
+	if err != nil {
+		return native.Config{}, err
+	}
+	return e.NativeConfig(), nil
+}
+
+// SetNativeConfig replaces the native detector thresholds and persists them
+func (a *App) SetNativeConfig(config native.Config) error {
+	e, err := getEngine()
+	if err != nil {
+		return err
+	}
+	return e.SetNativeConfig(config)
+}
+
+// forwardEvents emits engine events (status, detection, incident, block, unblocked) to the frontend
+func forwardEvents(events <-chan engine.Event) {
+	for event := range events {
+		// The GUI shows detections as the incidents they are merged into, and
+		// flow records are only of interest to the event log
+		if event.Type == engine.EventDetection || event.Type == engine.EventFlow {
+			continue
+		}
+		if appInstance != nil && appInstance.ctx != nil {
+			runtime.EventsEmit(appInstance.ctx, event.Type, event.Data)
+		}
 	}
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// client talks to the management API of a running IPS
+type client struct {
+	http  *http.Client
+	base  string
+	token string
+}
+
+// newClient connects to addr, either "unix:/path/to.sock" or "host:port"
+func newClient(addr, token string) *client {
+	c := &client{http: &http.Client{}, base: "http://" + addr, token: token}
+
+	if path, isUnix := strings.CutPrefix(addr, "unix:"); isUnix {
+		c.base = "http://ips"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}
+	}
+	return c
+}
+
+// do sends body as JSON and decodes the JSON response into out when out isn't nil
+func (c *client) do(method, path string, query url.Values, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	resp, err := c.send(method, path, query, reader)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// stream calls handle with the type and data of every Server-Sent Event until the stream ends
+func (c *client) stream(path string, query url.Values, handle func(event string, data []byte) error) error {
+	resp, err := c.send(http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := handle(event, []byte(strings.TrimPrefix(line, "data: "))); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func (c *client) send(method, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	target := c.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach the IPS: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return nil, fmt.Errorf("%s", apiErr.Error)
+		}
+		return nil, fmt.Errorf("request failed: %s", resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,638 @@
+// ipsctl queries and controls a running IPS through its management API
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"main/model"
+	"main/suppress"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: ipsctl [-addr unix:/run/ips.sock] [-token TOKEN | -token-file FILE] [-o table|json] <command>
+
+commands:
+  status
+  alerts tail [-n 20] [-f]
+  alerts search [-ip IP] [-target IP] [-detector NAME] [-sid SID] [-min-severity N] [-since 1h|RFC3339] [-limit N]
+  block ls
+  block add <ip|cidr> [-ttl 1h] [-reason TEXT]
+  block rm <ip|cidr> [-kind KIND -protocol P -port N -direction D]
+  allow ls | allow add <cidr> | allow rm <cidr>
+  detector ls | detector enable <name> | detector disable <name>
+  capture start <tcp|udp|icmp> | capture stop <tcp|udp|icmp>
+  rules ls | rules disable <sid> | rules enable <sid>
+  log ls | log level <component|default> <debug|info|warn|error>
+  webhook ls | webhook test <name> | webhook dlq | webhook retry
+`
+
+var errUsage = errors.New("invalid arguments")
+
+type cli struct {
+	client *client
+	json   bool
+}
+
+func main() {
+	flags := flag.NewFlagSet("ipsctl", flag.ExitOnError)
+	addr := flags.String("addr", envOr("IPS_API_ADDR", defaultAddr), "management API address, unix:/path or host:port")
+	token := flags.String("token", os.Getenv("IPS_API_TOKEN"), "API token")
+	tokenFile := flags.String("token-file", envOr("IPS_API_TOKEN_FILE", "config/api.token"), "file holding the API token")
+	output := flags.String("o", "table", "output format: table or json")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flags.Parse(os.Args[1:])
+
+	if *output != "table" && *output != "json" {
+		fmt.Fprintln(os.Stderr, "ipsctl: -o must be table or json")
+		os.Exit(2)
+	}
+	if *token == "" {
+		data, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipsctl: no API token:", err)
+			os.Exit(1)
+		}
+		*token = strings.TrimSpace(string(data))
+	}
+
+	c := &cli{client: newClient(*addr, *token), json: *output == "json"}
+	if err := c.run(flags.Args()); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "ipsctl:", err)
+		os.Exit(1)
+	}
+}
+
+func (c *cli) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	command, args := args[0], args[1:]
+	if command == "status" {
+		return c.status()
+	}
+
+	if len(args) == 0 {
+		return errUsage
+	}
+	sub, args := args[0], args[1:]
+	switch command + " " + sub {
+	case "alerts tail":
+		return c.alertsTail(args)
+	case "alerts search":
+		return c.alertsSearch(args)
+	case "block ls":
+		return c.blockList()
+	case "block add":
+		return c.blockAdd(args)
+	case "block rm":
+		return c.blockRemove(args)
+	case "allow ls":
+		return c.allowList()
+	case "allow add":
+		return c.allowAdd(args)
+	case "allow rm":
+		return c.allowRemove(args)
+	case "detector ls":
+		return c.detectorList()
+	case "detector enable", "detector disable":
+		return c.detectorSet(args, sub == "enable")
+	case "capture start", "capture stop":
+		return c.captureSet(args, sub == "start")
+	case "rules ls":
+		return c.rulesList()
+	case "rules disable":
+		return c.rulesDisable(args)
+	case "rules enable":
+		return c.rulesEnable(args)
+	case "log ls":
+		return c.logList()
+	case "log level":
+		return c.logLevel(args)
+	case "webhook ls":
+		return c.webhookList()
+	case "webhook test":
+		return c.webhookTest(args)
+	case "webhook dlq":
+		return c.webhookDeadLetters()
+	case "webhook retry":
+		return c.webhookRetry()
+	}
+	return errUsage
+}
+
+func (c *cli) status() error {
+	var status engineStatus
+	if err := c.client.do("GET", "/api/v1/status", nil, nil, &status); err != nil {
+		return err
+	}
+	return c.print(status, func(w *tabwriter.Writer) {
+		blocking := "active"
+		if status.AvoidBlocking {
+			blocking = "paused (capture on)"
+		}
+		fmt.Fprintf(w, "running\t%v\n", status.Running)
+		fmt.Fprintf(w, "detectors\t%s\n", onOff(status.Detectors))
+		fmt.Fprintf(w, "capture\t%s\n", onOff(status.CSVCapture))
+		fmt.Fprintf(w, "blocking\t%s\n", blocking)
+		fmt.Fprintf(w, "blocked\t%d\n", status.Blocked)
+		fmt.Fprintf(w, "open incidents\t%d\n", status.OpenIncidents)
+	})
+}
+
+func (c *cli) alertsTail(args []string) error {
+	flags := flag.NewFlagSet("alerts tail", flag.ContinueOnError)
+	n := flags.Int("n", 20, "number of stored alerts to show")
+	follow := flags.Bool("f", false, "keep streaming new alerts")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	var alerts []model.Detection
+	if err := c.client.do("GET", "/api/v1/alerts", url.Values{"limit": {strconv.Itoa(*n)}}, nil, &alerts); err != nil {
+		return err
+	}
+	if !c.json {
+		printAlertHeader()
+	}
+	for _, alert := range alerts {
+		c.printAlert(alert)
+	}
+	if !*follow {
+		return nil
+	}
+
+	return c.client.stream("/api/v1/events", url.Values{"types": {eventDetection}}, func(_ string, data []byte) error {
+		var alert model.Detection
+		if err := json.Unmarshal(data, &alert); err != nil {
+			return err
+		}
+		c.printAlert(alert)
+		return nil
+	})
+}
+
+func (c *cli) alertsSearch(args []string) error {
+	flags := flag.NewFlagSet("alerts search", flag.ContinueOnError)
+	ip := flags.String("ip", "", "attacker address")
+	target := flags.String("target", "", "target address")
+	detector := flags.String("detector", "", "detector: snort, own or unswb")
+	sid := flags.String("sid", "", "signature id")
+	minSeverity := flags.Int("min-severity", 0, "minimum severity, 1 (low) to 4 (critical)")
+	since := flags.String("since", "", "duration like 1h or an RFC 3339 time")
+	limit := flags.Int("limit", 100, "newest N matches, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	query := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	set("attacker_ip", *ip)
+	set("target_ip", *target)
+	set("detector", *detector)
+	set("signature_id", *sid)
+	if *minSeverity > 0 {
+		query.Set("min_severity", strconv.Itoa(*minSeverity))
+	}
+	if *limit > 0 {
+		query.Set("limit", strconv.Itoa(*limit))
+	}
+	if *since != "" {
+		t, err := parseSince(*since)
+		if err != nil {
+			return err
+		}
+		query.Set("since", t.Format(time.RFC3339))
+	}
+
+	var alerts []model.Detection
+	if err := c.client.do("GET", "/api/v1/alerts", query, nil, &alerts); err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(alerts)
+	}
+	printAlertHeader()
+	for _, alert := range alerts {
+		c.printAlert(alert)
+	}
+	return nil
+}
+
+func (c *cli) blockList() error {
+	var responses []firewallResponse
+	if err := c.client.do("GET", "/api/v1/blocks", nil, nil, &responses); err != nil {
+		return err
+	}
+	return c.print(responses, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "ADDRESS\tKIND\tSCOPE\tEXPIRES\tMEMBERS\tREASON")
+		for _, r := range responses {
+			expires := "never"
+			if !r.Expires.IsZero() {
+				expires = "in " + time.Until(r.Expires).Round(time.Second).String()
+			}
+			members := "-"
+			if len(r.Members) > 0 {
+				members = strconv.Itoa(len(r.Members))
+				if r.ASN != "" {
+					members += " (AS" + r.ASN + ")"
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.IP, r.Kind, r.scope(), expires, members, r.Reason)
+		}
+	})
+}
+
+func (c *cli) blockAdd(args []string) error {
+	ip, args, err := positional(args)
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("block add", flag.ContinueOnError)
+	ttl := flags.Duration("ttl", 0, "remove the block again after this long, e.g. 30m")
+	reason := flags.String("reason", "ipsctl", "why the address is blocked")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	body := map[string]any{"ip": ip, "ttl": int(ttl.Seconds()), "reason": *reason}
+	if err := c.client.do("POST", "/api/v1/blocks", nil, body, nil); err != nil {
+		return err
+	}
+	return c.done("blocked " + ip)
+}
+
+func (c *cli) blockRemove(args []string) error {
+	ip, args, err := positional(args)
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("block rm", flag.ContinueOnError)
+	kind := flags.String("kind", "", "only lift this kind of response, e.g. rate_limit")
+	protocol := flags.String("protocol", "", "protocol of the response")
+	port := flags.String("port", "", "port of the response")
+	direction := flags.String("direction", "", "direction of the response")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	query := url.Values{}
+	if *kind != "" {
+		query.Set("kind", *kind)
+		query.Set("protocol", *protocol)
+		query.Set("port", *port)
+		query.Set("direction", *direction)
+	}
+	if err := c.client.do("DELETE", "/api/v1/blocks/"+ip, query, nil, nil); err != nil {
+		return err
+	}
+	return c.done("unblocked " + ip)
+}
+
+func (c *cli) allowList() error {
+	var allowlist []string
+	if err := c.client.do("GET", "/api/v1/allowlist", nil, nil, &allowlist); err != nil {
+		return err
+	}
+	return c.print(allowlist, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "CIDR")
+		for _, cidr := range allowlist {
+			fmt.Fprintln(w, cidr)
+		}
+	})
+}
+
+func (c *cli) allowAdd(args []string) error {
+	cidr, _, err := positional(args)
+	if err != nil {
+		return err
+	}
+	if err := c.client.do("POST", "/api/v1/allowlist", nil, map[string]string{"cidr": cidr}, nil); err != nil {
+		return err
+	}
+	return c.done("allowlisted " + cidr)
+}
+
+func (c *cli) allowRemove(args []string) error {
+	cidr, _, err := positional(args)
+	if err != nil {
+		return err
+	}
+	if err := c.client.do("DELETE", "/api/v1/allowlist/"+cidr, nil, nil, nil); err != nil {
+		return err
+	}
+	return c.done("removed " + cidr + " from the allowlist")
+}
+
+func (c *cli) detectorList() error {
+	var detectors map[string]bool
+	if err := c.client.do("GET", "/api/v1/detectors", nil, nil, &detectors); err != nil {
+		return err
+	}
+	return c.print(detectors, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "DETECTOR\tENABLED")
+		for _, name := range sortedKeys(detectors) {
+			fmt.Fprintf(w, "%s\t%v\n", name, detectors[name])
+		}
+	})
+}
+
+func (c *cli) detectorSet(args []string, enabled bool) error {
+	name, _, err := positional(args)
+	if err != nil {
+		return err
+	}
+	var status engineStatus
+	if err := c.client.do("PUT", "/api/v1/detectors/"+name, nil, map[string]bool{"enabled": enabled}, &status); err != nil {
+		return err
+	}
+	return c.print(status, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "detectors\t%s\n", onOff(status.Detectors))
+	})
+}
+
+func (c *cli) captureSet(args []string, enabled bool) error {
+	proto, _, err := positional(args)
+	if err != nil {
+		return err
+	}
+	var status engineStatus
+	if err := c.client.do("PUT", "/api/v1/capture/"+proto, nil, map[string]bool{"enabled": enabled}, &status); err != nil {
+		return err
+	}
+	return c.print(status, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "capture\t%s\n", onOff(status.CSVCapture))
+		if status.AvoidBlocking {
+			fmt.Fprintln(w, "blocking\tpaused while capturing")
+		}
+	})
+}
+
+func (c *cli) rulesList() error {
+	var config suppress.Config
+	if err := c.client.do("GET", "/api/v1/config/suppress", nil, nil, &config); err != nil {
+		return err
+	}
+	return c.print(config.Suppress, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "ID\tSIGNATURE\tDETECTOR\tSOURCE\tTARGET\tMESSAGE")
+		for _, r := range config.Suppress {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, dash(r.SignatureID), dash(r.Detector), dash(r.SourceCIDR), dash(r.TargetCIDR), dash(r.Message))
+		}
+	})
+}
+
+// rulesDisable suppresses every detection of a signature
+func (c *cli) rulesDisable(args []string) error {
+	sid, _, err := positional(args)
+	if err != nil {
+		return err
+	}
+
+	var config suppress.Config
+	if err := c.client.do("GET", "/api/v1/config/suppress", nil, nil, &config); err != nil {
+		return err
+	}
+	if slices.ContainsFunc(config.Suppress, func(r suppress.Rule) bool { return isSignatureRule(r, sid) }) {
+		return c.done("signature " + sid + " is already disabled")
+	}
+	config.Suppress = append(config.Suppress, suppress.Rule{ID: "disabled-" + sid, SignatureID: sid})
+
+	if err := c.client.do("PUT", "/api/v1/config/suppress", nil, config, nil); err != nil {
+		return err
+	}
+	return c.done("disabled signature " + sid)
+}
+
+// rulesEnable removes the suppression rules that disable a whole signature
+func (c *cli) rulesEnable(args []string) error {
+	sid, _, err := positional(args)
+	if err != nil {
+		return err
+	}
+
+	var config suppress.Config
+	if err := c.client.do("GET", "/api/v1/config/suppress", nil, nil, &config); err != nil {
+		return err
+	}
+	count := len(config.Suppress)
+	config.Suppress = slices.DeleteFunc(config.Suppress, func(r suppress.Rule) bool { return isSignatureRule(r, sid) })
+	if len(config.Suppress) == count {
+		return fmt.Errorf("signature %s is not disabled", sid)
+	}
+
+	if err := c.client.do("PUT", "/api/v1/config/suppress", nil, config, nil); err != nil {
+		return err
+	}
+	return c.done("enabled signature " + sid)
+}
+
+// isSignatureRule reports whether a rule suppresses sid without any further condition
+func isSignatureRule(r suppress.Rule, sid string) bool {
+	return r.SignatureID == sid && r.Message == "" && r.SourceCIDR == "" && r.TargetCIDR == "" && r.Detector == ""
+}
+
+func (c *cli) logList() error {
+	var levels map[string]string
+	if err := c.client.do("GET", "/api/v1/log-levels", nil, nil, &levels); err != nil {
+		return err
+	}
+	return c.print(levels, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "COMPONENT\tLEVEL")
+		for _, component := range slices.Sorted(maps.Keys(levels)) {
+			fmt.Fprintf(w, "%s\t%s\n", component, levels[component])
+		}
+	})
+}
+
+func (c *cli) logLevel(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	component, level := args[0], args[1]
+	if err := c.client.do("PUT", "/api/v1/log-levels/"+component, nil, map[string]string{"level": level}, nil); err != nil {
+		return err
+	}
+	return c.done("set " + component + " log level to " + level)
+}
+
+func (c *cli) webhookList() error {
+	var endpoints []webhookEndpoint
+	if err := c.client.do("GET", "/api/v1/webhooks", nil, nil, &endpoints); err != nil {
+		return err
+	}
+	return c.print(endpoints, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "NAME\tENABLED\tEVENTS\tMIN SEVERITY\tURL")
+		for _, e := range endpoints {
+			events := strings.Join(e.Events, ",")
+			fmt.Fprintf(w, "%s\t%v\t%s\t%d\t%s\n", e.Name, e.Enabled, dash(events), e.MinSeverity, e.URL)
+		}
+	})
+}
+
+func (c *cli) webhookTest(args []string) error {
+	name, _, err := positional(args)
+	if err != nil {
+		return err
+	}
+	var result webhookResult
+	if err := c.client.do("POST", "/api/v1/webhooks/"+name+"/test", nil, nil, &result); err != nil {
+		return err
+	}
+	return c.print(result, func(w *tabwriter.Writer) {
+		if result.Error != "" {
+			fmt.Fprintf(w, "error\t%s\n", result.Error)
+		} else {
+			fmt.Fprintf(w, "status\t%d\n", result.Status)
+		}
+		fmt.Fprintf(w, "duration\t%s\n", result.Duration)
+		if result.Body != "" {
+			fmt.Fprintf(w, "response\t%s\n", strings.TrimSpace(result.Body))
+		}
+	})
+}
+
+func (c *cli) webhookDeadLetters() error {
+	var letters []deadLetter
+	if err := c.client.do("GET", "/api/v1/webhooks/dead-letters", nil, nil, &letters); err != nil {
+		return err
+	}
+	return c.print(letters, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "TIME\tENDPOINT\tEVENT\tATTEMPTS\tERROR")
+		for _, l := range letters {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", l.Time.Local().Format(time.DateTime), l.Endpoint, l.Event, l.Attempts, l.Error)
+		}
+	})
+}
+
+func (c *cli) webhookRetry() error {
+	var result map[string]int
+	if err := c.client.do("POST", "/api/v1/webhooks/dead-letters/retry", nil, nil, &result); err != nil {
+		return err
+	}
+	return c.done(fmt.Sprintf("queued %d dead letters for delivery", result["queued"]))
+}
+
+// print writes v as JSON or renders the table
+func (c *cli) print(v any, table func(w *tabwriter.Writer)) error {
+	if c.json {
+		return printJSON(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+func (c *cli) done(message string) error {
+	if c.json {
+		return printJSON(map[string]string{"result": message})
+	}
+	fmt.Println(message)
+	return nil
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+const alertFormat = "%-19s  %-8s  %-6s  %-5s  %-15s  %-21s  %-12s  %s\n"
+
+func printAlertHeader() {
+	fmt.Printf(alertFormat, "TIME", "SEVERITY", "SOURCE", "PROTO", "ATTACKER", "TARGET", "SIGNATURE", "MESSAGE")
+}
+
+// printAlert writes one alert per line so streamed alerts line up with the header
+func (c *cli) printAlert(alert model.Detection) {
+	if c.json {
+		data, _ := json.Marshal(alert)
+		fmt.Println(string(data))
+		return
+	}
+
+	target := alert.TargetIP
+	if alert.TargetPort != "" {
+		target += ":" + alert.TargetPort
+	}
+	fmt.Printf(alertFormat,
+		alert.Timestamp.Local().Format("2006-01-02 15:04:05"),
+		model.SeverityName(alert.Severity),
+		alert.Detector(),
+		alert.Protocol,
+		alert.AttackerIP,
+		dash(target),
+		dash(alert.SignatureID),
+		alert.Message,
+	)
+}
+
+// positional splits off the leading argument that precedes the flags
+func positional(args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", nil, errUsage
+	}
+	return args[0], args[1:], nil
+}
+
+// parseSince accepts a duration back from now or an RFC 3339 time
+func parseSince(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -since %q: use a duration like 1h or an RFC 3339 time", value)
+	}
+	return t, nil
+}
+
+func onOff(state map[string]bool) string {
+	var parts []string
+	for _, name := range sortedKeys(state) {
+		if state[name] {
+			parts = append(parts, name+"=on")
+		} else {
+			parts = append(parts, name+"=off")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func envOr(envVar, fallback string) string {
+	if value := os.Getenv(envVar); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,92 @@
+package main
+
+import "time"
+
+// The API's JSON documents as ipsctl decodes them. They mirror the server's
+// types field for field so that -o json prints everything, but are declared
+// here so the client doesn't link the engine, the firewall or the detectors.
+
+// defaultAddr is api.DefaultAddr
+const defaultAddr = "unix:/run/ips.sock"
+
+// eventDetection is engine.EventDetection
+const eventDetection = "detection"
+
+// engineStatus is engine.Status
+type engineStatus struct {
+	Running       bool            `json:"running"`
+	Detectors     map[string]bool `json:"detectors"`
+	CSVCapture    map[string]bool `json:"csv_capture"`
+	AvoidBlocking bool            `json:"avoid_blocking"`
+	Blocked       int             `json:"blocked"`
+	OpenIncidents int             `json:"open_incidents"`
+}
+
+// firewallResponse is iptables.Response
+type firewallResponse struct {
+	IP         string    `json:"ip"`
+	Kind       string    `json:"kind"`
+	Protocol   string    `json:"protocol,omitempty"`
+	Port       string    `json:"port,omitempty"`
+	Direction  string    `json:"direction,omitempty"`
+	Expires    time.Time `json:"expires,omitempty"`
+	Members    []string  `json:"members,omitempty"`
+	ASN        string    `json:"asn,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Rate       string    `json:"rate,omitempty"`
+	Burst      int       `json:"burst,omitempty"`
+	MaxConns   int       `json:"max_conns,omitempty"`
+	RejectWith string    `json:"reject_with,omitempty"`
+}
+
+// scope is iptables.Response.Scope
+func (r firewallResponse) scope() string {
+	scope := "all"
+	if r.Protocol != "" {
+		scope = r.Protocol
+		if r.Port != "" {
+			scope += "/" + r.Port
+		}
+	}
+	if r.Direction != "" && r.Direction != "both" {
+		scope += " " + r.Direction
+	}
+	return scope
+}
+
+// webhookEndpoint is webhook.Endpoint
+type webhookEndpoint struct {
+	Name        string            `json:"name"`
+	Enabled     bool              `json:"enabled"`
+	URL         string            `json:"url"`
+	Method      string            `json:"method,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Events      []string          `json:"events,omitempty"`
+	MinSeverity int               `json:"min_severity,omitempty"`
+	Detectors   []string          `json:"detectors,omitempty"`
+	Template    string            `json:"template,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Secret      string            `json:"secret,omitempty"`
+	Timeout     int               `json:"timeout,omitempty"`
+	MaxAttempts int               `json:"max_attempts,omitempty"`
+	Backoff     int               `json:"backoff,omitempty"`
+}
+
+// webhookResult is webhook.Result
+type webhookResult struct {
+	Status   int    `json:"status,omitempty"`
+	Body     string `json:"body,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// deadLetter is webhook.DeadLetter
+type deadLetter struct {
+	ID       string    `json:"id"`
+	Endpoint string    `json:"endpoint"`
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Body     string    `json:"body"`
+}
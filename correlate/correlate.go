@@ -0,0 +1,248 @@
+package correlate
+
+import (
+	"context"
+	"fmt"
+	"main/model"
+	"slices"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	IdleTimeout    time.Duration // close an incident after this long without detections
+	UpdateInterval time.Duration // minimum gap between updates that only bump hit counts
+	GroupByTarget  bool          // open separate incidents per attacker and target
+	MaxEvidence    int           // distinct detections kept per incident
+}
+
+func DefaultConfig() Config {
+	return Config{
+		IdleTimeout:    30 * time.Second,
+		UpdateInterval: 5 * time.Second,
+		GroupByTarget:  true,
+		MaxEvidence:    32,
+	}
+}
+
+type incident struct {
+	model.Incident
+	lastEmit    time.Time
+	pendingHits bool
+}
+
+// Correlator merges detections from all detectors into incidents
+type Correlator struct {
+	mu        sync.Mutex
+	config    Config
+	incidents map[string]*incident
+	emit      func(model.IncidentEvent)
+	nextID    uint64
+}
+
+func New(config Config, emit func(model.IncidentEvent)) *Correlator {
+	return &Correlator{
+		config:    config,
+		incidents: make(map[string]*incident),
+		emit:      emit,
+	}
+}
+
+// Add merges a detection into its incident, opening one if needed, and returns the incident
+func (c *Correlator) Add(d model.Detection) model.Incident {
+	now := time.Now()
+
+	c.mu.Lock()
+	inc := c.find(d)
+	if inc == nil {
+		c.nextID++
+		inc = &incident{
+			Incident: model.Incident{
+				ID:          fmt.Sprintf("%d-%d", now.Unix(), c.nextID),
+				AttackerIP:  d.AttackerIP,
+				TargetIP:    d.TargetIP,
+				TargetPorts: []string{},
+				Protocols:   []string{},
+				Detectors:   []string{},
+				Status:      "open",
+				FirstSeen:   now,
+			},
+		}
+		c.incidents[c.key(d.AttackerIP, d.TargetIP)] = inc
+	}
+
+	opened := inc.Hits == 0
+	changed := c.merge(inc, d, now)
+
+	var event *model.IncidentEvent
+	switch {
+	case opened:
+		event = &model.IncidentEvent{Type: model.IncidentOpen, Incident: snapshot(inc)}
+	case changed || now.Sub(inc.lastEmit) >= c.config.UpdateInterval:
+		event = &model.IncidentEvent{Type: model.IncidentUpdate, Incident: snapshot(inc)}
+	default:
+		inc.pendingHits = true
+	}
+	if event != nil {
+		inc.lastEmit = now
+		inc.pendingHits = false
+	}
+	result := snapshot(inc)
+	c.mu.Unlock()
+
+	if event != nil {
+		c.emit(*event)
+	}
+	return result
+}
+
+// Open returns a snapshot of all open incidents
+func (c *Correlator) Open() []model.Incident {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	incidents := make([]model.Incident, 0, len(c.incidents))
+	for _, inc := range c.incidents {
+		incidents = append(incidents, snapshot(inc))
+	}
+	return incidents
+}
+
+// Run closes idle incidents and flushes pending hit counts until ctx is cancelled
+func (c *Correlator) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, event := range c.sweep(now) {
+				c.emit(event)
+			}
+		}
+	}
+}
+
+func (c *Correlator) sweep(now time.Time) []model.IncidentEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var events []model.IncidentEvent
+	for key, inc := range c.incidents {
+		switch {
+		case now.Sub(inc.LastSeen) >= c.config.IdleTimeout:
+			inc.Status = "closed"
+			events = append(events, model.IncidentEvent{Type: model.IncidentClose, Incident: snapshot(inc)})
+			delete(c.incidents, key)
+		case inc.pendingHits && now.Sub(inc.lastEmit) >= c.config.UpdateInterval:
+			inc.lastEmit = now
+			inc.pendingHits = false
+			events = append(events, model.IncidentEvent{Type: model.IncidentUpdate, Incident: snapshot(inc)})
+		}
+	}
+	return events
+}
+
+// find returns the open incident for a detection. Detections without a target
+// (UNSW) join the most recently active incident of the same attacker, and an
+// incident opened without a target is claimed by the first targeted detection.
+func (c *Correlator) find(d model.Detection) *incident {
+	if inc, ok := c.incidents[c.key(d.AttackerIP, d.TargetIP)]; ok {
+		return inc
+	}
+	if !c.config.GroupByTarget {
+		return nil
+	}
+
+	if d.TargetIP != "" {
+		untargeted := c.key(d.AttackerIP, "")
+		inc, ok := c.incidents[untargeted]
+		if ok {
+			delete(c.incidents, untargeted)
+			c.incidents[c.key(d.AttackerIP, d.TargetIP)] = inc
+		}
+		return inc
+	}
+
+	var latest *incident
+	for _, inc := range c.incidents {
+		if inc.AttackerIP == d.AttackerIP && (latest == nil || inc.LastSeen.After(latest.LastSeen)) {
+			latest = inc
+		}
+	}
+	return latest
+}
+
+func (c *Correlator) key(attacker, target string) string {
+	if !c.config.GroupByTarget {
+		return attacker
+	}
+	return attacker + "|" + target
+}
+
+// merge adds d to the incident and reports whether anything besides the hit count changed
+func (c *Correlator) merge(inc *incident, d model.Detection, now time.Time) bool {
+	changed := false
+	inc.Hits++
+	inc.LastSeen = now
+
+	if inc.TargetIP == "" && d.TargetIP != "" {
+		inc.TargetIP = d.TargetIP
+		changed = true
+	}
+	if d.TargetPort != "" && !slices.Contains(inc.TargetPorts, d.TargetPort) {
+		inc.TargetPorts = append(inc.TargetPorts, d.TargetPort)
+		changed = true
+	}
+	if d.Protocol != "" && !slices.Contains(inc.Protocols, d.Protocol) {
+		inc.Protocols = append(inc.Protocols, d.Protocol)
+		changed = true
+	}
+	if !slices.Contains(inc.Detectors, d.Detector()) {
+		inc.Detectors = append(inc.Detectors, d.Detector())
+		changed = true
+	}
+
+	idx := slices.IndexFunc(inc.Evidence, func(e model.Evidence) bool {
+		return e.Method == d.Method && e.SignatureID == d.SignatureID && e.Message == d.Message && e.TargetPort == d.TargetPort
+	})
+	if idx != -1 {
+		inc.Evidence[idx].Count++
+		inc.Evidence[idx].LastSeen = now
+	} else {
+		if len(inc.Evidence) >= c.config.MaxEvidence {
+			inc.Evidence = inc.Evidence[1:]
+		}
+		inc.Evidence = append(inc.Evidence, model.Evidence{Detection: d, Count: 1, FirstSeen: now, LastSeen: now})
+		changed = true
+	}
+
+	if severity := incidentSeverity(inc); severity != inc.Severity {
+		inc.Severity = severity
+		changed = true
+	}
+
+	return changed
+}
+
+// incidentSeverity takes the worst evidence severity and raises it one level
+// for every additional independent detector that agrees
+func incidentSeverity(inc *incident) int {
+	severity := model.SeverityLow
+	for _, e := range inc.Evidence {
+		severity = max(severity, e.Severity)
+	}
+	severity += len(inc.Detectors) - 1
+	return min(severity, model.SeverityCritical)
+}
+
+func snapshot(inc *incident) model.Incident {
+	s := inc.Incident
+	s.TargetPorts = slices.Clone(inc.TargetPorts)
+	s.Protocols = slices.Clone(inc.Protocols)
+	s.Detectors = slices.Clone(inc.Detectors)
+	s.Evidence = slices.Clone(inc.Evidence)
+	return s
+}
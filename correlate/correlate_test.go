@@ -0,0 +1,202 @@
+package correlate
+
+import (
+	"main/model"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recorder collects the events a correlator emits
+type recorder struct {
+	events []model.IncidentEvent
+}
+
+func (r *recorder) emit(event model.IncidentEvent) {
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) types() []string {
+	var types []string
+	for _, e := range r.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func detection(method, target, port string, severity int) model.Detection {
+	return model.Detection{
+		Method:     method,
+		Protocol:   "TCP",
+		AttackerIP: "192.0.2.7",
+		TargetIP:   target,
+		TargetPort: port,
+		Message:    method + " detection",
+		Severity:   severity,
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	tests := []struct {
+		name       string
+		detections []model.Detection
+		want       int
+	}{
+		{
+			name:       "one detector keeps its severity",
+			detections: []model.Detection{detection(model.MethodRule, "10.0.0.1", "80", model.SeverityMedium)},
+			want:       model.SeverityMedium,
+		},
+		{
+			name: "repeats of one detector don't raise it",
+			detections: []model.Detection{
+				detection(model.MethodRule, "10.0.0.1", "80", model.SeverityMedium),
+				detection(model.MethodRule, "10.0.0.1", "80", model.SeverityMedium),
+				detection(model.MethodRule, "10.0.0.1", "443", model.SeverityMedium),
+			},
+			want: model.SeverityMedium,
+		},
+		{
+			name: "worst evidence wins",
+			detections: []model.Detection{
+				detection(model.MethodNative, "10.0.0.1", "80", model.SeverityLow),
+				detection(model.MethodNative, "10.0.0.1", "22", model.SeverityHigh),
+			},
+			want: model.SeverityHigh,
+		},
+		{
+			name: "a second detector raises it one level",
+			detections: []model.Detection{
+				detection(model.MethodRule, "10.0.0.1", "80", model.SeverityLow),
+				detection(model.MethodAI, "10.0.0.1", "80", model.SeverityLow),
+			},
+			want: model.SeverityMedium,
+		},
+		{
+			name: "untargeted detections count as a detector",
+			detections: []model.Detection{
+				detection(model.MethodRule, "10.0.0.1", "80", model.SeverityMedium),
+				detection(model.MethodAI, "10.0.0.1", "80", model.SeverityMedium),
+				detection(model.MethodUNSW, "", "", model.SeverityLow),
+			},
+			want: model.SeverityCritical,
+		},
+		{
+			name: "capped at critical",
+			detections: []model.Detection{
+				detection(model.MethodRule, "10.0.0.1", "80", model.SeverityHigh),
+				detection(model.MethodAI, "10.0.0.1", "80", model.SeverityHigh),
+				detection(model.MethodNative, "10.0.0.1", "80", model.SeverityHigh),
+			},
+			want: model.SeverityCritical,
+		},
+		{
+			name: "other targets are other incidents",
+			detections: []model.Detection{
+				detection(model.MethodAI, "10.0.0.2", "80", model.SeverityHigh),
+				detection(model.MethodRule, "10.0.0.1", "80", model.SeverityLow),
+			},
+			want: model.SeverityLow,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(DefaultConfig(), func(model.IncidentEvent) {})
+			var inc model.Incident
+			for _, d := range tt.detections {
+				inc = c.Add(d)
+			}
+			if inc.Severity != tt.want {
+				t.Errorf("severity = %d, want %d (detectors %v)", inc.Severity, tt.want, inc.Detectors)
+			}
+		})
+	}
+}
+
+func TestIdleClose(t *testing.T) {
+	config := DefaultConfig()
+	rec := &recorder{}
+	c := New(config, rec.emit)
+
+	inc := c.Add(detection(model.MethodRule, "10.0.0.1", "80", model.SeverityMedium))
+	start := c.Add(detection(model.MethodRule, "10.0.0.2", "80", model.SeverityMedium)).LastSeen
+
+	tests := []struct {
+		name   string
+		at     time.Duration // after the detections
+		closed int
+		open   int
+	}{
+		{"active", config.IdleTimeout / 2, 0, 2},
+		{"idle", config.IdleTimeout, 2, 0},
+		{"gone", 2 * config.IdleTimeout, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			closed := 0
+			for _, event := range c.sweep(start.Add(tt.at)) {
+				if event.Type != model.IncidentClose {
+					t.Errorf("unexpected %s event", event.Type)
+					continue
+				}
+				if event.Incident.Status != "closed" {
+					t.Errorf("closed incident has status %q", event.Incident.Status)
+				}
+				closed++
+			}
+			if closed != tt.closed {
+				t.Errorf("closed %d incidents, want %d", closed, tt.closed)
+			}
+			if open := len(c.Open()); open != tt.open {
+				t.Errorf("%d incidents open, want %d", open, tt.open)
+			}
+		})
+	}
+
+	// A detection after the close opens a new incident
+	if next := c.Add(detection(model.MethodRule, "10.0.0.1", "80", model.SeverityMedium)); next.ID == inc.ID || next.Hits != 1 {
+		t.Errorf("detection after the close joined incident %s with %d hits", next.ID, next.Hits)
+	}
+}
+
+func TestUpdates(t *testing.T) {
+	config := DefaultConfig()
+	rec := &recorder{}
+	c := New(config, rec.emit)
+
+	d := detection(model.MethodRule, "10.0.0.1", "80", model.SeverityMedium)
+	inc := c.Add(d)
+	c.Add(d) // hit count only, held back
+	c.Add(detection(model.MethodRule, "10.0.0.1", "443", model.SeverityMedium))
+	c.Add(d)
+
+	want := []string{model.IncidentOpen, model.IncidentUpdate}
+	if got := rec.types(); !slices.Equal(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+
+	// The held back hit is flushed once the update interval passed
+	if events := c.sweep(inc.LastSeen.Add(config.UpdateInterval / 2)); len(events) != 0 {
+		t.Errorf("flushed %d events within the update interval", len(events))
+	}
+	events := c.sweep(time.Now().Add(config.UpdateInterval))
+	if len(events) != 1 || events[0].Type != model.IncidentUpdate || events[0].Incident.Hits != 4 {
+		t.Errorf("flush = %+v, want one update with 4 hits", events)
+	}
+}
+
+func TestUntargeted(t *testing.T) {
+	c := New(DefaultConfig(), func(model.IncidentEvent) {})
+
+	first := c.Add(detection(model.MethodUNSW, "", "", model.SeverityLow))
+	claimed := c.Add(detection(model.MethodRule, "10.0.0.1", "80", model.SeverityMedium))
+	if claimed.ID != first.ID || claimed.TargetIP != "10.0.0.1" {
+		t.Errorf("targeted detection opened %s for %q, want it to claim %s", claimed.ID, claimed.TargetIP, first.ID)
+	}
+
+	other := c.Add(detection(model.MethodRule, "10.0.0.2", "80", model.SeverityMedium))
+	joined := c.Add(detection(model.MethodUNSW, "", "", model.SeverityLow))
+	if joined.ID != other.ID {
+		t.Errorf("untargeted detection joined %s, want the latest incident %s", joined.ID, other.ID)
+	}
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"main/logging"
+	"main/metrics"
+	"main/service"
+	"strconv"
+	"time"
+
+	"github.com/florianl/go-nfqueue"
+	"github.com/mdlayher/netlink"
+)
+
+// startDetector must be called with e.mu held
+func (e *Engine) startDetector(name string) error {
+	switch name {
+	case DetectorOwn:
+		ctx, cancel := context.WithCancel(context.Background())
+		e.startOwn(ctx)
+		e.cancelOwn = cancel
+		return nil
+	case DetectorSnort:
+		return service.StartSnort(e.alert)
+	case DetectorUNSW:
+		if err := service.StartUNSWRunnable(e.alert); err != nil {
+			return err
+		}
+		logger.Info("UNSW runner started")
+		return nil
+	}
+	return fmt.Errorf("unknown detector %q", name)
+}
+
+// stopDetector must be called with e.mu held
+func (e *Engine) stopDetector(name string) error {
+	switch name {
+	case DetectorOwn:
+		if e.cancelOwn != nil {
+			logger.Info("own detection disabled, stopping queue handlers")
+			e.cancelOwn()
+			e.cancelOwn = nil
+		}
+		return nil
+	case DetectorSnort:
+		return service.StopSnort()
+	case DetectorUNSW:
+		return service.StopUNSWRunnable()
+	}
+	return fmt.Errorf("unknown detector %q", name)
+}
+
+// startOwn analyzes the TCP, UDP and ICMP queues until ctx is cancelled
+func (e *Engine) startOwn(ctx context.Context) {
+	// Initialize services
+	tcpService := service.NewTCP(ctx, e.alert, e.native)
+	udpService := service.NewUDP(ctx, e.alert, e.native)
+	icmp := service.NewICMP(ctx, e.alert, e.native)
+
+	// Define queues and corresponding handlers
+	handlers := map[string]func([]byte){
+		"tcp":  tcpService.AnalyzeTCP,
+		"udp":  udpService.AnalyzeUDP,
+		"icmp": icmp.AnalyzeICMP,
+	}
+
+	// Start queue handlers with shared context
+	for proto, handler := range handlers {
+		go queueHandler(ctx, e.config.Queues[proto], proto, handler)
+	}
+}
+
+// packetLog reports per-packet NFQUEUE errors at most once per message every 10 seconds
+var packetLog = logging.Limited(logger, 10*time.Second)
+
+func queueHandler(ctx context.Context, queueNum uint16, proto string, packetHandler func([]byte)) {
+	queue := strconv.Itoa(int(queueNum))
+
+	config := nfqueue.Config{
+		NfQueue:      queueNum,
+		MaxPacketLen: 0xFFFF,
+		MaxQueueLen:  0xFF,
+		Copymode:     nfqueue.NfQnlCopyPacket,
+		WriteTimeout: 15 * time.Millisecond,
+	}
+
+	nf, err := nfqueue.Open(&config)
+	if err != nil {
+		logger.Error("could not open NFQUEUE socket", "queue", queueNum, "error", err)
+		return
+	}
+	defer nf.Close()
+
+	if err := nf.SetOption(netlink.NoENOBUFS, true); err != nil {
+		logger.Error("failed to set netlink option", "queue", queueNum, "error", err)
+		return
+	}
+
+	// NFQUEUE packet processing function
+	fn := func(a nfqueue.Attribute) int {
+		if a.PacketID == nil || a.Payload == nil {
+			packetLog.Warn("received invalid packet attributes", "queue", queueNum)
+			return -1
+		}
+
+		metrics.Packets.Inc(queue, proto)
+		start := time.Now()
+
+		packetHandler(*a.Payload)
+
+		metrics.PacketLatency.Observe(time.Since(start).Seconds(), proto)
+		if err := nf.SetVerdict(*a.PacketID, nfqueue.NfAccept); err != nil {
+			metrics.Verdicts.Inc(queue, "error")
+		} else {
+			metrics.Verdicts.Inc(queue, "accept")
+		}
+		return 0
+	}
+
+	if err := nf.RegisterWithErrorFunc(ctx, fn, func(e error) int {
+		packetLog.Error("NFQUEUE error", "queue", queueNum, "error", e)
+		return -1
+	}); err != nil {
+		logger.Error("failed to register NFQUEUE handler", "queue", queueNum, "error", err)
+		return
+	}
+
+	logger.Info("listening on NFQUEUE", "queue", queueNum, "protocol", proto)
+
+	<-ctx.Done()
+	logger.Info("NFQUEUE handler stopped", "queue", queueNum)
+}
@@ -0,0 +1,489 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"main/correlate"
+	"main/iptables"
+	"main/logging"
+	"main/metrics"
+	"main/model"
+	"main/native"
+	"main/policy"
+	"main/service"
+	"main/store"
+	"main/suppress"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Detectors the engine can start and stop
+const (
+	DetectorOwn   = "own"   // flow features from NFQUEUE classified by the own AI ensemble, plus the native detectors
+	DetectorSnort = "snort" // Snort 3 alert_fast output
+	DetectorUNSW  = "unswb" // the UNSW-NB15 Python runner
+)
+
+var logger = logging.For("engine")
+
+var detectorNames = []string{DetectorOwn, DetectorSnort, DetectorUNSW}
+
+var ErrUnknownDetector = errors.New("unknown detector")
+
+// alertBuffer is how many detections may wait for suppression, storage and the
+// response, so detectors on the packet path don't wait for them
+const alertBuffer = 1024
+
+// Event types published to subscribers
+const (
+	EventStatus    = "status"    // Status, after a detector or capture change
+	EventDetection = "detection" // model.Detection that passed suppression
+	EventIncident  = "incident"  // model.IncidentEvent
+	EventBlock     = "block"     // iptables.Response that was installed or updated
+	EventUnblock   = "unblocked" // iptables.Response that was removed
+	EventFlow      = "flow"      // model.Flow that expired from a flow table
+)
+
+type Event struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// Config holds the paths and queues the engine is built from
+type Config struct {
+	SuppressConfig string
+	PolicyConfig   string
+	FirewallConfig string
+	NativeConfig   string
+	AlertStore     string
+	AlertCapacity  int               // alerts kept in memory
+	AlertMaxSize   int64             // bytes from which the alert store is rotated, 0 never
+	AlertMaxFiles  int               // rotated alert store files kept
+	Queues         map[string]uint16 // NFQUEUE number per protocol: "tcp", "udp", "icmp"
+}
+
+// Status is the current state of the detectors, dataset capture and firewall
+type Status struct {
+	Running       bool            `json:"running"`
+	Detectors     map[string]bool `json:"detectors"`      // "own", "snort", "unswb"
+	CSVCapture    map[string]bool `json:"csv_capture"`    // "tcp", "udp", "icmp"
+	AvoidBlocking bool            `json:"avoid_blocking"` // blocking is paused while any capture is on
+	Blocked       int             `json:"blocked"`
+	OpenIncidents int             `json:"open_incidents"`
+}
+
+// Engine owns the detectors, the detection pipeline and the firewall responses.
+// Its methods are safe for concurrent use and take effect immediately.
+type Engine struct {
+	config Config
+
+	suppressor *suppress.Engine
+	policies   *policy.Engine
+	alerts     *store.Store
+	correlator *correlate.Correlator
+	native     *native.Engine
+	alert      chan model.Detection
+
+	mu        sync.Mutex
+	running   bool
+	cancel    context.CancelFunc
+	cancelOwn context.CancelFunc
+	detectors map[string]bool
+
+	expiries      map[string]*time.Timer // removal timers of temporary responses, keyed by iptables.Response.Key, and of SYN cookies
+	expiriesMutex sync.Mutex
+
+	subscribers map[chan Event]*subscriber
+	subsMutex   sync.Mutex
+}
+
+// subscriber is a named consumer of engine events, e.g. the EVE log
+type subscriber struct {
+	name    string
+	dropLog *slog.Logger // reports dropped events at most every 30 seconds
+}
+
+// New loads the suppression rules, policies, alert store and firewall config.
+// All detectors start enabled.
+func New(config Config) (*Engine, error) {
+	for _, proto := range []string{"tcp", "udp", "icmp"} {
+		if _, ok := config.Queues[proto]; !ok {
+			return nil, fmt.Errorf("no NFQUEUE number for %s", proto)
+		}
+	}
+
+	e := &Engine{
+		config:      config,
+		alert:       make(chan model.Detection, alertBuffer),
+		detectors:   map[string]bool{DetectorOwn: true, DetectorSnort: true, DetectorUNSW: true},
+		expiries:    make(map[string]*time.Timer),
+		subscribers: make(map[chan Event]*subscriber),
+	}
+
+	var err error
+	if e.suppressor, err = suppress.Load(config.SuppressConfig); err != nil {
+		return nil, err
+	}
+	if e.policies, err = policy.Load(config.PolicyConfig); err != nil {
+		return nil, err
+	}
+	if e.native, err = native.Load(config.NativeConfig, e.alert); err != nil {
+		return nil, err
+	}
+	if e.alerts, err = store.Open(config.AlertStore, config.AlertCapacity, config.AlertMaxSize, config.AlertMaxFiles); err != nil {
+		return nil, err
+	}
+	if err = iptables.LoadConfig(config.FirewallConfig); err != nil {
+		e.alerts.Close()
+		return nil, err
+	}
+
+	iptables.OnChange = func(r iptables.Response, installed bool) {
+		if installed {
+			e.emit(Event{Type: EventBlock, Data: r})
+		} else {
+			e.emit(Event{Type: EventUnblock, Data: r})
+		}
+	}
+	service.OnFlowExpired = func(flow model.Flow) {
+		if flow.Protocol == "tcp" {
+			if h, ok := e.native.TLSHandshake(flow); ok {
+				flow.TLS = &h
+			}
+		}
+		e.emit(Event{Type: EventFlow, Data: flow})
+	}
+	e.correlator = correlate.New(correlate.DefaultConfig(), func(event model.IncidentEvent) {
+		e.emit(Event{Type: EventIncident, Data: event})
+	})
+
+	metrics.ProcessUp.Set(0, DetectorSnort)
+	metrics.ProcessUp.Set(0, DetectorUNSW)
+
+	return e, nil
+}
+
+// Start prepares the NFQUEUE rules and starts the enabled detectors. A detector
+// that fails to start is logged and left disabled.
+func (e *Engine) Start() error {
+	e.mu.Lock()
+
+	if e.running {
+		e.mu.Unlock()
+		return fmt.Errorf("engine is already running")
+	}
+	if err := iptables.PrepareNFQueues(); err != nil {
+		e.mu.Unlock()
+		return fmt.Errorf("failed to prepare NFQUEUE rules: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.running = true
+
+	go e.correlator.Run(ctx)
+	go e.listen(ctx)
+
+	for _, name := range detectorNames {
+		if !e.detectors[name] {
+			continue
+		}
+		if err := e.startDetector(name); err != nil {
+			logger.Error("failed to start detector", "detector", name, "error", err)
+			e.detectors[name] = false
+		}
+	}
+
+	status := e.status()
+	e.mu.Unlock()
+
+	e.emit(Event{Type: EventStatus, Data: status})
+	return nil
+}
+
+// Stop stops the detectors and the pipeline. Installed responses stay in place
+// but temporary ones are no longer removed.
+func (e *Engine) Stop() {
+	e.mu.Lock()
+
+	if !e.running {
+		e.mu.Unlock()
+		return
+	}
+	for _, name := range detectorNames {
+		if !e.detectors[name] {
+			continue
+		}
+		if err := e.stopDetector(name); err != nil {
+			logger.Warn("failed to stop detector", "detector", name, "error", err)
+		}
+	}
+	e.cancel()
+	e.running = false
+	e.stopExpiries()
+
+	status := e.status()
+	e.mu.Unlock()
+
+	e.emit(Event{Type: EventStatus, Data: status})
+}
+
+// Status returns the current engine state
+func (e *Engine) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.status()
+}
+
+// SetDetectorEnabled starts or stops "own", "snort" or "unswb". Before Start it
+// only selects which detectors Start launches.
+func (e *Engine) SetDetectorEnabled(name string, enabled bool) error {
+	e.mu.Lock()
+
+	current, ok := e.detectors[name]
+	if !ok {
+		e.mu.Unlock()
+		return fmt.Errorf("%w %q", ErrUnknownDetector, name)
+	}
+	if current == enabled {
+		e.mu.Unlock()
+		return nil
+	}
+
+	var err error
+	if e.running {
+		if enabled {
+			err = e.startDetector(name)
+		} else {
+			err = e.stopDetector(name)
+		}
+	}
+	// A detector that failed to stop has usually exited on its own already
+	if err == nil || !enabled {
+		e.detectors[name] = enabled
+	}
+
+	status := e.status()
+	e.mu.Unlock()
+
+	logger.Info("detector toggled", "detector", name, "enabled", status.Detectors[name])
+	e.emit(Event{Type: EventStatus, Data: status})
+	return err
+}
+
+// SetCSVCapture turns dataset capture for "tcp", "udp" or "icmp" on or off.
+// Blocking is paused while any capture is on so attack traffic can be recorded.
+func (e *Engine) SetCSVCapture(proto string, enabled bool) error {
+	e.mu.Lock()
+
+	if err := service.SetCSVCapture(proto, enabled); err != nil {
+		e.mu.Unlock()
+		return err
+	}
+
+	capturing := false
+	for _, on := range service.CSVCapture() {
+		capturing = capturing || on
+	}
+	iptables.SetAvoidBlocking(capturing)
+
+	status := e.status()
+	e.mu.Unlock()
+
+	e.emit(Event{Type: EventStatus, Data: status})
+	return nil
+}
+
+// Blocked returns the installed blocks and throttles ordered by address
+func (e *Engine) Blocked() []iptables.Response {
+	responses := iptables.Active()
+	sort.Slice(responses, func(i, j int) bool {
+		return responses[i].Key() < responses[j].Key()
+	})
+	return responses
+}
+
+// Block blocks an address or CIDR for ttl, or permanently when ttl is 0
+func (e *Engine) Block(ip string, ttl time.Duration, reason string) error {
+	if net.ParseIP(ip) == nil {
+		if _, _, err := net.ParseCIDR(ip); err != nil {
+			return fmt.Errorf("invalid address %q", ip)
+		}
+	}
+	if ttl < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	if reason == "" {
+		reason = "manual"
+	}
+
+	err := e.installResponse(iptables.Response{IP: ip, Kind: iptables.KindDrop, Reason: reason}, ttl)
+	if errors.Is(err, iptables.ErrSkipped) {
+		if iptables.AvoidBlocking() {
+			return fmt.Errorf("blocking is paused while CSV capture is on")
+		}
+		return nil
+	}
+	return err
+}
+
+// Unblock lifts every block and throttle against ip. It returns
+// iptables.ErrCovered when an aggregate block keeps dropping ip.
+func (e *Engine) Unblock(ip string) error {
+	removed, err := e.removeResponses(ip)
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		return fmt.Errorf("%s is not blocked", ip)
+	}
+	return nil
+}
+
+// UnblockResponse lifts a single scoped block or throttle, see Unblock
+func (e *Engine) UnblockResponse(r iptables.Response) error {
+	return e.removeResponse(r)
+}
+
+// Alerts returns the stored alerts matching filter, oldest first
+func (e *Engine) Alerts(filter store.Filter) []model.Detection {
+	return e.alerts.Query(filter)
+}
+
+// Incidents returns the open incidents
+func (e *Engine) Incidents() []model.Incident {
+	return e.correlator.Open()
+}
+
+func (e *Engine) Suppression() suppress.Config {
+	return e.suppressor.Config()
+}
+
+func (e *Engine) SetSuppression(config suppress.Config) error {
+	return e.suppressor.SetConfig(config)
+}
+
+func (e *Engine) Policy() policy.Config {
+	return e.policies.Config()
+}
+
+func (e *Engine) SetPolicy(config policy.Config) error {
+	return e.policies.SetConfig(config)
+}
+
+// DryRunPolicy shows what config would have done against the stored alerts matching filter
+func (e *Engine) DryRunPolicy(config policy.Config, filter store.Filter) ([]policy.Result, error) {
+	return e.policies.DryRun(&config, e.alerts.Query(filter))
+}
+
+func (e *Engine) NativeConfig() native.Config {
+	return e.native.Config()
+}
+
+func (e *Engine) SetNativeConfig(config native.Config) error {
+	return e.native.SetConfig(config)
+}
+
+func (e *Engine) FirewallConfig() iptables.Config {
+	return iptables.GetConfig()
+}
+
+func (e *Engine) SetFirewallConfig(config iptables.Config) error {
+	return iptables.SetConfig(config)
+}
+
+// Subscribe returns a channel receiving engine events and a function that
+// unsubscribes and closes it. Events are dropped for subscribers that fall
+// more than buffer events behind, counted and logged under name.
+func (e *Engine) Subscribe(name string, buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	e.subsMutex.Lock()
+	e.subscribers[ch] = &subscriber{
+		name:    name,
+		dropLog: logging.Limited(logger.With("subscriber", name), 30*time.Second),
+	}
+	e.subsMutex.Unlock()
+
+	return ch, func() {
+		e.subsMutex.Lock()
+		defer e.subsMutex.Unlock()
+
+		if _, ok := e.subscribers[ch]; ok {
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (e *Engine) emit(event Event) {
+	e.subsMutex.Lock()
+	defer e.subsMutex.Unlock()
+
+	for ch, sub := range e.subscribers {
+		select {
+		case ch <- event:
+		default:
+			metrics.EventsDropped.Inc(sub.name)
+			sub.dropLog.Warn("subscriber fell behind, dropping events", "event", event.Type)
+		}
+	}
+}
+
+// status must be called with e.mu held
+func (e *Engine) status() Status {
+	detectors := make(map[string]bool, len(e.detectors))
+	for name, enabled := range e.detectors {
+		detectors[name] = enabled
+	}
+
+	return Status{
+		Running:       e.running,
+		Detectors:     detectors,
+		CSVCapture:    service.CSVCapture(),
+		AvoidBlocking: iptables.AvoidBlocking(),
+		Blocked:       len(iptables.Active()),
+		OpenIncidents: len(e.correlator.Open()),
+	}
+}
+
+// detectionSignature labels detections by signature id, or by message for the
+// AI detectors whose messages are a small fixed set
+func detectionSignature(alert model.Detection) string {
+	if alert.SignatureID != "" {
+		return alert.SignatureID
+	}
+	return alert.Message
+}
+
+// listen runs every detection through suppression, the alert store and the policy engine
+func (e *Engine) listen(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alert := <-e.alert:
+			if alert.Timestamp.IsZero() {
+				alert.Timestamp = time.Now()
+			}
+
+			decision := e.suppressor.Evaluate(alert)
+			if decision.Suppressed {
+				continue
+			}
+
+			if err := e.alerts.Add(alert); err != nil {
+				logger.Error("failed to store alert", "error", err)
+			}
+			e.emit(Event{Type: EventDetection, Data: alert})
+			metrics.Detections.Inc(alert.Detector(), detectionSignature(alert))
+
+			e.respond(alert, decision)
+		}
+	}
+}
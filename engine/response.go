@@ -0,0 +1,274 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/iptables"
+	"main/model"
+	"main/policy"
+	"main/suppress"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const hookTimeout = 10 * time.Second
+
+// synCookiesExpiry keys the timer that turns SYN cookies off again among the response expiries
+const synCookiesExpiry = "syn_cookies"
+
+// respond executes the response chain the policy engine selects for a detection
+func (e *Engine) respond(alert model.Detection, decision suppress.Decision) {
+	result := e.policies.Evaluate(alert)
+
+	for _, action := range applyOverride(result.Actions, decision.Action) {
+		switch action.Type {
+		case policy.ActionLog:
+			logger.Info("detection logged by policy", "policy", result.PolicyID, "method", alert.Method, "attacker", alert.AttackerIP, "target", alert.TargetIP, "port", alert.TargetPort, "message", alert.Message)
+		case policy.ActionAlert:
+			e.correlator.Add(alert)
+		case policy.ActionHook:
+			go runHook(action.Command, alert)
+		case policy.ActionSYNCookies:
+			e.enableSYNCookies(time.Duration(action.TTL) * time.Second)
+		default:
+			if alert.AttackerIP == "" {
+				// e.g. a flood from spoofed sources, there is nobody to block
+				logger.Debug("no attacker to respond to", "policy", result.PolicyID, "action", action.Type, "message", alert.Message)
+				continue
+			}
+			e.installResponse(firewallResponse(alert, action), time.Duration(action.TTL)*time.Second)
+		}
+	}
+}
+
+// firewallResponse translates a blocking or throttling action into a firewall response
+// scoped by the protocol and port of the detection that triggered it
+func firewallResponse(alert model.Detection, action policy.Action) iptables.Response {
+	r := iptables.Response{IP: alert.AttackerIP, Direction: action.Direction, Reason: alert.Detector() + ": " + alert.Message}
+
+	protocol := strings.ToLower(alert.Protocol)
+	if protocol == "tcp" || protocol == "udp" || protocol == "icmp" {
+		switch action.Scope {
+		case policy.ScopePort:
+			r.Protocol = protocol
+			if protocol != "icmp" {
+				r.Port = alert.TargetPort
+			}
+		case policy.ScopeProtocol:
+			r.Protocol = protocol
+		}
+	}
+
+	switch action.Type {
+	case policy.ActionBlock, policy.ActionTempBlock:
+		r.Kind = iptables.KindDrop
+	case policy.ActionRateLimit:
+		r.Kind = iptables.KindRateLimit
+		r.Rate = action.Rate
+		r.Burst = action.Burst
+	case policy.ActionSYNLimit:
+		r.Kind = iptables.KindSYNLimit
+		r.Rate = action.Rate
+		r.Burst = action.Burst
+	case policy.ActionConnLimit:
+		r.Kind = iptables.KindConnLimit
+		r.MaxConns = action.MaxConns
+	case policy.ActionTarpit:
+		r.Kind = iptables.KindTarpit
+	case policy.ActionReject:
+		r.Kind = iptables.KindReject
+		r.RejectWith = action.RejectWith
+	}
+	return r
+}
+
+// applyOverride enforces a suppression override on a response chain:
+// "alert" strips every block, "block" adds one if the chain has none
+func applyOverride(actions []policy.Action, override string) []policy.Action {
+	switch override {
+	case suppress.ActionAlert:
+		var kept []policy.Action
+		for _, a := range actions {
+			if !a.IsBlocking() {
+				kept = append(kept, a)
+			}
+		}
+		return kept
+	case suppress.ActionBlock:
+		if !policy.Has(actions, policy.ActionBlock) && !policy.Has(actions, policy.ActionTempBlock) {
+			return append(append([]policy.Action{}, actions...), policy.Action{Type: policy.ActionBlock})
+		}
+	}
+	return actions
+}
+
+// installResponse installs r, removing it again after ttl when ttl is positive.
+// A permanent install promotes a running temporary one, a temporary one issued
+// again restarts its ttl.
+func (e *Engine) installResponse(r iptables.Response, ttl time.Duration) error {
+	if ttl > 0 {
+		r.Expires = time.Now().Add(ttl)
+	}
+
+	err := iptables.Install(r)
+	if errors.Is(err, iptables.ErrPromoted) {
+		e.cancelExpiry(r)
+		return err
+	}
+	extended := errors.Is(err, iptables.ErrExtended)
+	if err != nil && !extended {
+		if !errors.Is(err, iptables.ErrSkipped) {
+			logger.Error("failed to install response", "kind", r.Kind, "ip", r.IP, "error", err)
+		}
+		return err
+	}
+
+	if ttl <= 0 {
+		return nil
+	}
+
+	e.expiriesMutex.Lock()
+	defer e.expiriesMutex.Unlock()
+
+	if timer, ok := e.expiries[r.Key()]; ok && extended {
+		timer.Reset(ttl)
+		return err
+	}
+	e.expiries[r.Key()] = time.AfterFunc(ttl, func() {
+		e.expiriesMutex.Lock()
+		delete(e.expiries, r.Key())
+		e.expiriesMutex.Unlock()
+
+		if iptables.Expire(r) == nil {
+			logger.Info("temporary response expired", "kind", r.Kind, "ip", r.IP)
+		}
+	})
+	return nil
+}
+
+// enableSYNCookies turns on SYN cookies when they are off and, when ttl is
+// positive, off again after ttl. Repeated floods extend the ttl.
+func (e *Engine) enableSYNCookies(ttl time.Duration) {
+	e.expiriesMutex.Lock()
+	defer e.expiriesMutex.Unlock()
+
+	if timer, ok := e.expiries[synCookiesExpiry]; ok {
+		// Turned on by an earlier flood
+		if ttl > 0 {
+			timer.Reset(ttl)
+		} else {
+			timer.Stop()
+			delete(e.expiries, synCookiesExpiry)
+		}
+		return
+	}
+
+	mode, err := iptables.SYNCookies()
+	if err != nil {
+		logger.Error("failed to enable SYN cookies", "error", err)
+		return
+	}
+	if mode != 0 {
+		return
+	}
+	if err := iptables.SetSYNCookies(1); err != nil {
+		logger.Error("failed to enable SYN cookies", "error", err)
+		return
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	e.expiries[synCookiesExpiry] = time.AfterFunc(ttl, func() {
+		e.expiriesMutex.Lock()
+		delete(e.expiries, synCookiesExpiry)
+		e.expiriesMutex.Unlock()
+
+		logger.Info("temporary SYN cookies expired")
+		iptables.SetSYNCookies(0)
+	})
+}
+
+// removeResponses lifts every response against ip, e.g. on a manual unblock
+func (e *Engine) removeResponses(ip string) ([]iptables.Response, error) {
+	removed, err := iptables.RemoveAll(ip)
+	for _, r := range removed {
+		e.cancelExpiry(r)
+	}
+	return removed, err
+}
+
+// removeResponse lifts a single response
+func (e *Engine) removeResponse(r iptables.Response) error {
+	err := iptables.Remove(r)
+	if errors.Is(err, iptables.ErrCovered) {
+		e.cancelExpiry(r)
+		return err
+	}
+	if errors.Is(err, iptables.ErrSkipped) {
+		e.cancelExpiry(r)
+		return fmt.Errorf("no %s (%s) is active against %s", r.Kind, r.Scope(), r.IP)
+	}
+	if err != nil {
+		logger.Error("failed to remove response", "kind", r.Kind, "ip", r.IP, "error", err)
+		return err
+	}
+	e.cancelExpiry(r)
+	return nil
+}
+
+func (e *Engine) cancelExpiry(r iptables.Response) {
+	e.expiriesMutex.Lock()
+	defer e.expiriesMutex.Unlock()
+
+	if timer, ok := e.expiries[r.Key()]; ok {
+		timer.Stop()
+		delete(e.expiries, r.Key())
+	}
+}
+
+// stopExpiries cancels the removal timers on shutdown
+func (e *Engine) stopExpiries() {
+	e.expiriesMutex.Lock()
+	defer e.expiriesMutex.Unlock()
+
+	for key, timer := range e.expiries {
+		timer.Stop()
+		delete(e.expiries, key)
+	}
+}
+
+// runHook runs an external command with the detection in its environment and on stdin
+func runHook(command []string, alert model.Detection) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	data, err := json.Marshal(alert)
+	if err != nil {
+		logger.Error("failed to encode detection for hook", "error", err)
+		return
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"IPS_DETECTOR="+alert.Detector(),
+		"IPS_ATTACKER_IP="+alert.AttackerIP,
+		"IPS_TARGET_IP="+alert.TargetIP,
+		"IPS_TARGET_PORT="+alert.TargetPort,
+		"IPS_PROTOCOL="+alert.Protocol,
+		"IPS_MESSAGE="+alert.Message,
+		"IPS_SIGNATURE_ID="+alert.SignatureID,
+		"IPS_SEVERITY="+strconv.Itoa(alert.Severity),
+	)
+	cmd.Stdin = bytes.NewReader(data)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		logger.Error("hook failed", "command", command, "output", string(output), "error", err)
+	}
+}
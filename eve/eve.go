@@ -0,0 +1,242 @@
+package eve
+
+import (
+	"encoding/json"
+	"main/engine"
+	"main/iptables"
+	"main/logging"
+	"main/metrics"
+	"main/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeFormat is the timestamp layout of Suricata's EVE output
+const timeFormat = "2006-01-02T15:04:05.000000-0700"
+
+var logger = logging.For("eve")
+
+type Config struct {
+	Path          string
+	MaxSize       int64         // bytes before the file is rotated, 0 never rotates
+	MaxFiles      int           // rotated files kept next to Path
+	StatsInterval time.Duration // 0 disables stats events
+}
+
+// Log writes engine events as newline-delimited EVE JSON records
+type Log struct {
+	config  Config
+	file    *rotatingFile
+	started time.Time
+}
+
+func Open(config Config) (*Log, error) {
+	file, err := openRotating(config.Path, config.MaxSize, config.MaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{config: config, file: file, started: time.Now()}, nil
+}
+
+// Run writes every event until events is closed, plus a stats record every
+// StatsInterval built from the metrics and status
+func (l *Log) Run(events <-chan engine.Event, status func() engine.Status) {
+	var tick <-chan time.Time
+	if l.config.StatsInterval > 0 {
+		ticker := time.NewTicker(l.config.StatsInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				l.file.Close()
+				return
+			}
+			if record := l.record(event); record != nil {
+				l.write(record)
+			}
+		case now := <-tick:
+			l.write(l.stats(now, status()))
+		}
+	}
+}
+
+func (l *Log) write(record map[string]any) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		logger.Error("failed to encode event", "event_type", record["event_type"], "error", err)
+		return
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		logger.Error("failed to write event log", "path", l.config.Path, "error", err)
+	}
+}
+
+// record converts an engine event, nil for events the log doesn't carry
+func (l *Log) record(event engine.Event) map[string]any {
+	switch data := event.Data.(type) {
+	case model.Detection:
+		return alertRecord(data)
+	case model.Flow:
+		return flowRecord(data)
+	case iptables.Response:
+		if event.Type == engine.EventUnblock {
+			return blockRecord("unblock", data)
+		}
+		return blockRecord("block", data)
+	}
+	return nil
+}
+
+func header(eventType string, timestamp time.Time, proto, srcIP, destIP, destPort string) map[string]any {
+	record := map[string]any{
+		"timestamp":  timestamp.Format(timeFormat),
+		"event_type": eventType,
+	}
+	if srcIP != "" {
+		record["src_ip"] = srcIP
+	}
+	if destIP != "" {
+		record["dest_ip"] = destIP
+	}
+	if port, err := strconv.Atoi(destPort); err == nil {
+		record["dest_port"] = port
+	}
+	if proto != "" {
+		record["proto"] = strings.ToUpper(proto)
+	}
+	return record
+}
+
+func alertRecord(alert model.Detection) map[string]any {
+	record := header("alert", alert.Timestamp, alert.Protocol, alert.AttackerIP, alert.TargetIP, alert.TargetPort)
+
+	details := map[string]any{
+		"action":    "allowed",
+		"signature": alert.Message,
+		"category":  alert.Method,
+		"severity":  suricataSeverity(alert.Severity),
+		"detector":  alert.Detector(),
+	}
+	// Snort signatures are "gid:sid", as in Suricata's gid and signature_id
+	if gid, sid, found := strings.Cut(alert.SignatureID, ":"); found {
+		if n, err := strconv.Atoi(gid); err == nil {
+			details["gid"] = n
+		}
+		if n, err := strconv.Atoi(sid); err == nil {
+			details["signature_id"] = n
+		}
+	} else if n, err := strconv.Atoi(alert.SignatureID); err == nil {
+		details["signature_id"] = n
+	}
+	record["alert"] = details
+	return record
+}
+
+// suricataSeverity maps detection severities onto Suricata's 1 (highest) to 3
+func suricataSeverity(severity int) int {
+	switch {
+	case severity >= model.SeverityHigh:
+		return 1
+	case severity == model.SeverityMedium:
+		return 2
+	}
+	return 3
+}
+
+func flowRecord(flow model.Flow) map[string]any {
+	record := header("flow", flow.End, flow.Protocol, flow.SourceIP, flow.DestinationIP, flow.DestinationPort)
+	f := flow.Features
+	record["flow"] = map[string]any{
+		"pkts_toserver":  f.TotalFwdPackets,
+		"pkts_toclient":  f.TotalBwdPackets,
+		"bytes_toserver": f.TotalLengthFwdPackets,
+		"bytes_toclient": f.TotalLengthBwdPackets,
+		"start":          flow.Start.Format(timeFormat),
+		"end":            flow.End.Format(timeFormat),
+		"age":            int(flow.End.Sub(flow.Start).Seconds()),
+		"state":          "closed",
+		"reason":         "timeout",
+	}
+	if port, err := strconv.Atoi(flow.SourcePort); err == nil {
+		record["src_port"] = port
+	}
+	record["features"] = f
+	if flow.TLS != nil {
+		record["tls"] = tlsRecord(*flow.TLS)
+	}
+	return record
+}
+
+// tlsRecord follows the fields of Suricata's tls events
+func tlsRecord(h model.TLSHandshake) map[string]any {
+	record := map[string]any{
+		"src_ip":    h.Client,
+		"src_port":  h.ClientPort,
+		"dest_ip":   h.Server,
+		"dest_port": h.ServerPort,
+		"version":   h.Version,
+		"ja3":       map[string]any{"hash": h.JA3, "string": h.JA3String},
+		"ja4":       h.JA4,
+	}
+	if h.SNI != "" {
+		record["sni"] = h.SNI
+	}
+	if len(h.ALPN) > 0 {
+		record["client_alpns"] = h.ALPN
+	}
+	if h.JA3S != "" {
+		record["ja3s"] = map[string]any{"hash": h.JA3S, "string": h.JA3SString}
+		record["cipher"] = h.Cipher
+	}
+	if h.Blocklisted != "" {
+		record["blocklisted"] = h.Blocklisted
+	}
+	return record
+}
+
+func blockRecord(eventType string, r iptables.Response) map[string]any {
+	record := header(eventType, time.Now(), r.Protocol, r.IP, "", "")
+	record["block"] = r
+	return record
+}
+
+func (l *Log) stats(now time.Time, status engine.Status) map[string]any {
+	record := header("stats", now, "", "", "", "")
+	record["stats"] = map[string]any{
+		"uptime":  int(now.Sub(l.started).Seconds()),
+		"packets": sumBy(metrics.Packets.Samples(), 1),
+		"flows": map[string]any{
+			"active":  sumBy(metrics.ActiveFlows.Samples(), 0),
+			"expired": sumBy(metrics.FlowEvictions.Samples(), 0),
+		},
+		"predictions": map[string]any{
+			"total":    sumBy(metrics.Predictions.Samples(), 0),
+			"failures": sumBy(metrics.PredictionFailures.Samples(), 0),
+		},
+		"detections": sumBy(metrics.Detections.Samples(), 0),
+		"blocks": map[string]any{
+			"active":  status.Blocked,
+			"added":   sumBy(metrics.BlocksAdded.Samples(), 0),
+			"removed": sumBy(metrics.BlocksRemoved.Samples(), 0),
+		},
+		"detectors":      status.Detectors,
+		"open_incidents": status.OpenIncidents,
+	}
+	return record
+}
+
+// sumBy totals samples by the label at index, e.g. packets by protocol across queues
+func sumBy(samples []metrics.Sample, index int) map[string]int64 {
+	totals := make(map[string]int64)
+	for _, s := range samples {
+		if index < len(s.Labels) {
+			totals[s.Labels[index]] += int64(s.Value)
+		}
+	}
+	return totals
+}
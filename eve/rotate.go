@@ -0,0 +1,90 @@
+package eve
+
+import (
+	"fmt"
+	"main/logging"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// rotateLog reports failed rotations at most once a minute, as every write retries them
+var rotateLog = logging.Limited(logger, time.Minute)
+
+// rotatingFile appends to path and renames it to path.1, path.2, … once it
+// reaches maxSize, keeping at most maxFiles old files
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func openRotating(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create event log directory: %w", err)
+	}
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Write writes one complete line, rotating first if it would overflow the file.
+// A failed rotation is retried on the next write, the line still goes to the
+// current file.
+func (r *rotatingFile) Write(line []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(line)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			rotateLog.Warn("failed to rotate event log", "path", r.path, "error", err)
+		}
+	}
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the open file aside and only then replaces it, so that a
+// failure at any step leaves a file to write to
+func (r *rotatingFile) rotate() error {
+	if r.maxFiles > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxFiles))
+		for i := r.maxFiles - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate event log: %w", err)
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return fmt.Errorf("failed to rotate event log: %w", err)
+	}
+
+	old := r.file
+	if err := r.open(); err != nil {
+		// Keep appending to the renamed file until a later rotation succeeds
+		return err
+	}
+	old.Close()
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	return r.file.Close()
+}
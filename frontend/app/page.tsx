@@ -1,6 +1,14 @@
 "use client";
 import { useEffect, useRef, useState } from "react";
-import { EventsEmit, EventsOn } from "../wailsjs/runtime/runtime";
+import { EventsOn } from "../wailsjs/runtime/runtime";
+import {
+  GetStatus,
+  ListBlocked,
+  SetCSVCapture,
+  SetDetectorEnabled,
+  UnblockResponse,
+} from "../wailsjs/go/main/App";
+import { engine } from "../wailsjs/go/models";
 import { Card, CardContent, CardHeader, CardTitle } from "@/components/ui/card";
 import { Switch } from "@/components/ui/switch";
 
@@ -12,6 +20,7 @@ export default function Home() {
   const [tcpCollectorOn, setTcpCollectorOn] = useState(false);
   const [udpCollectorOn, setUdpCollectorOn] = useState(false);
   const [icmpCollectorOn, setIcmpCollectorOn] = useState(false);
+  const [avoidBlocking, setAvoidBlocking] = useState(false);
   const [alerts, setAlerts] = useState<any[]>([]);
   const [blockedIPs, setBlockedIPs] = useState<any[]>([]);
 
@@ -22,70 +31,81 @@ export default function Home() {
     blockedIPsRef.current = blockedIPs;
   }, [blockedIPs]);
 
-  // Emit avoidBlocking based on collectors' state
+  // Mirror the engine state returned by the backend
+  const applyStatus = (status: engine.Status) => {
+    setUnswOn(!!status.detectors["unswb"]);
+    setOwnOn(!!status.detectors["own"]);
+    setSnortOn(!!status.detectors["snort"]);
+    setTcpCollectorOn(!!status.csv_capture["tcp"]);
+    setUdpCollectorOn(!!status.csv_capture["udp"]);
+    setIcmpCollectorOn(!!status.csv_capture["icmp"]);
+    setAvoidBlocking(status.avoid_blocking);
+  };
+
+  // Load the current state on mount and follow changes made elsewhere
   useEffect(() => {
-    const shouldAvoidBlocking =
-      tcpCollectorOn || udpCollectorOn || icmpCollectorOn;
-    EventsEmit("avoidBlocking", shouldAvoidBlocking ? "true" : "false");
-  }, [tcpCollectorOn, udpCollectorOn, icmpCollectorOn]);
+    GetStatus().then(applyStatus).catch(console.error);
+    ListBlocked()
+      .then((responses) => setBlockedIPs(responses || []))
+      .catch(console.error);
+
+    const unbindStatus = EventsOn("status", applyStatus);
+    return () => {
+      unbindStatus();
+    };
+  }, []);
+
+  const setDetector = (name: string, enabled: boolean) => {
+    SetDetectorEnabled(name, enabled)
+      .then(applyStatus)
+      .catch((err) => {
+        console.error(err);
+        GetStatus().then(applyStatus);
+      });
+  };
+
+  const setCapture = (proto: string, enabled: boolean) => {
+    SetCSVCapture(proto, enabled)
+      .then(applyStatus)
+      .catch((err) => {
+        console.error(err);
+        GetStatus().then(applyStatus);
+      });
+  };
 
-  // Update alert status or add new alert
-  const updateAlertStatus = (newAlert: any) => {
-    const now = Date.now();
+  // Insert or replace an incident on open/update/close events
+  const updateIncident = (event: any) => {
+    const incident = event.Incident;
 
     setAlerts((prevAlerts) => {
-      const index = prevAlerts.findIndex(
-        (alert) =>
-          alert.Attacker_ip === newAlert.Attacker_ip &&
-          alert.Target_port === newAlert.Target_port &&
-          alert.Method === newAlert.Method &&
-          alert.Protocol === newAlert.Protocol
-      );
+      const index = prevAlerts.findIndex((alert) => alert.Id === incident.Id);
 
       if (index !== -1) {
-        return prevAlerts.map((alert, i) =>
-          i === index ? { ...alert, Status: "active", lastSeen: now } : alert
-        );
+        return prevAlerts.map((alert, i) => (i === index ? incident : alert));
       }
 
-      return [
-        ...prevAlerts,
-        {
-          ...newAlert,
-          Status: "active",
-          lastSeen: now,
-          startTime: now,
-        },
-      ];
+      return [...prevAlerts, incident];
     });
   };
 
-  // Passive alerts logic
-  const setInactiveAlerts = () => {
-    const now = Date.now();
-    setAlerts((prevAlerts) =>
-      prevAlerts.map((alert) => {
-        const isOld = now - alert.lastSeen > 10000;
-        return isOld ? { ...alert, Status: "passive" } : alert;
-      })
-    );
-  };
-
-  // Shared useEffect for setting interval and handling "alert"
+  // Shared useEffect for handling "incident"
   useEffect(() => {
-    const interval = setInterval(setInactiveAlerts, 1000);
-
-    const unbindAlert = EventsOn("alert", (data: any) => {
-      console.log("Alert data:", data);
+    const unbindIncident = EventsOn("incident", (data: any) => {
+      console.log("Incident event:", data);
       console.log("Blocked IPs (ref):", blockedIPsRef.current);
 
-      if (blockedIPsRef.current.includes(data.Attacker_ip)) return;
-      updateAlertStatus(data);
+      if (
+        data.Type === "open" &&
+        blockedIPsRef.current.some(
+          (r) => r.ip === data.Incident.Attacker_ip && r.kind === "drop"
+        )
+      )
+        return;
+      updateIncident(data);
     });
 
     return () => {
-      clearInterval(interval);
-      unbindAlert();
+      unbindIncident();
     };
   }, []);
 
@@ -95,27 +115,70 @@ export default function Home() {
       updateBlockedIPs(data);
     });
 
+    // Temporary responses expire on the backend
+    const unbindUnblocked = EventsOn("unblocked", (data: any) => {
+      setBlockedIPs((prev) => prev.filter((r) => !sameResponse(r, data)));
+    });
+
     return () => {
       unbindBlock();
+      unbindUnblocked();
     };
   }, []);
 
-  // Add IP to blocked list if not already blocked
-  const updateBlockedIPs = (ip: string) => {
+  // Responses are identified by address, kind and scope
+  const sameResponse = (a: any, b: any) =>
+    a.ip === b.ip &&
+    a.kind === b.kind &&
+    (a.protocol || "") === (b.protocol || "") &&
+    (a.port || "") === (b.port || "") &&
+    (a.direction || "both") === (b.direction || "both");
+
+  // Add a block or throttle to the list, or update it (e.g. new aggregate members)
+  const updateBlockedIPs = (response: any) => {
     setBlockedIPs((prev) => {
-      if (!prev.includes(ip)) return [...prev, ip];
-      return prev;
+      if (!prev.some((r) => sameResponse(r, response)))
+        return [...prev, response];
+      return prev.map((r) => (sameResponse(r, response) ? response : r));
     });
   };
 
-  // Unblock a specific IP
-  const unblockIP = (attackerIP: string) => {
-    EventsEmit("unblock", attackerIP);
-    setBlockedIPs((prev) => prev.filter((ip) => ip !== attackerIP));
+  // Lift a single block or throttle
+  const unblockIP = (response: any) => {
+    UnblockResponse(response)
+      .then(() =>
+        setBlockedIPs((prev) => prev.filter((r) => !sameResponse(r, response)))
+      )
+      .catch(console.error);
+  };
+
+  // Describe which traffic of the address a response covers
+  const formatScope = (r: any) => {
+    let scope = r.protocol ? r.protocol.toUpperCase() : "all traffic";
+    if (r.port) scope += `/${r.port}`;
+    if (r.direction === "in") scope += " (inbound)";
+    if (r.direction === "out") scope += " (outbound)";
+    return scope;
   };
 
+  // Describe the parameters of a response
+  const formatResponse = (r: any) => {
+    switch (r.kind) {
+      case "rate_limit":
+        return `rate limit ${r.rate}`;
+      case "conn_limit":
+        return `max ${r.max_conns} connections`;
+      case "reject":
+        return `reject (${r.reject_with || "icmp-port-unreachable"})`;
+      default:
+        return r.kind;
+    }
+  };
+
+  const severityNames = ["", "low", "medium", "high", "critical"];
+
   // Function to format time in UTC+03:00 (Asia/Riyadh)
-  const formatStartTime = (timestamp: number) => {
+  const formatStartTime = (timestamp: number | string) => {
     return new Date(timestamp).toLocaleString("en-US", {
       timeZone: "Asia/Riyadh",
       hour12: false, // 24-hour format
@@ -135,7 +198,7 @@ export default function Home() {
           IPS Monitoring
         </h1>
 
-        {(tcpCollectorOn || udpCollectorOn || icmpCollectorOn) && (
+        {avoidBlocking && (
           <div className="p-3 bg-yellow-100 border border-yellow-400 text-yellow-800 rounded-md text-sm text-center md:text-left">
             ⚠️ No automatic IP Blocking while in collector mode.
           </div>
@@ -163,10 +226,7 @@ export default function Home() {
                     <div className="flex items-center space-x-2">
                       <Switch
                         checked={unswOn}
-                        onCheckedChange={(val) => {
-                          setUnswOn(val);
-                          EventsEmit("detector", "unswb");
-                        }}
+                        onCheckedChange={(val) => setDetector("unswb", val)}
                       />
                     </div>
                   </td>
@@ -175,10 +235,7 @@ export default function Home() {
                     <div className="flex items-center space-x-2">
                       <Switch
                         checked={ownOn}
-                        onCheckedChange={(val) => {
-                          setOwnOn(val);
-                          EventsEmit("detector", "own");
-                        }}
+                        onCheckedChange={(val) => setDetector("own", val)}
                       />
                     </div>
                   </td>
@@ -187,10 +244,7 @@ export default function Home() {
                     <div className="flex items-center space-x-2">
                       <Switch
                         checked={snortOn}
-                        onCheckedChange={(val) => {
-                          setSnortOn(val);
-                          EventsEmit("detector", "snort");
-                        }}
+                        onCheckedChange={(val) => setDetector("snort", val)}
                       />
                     </div>
                   </td>
@@ -223,10 +277,7 @@ export default function Home() {
                       <Switch
                         id="tcp-collector-mode"
                         checked={tcpCollectorOn}
-                        onCheckedChange={() => {
-                          EventsEmit("csv", "tcp");
-                          setTcpCollectorOn((prev) => !prev);
-                        }}
+                        onCheckedChange={(val) => setCapture("tcp", val)}
                       />
                       <span
                         className={`font-semibold ${
@@ -240,10 +291,7 @@ export default function Home() {
                       <Switch
                         id="udp-collector-mode"
                         checked={udpCollectorOn}
-                        onCheckedChange={() => {
-                          setUdpCollectorOn((prev) => !prev);
-                          EventsEmit("csv", "udp");
-                        }}
+                        onCheckedChange={(val) => setCapture("udp", val)}
                       />
                       <span
                         className={`font-semibold ${
@@ -257,10 +305,7 @@ export default function Home() {
                       <Switch
                         id="icmp-collector-mode"
                         checked={icmpCollectorOn}
-                        onCheckedChange={() => {
-                          EventsEmit("csv", "icmp");
-                          setIcmpCollectorOn((prev) => !prev);
-                        }}
+                        onCheckedChange={(val) => setCapture("icmp", val)}
                       />
                       <span
                         className={`font-semibold ${
@@ -285,27 +330,38 @@ export default function Home() {
             <table className="w-full table-auto border-collapse text-sm">
               <thead>
                 <tr className="bg-gray-100 text-left">
-                  <th className="p-2">Method</th>
+                  <th className="p-2">Severity</th>
+                  <th className="p-2">Detectors</th>
                   <th className="p-2">Protocol</th>
                   <th className="p-2">Attacker IP</th>
-                  <th className="p-2">Target Port</th>
+                  <th className="p-2">Target</th>
                   <th className="p-2">Message</th>
+                  <th className="p-2">Hits</th>
                   <th className="p-2">Status</th>
                   <th className="p-2">Time</th>
                 </tr>
               </thead>
               <tbody>
-                {alerts.map((alert, index) => (
-                  <tr key={index} className="border-t">
-                    <td className="p-2">{alert.Method}</td>
-                    <td className="p-2">{alert.Protocol}</td>
+                {alerts.map((alert) => (
+                  <tr key={alert.Id} className="border-t">
+                    <td className="p-2">{severityNames[alert.Severity]}</td>
+                    <td className="p-2">{alert.Detectors.join(", ")}</td>
+                    <td className="p-2">{alert.Protocols.join(", ")}</td>
                     <td className="p-2">{alert.Attacker_ip}</td>
-                    <td className="p-2">{alert.Target_port}</td>
-                    <td className="p-2">{alert.Message}</td>
+                    <td className="p-2">
+                      {alert.Target_ip}
+                      {alert.Target_ports.length > 0
+                        ? ":" + alert.Target_ports.join(",")
+                        : ""}
+                    </td>
+                    <td className="p-2">
+                      {alert.Evidence.map((e: any) => e.Message).join(" | ")}
+                    </td>
+                    <td className="p-2">{alert.Hits}</td>
                     <td className="p-2">
                       <span
                         className={`inline-block w-3 h-3 rounded-full ${
-                          alert.Status === "active"
+                          alert.Status === "open"
                             ? "bg-green-500"
                             : "bg-red-500"
                         }`}
@@ -313,7 +369,7 @@ export default function Home() {
                       />
                     </td>
                     <td className="p-2">
-                      {alert.startTime ? formatStartTime(alert.startTime) : "—"}
+                      {alert.First_seen ? formatStartTime(alert.First_seen) : "—"}
                     </td>
                   </tr>
                 ))}
@@ -334,16 +390,30 @@ export default function Home() {
               <thead>
                 <tr className="bg-gray-100 text-left">
                   <th className="p-2">Attacker IP</th>
+                  <th className="p-2">Response</th>
+                  <th className="p-2">Scope</th>
                   <th className="p-2">Action</th>
                 </tr>
               </thead>
               <tbody>
-                {blockedIPs.map((ip, index) => (
+                {blockedIPs.map((r, index) => (
                   <tr key={index} className="border-t">
-                    <td className="p-2">{ip}</td>
+                    <td className="p-2">
+                      {r.ip}
+                      {r.members && (
+                        <span
+                          className="ml-2 text-gray-500"
+                          title={r.members.join(", ")}>
+                          ({r.members.length} addresses
+                          {r.asn ? `, AS${r.asn}` : ""})
+                        </span>
+                      )}
+                    </td>
+                    <td className="p-2">{formatResponse(r)}</td>
+                    <td className="p-2">{formatScope(r)}</td>
                     <td className="p-2">
                       <button
-                        onClick={() => unblockIP(ip)}
+                        onClick={() => unblockIP(r)}
                         className="text-red-600">
                         Unblock
                       </button>
@@ -1,4 +1,43 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
+import {engine} from '../models';
+import {iptables} from '../models';
+import {model} from '../models';
+import {native} from '../models';
+import {policy} from '../models';
+import {store} from '../models';
+import {suppress} from '../models';
 
-export function Greet(arg1:string):Promise<string>;
+export function BlockManual(arg1:string,arg2:number,arg3:string):Promise<void>;
+
+export function DryRunPolicy(arg1:policy.Config,arg2:store.Filter):Promise<Array<policy.Result>>;
+
+export function GetFirewallConfig():Promise<iptables.Config>;
+
+export function GetNativeConfig():Promise<native.Config>;
+
+export function GetPolicy():Promise<policy.Config>;
+
+export function GetStatus():Promise<engine.Status>;
+
+export function GetSuppression():Promise<suppress.Config>;
+
+export function ListAlerts(arg1:store.Filter):Promise<Array<model.Detection>>;
+
+export function ListBlocked():Promise<Array<iptables.Response>>;
+
+export function SetCSVCapture(arg1:string,arg2:boolean):Promise<engine.Status>;
+
+export function SetDetectorEnabled(arg1:string,arg2:boolean):Promise<engine.Status>;
+
+export function SetFirewallConfig(arg1:iptables.Config):Promise<void>;
+
+export function SetNativeConfig(arg1:native.Config):Promise<void>;
+
+export function SetPolicy(arg1:policy.Config):Promise<void>;
+
+export function SetSuppression(arg1:suppress.Config):Promise<void>;
+
+export function Unblock(arg1:string):Promise<void>;
+
+export function UnblockResponse(arg1:iptables.Response):Promise<void>;
@@ -2,6 +2,70 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
-export function Greet(arg1) {
-  return window['go']['main']['App']['Greet'](arg1);
+export function BlockManual(arg1, arg2, arg3) {
+  return window['go']['main']['App']['BlockManual'](arg1, arg2, arg3);
+}
+
+export function DryRunPolicy(arg1, arg2) {
+  return window['go']['main']['App']['DryRunPolicy'](arg1, arg2);
+}
+
+export function GetFirewallConfig() {
+  return window['go']['main']['App']['GetFirewallConfig']();
+}
+
+export function GetNativeConfig() {
+  return window['go']['main']['App']['GetNativeConfig']();
+}
+
+export function GetPolicy() {
+  return window['go']['main']['App']['GetPolicy']();
+}
+
+export function GetStatus() {
+  return window['go']['main']['App']['GetStatus']();
+}
+
+export function GetSuppression() {
+  return window['go']['main']['App']['GetSuppression']();
+}
+
+export function ListAlerts(arg1) {
+  return window['go']['main']['App']['ListAlerts'](arg1);
+}
+
+export function ListBlocked() {
+  return window['go']['main']['App']['ListBlocked']();
+}
+
+export function SetCSVCapture(arg1, arg2) {
+  return window['go']['main']['App']['SetCSVCapture'](arg1, arg2);
+}
+
+export function SetDetectorEnabled(arg1, arg2) {
+  return window['go']['main']['App']['SetDetectorEnabled'](arg1, arg2);
+}
+
+export function SetFirewallConfig(arg1) {
+  return window['go']['main']['App']['SetFirewallConfig'](arg1);
+}
+
+export function SetNativeConfig(arg1) {
+  return window['go']['main']['App']['SetNativeConfig'](arg1);
+}
+
+export function SetPolicy(arg1) {
+  return window['go']['main']['App']['SetPolicy'](arg1);
+}
+
+export function SetSuppression(arg1) {
+  return window['go']['main']['App']['SetSuppression'](arg1);
+}
+
+export function Unblock(arg1) {
+  return window['go']['main']['App']['Unblock'](arg1);
+}
+
+export function UnblockResponse(arg1) {
+  return window['go']['main']['App']['UnblockResponse'](arg1);
 }
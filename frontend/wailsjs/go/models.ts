@@ -0,0 +1,914 @@
+export namespace engine {
+	
+	export class Status {
+	    running: boolean;
+	    detectors: Record<string, boolean>;
+	    csv_capture: Record<string, boolean>;
+	    avoid_blocking: boolean;
+	    blocked: number;
+	    open_incidents: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Status(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.running = source["running"];
+	        this.detectors = source["detectors"];
+	        this.csv_capture = source["csv_capture"];
+	        this.avoid_blocking = source["avoid_blocking"];
+	        this.blocked = source["blocked"];
+	        this.open_incidents = source["open_incidents"];
+	    }
+	}
+
+}
+
+export namespace iptables {
+	
+	export class AggregationConfig {
+	    enabled: boolean;
+	    threshold: number;
+	    prefix_length: number;
+	    max_prefix: number;
+	    asn_file?: string;
+	    expand_on_unblock: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new AggregationConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.threshold = source["threshold"];
+	        this.prefix_length = source["prefix_length"];
+	        this.max_prefix = source["max_prefix"];
+	        this.asn_file = source["asn_file"];
+	        this.expand_on_unblock = source["expand_on_unblock"];
+	    }
+	}
+	export class Config {
+	    allowlist: string[];
+	    aggregation: AggregationConfig;
+	
+	    static createFrom(source: any = {}) {
+	        return new Config(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.allowlist = source["allowlist"];
+	        this.aggregation = this.convertValues(source["aggregation"], AggregationConfig);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Response {
+	    ip: string;
+	    kind: string;
+	    protocol?: string;
+	    port?: string;
+	    direction?: string;
+	    expires?: any;
+	    members?: string[];
+	    asn?: string;
+	    reason?: string;
+	    rate?: string;
+	    burst?: number;
+	    max_conns?: number;
+	    reject_with?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Response(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.ip = source["ip"];
+	        this.kind = source["kind"];
+	        this.protocol = source["protocol"];
+	        this.port = source["port"];
+	        this.direction = source["direction"];
+	        this.expires = this.convertValues(source["expires"], null);
+	        this.members = source["members"];
+	        this.asn = source["asn"];
+	        this.reason = source["reason"];
+	        this.rate = source["rate"];
+	        this.burst = source["burst"];
+	        this.max_conns = source["max_conns"];
+	        this.reject_with = source["reject_with"];
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace model {
+	
+	export class Detection {
+	    Method: string;
+	    Protocol: string;
+	    Attacker_ip: string;
+	    Target_ip?: string;
+	    Target_port: string;
+	    Message: string;
+	    Signature_id?: string;
+	    Severity: number;
+	    Timestamp: any;
+	    Evidence?: Record<string, any>;
+	
+	    static createFrom(source: any = {}) {
+	        return new Detection(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.Method = source["Method"];
+	        this.Protocol = source["Protocol"];
+	        this.Attacker_ip = source["Attacker_ip"];
+	        this.Target_ip = source["Target_ip"];
+	        this.Target_port = source["Target_port"];
+	        this.Message = source["Message"];
+	        this.Signature_id = source["Signature_id"];
+	        this.Severity = source["Severity"];
+	        this.Timestamp = this.convertValues(source["Timestamp"], null);
+	        this.Evidence = source["Evidence"];
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace native {
+	
+	export class AmplificationConfig {
+	    enabled: boolean;
+	    window: number;
+	    services: AmplificationService[];
+	    victim_bytes: number;
+	    reflectors: number;
+	    reflector_bytes: number;
+	    cooldown: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new AmplificationConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.window = source["window"];
+	        this.services = this.convertValues(source["services"], AmplificationService);
+	        this.victim_bytes = source["victim_bytes"];
+	        this.reflectors = source["reflectors"];
+	        this.reflector_bytes = source["reflector_bytes"];
+	        this.cooldown = source["cooldown"];
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class AmplificationService {
+	    name: string;
+	    port: number;
+	    ratio: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new AmplificationService(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.port = source["port"];
+	        this.ratio = source["ratio"];
+	    }
+	}
+	export class AuthService {
+	    name: string;
+	    ports: number[];
+	    attempts: number;
+	    max_duration: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new AuthService(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.ports = source["ports"];
+	        this.attempts = source["attempts"];
+	        this.max_duration = source["max_duration"];
+	    }
+	}
+	export class BruteForceConfig {
+	    enabled: boolean;
+	    window: number;
+	    services: AuthService[];
+	    distributed_sources: number;
+	    distributed_attempts: number;
+	    cooldown: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new BruteForceConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.window = source["window"];
+	        this.services = this.convertValues(source["services"], AuthService);
+	        this.distributed_sources = source["distributed_sources"];
+	        this.distributed_attempts = source["distributed_attempts"];
+	        this.cooldown = source["cooldown"];
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Config {
+	    tcp: TCPConfig;
+	    reassembly: ReassemblyConfig;
+	    port_scan: PortScanConfig;
+	    syn_flood: SynFloodConfig;
+	    dns: DNSConfig;
+	    http: HTTPConfig;
+	    brute_force: BruteForceConfig;
+	    icmp: ICMPConfig;
+	    slow_http: SlowHTTPConfig;
+	    amplification: AmplificationConfig;
+	    tls: TLSConfig;
+	
+	    static createFrom(source: any = {}) {
+	        return new Config(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.tcp = this.convertValues(source["tcp"], TCPConfig);
+	        this.reassembly = this.convertValues(source["reassembly"], ReassemblyConfig);
+	        this.port_scan = this.convertValues(source["port_scan"], PortScanConfig);
+	        this.syn_flood = this.convertValues(source["syn_flood"], SynFloodConfig);
+	        this.dns = this.convertValues(source["dns"], DNSConfig);
+	        this.http = this.convertValues(source["http"], HTTPConfig);
+	        this.brute_force = this.convertValues(source["brute_force"], BruteForceConfig);
+	        this.icmp = this.convertValues(source["icmp"], ICMPConfig);
+	        this.slow_http = this.convertValues(source["slow_http"], SlowHTTPConfig);
+	        this.amplification = this.convertValues(source["amplification"], AmplificationConfig);
+	        this.tls = this.convertValues(source["tls"], TLSConfig);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DNSConfig {
+	    enabled: boolean;
+	    window: number;
+	    long_label: number;
+	    entropy_length: number;
+	    min_entropy: number;
+	    tunnel_queries: number;
+	    txt_queries: number;
+	    txt_bytes: number;
+	    nxdomain: number;
+	    reflection_responses: number;
+	    amplification_size: number;
+	    amplification_responses: number;
+	    cooldown: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new DNSConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.window = source["window"];
+	        this.long_label = source["long_label"];
+	        this.entropy_length = source["entropy_length"];
+	        this.min_entropy = source["min_entropy"];
+	        this.tunnel_queries = source["tunnel_queries"];
+	        this.txt_queries = source["txt_queries"];
+	        this.txt_bytes = source["txt_bytes"];
+	        this.nxdomain = source["nxdomain"];
+	        this.reflection_responses = source["reflection_responses"];
+	        this.amplification_size = source["amplification_size"];
+	        this.amplification_responses = source["amplification_responses"];
+	        this.cooldown = source["cooldown"];
+	    }
+	}
+	export class HTTPConfig {
+	    enabled: boolean;
+	    ports: number[];
+	    max_body: number;
+	    sql_injection: boolean;
+	    xss: boolean;
+	    path_traversal: boolean;
+	    command_injection: boolean;
+	    cooldown: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new HTTPConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.ports = source["ports"];
+	        this.max_body = source["max_body"];
+	        this.sql_injection = source["sql_injection"];
+	        this.xss = source["xss"];
+	        this.path_traversal = source["path_traversal"];
+	        this.command_injection = source["command_injection"];
+	        this.cooldown = source["cooldown"];
+	    }
+	}
+	export class ICMPConfig {
+	    enabled: boolean;
+	    window: number;
+	    max_payload: number;
+	    min_entropy: number;
+	    tunnel_packets: number;
+	    sweep_hosts: number;
+	    redirects: number;
+	    unreachable: number;
+	    min_mtu: number;
+	    cooldown: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ICMPConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.window = source["window"];
+	        this.max_payload = source["max_payload"];
+	        this.min_entropy = source["min_entropy"];
+	        this.tunnel_packets = source["tunnel_packets"];
+	        this.sweep_hosts = source["sweep_hosts"];
+	        this.redirects = source["redirects"];
+	        this.unreachable = source["unreachable"];
+	        this.min_mtu = source["min_mtu"];
+	        this.cooldown = source["cooldown"];
+	    }
+	}
+	export class PortScanConfig {
+	    enabled: boolean;
+	    window: number;
+	    ports: number;
+	    hosts: number;
+	    stealth_ports: number;
+	    distributed_sources: number;
+	    slow_window: number;
+	    slow_ports: number;
+	    cooldown: number;
+	    max_tracked: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new PortScanConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.window = source["window"];
+	        this.ports = source["ports"];
+	        this.hosts = source["hosts"];
+	        this.stealth_ports = source["stealth_ports"];
+	        this.distributed_sources = source["distributed_sources"];
+	        this.slow_window = source["slow_window"];
+	        this.slow_ports = source["slow_ports"];
+	        this.cooldown = source["cooldown"];
+	        this.max_tracked = source["max_tracked"];
+	    }
+	}
+	export class ReassemblyConfig {
+	    max_streams: number;
+	    stream_buffer: number;
+	    total_buffer: number;
+	    overlap: string;
+	    timeout: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ReassemblyConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.max_streams = source["max_streams"];
+	        this.stream_buffer = source["stream_buffer"];
+	        this.total_buffer = source["total_buffer"];
+	        this.overlap = source["overlap"];
+	        this.timeout = source["timeout"];
+	    }
+	}
+	export class SlowHTTPConfig {
+	    enabled: boolean;
+	    ports: number[];
+	    header_timeout: number;
+	    body_timeout: number;
+	    min_body_rate: number;
+	    connections: number;
+	    cooldown: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SlowHTTPConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.ports = source["ports"];
+	        this.header_timeout = source["header_timeout"];
+	        this.body_timeout = source["body_timeout"];
+	        this.min_body_rate = source["min_body_rate"];
+	        this.connections = source["connections"];
+	        this.cooldown = source["cooldown"];
+	    }
+	}
+	export class SynFloodConfig {
+	    enabled: boolean;
+	    window: number;
+	    syn_rate: number;
+	    half_open: number;
+	    source_syn_rate: number;
+	    source_half_open: number;
+	    max_completion: number;
+	    spoofed_sources: number;
+	    spoofed_syns_per_source: number;
+	    cooldown: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SynFloodConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.window = source["window"];
+	        this.syn_rate = source["syn_rate"];
+	        this.half_open = source["half_open"];
+	        this.source_syn_rate = source["source_syn_rate"];
+	        this.source_half_open = source["source_half_open"];
+	        this.max_completion = source["max_completion"];
+	        this.spoofed_sources = source["spoofed_sources"];
+	        this.spoofed_syns_per_source = source["spoofed_syns_per_source"];
+	        this.cooldown = source["cooldown"];
+	    }
+	}
+	export class TCPConfig {
+	    max_connections: number;
+	    half_open_timeout: number;
+	    established_timeout: number;
+	    closing_timeout: number;
+	    midstream_timeout: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new TCPConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.max_connections = source["max_connections"];
+	        this.half_open_timeout = source["half_open_timeout"];
+	        this.established_timeout = source["established_timeout"];
+	        this.closing_timeout = source["closing_timeout"];
+	        this.midstream_timeout = source["midstream_timeout"];
+	    }
+	}
+	export class TLSConfig {
+	    enabled: boolean;
+	    ports: number[];
+	    blocklist: TLSFingerprint[];
+	    cooldown: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new TLSConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.ports = source["ports"];
+	        this.blocklist = this.convertValues(source["blocklist"], TLSFingerprint);
+	        this.cooldown = source["cooldown"];
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class TLSFingerprint {
+	    fingerprint: string;
+	    name: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new TLSFingerprint(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.fingerprint = source["fingerprint"];
+	        this.name = source["name"];
+	    }
+	}
+
+}
+
+export namespace policy {
+	
+	export class Action {
+	    type: string;
+	    ttl?: number;
+	    rate?: string;
+	    burst?: number;
+	    max_conns?: number;
+	    reject_with?: string;
+	    command?: string[];
+	    scope?: string;
+	    direction?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Action(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.type = source["type"];
+	        this.ttl = source["ttl"];
+	        this.rate = source["rate"];
+	        this.burst = source["burst"];
+	        this.max_conns = source["max_conns"];
+	        this.reject_with = source["reject_with"];
+	        this.command = source["command"];
+	        this.scope = source["scope"];
+	        this.direction = source["direction"];
+	    }
+	}
+	export class Config {
+	    policies: Policy[];
+	    default: Action[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Config(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.policies = this.convertValues(source["policies"], Policy);
+	        this.default = this.convertValues(source["default"], Action);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Policy {
+	    id: string;
+	    detector?: string;
+	    signature_id?: string;
+	    message?: string;
+	    min_severity?: number;
+	    actions: Action[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Policy(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.detector = source["detector"];
+	        this.signature_id = source["signature_id"];
+	        this.message = source["message"];
+	        this.min_severity = source["min_severity"];
+	        this.actions = this.convertValues(source["actions"], Action);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Result {
+	    detection: model.Detection;
+	    policy_id: string;
+	    actions: Action[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Result(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.detection = this.convertValues(source["detection"], model.Detection);
+	        this.policy_id = source["policy_id"];
+	        this.actions = this.convertValues(source["actions"], Action);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace store {
+	
+	export class Filter {
+	    attacker_ip?: string;
+	    target_ip?: string;
+	    detector?: string;
+	    signature_id?: string;
+	    min_severity?: number;
+	    since?: any;
+	    until?: any;
+	    limit?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Filter(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.attacker_ip = source["attacker_ip"];
+	        this.target_ip = source["target_ip"];
+	        this.detector = source["detector"];
+	        this.signature_id = source["signature_id"];
+	        this.min_severity = source["min_severity"];
+	        this.since = this.convertValues(source["since"], null);
+	        this.until = this.convertValues(source["until"], null);
+	        this.limit = source["limit"];
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace suppress {
+	
+	export class Config {
+	    suppress: Rule[];
+	    thresholds: Threshold[];
+	    overrides: Override[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Config(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.suppress = this.convertValues(source["suppress"], Rule);
+	        this.thresholds = this.convertValues(source["thresholds"], Threshold);
+	        this.overrides = this.convertValues(source["overrides"], Override);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Override {
+	    signature_id: string;
+	    action: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Override(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.signature_id = source["signature_id"];
+	        this.action = source["action"];
+	    }
+	}
+	export class Rule {
+	    id: string;
+	    signature_id?: string;
+	    message?: string;
+	    source_cidr?: string;
+	    target_cidr?: string;
+	    detector?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Rule(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.signature_id = source["signature_id"];
+	        this.message = source["message"];
+	        this.source_cidr = source["source_cidr"];
+	        this.target_cidr = source["target_cidr"];
+	        this.detector = source["detector"];
+	    }
+	}
+	export class Threshold {
+	    id: string;
+	    signature_id?: string;
+	    detector?: string;
+	    count: number;
+	    seconds: number;
+	    track: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Threshold(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.signature_id = source["signature_id"];
+	        this.detector = source["detector"];
+	        this.count = source["count"];
+	        this.seconds = source["seconds"];
+	        this.track = source["track"];
+	    }
+	}
+
+}
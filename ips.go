@@ -1,217 +1,173 @@
 package main
 
 import (
-	"context"
-	"fmt"
-	"main/iptables"
+	"main/api"
+	"main/engine"
+	"main/eve"
+	"main/logging"
+	"main/metrics"
 	"main/model"
-	"main/service"
+	"main/siem"
+	"main/webhook"
 	"os"
 	"strconv"
 	"time"
 
-	"github.com/florianl/go-nfqueue"
 	"github.com/joho/godotenv"
-	"github.com/mdlayher/netlink"
 )
 
-var ToggleOwn bool = true
-var ToggleSnort bool = true
-var ToggleUNSW bool = true
-var cancelListen context.CancelFunc
-
-var StartOwn bool = true
+var logger = logging.For("ips")
 
 func StartSystem() {
 	time.Sleep(7 * time.Second)
-	fmt.Println("Starting IPS System...")
 
 	// Load .env
 	if err := godotenv.Load(".env"); err != nil {
-		fmt.Println("Error loading .env file:", err)
+		logger.Error("failed to load .env file", "error", err)
 		os.Exit(1)
 	}
 
-	// Prepare Netfilter queues
-	if err := iptables.PrepareNFQueues(); err != nil {
-		fmt.Println("Error preparing NFQueues:", err)
+	// Configure the log output before anything else logs
+	if err := logging.Setup(os.Getenv("LOG_FORMAT"), os.Stdout, logLevel()); err != nil {
+		logger.Error("invalid logging configuration", "error", err)
 		os.Exit(1)
 	}
-
-	alert := make(chan model.Detection)
-	
-	// Start handlers only if toggle is ON
-	var ctx context.Context
-	ctx, cancelListen = context.WithCancel(context.Background())
-	// Monitor toggleListenqueue to cancel context when toggled off
-	var stopped bool = true
-	var stoppedUNSW bool = true
-	go func() {
-		for {
-			time.Sleep(1 * time.Second)
-			if !ToggleOwn && cancelListen != nil {
-				fmt.Println("🛑 toggleListenqueue is false. Cancelling all handlers...")
-				cancelListen()
-				cancelListen = nil
-			}else if ToggleOwn && StartOwn { 
-				ctx, cancelListen = context.WithCancel(context.Background())
-				go startAIdetect(ctx, alert)
-				StartOwn = false
-			}
-
-			if !ToggleSnort && !stopped{
-				stopped = service.StopSnort();
-			}else if ToggleSnort && stopped{
-				go service.StartSnort(alert)
-				stopped = false
-			}
-
-			if !stoppedUNSW && !ToggleUNSW{
-				service.StopUNSWRunnable()
-				stoppedUNSW = true
-			}else if ToggleUNSW && stoppedUNSW{ 
-				fmt.Println("🚀 Starting UNSW runner (Python)...")
-				stoppedUNSW = false
-
-				go func() {
-					err := service.StartUNSWRunnable(alert)
-					if err != nil {
-						fmt.Println("❌ Error starting UNSW runner:", err)
-					} else {
-						fmt.Println("✅ UNSW runner started and output reading goroutine launched")
-					}
-				}()
-			}
-
-		}
-	}()
-
-	go listenAttack(alert)
-	
-	// Keep main alive indefinitely
-	select {}
-}
-
-func startAIdetect(ctx context.Context, alert chan model.Detection) { 
-	// Initialize services
-	tcpService := service.NewTCP(alert)
-	udpService := service.NewUDP(alert)
-	icmp := service.NewICMP(alert)
-
-	// Define queues and corresponding handlers
-	queues := map[string]func([]byte){
-		"TCP_QUEUE":  tcpService.AnalyzeTCP,
-		"UDP_QUEUE":  udpService.AnalyzeUDP,
-		"ICMP_QUEUE": icmp.AnalyzeICMP,
+	if err := logging.SetLevels(os.Getenv("LOG_LEVELS")); err != nil {
+		logger.Error("invalid LOG_LEVELS", "error", err)
+		os.Exit(1)
 	}
+	logger.Info("starting IPS system")
 
-	// Start queue handlers with shared context
-	for envVar, handler := range queues {
+	queues := make(map[string]uint16)
+	for proto, envVar := range map[string]string{"tcp": "TCP_QUEUE", "udp": "UDP_QUEUE", "icmp": "ICMP_QUEUE"} {
 		queueNum, err := strconv.Atoi(os.Getenv(envVar))
 		if err != nil {
-			fmt.Printf("Invalid NFQUEUE number for %s: %v\n", envVar, err)
+			logger.Error("invalid NFQUEUE number", "variable", envVar, "error", err)
 			os.Exit(1)
 		}
-		go queueHandler(ctx, uint16(queueNum), handler)
+		queues[proto] = uint16(queueNum)
 	}
 
-	
-}
-
-func queueHandler(ctx context.Context, queueNum uint16, packetHandler func([]byte)) {
-	config := nfqueue.Config{
-		NfQueue:      queueNum,
-		MaxPacketLen: 0xFFFF,
-		MaxQueueLen:  0xFF,
-		Copymode:     nfqueue.NfQnlCopyPacket,
-		WriteTimeout: 15 * time.Millisecond,
+	// Load suppression rules, policies, the alert store and the firewall config
+	ips, err := engine.New(engine.Config{
+		SuppressConfig: configPath("SUPPRESS_CONFIG", "config/suppress.json"),
+		PolicyConfig:   configPath("POLICY_CONFIG", "config/policy.json"),
+		FirewallConfig: configPath("FIREWALL_CONFIG", "config/firewall.json"),
+		NativeConfig:   configPath("NATIVE_CONFIG", "config/native.json"),
+		AlertStore:     configPath("ALERT_STORE", "logs/alerts.jsonl"),
+		AlertCapacity:  10000,
+		AlertMaxSize:   int64(envInt("ALERT_STORE_MAX_SIZE_MB", 100)) << 20,
+		AlertMaxFiles:  envInt("ALERT_STORE_MAX_FILES", 5),
+		Queues:         queues,
+	})
+	if err != nil {
+		logger.Error("failed to load engine", "error", err)
+		os.Exit(1)
 	}
 
-	nf, err := nfqueue.Open(&config)
+	// Forward engine events to the GUI
+	events, _ := ips.Subscribe("gui", 256)
+	go forwardEvents(events)
+	ipsEngine.Store(ips)
+
+	// Export detections to the configured SIEM outputs
+	exporter, err := siem.Load(configPath("SIEM_CONFIG", "config/siem.json"))
 	if err != nil {
-		fmt.Printf("Could not open nfqueue socket for queue %d: %v\n", queueNum, err)
-		return
+		logger.Error("failed to load SIEM config", "error", err)
+		os.Exit(1)
 	}
-	defer nf.Close()
+	incidents, _ := ips.Subscribe("siem", 1024)
+	go exportIncidents(incidents, exporter)
 
-	if err := nf.SetOption(netlink.NoENOBUFS, true); err != nil {
-		fmt.Printf("Failed to set netlink option for queue %d: %v\n", queueNum, err)
-		return
+	// Notify the configured webhook endpoints
+	webhooks, err := webhook.Load(configPath("WEBHOOK_CONFIG", "config/webhooks.json"))
+	if err != nil {
+		logger.Error("failed to load webhook config", "error", err)
+		os.Exit(1)
 	}
-
-	// NFQUEUE packet processing function
-	fn := func(a nfqueue.Attribute) int {
-		if a.PacketID == nil || a.Payload == nil {
-			fmt.Println("Received invalid packet attributes")
-			return -1
+	webhookEvents, _ := ips.Subscribe("webhook", 1024)
+	go webhooks.Run(webhookEvents)
+
+	// Write the EVE JSON event log when configured
+	if path := os.Getenv("EVE_LOG"); path != "" {
+		eveLog, err := eve.Open(eve.Config{
+			Path:          path,
+			MaxSize:       int64(envInt("EVE_MAX_SIZE_MB", 100)) << 20,
+			MaxFiles:      envInt("EVE_MAX_FILES", 5),
+			StatsInterval: time.Duration(envInt("EVE_STATS_INTERVAL", 60)) * time.Second,
+		})
+		if err != nil {
+			logger.Error("failed to open EVE log", "error", err)
+			os.Exit(1)
 		}
-
-		packetHandler(*a.Payload)
-
-		nf.SetVerdict(*a.PacketID, nfqueue.NfAccept)
-		return 0
+		eveEvents, _ := ips.Subscribe("eve", 4096)
+		go eveLog.Run(eveEvents, ips.Status)
 	}
 
-	if err := nf.RegisterWithErrorFunc(ctx, fn, func(e error) int {
-		fmt.Println("NFQUEUE error:", e)
-		return -1
-	}); err != nil {
-		fmt.Println("Failed to register NFQUEUE handler:", err)
-		return
+	// Prepare Netfilter queues and start the detectors
+	if err := ips.Start(); err != nil {
+		logger.Error("failed to start engine", "error", err)
+		os.Exit(1)
 	}
 
-	fmt.Printf("Listening on NFQueue [%d]...\n", queueNum)
-
-	<-ctx.Done()
-	fmt.Printf("🛑 Queue [%d] handler stopped\n", queueNum)
-}
-
-func listenAttack(ch <-chan model.Detection) {
-	alertMap := make(map[string][]model.Detection)
-	ticker := time.NewTicker(4 * time.Second)
-	defer ticker.Stop()
+	// Serve the management API
+	token := os.Getenv("API_TOKEN")
+	if token == "" {
+		if token, err = api.LoadToken(configPath("API_TOKEN_FILE", "config/api.token")); err != nil {
+			logger.Error("failed to load API token", "error", err)
+			os.Exit(1)
+		}
+	}
+	go func() {
+		if err := api.New(ips, webhooks, token).Serve(configPath("API_ADDR", api.DefaultAddr)); err != nil {
+			logger.Error("management API stopped", "error", err)
+		}
+	}()
 
-	for {
-		select {
-		case alert := <-ch:
-			if alert.AttackerIP == "172.30.0.1" || alert.AttackerIP == "172.30.0.2" ||  alert.AttackerIP ==  "127.0.0.1" || alert.AttackerIP == "127.0.0.11" {
-				continue
-			}
-			if alert.Method == "Rule Detection" {
-				if alert.Message == "POLICY-OTHER HTTP request by IPv4 address attempt" {
-					continue
-				}
-				alertMap[alert.AttackerIP] = append(alertMap[alert.AttackerIP], alert)
-			} else {
-				EmitAlert(alert)
-
-				if alert.Method == "AI Detection"{ 
-					if ok := iptables.BlockIP(alert.AttackerIP); ok != -1 {
-						EmitBlockIP(alert.AttackerIP)
-					}
-				}
-				
+	// Expose Prometheus metrics without authentication when configured
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		go func() {
+			if err := metrics.Serve(addr); err != nil {
+				logger.Error("metrics endpoint stopped", "error", err)
 			}
+		}()
+	}
 
-		case <-ticker.C:
-			if len(alertMap) > 0 {
-				for ip, alerts := range alertMap {
-					if ip == "172.30.0.1" || ip == "172.30.0.2" || ip ==  "127.0.0.1" || ip == "127.0.0.11" {
-						continue
-					}
-
-					last := alerts[len(alerts)-1]
+	// Keep main alive indefinitely
+	select {}
+}
 
-					EmitAlert(last)
+// exportIncidents hands every incident event to the SIEM exporter, the
+// detections they merge are only kept in the alert store and the EVE log
+func exportIncidents(events <-chan engine.Event, exporter *siem.Exporter) {
+	for event := range events {
+		if incident, ok := event.Data.(model.IncidentEvent); ok && event.Type == engine.EventIncident {
+			exporter.ExportIncident(incident)
+		}
+	}
+}
 
-					if ok := iptables.BlockIP(last.AttackerIP); ok != -1 {
-						EmitBlockIP(last.AttackerIP)
-					}
+// logLevel returns the default log level from LOG_LEVEL, "info" when unset
+func logLevel() string {
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		return level
+	}
+	return "info"
+}
 
-				}
+// envInt returns the integer set in envVar, or fallback when it is unset or invalid
+func envInt(envVar string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(envVar))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
 
-				alertMap = make(map[string][]model.Detection)
-			}
-		}
+// configPath returns the path set in envVar, or fallback when it is unset
+func configPath(envVar, fallback string) string {
+	if path := os.Getenv(envVar); path != "" {
+		return path
 	}
+	return fallback
 }
@@ -0,0 +1,342 @@
+package iptables
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Config holds the firewall settings that can be edited at runtime
+type Config struct {
+	Allowlist   []string          `json:"allowlist"` // CIDRs that are never blocked or aggregated over
+	Aggregation AggregationConfig `json:"aggregation"`
+}
+
+// AggregationConfig controls collapsing many blocked addresses into one prefix
+// block. Only permanent drops of the same scope are collapsed, and into an
+// aggregate of that scope: sixteen addresses blocked on tcp/22 become one
+// tcp/22 block of their prefix, which leaves their other traffic alone.
+type AggregationConfig struct {
+	Enabled         bool   `json:"enabled"`
+	Threshold       int    `json:"threshold"`          // blocked addresses in a prefix before it is collapsed
+	PrefixLength    int    `json:"prefix_length"`      // prefix addresses are grouped by, e.g. 24
+	MaxPrefix       int    `json:"max_prefix"`         // broadest prefix an aggregate may cover, e.g. 16
+	ASNFile         string `json:"asn_file,omitempty"` // optional "cidr asn" lines, groups by announced prefix instead
+	ExpandOnUnblock bool   `json:"expand_on_unblock"`  // unblocking re-blocks the remaining members individually
+}
+
+type asnPrefix struct {
+	network *net.IPNet
+	asn     string
+}
+
+var config Config
+var configPath string
+var allowlist []*net.IPNet
+var asnPrefixes []asnPrefix
+
+// expanded remembers prefixes that were split up again so they aren't collapsed right back
+var expanded = make(map[string]bool)
+
+func DefaultConfig() Config {
+	return Config{
+		Allowlist: []string{"127.0.0.0/8", "172.30.0.1/32", "172.30.0.11/32"},
+		Aggregation: AggregationConfig{
+			Enabled:         true,
+			Threshold:       16,
+			PrefixLength:    24,
+			MaxPrefix:       16,
+			ExpandOnUnblock: true,
+		},
+	}
+}
+
+// LoadConfig reads the firewall config from path, creating it with defaults if it doesn't exist
+func LoadConfig(path string) error {
+	configPath = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return SetConfig(DefaultConfig())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read firewall config: %w", err)
+	}
+
+	var loaded Config
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse firewall config: %w", err)
+	}
+
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+	return applyConfig(loaded)
+}
+
+// GetConfig returns a copy of the active firewall config
+func GetConfig() Config {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+
+	c := config
+	c.Allowlist = slices.Clone(config.Allowlist)
+	return c
+}
+
+// SetConfig validates and activates a firewall config, then persists it
+func SetConfig(c Config) error {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+
+	if err := applyConfig(c); err != nil {
+		return err
+	}
+	if configPath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write firewall config: %w", err)
+	}
+	return nil
+}
+
+func applyConfig(c Config) error {
+	var networks []*net.IPNet
+	for _, cidr := range c.Allowlist {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid allowlist entry %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+
+	a := c.Aggregation
+	if a.Enabled {
+		if a.Threshold < 2 {
+			return fmt.Errorf("aggregation threshold must be at least 2")
+		}
+		if a.MaxPrefix < 8 || a.MaxPrefix > 32 {
+			return fmt.Errorf("aggregation max_prefix must be between 8 and 32")
+		}
+		if a.PrefixLength < a.MaxPrefix || a.PrefixLength > 31 {
+			return fmt.Errorf("aggregation prefix_length must be between max_prefix and 31")
+		}
+	}
+
+	var prefixes []asnPrefix
+	if a.ASNFile != "" {
+		var err error
+		if prefixes, err = loadASN(a.ASNFile); err != nil {
+			return err
+		}
+	}
+
+	if c.Allowlist == nil {
+		c.Allowlist = []string{}
+	}
+	config = c
+	allowlist = networks
+	asnPrefixes = prefixes
+	expanded = make(map[string]bool)
+	return nil
+}
+
+// loadASN reads "cidr asn" lines (whitespace or comma separated, # comments)
+func loadASN(path string) ([]asnPrefix, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ASN file: %w", err)
+	}
+	defer file.Close()
+
+	var prefixes []asnPrefix
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if len(fields) < 2 {
+			continue
+		}
+		_, network, err := net.ParseCIDR(fields[0])
+		if err != nil || network.IP.To4() == nil {
+			continue
+		}
+		prefixes = append(prefixes, asnPrefix{network: network, asn: strings.TrimPrefix(strings.ToUpper(fields[1]), "AS")})
+	}
+	return prefixes, scanner.Err()
+}
+
+func aggregation() AggregationConfig {
+	return config.Aggregation
+}
+
+func isAllowlisted(address string) bool {
+	if ip := net.ParseIP(address); ip != nil {
+		for _, network := range allowlist {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	_, prefix, err := net.ParseCIDR(address)
+	return err == nil && overlapsAllowlist(prefix)
+}
+
+func overlapsAllowlist(prefix *net.IPNet) bool {
+	for _, network := range allowlist {
+		if network.Contains(prefix.IP) || prefix.Contains(network.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// coveringAggregate returns the aggregate block of the same scope as the
+// address block b whose prefix contains its address
+func coveringAggregate(b Response) (Response, bool) {
+	parsed := net.ParseIP(b.IP)
+	for _, r := range active {
+		if len(r.Members) == 0 || !r.sameScope(b) {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(r.IP); err == nil && network.Contains(parsed) {
+			return r, true
+		}
+	}
+	return Response{}, false
+}
+
+// aggregatesOf returns the aggregate blocks ip was collapsed into, in any scope
+func aggregatesOf(ip string) []Response {
+	var aggregates []Response
+	for _, r := range active {
+		if slices.Contains(r.Members, ip) {
+			aggregates = append(aggregates, r)
+		}
+	}
+	return aggregates
+}
+
+// aggregatePrefix picks the prefix ip is grouped into: its announced ASN prefix
+// when known and no broader than MaxPrefix, otherwise its /PrefixLength
+func aggregatePrefix(ip net.IP) (*net.IPNet, string) {
+	a := aggregation()
+
+	var best *asnPrefix
+	for i, p := range asnPrefixes {
+		if !p.network.Contains(ip) {
+			continue
+		}
+		if best == nil || prefixOnes(p.network) > prefixOnes(best.network) {
+			best = &asnPrefixes[i]
+		}
+	}
+	if best != nil && prefixOnes(best.network) >= a.MaxPrefix {
+		return best.network, best.asn
+	}
+
+	mask := net.CIDRMask(a.PrefixLength, 32)
+	return &net.IPNet{IP: ip.To4().Mask(mask), Mask: mask}, ""
+}
+
+// aggregate collapses the address blocks of b's scope around its address into
+// one prefix block of that scope once enough are active
+func aggregate(b Response) []change {
+	a := aggregation()
+	parsed := net.ParseIP(b.IP)
+	if !a.Enabled || parsed.To4() == nil {
+		return nil
+	}
+
+	prefix, asn := aggregatePrefix(parsed)
+	agg := Response{IP: prefix.String(), Kind: KindDrop, Protocol: b.Protocol, Port: b.Port, Direction: b.Direction, ASN: asn}
+	if expanded[agg.Key()] {
+		return nil
+	}
+
+	var members []Response
+	for _, r := range active {
+		if r.isAddressBlock() && r.Expires.IsZero() && r.sameScope(b) && prefix.Contains(net.ParseIP(r.IP)) {
+			members = append(members, r)
+		}
+	}
+	if len(members) < a.Threshold {
+		return nil
+	}
+	if overlapsAllowlist(prefix) {
+		logger.Warn("not aggregating blocks, prefix overlaps the allowlist", "prefix", prefix.String(), "scope", agg.Scope(), "blocks", len(members))
+		return nil
+	}
+
+	for _, m := range members {
+		agg.Members = append(agg.Members, m.IP)
+	}
+	slices.Sort(agg.Members)
+
+	// The aggregate goes in before its members come out, see commit
+	setActive(agg)
+	changes := []change{{agg, true, true}}
+	for _, m := range members {
+		deleteActive(m)
+		changes = append(changes, change{m, false, true})
+	}
+	return changes
+}
+
+// removeMember unblocks one address of an aggregate, splitting the aggregate
+// back into its members when configured to. Otherwise the address only leaves
+// the member list and ErrCovered says the aggregate still drops it.
+func removeMember(agg Response, ip string) ([]change, error) {
+	if !aggregation().ExpandOnUnblock {
+		agg.Members = slices.DeleteFunc(slices.Clone(agg.Members), func(m string) bool { return m == ip })
+		setActive(agg)
+		logger.Warn("address stays covered by an aggregate block", "ip", ip, "aggregate", agg.IP, "scope", agg.Scope())
+		return []change{{agg, true, false}}, fmt.Errorf("%w: %s is still dropped by %s (%s), unblock that or enable expand_on_unblock", ErrCovered, ip, agg.IP, agg.Scope())
+	}
+
+	deleteActive(agg)
+	logger.Info("aggregate block removed", "aggregate", agg.IP)
+
+	return append([]change{{agg, false, true}}, expand(agg, ip)...), nil
+}
+
+// expand re-blocks the members of a removed aggregate individually, except skip
+func expand(agg Response, skip string) []change {
+	expanded[agg.Key()] = true
+
+	var changes []change
+	for _, member := range agg.Members {
+		if member == skip {
+			continue
+		}
+		r := agg.member(member)
+		setActive(r)
+		changes = append(changes, change{r, true, true})
+	}
+	logger.Info("expanding aggregate back into blocks", "aggregate", agg.IP, "blocks", len(changes))
+	return changes
+}
+
+func prefixOnes(network *net.IPNet) int {
+	ones, _ := network.Mask.Size()
+	return ones
+}
@@ -0,0 +1,315 @@
+package iptables
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFirewall puts an iptables in PATH that records its arguments and fails
+// for arguments containing fail, and starts from no responses with aggregation
+// configured as given
+func fakeFirewall(t *testing.T, aggregation AggregationConfig, fail string) (commands func() []string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	log := filepath.Join(dir, "commands")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$*\" >> %q\n", log)
+	if fail != "" {
+		script += fmt.Sprintf("case \"$*\" in *%q*) exit 1;; esac\n", fail)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "iptables"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	activeMutex.Lock()
+	active = make(map[string]Response)
+	err := applyConfig(Config{Allowlist: []string{"203.0.113.0/28"}, Aggregation: aggregation})
+	activeMutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return func() []string {
+		data, _ := os.ReadFile(log)
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+}
+
+func aggregating(threshold int, expand bool) AggregationConfig {
+	return AggregationConfig{Enabled: true, Threshold: threshold, PrefixLength: 24, MaxPrefix: 16, ExpandOnUnblock: expand}
+}
+
+// blocks returns the active responses as sorted "ip scope [members]" lines
+func blocks() []string {
+	var lines []string
+	for _, r := range Active() {
+		line := r.IP + " " + r.Scope()
+		if len(r.Members) > 0 {
+			line += " " + strings.Join(r.Members, ",")
+		}
+		lines = append(lines, line)
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+func drop(ip string) Response {
+	return Response{IP: ip, Kind: KindDrop}
+}
+
+func portDrop(ip, port string) Response {
+	return Response{IP: ip, Kind: KindDrop, Protocol: "tcp", Port: port}
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		name    string
+		install []Response
+		want    []string
+	}{
+		{
+			name:    "below the threshold",
+			install: []Response{drop("192.0.2.1"), drop("192.0.2.2")},
+			want:    []string{"192.0.2.1 all", "192.0.2.2 all"},
+		},
+		{
+			name:    "collapsed at the threshold",
+			install: []Response{drop("192.0.2.1"), drop("192.0.2.2"), drop("192.0.2.3")},
+			want:    []string{"192.0.2.0/24 all 192.0.2.1,192.0.2.2,192.0.2.3"},
+		},
+		{
+			name:    "later addresses join the aggregate",
+			install: []Response{drop("192.0.2.1"), drop("192.0.2.2"), drop("192.0.2.3"), drop("192.0.2.200")},
+			want:    []string{"192.0.2.0/24 all 192.0.2.1,192.0.2.2,192.0.2.3,192.0.2.200"},
+		},
+		{
+			name:    "other prefixes stay apart",
+			install: []Response{drop("192.0.2.1"), drop("192.0.2.2"), drop("198.51.100.3")},
+			want:    []string{"192.0.2.1 all", "192.0.2.2 all", "198.51.100.3 all"},
+		},
+		{
+			name: "temporary blocks aren't collapsed",
+			install: []Response{
+				drop("192.0.2.1"), drop("192.0.2.2"),
+				{IP: "192.0.2.3", Kind: KindDrop, Expires: time.Now().Add(time.Hour)},
+			},
+			want: []string{"192.0.2.1 all", "192.0.2.2 all", "192.0.2.3 all"},
+		},
+		{
+			name:    "throttles aren't collapsed",
+			install: []Response{drop("192.0.2.1"), drop("192.0.2.2"), {IP: "192.0.2.3", Kind: KindTarpit}},
+			want:    []string{"192.0.2.1 all", "192.0.2.2 all", "192.0.2.3 all"},
+		},
+		{
+			name:    "collapsed within their scope",
+			install: []Response{portDrop("192.0.2.1", "22"), portDrop("192.0.2.2", "22"), portDrop("192.0.2.3", "22")},
+			want:    []string{"192.0.2.0/24 tcp/22 192.0.2.1,192.0.2.2,192.0.2.3"},
+		},
+		{
+			name:    "scopes aren't mixed",
+			install: []Response{portDrop("192.0.2.1", "22"), portDrop("192.0.2.2", "80"), drop("192.0.2.3")},
+			want:    []string{"192.0.2.1 tcp/22", "192.0.2.2 tcp/80", "192.0.2.3 all"},
+		},
+		{
+			name:    "prefix overlapping the allowlist",
+			install: []Response{drop("203.0.113.100"), drop("203.0.113.101"), drop("203.0.113.102")},
+			want:    []string{"203.0.113.100 all", "203.0.113.101 all", "203.0.113.102 all"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirewall(t, aggregating(3, true), "")
+			for _, r := range tt.install {
+				if err := Install(r); err != nil && !errors.Is(err, ErrSkipped) {
+					t.Fatalf("install %s: %v", r.IP, err)
+				}
+			}
+			if got := blocks(); !slices.Equal(got, tt.want) {
+				t.Errorf("blocks = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregatePromoted(t *testing.T) {
+	fakeFirewall(t, aggregating(3, true), "")
+
+	temporary := drop("192.0.2.3")
+	temporary.Expires = time.Now().Add(time.Hour)
+	for _, r := range []Response{drop("192.0.2.1"), drop("192.0.2.2"), temporary} {
+		if err := Install(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Install(drop("192.0.2.3")); !errors.Is(err, ErrPromoted) {
+		t.Fatalf("promotion returned %v, want ErrPromoted", err)
+	}
+
+	want := []string{"192.0.2.0/24 all 192.0.2.1,192.0.2.2,192.0.2.3"}
+	if got := blocks(); !slices.Equal(got, want) {
+		t.Errorf("blocks = %q, want %q", got, want)
+	}
+}
+
+func TestAggregateFailed(t *testing.T) {
+	commands := fakeFirewall(t, aggregating(2, true), "192.0.2.0/24")
+
+	for _, r := range []Response{drop("192.0.2.1"), drop("192.0.2.2")} {
+		if err := Install(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The members stay blocked when the aggregate can't be inserted
+	want := []string{"192.0.2.1 all", "192.0.2.2 all"}
+	if got := blocks(); !slices.Equal(got, want) {
+		t.Errorf("blocks = %q, want %q", got, want)
+	}
+	for _, c := range commands() {
+		if strings.HasPrefix(c, "-D") && !strings.Contains(c, "192.0.2.0/24") {
+			t.Errorf("member rule deleted: %s", c)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	members := []Response{drop("192.0.2.1"), drop("192.0.2.2"), drop("192.0.2.3")}
+
+	tests := []struct {
+		name    string
+		expand  bool
+		remove  Response
+		wantErr error
+		want    []string
+	}{
+		{
+			name:   "member expands the rest",
+			expand: true,
+			remove: drop("192.0.2.2"),
+			want:   []string{"192.0.2.1 all", "192.0.2.3 all"},
+		},
+		{
+			name:   "aggregate expands all members",
+			expand: true,
+			remove: drop("192.0.2.0/24"),
+			want:   []string{"192.0.2.1 all", "192.0.2.2 all", "192.0.2.3 all"},
+		},
+		{
+			name:    "member stays covered",
+			remove:  drop("192.0.2.2"),
+			wantErr: ErrCovered,
+			want:    []string{"192.0.2.0/24 all 192.0.2.1,192.0.2.3"},
+		},
+		{
+			name:   "aggregate without expanding",
+			remove: drop("192.0.2.0/24"),
+			want:   nil,
+		},
+		{
+			name:    "member of another scope",
+			expand:  true,
+			remove:  portDrop("192.0.2.2", "22"),
+			wantErr: ErrSkipped,
+			want:    []string{"192.0.2.0/24 all 192.0.2.1,192.0.2.2,192.0.2.3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirewall(t, aggregating(3, tt.expand), "")
+			for _, r := range members {
+				if err := Install(r); err != nil && !errors.Is(err, ErrSkipped) {
+					t.Fatal(err)
+				}
+			}
+
+			err := Remove(tt.remove)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("remove = %v, want %v", err, tt.wantErr)
+			}
+			if got := blocks(); !slices.Equal(got, tt.want) {
+				t.Errorf("blocks = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandedStaysExpanded(t *testing.T) {
+	fakeFirewall(t, aggregating(3, true), "")
+	for _, r := range []Response{drop("192.0.2.1"), drop("192.0.2.2"), drop("192.0.2.3")} {
+		if err := Install(r); err != nil && !errors.Is(err, ErrSkipped) {
+			t.Fatal(err)
+		}
+	}
+	if err := Remove(drop("192.0.2.0/24")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another block in the prefix doesn't collapse it right back
+	if err := Install(drop("192.0.2.4")); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.1 all", "192.0.2.2 all", "192.0.2.3 all", "192.0.2.4 all"}
+	if got := blocks(); !slices.Equal(got, want) {
+		t.Errorf("blocks = %q, want %q", got, want)
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	fakeFirewall(t, aggregating(3, false), "")
+	for _, r := range []Response{
+		drop("192.0.2.1"), drop("192.0.2.2"), drop("192.0.2.3"),
+		{IP: "192.0.2.2", Kind: KindRateLimit, Rate: "10/second"},
+	} {
+		if err := Install(r); err != nil && !errors.Is(err, ErrSkipped) {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := RemoveAll("192.0.2.2")
+	if !errors.Is(err, ErrCovered) {
+		t.Errorf("RemoveAll = %v, want ErrCovered", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("removed %d responses, want the rate limit and the aggregate member", len(removed))
+	}
+	want := []string{"192.0.2.0/24 all 192.0.2.1,192.0.2.3"}
+	if got := blocks(); !slices.Equal(got, want) {
+		t.Errorf("blocks = %q, want %q", got, want)
+	}
+
+	if removed, err := RemoveAll("192.0.2.9"); err != nil || len(removed) != 0 {
+		t.Errorf("RemoveAll of an unblocked address = %v, %v", removed, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		r     Response
+		valid bool
+	}{
+		{drop("192.0.2.1"), true},
+		{drop("192.0.2.0/24"), true},
+		{drop("2001:db8::1"), false},
+		{drop("2001:db8::/32"), false},
+		{drop("192.0.2"), false},
+		{Response{IP: "192.0.2.1", Kind: KindDrop, Port: "22"}, false},
+		{Response{IP: "192.0.2.1", Kind: KindDrop, Protocol: "icmp", Port: "22"}, false},
+		{Response{IP: "192.0.2.1", Kind: KindDrop, Protocol: "udp", Port: "70000"}, false},
+		{Response{IP: "192.0.2.1", Kind: KindDrop, Protocol: "sctp"}, false},
+		{Response{IP: "192.0.2.1", Kind: KindDrop, Direction: "up"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.r.Key(), func(t *testing.T) {
+			if err := tt.r.validate(); (err == nil) != tt.valid {
+				t.Errorf("validate = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -2,14 +2,26 @@ package iptables
 
 import (
 	"fmt"
+	"main/logging"
 	"os"
 	"os/exec"
-	"slices"
+	"sync/atomic"
 
 	"github.com/joho/godotenv"
 )
 
-var AvoidBlocking = false
+var logger = logging.For("iptables")
+
+// avoidBlocking pauses installing responses, e.g. while attack traffic is captured for datasets
+var avoidBlocking atomic.Bool
+
+func SetAvoidBlocking(avoid bool) {
+	avoidBlocking.Store(avoid)
+}
+
+func AvoidBlocking() bool {
+	return avoidBlocking.Load()
+}
 
 func runCommand(cmd string, args ...string) error {
 	command := exec.Command(cmd, args...)
@@ -17,7 +29,7 @@ func runCommand(cmd string, args ...string) error {
 	if err != nil {
 		return fmt.Errorf("[ERROR] Command failed: %s %v\nOutput: %s", cmd, args, string(output))
 	}
-	fmt.Printf("[✔] %s\n", string(output))
+	logger.Debug("command succeeded", "command", cmd, "args", args, "output", string(output))
 	return nil
 }
 
@@ -25,11 +37,11 @@ func PrepareNFQueues() error {
 
 	err := godotenv.Load(".env")
 	if err != nil {
-		fmt.Println("Error loading .env file:", err)
+		logger.Error("failed to load .env file", "error", err)
 		os.Exit(1)
 	}
 
-	fmt.Println("[*] Flushing existing iptables and arptables rules...")
+	logger.Info("flushing existing iptables and arptables rules")
 
 	flushCommands := [][]string{
 		{"iptables", "-F"},
@@ -44,7 +56,7 @@ func PrepareNFQueues() error {
 
 	for _, cmd := range flushCommands {
 		if err := runCommand(cmd[0], cmd[1:]...); err != nil {
-			fmt.Printf("[ERROR] Failed to execute: %v\n", cmd)
+			logger.Error("failed to flush rules", "command", cmd, "error", err)
 		}
 	}
 
@@ -61,87 +73,55 @@ func PrepareNFQueues() error {
 		{"iptables", "-A", "OUTPUT", "-p", "udp", "!", "--destination", "172.30.0.11", "-j", "NFQUEUE", "--queue-num", os.Getenv("UDP_QUEUE")},
 	}
 
-	fmt.Println("[*] Applying iptables rules...")
+	logger.Info("applying NFQUEUE rules")
 	for _, rule := range nfqueueRules {
 		if err := runCommand(rule[0], rule[1:]...); err != nil {
-			fmt.Printf("[ERROR] Failed to apply rule: %v\n", rule)
+			logger.Error("failed to apply rule", "rule", rule, "error", err)
 		}
 	}
 
-	fmt.Println("[*] Ensuring /etc/iptables directory exists...")
-	fmt.Println("[ERROR] Failed to create /etc/iptables directory:", err)
+	logger.Debug("ensuring /etc/iptables directory exists")
 	if err := runCommand("mkdir", "-p", "/etc/iptables"); err != nil {
+		logger.Error("failed to create /etc/iptables directory", "error", err)
 	}
 
-	fmt.Println("[*] Saving iptables rules for persistence...")
+	logger.Info("saving iptables rules for persistence")
 	saveCmd := exec.Command("iptables-save")
 	rulesFile, err := os.Create("/etc/iptables/rules.v4")
 	if err != nil {
-		fmt.Println("[ERROR] Failed to open rules file:", err)
+		logger.Error("failed to open rules file", "error", err)
 		return err
 	}
 	defer rulesFile.Close()
 
 	saveCmd.Stdout = rulesFile
 	if err := saveCmd.Run(); err != nil {
-		fmt.Println("[ERROR] Failed to save iptables rules:", err)
+		logger.Error("failed to save iptables rules", "error", err)
 		return err
 	}
 
-	fmt.Println("[✔] iptables rules saved successfully.")
+	logger.Info("iptables rules saved")
 	return nil
 }
 
-var blockedIPs []string
-
 // BlockIP inserts DROP rules in INPUT, OUTPUT, and FORWARD chains to block all traffic to/from a specific IP
 func BlockIP(ip string) int {
-	if AvoidBlocking || slices.Contains(blockedIPs, ip) { 
-		return -1;
-	}
-
-	blockRules := [][]string{
-		{"iptables", "-I", "INPUT", "1", "-s", ip, "-j", "DROP"},
-		{"iptables", "-I", "OUTPUT", "1", "-d", ip, "-j", "DROP"},
-		{"iptables", "-I", "FORWARD", "1", "-s", ip, "-j", "DROP"},
-		{"iptables", "-I", "FORWARD", "1", "-d", ip, "-j", "DROP"},
-	}
-
-	for _, rule := range blockRules {
-		if err := runCommand(rule[0], rule[1:]...); err != nil {
-			return -1
-		}
+	if err := Install(Response{IP: ip, Kind: KindDrop}); err != nil {
+		return -1
 	}
 
-	fmt.Printf("[✔] IP %s blocked successfully.\n", ip)
-	
-	blockedIPs = append(blockedIPs, ip)
-
+	logger.Info("IP blocked", "ip", ip)
 	return 0
 }
 
 // UnblockIP deletes any DROP rules for a specific IP in INPUT, OUTPUT, and FORWARD chains
 func UnblockIP(ip string) error {
-	fmt.Printf("[*] Unblocking IP: %s\n", ip)
-
-	unblockRules := [][]string{
-		{"iptables", "-D", "INPUT", "-s", ip, "-j", "DROP"},
-		{"iptables", "-D", "OUTPUT", "-d", ip, "-j", "DROP"},
-		{"iptables", "-D", "FORWARD", "-s", ip, "-j", "DROP"},
-		{"iptables", "-D", "FORWARD", "-d", ip, "-j", "DROP"},
-	}
+	logger.Debug("unblocking IP", "ip", ip)
 
-	for _, rule := range unblockRules {
-		if err := runCommand(rule[0], rule[1:]...); err != nil {
-			fmt.Printf("[WARNING] Could not delete rule: %v (possibly already removed)\n", rule)
-		}
-	}
-
-	fmt.Printf("[✔] IP %s unblocked successfully.\n", ip)
-	
-	if idx := slices.Index(blockedIPs, ip); idx != -1 { 
-		blockedIPs = append(blockedIPs[:idx], blockedIPs[idx+1:]...)
+	if err := Remove(Response{IP: ip, Kind: KindDrop}); err != nil {
+		return err
 	}
 
+	logger.Info("IP unblocked", "ip", ip)
 	return nil
 }
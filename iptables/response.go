@@ -0,0 +1,621 @@
+package iptables
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"main/metrics"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response kinds the firewall can install against a source
+const (
+	KindDrop      = "drop"       // drop everything to and from the address
+	KindRateLimit = "rate_limit" // drop packets above a per-source rate (hashlimit)
+	KindConnLimit = "conn_limit" // reset new TCP connections above a cap (connlimit)
+	KindTarpit    = "tarpit"     // hold TCP connections open with a zero window (xtables-addons TARPIT)
+	KindReject    = "reject"     // answer with RST / ICMP unreachable instead of dropping silently
+	KindSYNLimit  = "syn_limit"  // drop new TCP connections above a per-source rate (hashlimit on SYNs)
+)
+
+// Block directions
+const (
+	DirectionBoth = "both" // traffic from and to the address
+	DirectionIn   = "in"   // traffic from the address only
+	DirectionOut  = "out"  // traffic to the address only
+)
+
+// Response is one firewall action against a source address or prefix, optionally
+// scoped to a protocol, a local destination port and a direction
+type Response struct {
+	IP         string    `json:"ip"`
+	Kind       string    `json:"kind"`
+	Protocol   string    `json:"protocol,omitempty"`    // "tcp", "udp" or "icmp", empty for all
+	Port       string    `json:"port,omitempty"`        // local port the attacker targets, needs tcp or udp
+	Direction  string    `json:"direction,omitempty"`   // "both" (default), "in" or "out"
+	Expires    time.Time `json:"expires,omitempty"`     // zero for permanent responses
+	Members    []string  `json:"members,omitempty"`     // addresses collapsed into an aggregate block
+	ASN        string    `json:"asn,omitempty"`         // origin AS of an aggregate block
+	Reason     string    `json:"reason,omitempty"`      // why the response was installed
+	Rate       string    `json:"rate,omitempty"`        // rate_limit and syn_limit, e.g. "10/second"
+	Burst      int       `json:"burst,omitempty"`       // rate_limit and syn_limit
+	MaxConns   int       `json:"max_conns,omitempty"`   // conn_limit
+	RejectWith string    `json:"reject_with,omitempty"` // reject, e.g. "tcp-reset" or "icmp-port-unreachable"
+}
+
+// Key identifies a response so it can be looked up and removed again
+func (r Response) Key() string {
+	return strings.Join([]string{r.IP, r.Kind, r.Protocol, r.Port, r.direction()}, "|")
+}
+
+// Scope describes what part of the address's traffic the response covers
+func (r Response) Scope() string {
+	scope := "all"
+	if r.Protocol != "" {
+		scope = r.Protocol
+		if r.Port != "" {
+			scope += "/" + r.Port
+		}
+	}
+	if r.direction() != DirectionBoth {
+		scope += " " + r.direction()
+	}
+	return scope
+}
+
+func (r Response) direction() string {
+	if r.Direction == "" {
+		return DirectionBoth
+	}
+	return r.Direction
+}
+
+// inbound returns the match arguments for packets from the address
+func (r Response) inbound() []string {
+	args := []string{"-s", r.IP}
+	if r.Protocol != "" {
+		args = append(args, "-p", r.Protocol)
+	}
+	if r.Port != "" {
+		args = append(args, "--dport", r.Port)
+	}
+	return args
+}
+
+// outbound returns the match arguments for packets to the address
+func (r Response) outbound() []string {
+	args := []string{"-d", r.IP}
+	if r.Protocol != "" {
+		args = append(args, "-p", r.Protocol)
+	}
+	if r.Port != "" {
+		args = append(args, "--sport", r.Port)
+	}
+	return args
+}
+
+// isAddressBlock reports whether r drops the traffic of a single address, in any scope
+func (r Response) isAddressBlock() bool {
+	return r.Kind == KindDrop && net.ParseIP(r.IP) != nil
+}
+
+// sameScope reports whether r and o cover the same protocol, port and direction
+func (r Response) sameScope(o Response) bool {
+	return r.Protocol == o.Protocol && r.Port == o.Port && r.direction() == o.direction()
+}
+
+// member returns the block of one address of an aggregate, in its scope
+func (r Response) member(ip string) Response {
+	return Response{IP: ip, Kind: KindDrop, Protocol: r.Protocol, Port: r.Port, Direction: r.Direction}
+}
+
+func (r Response) validate() error {
+	if r.IP == "" {
+		return fmt.Errorf("response without address")
+	}
+	ip := net.ParseIP(r.IP)
+	if ip == nil {
+		_, network, err := net.ParseCIDR(r.IP)
+		if err != nil {
+			return fmt.Errorf("invalid address %q", r.IP)
+		}
+		ip = network.IP
+	}
+	// The rules go to iptables, which only filters IPv4
+	if ip.To4() == nil {
+		return fmt.Errorf("%s is not an IPv4 address", r.IP)
+	}
+	switch r.Protocol {
+	case "", "tcp", "udp", "icmp":
+	default:
+		return fmt.Errorf("unsupported protocol %q for %s", r.Protocol, r.IP)
+	}
+	if r.Port != "" {
+		if r.Protocol != "tcp" && r.Protocol != "udp" {
+			return fmt.Errorf("port scope for %s needs tcp or udp", r.IP)
+		}
+		if port, err := strconv.Atoi(r.Port); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %q for %s", r.Port, r.IP)
+		}
+	}
+	switch r.direction() {
+	case DirectionBoth, DirectionIn, DirectionOut:
+	default:
+		return fmt.Errorf("invalid direction %q for %s", r.Direction, r.IP)
+	}
+	return nil
+}
+
+// ErrSkipped is returned by Install when blocking is paused or the response is already active
+var ErrSkipped = errors.New("response skipped")
+
+// ErrExtended is returned by Install when a temporary response is already active
+// and only its expiry moved later, it is an ErrSkipped
+var ErrExtended = fmt.Errorf("%w: expiry extended", ErrSkipped)
+
+// ErrPromoted is returned by Install when a temporary response is already active
+// and was made permanent, it is an ErrSkipped
+var ErrPromoted = fmt.Errorf("%w: made permanent", ErrSkipped)
+
+// ErrAllowlisted is returned by Install for addresses in the allowlist
+var ErrAllowlisted = errors.New("address is allowlisted")
+
+// ErrCovered is returned by Remove and RemoveAll for an address taken out of an
+// aggregate block that isn't expanded on unblock, so it is still dropped
+var ErrCovered = errors.New("address is still covered by an aggregate block")
+
+// OnChange is called after a response was installed, updated or removed
+var OnChange func(r Response, installed bool)
+
+type change struct {
+	response  Response
+	installed bool
+	rules     bool // the rules of the response are inserted or deleted, not only its record updated
+}
+
+// active is guarded by activeMutex and only changed with updateMutex held,
+// which also orders the iptables commands of concurrent updates. The commands
+// run outside activeMutex so readers aren't held up by them.
+var active = make(map[string]Response)
+var activeMutex sync.Mutex
+var updateMutex sync.Mutex
+
+var _ = metrics.NewGaugeFunc("ips_blocks_active", "Firewall responses currently installed.", activeByKind, "kind")
+
+// Install inserts the rules of a response at the top of their chains.
+// It returns ErrSkipped when blocking is paused or the response is already active,
+// ErrExtended or ErrPromoted when it only changed the expiry of a temporary one.
+func Install(r Response) error {
+	if _, err := r.rules(); err != nil {
+		return err
+	}
+
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	activeMutex.Lock()
+	changes, err := install(r)
+	activeMutex.Unlock()
+
+	changes, insertErr := commit(changes)
+	if insertErr != nil {
+		err = insertErr
+	} else if err == nil {
+		logger.Info("response installed", "kind", r.Kind, "scope", r.Scope(), "ip", r.IP)
+	}
+
+	if (err == nil || errors.Is(err, ErrPromoted)) && r.isAddressBlock() && r.Expires.IsZero() {
+		activeMutex.Lock()
+		grouped := aggregate(r)
+		activeMutex.Unlock()
+
+		grouped, aggregateErr := commit(grouped)
+		if aggregateErr != nil {
+			logger.Error("failed to aggregate blocks", "ip", r.IP, "scope", r.Scope(), "error", aggregateErr)
+		} else if len(grouped) > 0 {
+			agg := grouped[0].response
+			logger.Info("aggregated blocked addresses", "prefix", agg.IP, "scope", agg.Scope(), "blocks", len(agg.Members))
+		}
+		changes = append(changes, grouped...)
+	}
+
+	notify(changes)
+	return err
+}
+
+// Remove deletes the rules of an installed response. It returns ErrSkipped
+// when the response isn't active, and ErrCovered when the address stays
+// dropped by an aggregate block.
+func Remove(r Response) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	activeMutex.Lock()
+	changes, err := remove(r)
+	activeMutex.Unlock()
+
+	commitRemoval(changes)
+	return err
+}
+
+// Expire deletes a temporary response once its expiry has passed. A response
+// promoted or extended in the meantime stays.
+func Expire(r Response) error {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	activeMutex.Lock()
+	installed, ok := active[r.Key()]
+	if !ok || installed.Expires.IsZero() || installed.Expires.After(time.Now()) {
+		activeMutex.Unlock()
+		return ErrSkipped
+	}
+	changes, err := remove(installed)
+	activeMutex.Unlock()
+
+	commitRemoval(changes)
+	return err
+}
+
+// RemoveAll deletes every response installed against ip and returns them.
+// An address collapsed into aggregate blocks is taken out of them, the first
+// error is returned, e.g. ErrCovered when an aggregate keeps dropping it.
+func RemoveAll(ip string) ([]Response, error) {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	activeMutex.Lock()
+	var targets []Response
+	for _, r := range active {
+		if r.IP == ip {
+			targets = append(targets, r)
+		}
+	}
+
+	var removed []Response
+	var changes []change
+	var err error
+	for _, r := range targets {
+		c, removeErr := remove(r)
+		changes = append(changes, c...)
+		if removeErr != nil {
+			if err == nil {
+				err = removeErr
+			}
+			continue
+		}
+		removed = append(removed, r)
+	}
+	for _, agg := range aggregatesOf(ip) {
+		c, memberErr := removeMember(agg, ip)
+		changes = append(changes, c...)
+		if err == nil {
+			err = memberErr
+		}
+		removed = append(removed, agg.member(ip))
+	}
+	activeMutex.Unlock()
+
+	commitRemoval(changes)
+	return removed, err
+}
+
+// Active returns the installed responses
+func Active() []Response {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+
+	responses := make([]Response, 0, len(active))
+	for _, r := range active {
+		responses = append(responses, r)
+	}
+	return responses
+}
+
+func install(r Response) ([]change, error) {
+	if AvoidBlocking() {
+		return nil, ErrSkipped
+	}
+	if isAllowlisted(r.IP) {
+		return nil, ErrAllowlisted
+	}
+	if existing, ok := active[r.Key()]; ok {
+		if !existing.Expires.IsZero() && r.Expires.IsZero() {
+			// Promote a temporary response to a permanent one
+			existing.Expires = time.Time{}
+			setActive(existing)
+			return []change{{existing, true, false}}, ErrPromoted
+		}
+		if !existing.Expires.IsZero() && r.Expires.After(existing.Expires) {
+			// Extend a temporary response that is issued again
+			existing.Expires = r.Expires
+			setActive(existing)
+			return []change{{existing, true, false}}, ErrExtended
+		}
+		return nil, ErrSkipped
+	}
+
+	// An address inside an aggregate of the same scope only joins its member list
+	if r.isAddressBlock() {
+		if agg, ok := coveringAggregate(r); ok {
+			agg.Members = append(slices.Clone(agg.Members), r.IP)
+			setActive(agg)
+			return []change{{agg, true, false}}, ErrSkipped
+		}
+	}
+
+	setActive(r)
+	return []change{{r, true, true}}, nil
+}
+
+func remove(r Response) ([]change, error) {
+	installed, ok := active[r.Key()]
+	if !ok {
+		if r.isAddressBlock() {
+			if agg, found := coveringAggregate(r); found {
+				return removeMember(agg, r.IP)
+			}
+		}
+		return nil, ErrSkipped
+	}
+
+	deleteActive(installed)
+	logger.Info("response removed", "kind", installed.Kind, "scope", installed.Scope(), "ip", installed.IP)
+
+	changes := []change{{installed, false, true}}
+	if len(installed.Members) > 0 && aggregation().ExpandOnUnblock {
+		changes = append(changes, expand(installed, "")...)
+	}
+	return changes, nil
+}
+
+// commit runs the iptables commands of changes, which must be called with
+// updateMutex held and without activeMutex. A response whose rules couldn't
+// be inserted is forgotten again, together with the removal of the blocks it
+// was to aggregate. It returns the changes that took effect and the first error.
+func commit(changes []change) ([]change, error) {
+	var done []change
+	var failed []Response
+	var err error
+	for _, c := range changes {
+		switch {
+		case !c.rules:
+			done = append(done, c)
+
+		case !c.installed:
+			if slices.ContainsFunc(failed, func(agg Response) bool {
+				return agg.sameScope(c.response) && slices.Contains(agg.Members, c.response.IP)
+			}) {
+				// Its aggregate isn't in place, so the block stays
+				activeMutex.Lock()
+				setActive(c.response)
+				activeMutex.Unlock()
+				continue
+			}
+			deleteRules(c.response)
+			metrics.BlocksRemoved.Inc(c.response.Kind)
+			done = append(done, c)
+
+		default:
+			if insertErr := insertRules(c.response); insertErr != nil {
+				activeMutex.Lock()
+				deleteActive(c.response)
+				activeMutex.Unlock()
+				failed = append(failed, c.response)
+				if err == nil {
+					err = insertErr
+				}
+				continue
+			}
+			metrics.BlocksAdded.Inc(c.response.Kind)
+			done = append(done, c)
+		}
+	}
+	return done, err
+}
+
+// commitRemoval commits changes that remove responses, re-blocking the members
+// of an expanded aggregate is the only part that can fail
+func commitRemoval(changes []change) {
+	changes, err := commit(changes)
+	if err != nil {
+		logger.Error("failed to re-block aggregate members", "error", err)
+	}
+	notify(changes)
+}
+
+// setActive records an installed or updated response, must be called with activeMutex held
+func setActive(r Response) {
+	active[r.Key()] = r
+}
+
+// deleteActive forgets a removed response, must be called with activeMutex held
+func deleteActive(r Response) {
+	delete(active, r.Key())
+}
+
+func activeByKind() []metrics.Sample {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+
+	counts := make(map[string]int)
+	for _, r := range active {
+		counts[r.Kind]++
+	}
+	samples := make([]metrics.Sample, 0, len(counts))
+	for kind, count := range counts {
+		samples = append(samples, metrics.Sample{Labels: []string{kind}, Value: float64(count)})
+	}
+	return samples
+}
+
+func insertRules(r Response) error {
+	rules, err := r.rules()
+	if err != nil {
+		return err
+	}
+
+	for i, rule := range rules {
+		args := append([]string{"-I", rule[0], "1"}, rule[1:]...)
+		if err := runCommand("iptables", args...); err != nil {
+			// Roll back what was already inserted
+			for _, inserted := range rules[:i] {
+				runCommand("iptables", append([]string{"-D"}, inserted...)...)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteRules(r Response) {
+	rules, err := r.rules()
+	if err != nil {
+		return
+	}
+
+	for _, rule := range rules {
+		if err := runCommand("iptables", append([]string{"-D"}, rule...)...); err != nil {
+			logger.Warn("could not delete rule, possibly already removed", "rule", rule, "error", err)
+		}
+	}
+}
+
+func notify(changes []change) {
+	if OnChange == nil {
+		return
+	}
+	for _, c := range changes {
+		OnChange(c.response, c.installed)
+	}
+}
+
+// rules returns the chain and match/target arguments of every rule of the response.
+// Throttles only ever apply to traffic from the address.
+func (r Response) rules() ([][]string, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+
+	switch r.Kind {
+	case KindDrop:
+		var rules [][]string
+		if r.direction() != DirectionOut {
+			in := append(r.inbound(), "-j", "DROP")
+			rules = append(rules, append([]string{"INPUT"}, in...), append([]string{"FORWARD"}, in...))
+		}
+		if r.direction() != DirectionIn {
+			out := append(r.outbound(), "-j", "DROP")
+			rules = append(rules, append([]string{"OUTPUT"}, out...), append([]string{"FORWARD"}, out...))
+		}
+		return rules, nil
+
+	case KindRateLimit:
+		if r.Rate == "" {
+			return nil, fmt.Errorf("rate_limit for %s needs a rate", r.IP)
+		}
+		burst := r.Burst
+		if burst <= 0 {
+			burst = 5
+		}
+		limit := append(r.inbound(),
+			"-m", "hashlimit",
+			"--hashlimit-above", r.Rate,
+			"--hashlimit-burst", strconv.Itoa(burst),
+			"--hashlimit-mode", "srcip",
+			"--hashlimit-name", hashlimitName(r.Key()),
+			"-j", "DROP",
+		)
+		return [][]string{
+			append([]string{"INPUT"}, limit...),
+			append([]string{"FORWARD"}, limit...),
+		}, nil
+
+	case KindSYNLimit:
+		if r.Rate == "" {
+			return nil, fmt.Errorf("syn_limit for %s needs a rate", r.IP)
+		}
+		if r.Protocol != "" && r.Protocol != "tcp" {
+			return nil, fmt.Errorf("syn_limit for %s only applies to tcp", r.IP)
+		}
+		r.Protocol = "tcp"
+		burst := r.Burst
+		if burst <= 0 {
+			burst = 5
+		}
+		limit := append(r.inbound(),
+			"--syn",
+			"-m", "hashlimit",
+			"--hashlimit-above", r.Rate,
+			"--hashlimit-burst", strconv.Itoa(burst),
+			"--hashlimit-mode", "srcip",
+			"--hashlimit-name", hashlimitName(r.Key()),
+			"-j", "DROP",
+		)
+		return [][]string{
+			append([]string{"INPUT"}, limit...),
+			append([]string{"FORWARD"}, limit...),
+		}, nil
+
+	case KindConnLimit:
+		if r.MaxConns <= 0 {
+			return nil, fmt.Errorf("conn_limit for %s needs max_conns", r.IP)
+		}
+		if r.Protocol != "" && r.Protocol != "tcp" {
+			return nil, fmt.Errorf("conn_limit for %s only applies to tcp", r.IP)
+		}
+		r.Protocol = "tcp"
+		rule := append([]string{"INPUT"}, r.inbound()...)
+		rule = append(rule, "--syn", "-m", "connlimit",
+			"--connlimit-above", strconv.Itoa(r.MaxConns), "--connlimit-mask", "32",
+			"-j", "REJECT", "--reject-with", "tcp-reset")
+		return [][]string{rule}, nil
+
+	case KindTarpit:
+		if r.Protocol != "" && r.Protocol != "tcp" {
+			return nil, fmt.Errorf("tarpit for %s only applies to tcp", r.IP)
+		}
+		r.Protocol = "tcp"
+		return [][]string{
+			append(append([]string{"INPUT"}, r.inbound()...), "-j", "TARPIT"),
+		}, nil
+
+	case KindReject:
+		rejectWith := r.RejectWith
+		if rejectWith == "" {
+			rejectWith = "icmp-port-unreachable"
+		}
+		if rejectWith == "tcp-reset" && r.Protocol == "" {
+			// RST only makes sense for TCP, everything else gets port unreachable.
+			// Rules are inserted at the top in order, so the TCP rule goes last to be evaluated first.
+			tcp := r
+			tcp.Protocol = "tcp"
+			return [][]string{
+				append(append([]string{"INPUT"}, r.inbound()...), "-j", "REJECT", "--reject-with", "icmp-port-unreachable"),
+				append(append([]string{"INPUT"}, tcp.inbound()...), "-j", "REJECT", "--reject-with", "tcp-reset"),
+			}, nil
+		}
+		if rejectWith == "tcp-reset" && r.Protocol != "tcp" {
+			return nil, fmt.Errorf("tcp-reset for %s only applies to tcp", r.IP)
+		}
+		return [][]string{
+			append(append([]string{"INPUT"}, r.inbound()...), "-j", "REJECT", "--reject-with", rejectWith),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown response kind %q", r.Kind)
+}
+
+// hashlimitName derives a per-response table name within the kernel's 15 character limit
+func hashlimitName(key string) string {
+	return fmt.Sprintf("ips%08x", crc32.ChecksumIEEE([]byte(key)))
+}
@@ -0,0 +1,34 @@
+package iptables
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// syncookiesPath holds the kernel's SYN cookie mode: 0 off, 1 when the SYN
+// backlog overflows, 2 always
+const syncookiesPath = "/proc/sys/net/ipv4/tcp_syncookies"
+
+// SYNCookies returns the current SYN cookie mode
+func SYNCookies() (int, error) {
+	data, err := os.ReadFile(syncookiesPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read SYN cookie mode: %w", err)
+	}
+	mode, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid SYN cookie mode %q", strings.TrimSpace(string(data)))
+	}
+	return mode, nil
+}
+
+// SetSYNCookies switches the kernel's SYN cookie mode
+func SetSYNCookies(mode int) error {
+	if err := runCommand("sysctl", "-w", "net.ipv4.tcp_syncookies="+strconv.Itoa(mode)); err != nil {
+		return err
+	}
+	logger.Info("SYN cookie mode set", "mode", mode)
+	return nil
+}
@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// maxLimitedMessages bounds the distinct messages a limited logger remembers
+const maxLimitedMessages = 1024
+
+// Limited returns a logger that lets each message through at most once per
+// interval and reports how many repeats it dropped in between. It is meant
+// for per-packet errors, which would otherwise flood the output under attack.
+func Limited(logger *slog.Logger, interval time.Duration) *slog.Logger {
+	return slog.New(&limitHandler{
+		inner: logger.Handler(),
+		state: &limitState{interval: interval, last: make(map[string]time.Time), dropped: make(map[string]int)},
+	})
+}
+
+type limitState struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     map[string]time.Time
+	dropped  map[string]int
+}
+
+// allow reports whether msg may be logged now and how many repeats were dropped since
+func (s *limitState) allow(msg string, now time.Time) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, ok := s.last[msg]; ok && now.Sub(last) < s.interval {
+		s.dropped[msg]++
+		return false, 0
+	}
+
+	if len(s.last) >= maxLimitedMessages {
+		clear(s.last)
+		clear(s.dropped)
+	}
+	dropped := s.dropped[msg]
+	s.last[msg] = now
+	delete(s.dropped, msg)
+	return true, dropped
+}
+
+type limitHandler struct {
+	inner slog.Handler
+	state *limitState
+}
+
+func (h *limitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *limitHandler) Handle(ctx context.Context, r slog.Record) error {
+	ok, dropped := h.state.allow(r.Message, r.Time)
+	if !ok {
+		return nil
+	}
+	if dropped > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("suppressed", dropped))
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *limitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &limitHandler{inner: h.inner.WithAttrs(attrs), state: h.state}
+}
+
+func (h *limitHandler) WithGroup(name string) slog.Handler {
+	return &limitHandler{inner: h.inner.WithGroup(name), state: h.state}
+}
@@ -0,0 +1,155 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultComponent names the level used by components without their own
+const DefaultComponent = "default"
+
+var base atomic.Pointer[slog.Handler]
+
+var defaultLevel slog.LevelVar
+var levels = make(map[string]*slog.LevelVar)
+var levelsMutex sync.RWMutex
+
+func init() {
+	var h slog.Handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})
+	base.Store(&h)
+}
+
+// Setup switches the output to format ("text" or "json") on w at the given default level.
+// Loggers created with For before Setup follow the change.
+func Setup(format string, w io.Writer, level string) error {
+	if err := SetLevel(DefaultComponent, level); err != nil {
+		return err
+	}
+
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	switch format {
+	case "", "text":
+		h = slog.NewTextHandler(w, options)
+	case "json":
+		h = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	base.Store(&h)
+	return nil
+}
+
+// For returns the logger of a component, e.g. "iptables"
+func For(component string) *slog.Logger {
+	return slog.New(&componentHandler{component: component})
+}
+
+// SetLevel changes the level of one component at runtime, or the default level
+func SetLevel(component, level string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	if component == DefaultComponent || component == "" {
+		defaultLevel.Set(l)
+		return nil
+	}
+
+	levelsMutex.Lock()
+	defer levelsMutex.Unlock()
+
+	v, ok := levels[component]
+	if !ok {
+		v = new(slog.LevelVar)
+		levels[component] = v
+	}
+	v.Set(l)
+	return nil
+}
+
+// SetLevels applies a "component=level,component=level" list, e.g. from LOG_LEVELS
+func SetLevels(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		component, level, found := strings.Cut(entry, "=")
+		if !found {
+			return fmt.Errorf("invalid log level entry %q, want component=level", entry)
+		}
+		if err := SetLevel(strings.TrimSpace(component), strings.TrimSpace(level)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Levels returns the default level and every component level that was set
+func Levels() map[string]string {
+	levelsMutex.RLock()
+	defer levelsMutex.RUnlock()
+
+	result := map[string]string{DefaultComponent: strings.ToLower(defaultLevel.Level().String())}
+	components := make([]string, 0, len(levels))
+	for component := range levels {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		result[component] = strings.ToLower(levels[component].Level().String())
+	}
+	return result
+}
+
+func levelFor(component string) slog.Level {
+	levelsMutex.RLock()
+	v, ok := levels[component]
+	levelsMutex.RUnlock()
+
+	if ok {
+		return v.Level()
+	}
+	return defaultLevel.Level()
+}
+
+// componentHandler filters by the component's level and forwards to the current base handler
+type componentHandler struct {
+	component string
+	wrap      []func(slog.Handler) slog.Handler // WithAttrs and WithGroup calls, replayed on the base
+}
+
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= levelFor(h.component)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	handler := (*base.Load()).WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	for _, wrap := range h.wrap {
+		handler = wrap(handler)
+	}
+	return handler.Handle(ctx, r)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(inner slog.Handler) slog.Handler { return inner.WithAttrs(attrs) })
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(inner slog.Handler) slog.Handler { return inner.WithGroup(name) })
+}
+
+func (h *componentHandler) with(wrap func(slog.Handler) slog.Handler) slog.Handler {
+	wraps := make([]func(slog.Handler) slog.Handler, 0, len(h.wrap)+1)
+	wraps = append(wraps, h.wrap...)
+	return &componentHandler{component: h.component, wrap: append(wraps, wrap)}
+}
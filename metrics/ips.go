@@ -0,0 +1,56 @@
+package metrics
+
+// Latency buckets in seconds
+var (
+	packetBuckets     = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.05, 0.25, 1}
+	predictionBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 15}
+)
+
+// Packet pipeline
+var (
+	Packets       = NewCounter("ips_packets_total", "Packets received from NFQUEUE.", "queue", "protocol")
+	PacketLatency = NewHistogram("ips_packet_handler_seconds", "Time spent analyzing a packet before its verdict.", packetBuckets, "protocol")
+	Verdicts      = NewCounter("ips_verdicts_total", "NFQUEUE verdicts issued.", "queue", "verdict")
+	ActiveFlows   = NewGauge("ips_active_flows", "Flows currently tracked by the feature analyzers.", "protocol")
+	FlowEvictions = NewCounter("ips_flow_evictions_total", "Flows removed from the feature analyzers after going idle.", "protocol")
+)
+
+// AI predictions
+var (
+	Predictions        = NewCounter("ips_predictions_total", "Flow predictions requested from the model server.", "protocol")
+	PredictionFailures = NewCounter("ips_prediction_failures_total", "Flow predictions that failed.", "protocol")
+	PredictionLatency  = NewHistogram("ips_prediction_seconds", "Round trip time of a flow prediction.", predictionBuckets, "protocol")
+	ModelVotes         = NewCounter("ips_model_votes_total", "Votes cast by each model of the ensemble.", "model", "vote")
+)
+
+// Detections and responses
+var (
+	Detections    = NewCounter("ips_detections_total", "Detections that passed suppression.", "detector", "signature")
+	BlocksAdded   = NewCounter("ips_blocks_added_total", "Firewall responses installed.", "kind")
+	BlocksRemoved = NewCounter("ips_blocks_removed_total", "Firewall responses removed.", "kind")
+	ProcessUp     = NewGauge("ips_process_up", "Whether an external detector process is running.", "process")
+)
+
+// Event subscribers
+var EventsDropped = NewCounter("ips_events_dropped_total", "Engine events dropped because a subscriber fell behind.", "subscriber")
+
+// SIEM export
+var (
+	ExportMessages  = NewCounter("ips_export_messages_total", "Detections exported to SIEM outputs by result (sent, failed, dropped).", "output", "result")
+	ExportConnected = NewGauge("ips_export_connected", "Whether a SIEM output is connected to its collector.", "output")
+)
+
+// Native detectors
+var (
+	TCPConnections     = NewGauge("ips_tcp_connections", "TCP connections tracked by the native detectors.", "state")
+	ReassemblyStreams  = NewGauge("ips_reassembly_streams", "TCP streams being reassembled.")
+	ReassemblyBuffered = NewGauge("ips_reassembly_buffered_bytes", "Out-of-order TCP bytes buffered for reassembly.")
+	ReassemblyEvents   = NewCounter("ips_reassembly_events_total", "TCP reassembly events (overlap, conflict, gap, timeout, stream_limit).", "event")
+
+	NativeAlertsDropped = NewCounter("ips_native_alerts_dropped_total", "Native detections dropped because the engine fell behind.", "signature")
+)
+
+// Webhooks
+var (
+	WebhookDeliveries = NewCounter("ips_webhook_deliveries_total", "Webhook deliveries by result (delivered, retried, failed, dropped).", "endpoint", "result")
+)
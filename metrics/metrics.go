@@ -0,0 +1,318 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"main/logging"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sample is one labelled value reported by a GaugeFunc
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+var registry []metric
+var registryMutex sync.Mutex
+
+func register(m metric) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry = append(registry, m)
+}
+
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d desc) header(w *bufio.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, kind)
+}
+
+// series formats name{label="value",...} with extra trailing label pairs
+func (d desc) series(name string, values []string, extra ...string) string {
+	var b strings.Builder
+	b.WriteString(name)
+
+	pairs := make([]string, 0, len(d.labels)+len(extra)/2)
+	for i, label := range d.labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, label+`="`+escape(value)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+	if len(pairs) > 0 {
+		b.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	return b.String()
+}
+
+// values keeps one float per label combination
+type values struct {
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+func (v *values) add(delta float64, labels []string) {
+	key := strings.Join(labels, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.values == nil {
+		v.values = make(map[string]float64)
+		v.labels = make(map[string][]string)
+	}
+	if _, ok := v.labels[key]; !ok {
+		v.labels[key] = slices.Clone(labels)
+	}
+	v.values[key] += delta
+}
+
+func (v *values) set(value float64, labels []string) {
+	key := strings.Join(labels, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.values == nil {
+		v.values = make(map[string]float64)
+		v.labels = make(map[string][]string)
+	}
+	v.labels[key] = slices.Clone(labels)
+	v.values[key] = value
+}
+
+// Samples returns the current value of every label combination
+func (v *values) Samples() []Sample {
+	return v.snapshot()
+}
+
+func (v *values) snapshot() []Sample {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	samples := make([]Sample, 0, len(v.values))
+	for key, value := range v.values {
+		samples = append(samples, Sample{Labels: v.labels[key], Value: value})
+	}
+	sortSamples(samples)
+	return samples
+}
+
+// Counter only goes up, e.g. packets processed
+type Counter struct {
+	desc
+	values
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, labels}}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc(labels ...string) {
+	c.add(1, labels)
+}
+
+func (c *Counter) Add(delta float64, labels ...string) {
+	c.add(delta, labels)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.header(w, "counter")
+	for _, s := range c.snapshot() {
+		fmt.Fprintf(w, "%s %s\n", c.series(c.name, s.Labels), formatFloat(s.Value))
+	}
+}
+
+// Gauge goes up and down, e.g. active flows
+type Gauge struct {
+	desc
+	values
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{name, help, labels}}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labels ...string) {
+	g.set(value, labels)
+}
+
+func (g *Gauge) Add(delta float64, labels ...string) {
+	g.add(delta, labels)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.header(w, "gauge")
+	for _, s := range g.snapshot() {
+		fmt.Fprintf(w, "%s %s\n", g.series(g.name, s.Labels), formatFloat(s.Value))
+	}
+}
+
+// GaugeFunc is a gauge whose samples are collected at scrape time
+type GaugeFunc struct {
+	desc
+	collect func() []Sample
+}
+
+func NewGaugeFunc(name, help string, collect func() []Sample, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name, help, labels}, collect: collect}
+	register(g)
+	return g
+}
+
+// Samples collects the current values
+func (g *GaugeFunc) Samples() []Sample {
+	samples := g.collect()
+	sortSamples(samples)
+	return samples
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.header(w, "gauge")
+	samples := g.collect()
+	sortSamples(samples)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s %s\n", g.series(g.name, s.Labels), formatFloat(s.Value))
+	}
+}
+
+// Histogram counts observations into cumulative buckets, e.g. latencies in seconds
+type Histogram struct {
+	desc
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name, help, labels},
+		buckets: slices.Sorted(slices.Values(buckets)),
+		series:  make(map[string]*histogramSeries),
+	}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labels ...string) {
+	key := strings.Join(labels, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: slices.Clone(labels), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.header(w, "histogram")
+
+	h.mu.Lock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s %d\n", h.desc.series(h.name+"_bucket", s.labels, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s %d\n", h.desc.series(h.name+"_bucket", s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s %s\n", h.desc.series(h.name+"_sum", s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s %d\n", h.desc.series(h.name+"_count", s.labels), s.count)
+	}
+	h.mu.Unlock()
+}
+
+// Write renders every registered metric in the Prometheus text exposition format
+func Write(out io.Writer) error {
+	registryMutex.Lock()
+	metrics := slices.Clone(registry)
+	registryMutex.Unlock()
+
+	w := bufio.NewWriter(out)
+	for _, m := range metrics {
+		m.write(w)
+	}
+	return w.Flush()
+}
+
+// Handler serves the metrics for Prometheus to scrape
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// Serve exposes /metrics on addr until the listener fails
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Handler())
+	logging.For("metrics").Info("metrics listening", "addr", addr, "path", "/metrics")
+	return http.ListenAndServe(addr, mux)
+}
+
+func sortSamples(samples []Sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].Labels, "\xff") < strings.Join(samples[j].Labels, "\xff")
+	})
+}
+
+func escape(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -1,9 +1,55 @@
 package model
 
+import (
+	"strings"
+	"time"
+)
+
+// Detection methods reported by the detectors
+const (
+	MethodRule   = "Rule Detection"
+	MethodAI     = "AI Detection"
+	MethodUNSW   = "AI Detection ( UNSWB )"
+	MethodNative = "Native Detection"
+)
+
 type Detection struct {
-	Method     string `json:"Method"`
-	Protocol   string `json:"Protocol"`
-	AttackerIP string `json:"Attacker_ip"`
-	TargetPort string `json:"Target_port"`
-	Message    string `json:"Message"`
+	Method      string         `json:"Method"`
+	Protocol    string         `json:"Protocol"`
+	AttackerIP  string         `json:"Attacker_ip"`
+	TargetIP    string         `json:"Target_ip,omitempty"`
+	TargetPort  string         `json:"Target_port"`
+	Message     string         `json:"Message"`
+	SignatureID string         `json:"Signature_id,omitempty"` // "gid:sid" for Snort and native alerts
+	Severity    int            `json:"Severity"`
+	Timestamp   time.Time      `json:"Timestamp"`
+	Evidence    map[string]any `json:"Evidence,omitempty"` // what a native detector saw, e.g. the ports scanned
+}
+
+// Detector returns the short detector name used by the GUI toggles and filters ("snort", "own", "unswb", "native")
+func (d Detection) Detector() string {
+	switch d.Method {
+	case MethodRule:
+		return "snort"
+	case MethodAI:
+		return "own"
+	case MethodUNSW:
+		return "unswb"
+	case MethodNative:
+		return "native"
+	}
+	return strings.ToLower(d.Method)
+}
+
+// MatchesSignature reports whether the signature ID matches pattern, a "gid:sid"
+// or a bare "sid" of any gid. An empty pattern matches every detection.
+func (d Detection) MatchesSignature(pattern string) bool {
+	if pattern == "" || pattern == d.SignatureID {
+		return true
+	}
+	if strings.Contains(pattern, ":") {
+		return false
+	}
+	_, sid, found := strings.Cut(d.SignatureID, ":")
+	return found && sid == pattern
 }
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// Flow is a flow evicted from the TCP, UDP or ICMP flow table after going idle
+type Flow struct {
+	Protocol        string        `json:"Protocol"` // "tcp", "udp" or "icmp"
+	SourceIP        string        `json:"Src_ip"`
+	SourcePort      string        `json:"Src_port,omitempty"` // with DestinationPort, of the connection the flow started with
+	DestinationIP   string        `json:"Dest_ip"`
+	DestinationPort string        `json:"Dest_port,omitempty"`
+	Start           time.Time     `json:"Start"`
+	End             time.Time     `json:"End"`
+	Features        FlowFeatures  `json:"Features"`
+	TLS             *TLSHandshake `json:"TLS,omitempty"` // TLS handshake of that connection
+}
+
+// TLSHandshake is what the ClientHello and ServerHello of one TLS connection revealed
+type TLSHandshake struct {
+	Client      string   `json:"Client"`
+	ClientPort  uint64   `json:"Client_port"`
+	Server      string   `json:"Server"`
+	ServerPort  uint64   `json:"Server_port"`
+	SNI         string   `json:"SNI,omitempty"`
+	Version     string   `json:"Version,omitempty"` // negotiated, e.g. "TLS 1.3", or offered without a ServerHello
+	ALPN        []string `json:"ALPN,omitempty"`    // protocols offered by the client
+	Cipher      string   `json:"Cipher,omitempty"`  // suite chosen by the server, e.g. "0x1301"
+	JA3         string   `json:"JA3,omitempty"`     // MD5 of JA3String
+	JA3String   string   `json:"JA3_string,omitempty"`
+	JA3S        string   `json:"JA3S,omitempty"` // MD5 of JA3SString
+	JA3SString  string   `json:"JA3S_string,omitempty"`
+	JA4         string   `json:"JA4,omitempty"`
+	Blocklisted string   `json:"Blocklisted,omitempty"` // name of the blocklist entry matched
+}
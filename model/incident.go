@@ -0,0 +1,60 @@
+package model
+
+import "time"
+
+// Detection and incident severities
+const (
+	SeverityLow      = 1
+	SeverityMedium   = 2
+	SeverityHigh     = 3
+	SeverityCritical = 4
+)
+
+// Incident lifecycle events
+const (
+	IncidentOpen   = "open"
+	IncidentUpdate = "update"
+	IncidentClose  = "close"
+)
+
+// SeverityName returns the label shown in the GUI for a severity level
+func SeverityName(severity int) string {
+	switch {
+	case severity >= SeverityCritical:
+		return "critical"
+	case severity == SeverityHigh:
+		return "high"
+	case severity == SeverityMedium:
+		return "medium"
+	}
+	return "low"
+}
+
+// Evidence is one distinct detection merged into an incident
+type Evidence struct {
+	Detection
+	Count     int       `json:"Count"`
+	FirstSeen time.Time `json:"First_seen"`
+	LastSeen  time.Time `json:"Last_seen"`
+}
+
+// Incident groups the detections of all detectors against one attacker (and target)
+type Incident struct {
+	ID          string     `json:"Id"`
+	AttackerIP  string     `json:"Attacker_ip"`
+	TargetIP    string     `json:"Target_ip,omitempty"`
+	TargetPorts []string   `json:"Target_ports"`
+	Protocols   []string   `json:"Protocols"`
+	Detectors   []string   `json:"Detectors"`
+	Severity    int        `json:"Severity"`
+	Status      string     `json:"Status"` // "open" or "closed"
+	Hits        int        `json:"Hits"`
+	FirstSeen   time.Time  `json:"First_seen"`
+	LastSeen    time.Time  `json:"Last_seen"`
+	Evidence    []Evidence `json:"Evidence"`
+}
+
+type IncidentEvent struct {
+	Type     string   `json:"Type"` // "open", "update" or "close"
+	Incident Incident `json:"Incident"`
+}
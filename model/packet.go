@@ -155,6 +155,8 @@ type PacketAnalysisICMP struct {
 type TCPInfo struct {
 	SourcePort      uint64
 	DestinationPort uint64
+	Seq             uint32 // Sequence number
+	Ack             uint32 // Acknowledgment number
 	SYN             bool
 	ACK             bool
 	FIN             bool
@@ -177,7 +179,12 @@ type UDPInfo struct {
 
 // Define a struct to represent ICMP header information
 type ICMPInfo struct {
-	Payload []byte
-	Type    uint64
-	Code    uint64
+	Payload    []byte
+	Type       uint64
+	Code       uint64
+	Identifier uint16 // Echo identifier
+	Sequence   uint16 // Echo sequence number
+	Gateway    string // Gateway address of a redirect
+	MTU        uint16 // Next-hop MTU of a fragmentation needed message
+	Original   []byte // IP header and start of the datagram an error message is about
 }
@@ -0,0 +1,310 @@
+package native
+
+import (
+	"bytes"
+	"fmt"
+	"main/model"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UDP amplification signatures
+const (
+	SigAmplificationVictim = GID + ":1701" // a host flooded with responses it never asked for
+	SigReflector           = GID + ":1702" // a server answering spoofed requests with much larger responses
+)
+
+// maxExchanges bounds the client and server pairs followed at once
+const maxExchanges = 65536
+
+type AmplificationConfig struct {
+	Enabled        bool                   `json:"enabled"`
+	Window         int                    `json:"window"`          // seconds bytes are counted over
+	Services       []AmplificationService `json:"services"`        // the services abused for amplification
+	VictimBytes    int                    `json:"victim_bytes"`    // unsolicited response bytes one host receives from a service
+	Reflectors     int                    `json:"reflectors"`      // distinct servers those responses must come from
+	ReflectorBytes int                    `json:"reflector_bytes"` // amplified response bytes one server sends one client
+	Cooldown       int                    `json:"cooldown"`        // seconds before the same finding is reported again
+}
+
+// AmplificationService is a UDP service whose responses can be much larger than the requests
+type AmplificationService struct {
+	Name  string  `json:"name"`
+	Port  int     `json:"port"`
+	Ratio float64 `json:"ratio"` // response bytes per request byte from which a server amplifies
+}
+
+func DefaultAmplificationConfig() AmplificationConfig {
+	return AmplificationConfig{
+		Enabled: true,
+		Window:  60,
+		// DNS is left out, the dns detector reports its reflection and amplification already
+		Services: []AmplificationService{
+			{Name: "ntp", Port: 123, Ratio: 10},
+			{Name: "snmp", Port: 161, Ratio: 20},
+			{Name: "cldap", Port: 389, Ratio: 10},
+			{Name: "ssdp", Port: 1900, Ratio: 10},
+			{Name: "memcached", Port: 11211, Ratio: 10},
+		},
+		VictimBytes:    1000000,
+		Reflectors:     3,
+		ReflectorBytes: 100000,
+		Cooldown:       300,
+	}
+}
+
+func (c AmplificationConfig) validate() error {
+	if c.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	names, ports := make(map[string]bool), make(map[int]bool)
+	for _, service := range c.Services {
+		if service.Name == "" || names[service.Name] {
+			return fmt.Errorf("services need distinct names")
+		}
+		if service.Port < 1 || service.Port > 65535 || ports[service.Port] {
+			return fmt.Errorf("%s: invalid or duplicate port %d", service.Name, service.Port)
+		}
+		names[service.Name], ports[service.Port] = true, true
+		if service.Ratio <= 1 {
+			return fmt.Errorf("%s: ratio must be above 1", service.Name)
+		}
+	}
+	if c.VictimBytes <= 0 || c.Reflectors <= 0 || c.ReflectorBytes <= 0 {
+		return fmt.Errorf("thresholds must be positive")
+	}
+	if c.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative")
+	}
+	return nil
+}
+
+// exchange counts the requests a client sent a server and the responses it got
+// back in a tumbling window
+type exchange struct {
+	start         time.Time
+	requests      int
+	requestBytes  int
+	responses     int
+	responseBytes int
+	kinds         map[string]int // recognized requests, e.g. "monlist"
+}
+
+// ratio is the amplification of the exchange, infinite without requests
+func (e *exchange) ratio() float64 {
+	if e.requestBytes == 0 {
+		return math.Inf(1)
+	}
+	return float64(e.responseBytes) / float64(e.requestBytes)
+}
+
+// amplification follows the request and response bytes of UDP services prone
+// to amplification. A host getting responses from many servers it sent nothing
+// to is a victim; a server whose responses to the requests it did get are many
+// times larger is being used as a reflector, with the spoofed source as victim.
+type amplification struct {
+	mu     sync.Mutex
+	config AmplificationConfig
+	emit   func(model.Detection)
+
+	services  map[uint64]AmplificationService // by port
+	exchanges map[string]*exchange            // by "client|server|service"
+	victims   tallies                         // unsolicited responses by "client|service", peers are servers
+	reported  cooldown
+	lastSweep time.Time
+}
+
+func newAmplification(config AmplificationConfig, emit func(model.Detection)) *amplification {
+	services := make(map[uint64]AmplificationService)
+	for _, service := range config.Services {
+		services[uint64(service.Port)] = service
+	}
+	return &amplification{
+		config:    config,
+		emit:      emit,
+		services:  services,
+		exchanges: make(map[string]*exchange),
+		victims:   make(tallies),
+		reported:  make(cooldown),
+	}
+}
+
+func (a *amplification) udp(p *model.PacketAnalysisUDP, now time.Time) {
+	udp := p.UDP
+	src, dst := p.IPv4.SourceIP, p.IPv4.DestinationIP
+
+	// Responses come from the service port, requests go to it
+	service, response := a.services[udp.SourcePort]
+	client, server := dst, src
+	if !response {
+		var ok bool
+		if service, ok = a.services[udp.DestinationPort]; !ok {
+			return
+		}
+		client, server = src, dst
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.sweep(now)
+	window := seconds(a.config.Window)
+	key := client + "|" + server + "|" + service.Name
+	e, ok := a.exchanges[key]
+	if !ok || now.Sub(e.start) >= window {
+		if !ok && len(a.exchanges) >= maxExchanges {
+			return
+		}
+		e = &exchange{start: now, kinds: make(map[string]int)}
+		a.exchanges[key] = e
+	}
+
+	size := len(udp.Payload)
+	if !response {
+		e.requests++
+		e.requestBytes += size
+		if kind := requestKind(service.Name, udp.Payload); kind != "" {
+			e.kinds[kind]++
+		}
+		return
+	}
+	e.responses++
+	e.responseBytes += size
+
+	if e.requests == 0 {
+		a.unsolicited(service, client, server, size, now)
+	} else if e.responseBytes >= a.config.ReflectorBytes && e.ratio() >= service.Ratio {
+		a.reflector(service, client, server, e, now)
+	}
+}
+
+// unsolicited counts a response the client never asked for, must be called with a.mu held
+func (a *amplification) unsolicited(service AmplificationService, client, server string, size int, now time.Time) {
+	window := seconds(a.config.Window)
+	t := a.victims.get(client+"|"+service.Name, now, window)
+	t.add(server, size)
+	if t.bytes < a.config.VictimBytes || len(t.peers) < a.config.Reflectors {
+		return
+	}
+	if !a.reported.ready("victim|"+client+"|"+service.Name, now, seconds(a.config.Cooldown)) {
+		return
+	}
+
+	reflectors := topKeys(t.peers, topPeers)
+	name := strings.ToUpper(service.Name)
+	a.emit(model.Detection{
+		Protocol:    "UDP",
+		AttackerIP:  reflectors[0],
+		TargetIP:    client,
+		Message:     fmt.Sprintf("%s amplification attack against %s: %d unsolicited responses (%d bytes) from %d reflectors", name, client, t.count, t.bytes, len(t.peers)),
+		SignatureID: SigAmplificationVictim,
+		Severity:    model.SeverityHigh,
+		Evidence: map[string]any{
+			"protocol":       service.Name,
+			"source_port":    service.Port,
+			"role":           "victim",
+			"responses":      t.count,
+			"response_bytes": t.bytes,
+			"reflectors":     len(t.peers),
+			"top_reflectors": reflectors,
+			"window":         window.String(),
+		},
+	})
+}
+
+// reflector reports a server amplifying requests sent in the client's name,
+// must be called with a.mu held
+func (a *amplification) reflector(service AmplificationService, client, server string, e *exchange, now time.Time) {
+	if !a.reported.ready("reflector|"+server+"|"+client+"|"+service.Name, now, seconds(a.config.Cooldown)) {
+		return
+	}
+
+	name := strings.ToUpper(service.Name)
+	evidence := map[string]any{
+		"protocol":       service.Name,
+		"port":           service.Port,
+		"role":           "reflector",
+		"victim":         client,
+		"requests":       e.requests,
+		"request_bytes":  e.requestBytes,
+		"responses":      e.responses,
+		"response_bytes": e.responseBytes,
+		"ratio":          math.Round(e.ratio()*10) / 10,
+		"window":         seconds(a.config.Window).String(),
+	}
+	if len(e.kinds) > 0 {
+		evidence["requests_seen"] = topKeys(e.kinds, len(e.kinds))
+	}
+	// The requests carry the victim's spoofed address, blocking it would only hurt the victim
+	a.emit(model.Detection{
+		Protocol:    "UDP",
+		TargetIP:    server,
+		TargetPort:  strconv.Itoa(service.Port),
+		Message:     fmt.Sprintf("%s reflector %s amplifies requests %.0fx against %s (%d bytes)", name, server, e.ratio(), client, e.responseBytes),
+		SignatureID: SigReflector,
+		Severity:    model.SeverityHigh,
+		Evidence:    evidence,
+	})
+}
+
+// requestKind recognizes the requests amplification attacks send
+func requestKind(service string, payload []byte) string {
+	switch service {
+	case "ntp":
+		// Mode 7 private request, MON_GETLIST or MON_GETLIST_1
+		if len(payload) >= 4 && payload[0]&0x07 == 7 && (payload[3] == 20 || payload[3] == 42) {
+			return "monlist"
+		}
+	case "memcached":
+		// An 8 byte frame header precedes the text command
+		if len(payload) > 8 {
+			command, _, _ := bytes.Cut(payload[8:], []byte(" "))
+			command = bytes.TrimSpace(command)
+			switch string(command) {
+			case "stats", "get", "gets":
+				return string(command)
+			}
+		}
+	case "ssdp":
+		if bytes.HasPrefix(payload, []byte("M-SEARCH")) {
+			return "m-search"
+		}
+	case "snmp":
+		// GetBulkRequest PDU
+		if bytes.Contains(payload, []byte{0xa5}) {
+			return "getbulk"
+		}
+	case "cldap":
+		// LDAP searchRequest, application tag 3
+		if bytes.Contains(payload, []byte{0x63}) {
+			return "search"
+		}
+	case "dns":
+		if m, err := parseDNS(payload); err == nil {
+			if q, ok := m.question(); ok {
+				return strings.ToLower(dnsTypeName(q.Type))
+			}
+		}
+	}
+	return ""
+}
+
+// sweep must be called with a.mu held
+func (a *amplification) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < sweepInterval {
+		return
+	}
+	a.lastSweep = now
+
+	window := seconds(a.config.Window)
+	for key, e := range a.exchanges {
+		if now.Sub(e.start) >= window {
+			delete(a.exchanges, key)
+		}
+	}
+	a.victims.prune(now, window)
+	a.reported.prune(now)
+}
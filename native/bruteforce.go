@@ -0,0 +1,410 @@
+package native
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"main/model"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Brute force signatures
+const (
+	SigBruteForce            = GID + ":1401" // one source guessing logins on a service
+	SigDistributedBruteForce = GID + ":1402" // many sources guessing logins on one host
+	SigAccountBruteForce     = GID + ":1403" // many sources guessing the password of one account
+)
+
+// maxAuthLine bounds the bytes of a protocol line kept while it is incomplete
+const maxAuthLine = 4096
+
+type BruteForceConfig struct {
+	Enabled             bool          `json:"enabled"`
+	Window              int           `json:"window"`               // seconds attempts are counted over
+	Services            []AuthService `json:"services"`             // the login services watched
+	DistributedSources  int           `json:"distributed_sources"`  // distinct sources whose attempts on one host or account...
+	DistributedAttempts int           `json:"distributed_attempts"` // ...add up to this many within Window
+	Cooldown            int           `json:"cooldown"`             // seconds before the same brute force is reported again
+}
+
+// AuthService is a service logins are guessed on. Failed logins are recognized
+// in cleartext for the services named ftp, smtp, pop3, imap and http.
+type AuthService struct {
+	Name        string `json:"name"`
+	Ports       []int  `json:"ports"`
+	Attempts    int    `json:"attempts"`     // failed logins or short connections from one source within Window
+	MaxDuration int    `json:"max_duration"` // seconds; answered connections closed sooner are attempts, 0 counts failed logins only
+}
+
+func DefaultBruteForceConfig() BruteForceConfig {
+	return BruteForceConfig{
+		Enabled: true,
+		Window:  60,
+		Services: []AuthService{
+			{Name: "ssh", Ports: []int{22}, Attempts: 20, MaxDuration: 5},
+			{Name: "ftp", Ports: []int{21}, Attempts: 10, MaxDuration: 5},
+			{Name: "telnet", Ports: []int{23}, Attempts: 10, MaxDuration: 10},
+			{Name: "smtp", Ports: []int{25, 465, 587}, Attempts: 10, MaxDuration: 0},
+			{Name: "pop3", Ports: []int{110, 995}, Attempts: 10, MaxDuration: 3},
+			{Name: "imap", Ports: []int{143, 993}, Attempts: 10, MaxDuration: 3},
+			{Name: "http", Ports: []int{80, 443, 8000, 8080, 8888}, Attempts: 20, MaxDuration: 0},
+			{Name: "rdp", Ports: []int{3389}, Attempts: 10, MaxDuration: 5},
+		},
+		DistributedSources:  10,
+		DistributedAttempts: 50,
+		Cooldown:            300,
+	}
+}
+
+func (c BruteForceConfig) validate() error {
+	if c.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	names := make(map[string]bool)
+	for _, service := range c.Services {
+		if service.Name == "" || names[service.Name] {
+			return fmt.Errorf("services need distinct names")
+		}
+		names[service.Name] = true
+		for _, port := range service.Ports {
+			if port < 1 || port > 65535 {
+				return fmt.Errorf("%s: invalid port %d", service.Name, port)
+			}
+		}
+		if service.Attempts <= 0 {
+			return fmt.Errorf("%s: attempts must be positive", service.Name)
+		}
+		if service.MaxDuration < 0 {
+			return fmt.Errorf("%s: max_duration must not be negative", service.Name)
+		}
+	}
+	if c.DistributedSources <= 0 || c.DistributedAttempts <= 0 {
+		return fmt.Errorf("distributed thresholds must be positive")
+	}
+	if c.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative")
+	}
+	return nil
+}
+
+// bruteForce counts login attempts per source, host and account. It follows the
+// reassembled connections to the service ports: failed logins are read from
+// cleartext protocols, and connections the server answered that close quickly
+// count as one attempt when no login was seen.
+type bruteForce struct {
+	mu     sync.Mutex
+	config BruteForceConfig
+	emit   func(model.Detection)
+
+	services  map[int]AuthService // by port
+	sources   tallies             // attempts by "source|host|service", peers are accounts
+	hosts     tallies             // attempts by "host|service", peers are sources
+	accounts  tallies             // attempts by "host|service|account", peers are sources
+	reported  cooldown
+	lastSweep time.Time
+}
+
+func newBruteForce(config BruteForceConfig, emit func(model.Detection)) *bruteForce {
+	services := make(map[int]AuthService)
+	for _, service := range config.Services {
+		for _, port := range service.Ports {
+			if _, ok := services[port]; !ok {
+				services[port] = service
+			}
+		}
+	}
+	return &bruteForce{
+		config:   config,
+		emit:     emit,
+		services: services,
+		sources:  make(tallies),
+		hosts:    make(tallies),
+		accounts: make(tallies),
+		reported: make(cooldown),
+	}
+}
+
+func (b *bruteForce) newStream(id streamID, now time.Time) streamHandler {
+	service, ok := b.services[int(id.ServerPort)]
+	if !ok {
+		return nil
+	}
+	return &authStream{detector: b, id: id, service: service, opened: now}
+}
+
+// authStream follows the login exchange of one connection
+type authStream struct {
+	detector *bruteForce
+	id       streamID
+	service  AuthService
+	opened   time.Time
+
+	lines       [2]lineReader
+	answered    bool   // the server sent data
+	failures    int    // failed logins seen
+	account     string // the user name the client last sent
+	command     string // the client's last command, e.g. "PASS"
+	imapTag     string // tag of the client's last IMAP LOGIN
+	loginPrompt bool   // SMTP AUTH LOGIN waits for the user name
+}
+
+func (s *authStream) data(dir direction, data []byte, now time.Time) {
+	if dir == toClient {
+		s.answered = true
+	}
+	switch s.service.Name {
+	case "ftp", "smtp", "pop3", "imap", "http":
+	default:
+		return
+	}
+
+	for _, line := range s.lines[dir].lines(data) {
+		if dir == toServer {
+			s.clientLine(line)
+		} else if s.failed(line) {
+			s.failures++
+			s.detector.attempt(s, now)
+		}
+	}
+}
+
+func (s *authStream) gap(dir direction, n int) {
+	s.lines[dir].reset()
+}
+
+func (s *authStream) close(now time.Time) {
+	if s.failures > 0 || !s.answered || s.service.MaxDuration == 0 {
+		return
+	}
+	if now.Sub(s.opened) <= seconds(s.service.MaxDuration) {
+		s.detector.attempt(s, now)
+	}
+}
+
+// clientLine picks the user name and command out of a line the client sent
+func (s *authStream) clientLine(line string) {
+	command, args, _ := strings.Cut(line, " ")
+	command = strings.ToUpper(command)
+
+	if s.loginPrompt {
+		// The line after "AUTH LOGIN" is the base64 user name
+		s.loginPrompt = false
+		if user, ok := decodeBase64(line); ok {
+			s.account = user
+		}
+		return
+	}
+
+	switch s.service.Name {
+	case "ftp", "pop3":
+		s.command = command
+		if command == "USER" || command == "APOP" {
+			s.account, _, _ = strings.Cut(args, " ")
+		}
+	case "smtp":
+		s.command = command
+		mechanism, initial, _ := strings.Cut(args, " ")
+		switch {
+		case command != "AUTH":
+		case strings.EqualFold(mechanism, "PLAIN") && initial != "":
+			// authzid \0 authcid \0 password
+			if plain, ok := decodeBase64(initial); ok {
+				if parts := strings.Split(plain, "\x00"); len(parts) == 3 {
+					s.account = parts[1]
+				}
+			}
+		case strings.EqualFold(mechanism, "LOGIN") && initial != "":
+			if user, ok := decodeBase64(initial); ok {
+				s.account = user
+			}
+		case strings.EqualFold(mechanism, "LOGIN"):
+			s.loginPrompt = true
+		}
+	case "imap":
+		// a1 LOGIN user password
+		verb, rest, _ := strings.Cut(args, " ")
+		if strings.EqualFold(verb, "LOGIN") {
+			s.imapTag = command
+			user, _, _ := strings.Cut(rest, " ")
+			s.account = strings.Trim(user, `"`)
+		}
+	case "http":
+		name, value, found := strings.Cut(line, ":")
+		if !found || !strings.EqualFold(name, "Authorization") {
+			return
+		}
+		scheme, credentials, _ := strings.Cut(strings.TrimSpace(value), " ")
+		if strings.EqualFold(scheme, "Basic") {
+			if plain, ok := decodeBase64(credentials); ok {
+				s.account, _, _ = strings.Cut(plain, ":")
+			}
+		}
+	}
+}
+
+// failed reports whether a line the server sent rejects a login
+func (s *authStream) failed(line string) bool {
+	switch s.service.Name {
+	case "ftp":
+		return strings.HasPrefix(line, "530")
+	case "smtp":
+		return strings.HasPrefix(line, "535")
+	case "pop3":
+		return strings.HasPrefix(line, "-ERR") && (s.command == "PASS" || s.command == "APOP" || s.command == "AUTH")
+	case "imap":
+		return s.imapTag != "" && strings.HasPrefix(strings.ToUpper(line), strings.ToUpper(s.imapTag)+" NO")
+	case "http":
+		// HTTP/1.1 401 Unauthorized
+		version, rest, _ := strings.Cut(line, " ")
+		return strings.HasPrefix(version, "HTTP/1.") && strings.HasPrefix(rest, "401")
+	}
+	return false
+}
+
+// attempt counts a failed login or short connection of s and reports the brute
+// forces it completes
+func (b *bruteForce) attempt(s *authStream, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sweep(now)
+	window := seconds(b.config.Window)
+	src, dst, service := s.id.Client, s.id.Server, s.service.Name
+	port := strconv.FormatUint(s.id.ServerPort, 10)
+
+	source := b.sources.get(src+"|"+dst+"|"+service, now, window)
+	source.add(s.account, 0)
+	if source.count >= s.service.Attempts && b.reported.ready("source|"+src+"|"+dst+"|"+service, now, seconds(b.config.Cooldown)) {
+		b.emit(model.Detection{
+			Protocol:    "TCP",
+			AttackerIP:  src,
+			TargetIP:    dst,
+			TargetPort:  port,
+			Message:     fmt.Sprintf("%s brute force from %s: %d login attempts in %s", strings.ToUpper(service), src, source.count, window),
+			SignatureID: SigBruteForce,
+			Severity:    model.SeverityHigh,
+			Evidence: map[string]any{
+				"service":  service,
+				"attempts": source.count,
+				"accounts": accountNames(source),
+				"window":   window.String(),
+			},
+		})
+	}
+
+	host := b.hosts.get(dst+"|"+service, now, window)
+	host.add(src, 0)
+	b.checkDistributed(host, "host|"+dst+"|"+service, SigDistributedBruteForce,
+		fmt.Sprintf("Distributed %s brute force against %s", strings.ToUpper(service), dst), service, dst, port, "", now)
+
+	if s.account != "" {
+		account := b.accounts.get(dst+"|"+service+"|"+s.account, now, window)
+		account.add(src, 0)
+		b.checkDistributed(account, "account|"+dst+"|"+service+"|"+s.account, SigAccountBruteForce,
+			fmt.Sprintf("Distributed %s brute force against account %s on %s", strings.ToUpper(service), s.account, dst), service, dst, port, s.account, now)
+	}
+}
+
+// checkDistributed reports attempts from many sources counted in t, attributed
+// to the busiest source
+func (b *bruteForce) checkDistributed(t *tally, key, signature, message, service, dst, port, account string, now time.Time) {
+	if len(t.peers) < b.config.DistributedSources || t.count < b.config.DistributedAttempts {
+		return
+	}
+	if !b.reported.ready(key, now, seconds(b.config.Cooldown)) {
+		return
+	}
+
+	sources := topKeys(t.peers, topPeers)
+	evidence := map[string]any{
+		"service":     service,
+		"attempts":    t.count,
+		"sources":     len(t.peers),
+		"top_sources": sources,
+		"window":      seconds(b.config.Window).String(),
+	}
+	if account != "" {
+		evidence["account"] = account
+	}
+	b.emit(model.Detection{
+		Protocol:    "TCP",
+		AttackerIP:  sources[0],
+		TargetIP:    dst,
+		TargetPort:  port,
+		Message:     fmt.Sprintf("%s: %d login attempts from %d sources", message, t.count, len(t.peers)),
+		SignatureID: signature,
+		Severity:    model.SeverityHigh,
+		Evidence:    evidence,
+	})
+}
+
+// accountNames returns the accounts tried most, the peers of a source tally
+func accountNames(t *tally) []string {
+	accounts := make(map[string]int, len(t.peers))
+	for account, n := range t.peers {
+		if account != "" {
+			accounts[account] = n
+		}
+	}
+	return topKeys(accounts, topPeers)
+}
+
+func decodeBase64(s string) (string, bool) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// lineReader splits a byte stream into lines without their line ending. Lines
+// longer than maxAuthLine are dropped.
+type lineReader struct {
+	buf      []byte
+	skipping bool // inside an overlong or partly lost line
+}
+
+func (r *lineReader) lines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		line, rest, found := bytes.Cut(data, []byte("\n"))
+		if !found {
+			if !r.skipping {
+				r.buf = append(r.buf, data...)
+				if len(r.buf) > maxAuthLine {
+					r.reset()
+				}
+			}
+			break
+		}
+		if !r.skipping {
+			lines = append(lines, string(bytes.TrimSuffix(append(r.buf, line...), []byte("\r"))))
+		}
+		r.buf = r.buf[:0]
+		r.skipping = false
+		data = rest
+	}
+	return lines
+}
+
+func (r *lineReader) reset() {
+	r.buf = nil
+	r.skipping = true
+}
+
+// sweep must be called with b.mu held
+func (b *bruteForce) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < sweepInterval {
+		return
+	}
+	b.lastSweep = now
+
+	window := seconds(b.config.Window)
+	b.sources.prune(now, window)
+	b.hosts.prune(now, window)
+	b.accounts.prune(now, window)
+	b.reported.prune(now)
+}
@@ -0,0 +1,267 @@
+package native
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DNS record types the detectors care about, others are shown as TYPE<n>
+const (
+	dnsTypeA     = 1
+	dnsTypeNS    = 2
+	dnsTypeCNAME = 5
+	dnsTypeSOA   = 6
+	dnsTypeNULL  = 10
+	dnsTypePTR   = 12
+	dnsTypeMX    = 15
+	dnsTypeTXT   = 16
+	dnsTypeAAAA  = 28
+	dnsTypeSRV   = 33
+	dnsTypeOPT   = 41
+	dnsTypeANY   = 255
+)
+
+var dnsTypeNames = map[uint16]string{
+	dnsTypeA:     "A",
+	dnsTypeNS:    "NS",
+	dnsTypeCNAME: "CNAME",
+	dnsTypeSOA:   "SOA",
+	dnsTypeNULL:  "NULL",
+	dnsTypePTR:   "PTR",
+	dnsTypeMX:    "MX",
+	dnsTypeTXT:   "TXT",
+	dnsTypeAAAA:  "AAAA",
+	dnsTypeSRV:   "SRV",
+	dnsTypeOPT:   "OPT",
+	dnsTypeANY:   "ANY",
+}
+
+const dnsRcodeNXDomain = 3
+
+var dnsRcodeNames = map[uint8]string{
+	0: "NOERROR",
+	1: "FORMERR",
+	2: "SERVFAIL",
+	3: "NXDOMAIN",
+	4: "NOTIMP",
+	5: "REFUSED",
+}
+
+// dnsPort is where the detectors look for DNS over UDP and TCP
+const dnsPort = 53
+
+// maxDNSRecords bounds the records decoded per section
+const maxDNSRecords = 64
+
+var errDNSTruncated = errors.New("truncated DNS message")
+
+type dnsQuestion struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+type dnsAnswer struct {
+	Name string
+	Type uint16
+	TTL  uint32
+	Data string // address, name or text, depending on the type
+	Size int    // RDATA length
+}
+
+// dnsMessage is a decoded DNS query or response
+type dnsMessage struct {
+	ID        uint16
+	Response  bool
+	Opcode    uint8
+	Truncated bool
+	Rcode     uint8
+	Questions []dnsQuestion
+	Answers   []dnsAnswer
+	Size      int // bytes on the wire
+}
+
+// question returns the first question, which is the only one in practice
+func (m *dnsMessage) question() (dnsQuestion, bool) {
+	if len(m.Questions) == 0 {
+		return dnsQuestion{}, false
+	}
+	return m.Questions[0], true
+}
+
+func dnsTypeName(t uint16) string {
+	if name, ok := dnsTypeNames[t]; ok {
+		return name
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+func dnsRcodeName(rcode uint8) string {
+	if name, ok := dnsRcodeNames[rcode]; ok {
+		return name
+	}
+	return "RCODE" + strconv.Itoa(int(rcode))
+}
+
+// parseDNS decodes the header, questions and answers of a DNS message.
+// Authority and additional records are skipped.
+func parseDNS(data []byte) (*dnsMessage, error) {
+	if len(data) < 12 {
+		return nil, errDNSTruncated
+	}
+
+	flags := binary.BigEndian.Uint16(data[2:4])
+	m := &dnsMessage{
+		ID:        binary.BigEndian.Uint16(data[0:2]),
+		Response:  flags&0x8000 != 0,
+		Opcode:    uint8(flags>>11) & 0x0F,
+		Truncated: flags&0x0200 != 0,
+		Rcode:     uint8(flags & 0x000F),
+		Size:      len(data),
+	}
+	questions := int(binary.BigEndian.Uint16(data[4:6]))
+	answers := int(binary.BigEndian.Uint16(data[6:8]))
+	if questions > maxDNSRecords || answers > maxDNSRecords*4 {
+		return nil, fmt.Errorf("implausible DNS record counts %d/%d", questions, answers)
+	}
+
+	offset := 12
+	for i := 0; i < questions; i++ {
+		name, next, err := readDNSName(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(data) {
+			return nil, errDNSTruncated
+		}
+		m.Questions = append(m.Questions, dnsQuestion{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(data[next : next+2]),
+			Class: binary.BigEndian.Uint16(data[next+2 : next+4]),
+		})
+		offset = next + 4
+	}
+
+	for i := 0; i < answers; i++ {
+		name, next, err := readDNSName(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(data) {
+			return nil, errDNSTruncated
+		}
+		rrType := binary.BigEndian.Uint16(data[next : next+2])
+		ttl := binary.BigEndian.Uint32(data[next+4 : next+8])
+		length := int(binary.BigEndian.Uint16(data[next+8 : next+10]))
+		start := next + 10
+		if start+length > len(data) {
+			return nil, errDNSTruncated
+		}
+		if len(m.Answers) < maxDNSRecords {
+			m.Answers = append(m.Answers, dnsAnswer{
+				Name: name,
+				Type: rrType,
+				TTL:  ttl,
+				Data: dnsRecordData(data, rrType, start, length),
+				Size: length,
+			})
+		}
+		offset = start + length
+	}
+	return m, nil
+}
+
+// readDNSName reads a possibly compressed name at offset and returns it with
+// the offset following it
+func readDNSName(data []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(data) {
+			return "", 0, errDNSTruncated
+		}
+		length := int(data[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(data) {
+				return "", 0, errDNSTruncated
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, fmt.Errorf("DNS name compression loop")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(data[offset:offset+2]) & 0x3FFF)
+
+		case length&0xC0 != 0:
+			return "", 0, fmt.Errorf("unsupported DNS label type %#x", length&0xC0)
+
+		default:
+			if offset+1+length > len(data) {
+				return "", 0, errDNSTruncated
+			}
+			labels = append(labels, strings.ToLower(string(data[offset+1:offset+1+length])))
+			offset += 1 + length
+		}
+	}
+}
+
+func dnsRecordData(data []byte, rrType uint16, start, length int) string {
+	rdata := data[start : start+length]
+	switch rrType {
+	case dnsTypeA, dnsTypeAAAA:
+		if length == 4 || length == 16 {
+			return net.IP(rdata).String()
+		}
+	case dnsTypeNS, dnsTypeCNAME, dnsTypePTR:
+		if name, _, err := readDNSName(data, start); err == nil {
+			return name
+		}
+	case dnsTypeMX:
+		if length > 2 {
+			if name, _, err := readDNSName(data, start+2); err == nil {
+				return name
+			}
+		}
+	case dnsTypeTXT:
+		var parts []string
+		for i := 0; i < len(rdata); {
+			n := int(rdata[i])
+			if i+1+n > len(rdata) {
+				break
+			}
+			parts = append(parts, string(rdata[i+1:i+1+n]))
+			i += 1 + n
+		}
+		return strings.Join(parts, "")
+	}
+	return ""
+}
+
+// dnsOverTCP splits a TCP stream into its messages, each prefixed with its
+// two byte length, and returns the incomplete rest
+func dnsOverTCP(stream []byte) ([][]byte, []byte) {
+	var messages [][]byte
+	for len(stream) >= 2 {
+		length := int(binary.BigEndian.Uint16(stream[0:2]))
+		if 2+length > len(stream) {
+			break
+		}
+		if length > 0 {
+			messages = append(messages, stream[2:2+length])
+		}
+		stream = stream[2+length:]
+	}
+	return messages, stream
+}
@@ -0,0 +1,242 @@
+package native
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// dnsName encodes a name as uncompressed labels
+func dnsName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(name, ".") {
+		if label != "" {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0)
+}
+
+// dnsHeader encodes a header with the given flags and question and answer counts
+func dnsHeader(flags uint16, questions, answers int) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[0:2], 0x1234)
+	binary.BigEndian.PutUint16(b[2:4], flags)
+	binary.BigEndian.PutUint16(b[4:6], uint16(questions))
+	binary.BigEndian.PutUint16(b[6:8], uint16(answers))
+	return b
+}
+
+// dnsRecord encodes a resource record after name
+func dnsRecord(name []byte, rrType uint16, ttl uint32, rdata []byte) []byte {
+	b := slices.Clone(name)
+	b = binary.BigEndian.AppendUint16(b, rrType)
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = binary.BigEndian.AppendUint32(b, ttl)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...)
+}
+
+// dnsQuery encodes the question of a message
+func dnsQuery(name string, qType uint16) []byte {
+	b := dnsName(name)
+	b = binary.BigEndian.AppendUint16(b, qType)
+	return binary.BigEndian.AppendUint16(b, 1)
+}
+
+func TestReadDNSName(t *testing.T) {
+	// example.com at 12, www pointing to it at 25
+	compressed := slices.Concat(dnsHeader(0, 0, 0), dnsName("example.com"), []byte{3, 'w', 'w', 'w', 0xC0, 12, 0xFF})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		offset  int
+		want    string
+		next    int
+		wantErr bool
+	}{
+		{name: "plain", data: dnsName("www.example.com"), want: "www.example.com", next: 17},
+		{name: "root", data: []byte{0}, want: "", next: 1},
+		{name: "lowercased", data: dnsName("WWW.Example.COM"), want: "www.example.com", next: 17},
+		{name: "pointer", data: compressed, offset: 25, want: "www.example.com", next: 31},
+		{name: "pointer only", data: compressed, offset: 29, want: "example.com", next: 31},
+		{name: "pointer to a pointer", data: slices.Concat(compressed, []byte{0xC0, 25}), offset: 32, want: "www.example.com", next: 34},
+		{name: "pointer to itself", data: []byte{0xC0, 0}, wantErr: true},
+		{name: "pointers to each other", data: []byte{0xC0, 2, 0xC0, 0}, wantErr: true},
+		{name: "label pointing back to itself", data: []byte{1, 'a', 0xC0, 0}, wantErr: true},
+		{name: "pointer beyond the message", data: []byte{0xC0, 0x40}, wantErr: true},
+		{name: "pointer cut off", data: []byte{1, 'a', 0xC0}, wantErr: true},
+		{name: "label cut off", data: []byte{5, 'a', 'b'}, wantErr: true},
+		{name: "missing terminator", data: []byte{1, 'a'}, wantErr: true},
+		{name: "extended label type", data: []byte{0x41, 0}, wantErr: true},
+		{name: "offset beyond the message", data: []byte{0}, offset: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next, err := readDNSName(tt.data, tt.offset)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (got != tt.want || next != tt.next) {
+				t.Errorf("readDNSName = %q, %d, want %q, %d", got, next, tt.want, tt.next)
+			}
+		})
+	}
+}
+
+func TestParseDNS(t *testing.T) {
+	question := dnsQuery("example.com", dnsTypeA)
+	// Answers name the question by a pointer to offset 12
+	pointer := []byte{0xC0, 12}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    dnsMessage
+		wantErr bool
+	}{
+		{
+			name: "query",
+			data: slices.Concat(dnsHeader(0x0100, 1, 0), question),
+			want: dnsMessage{Questions: []dnsQuestion{{"example.com", dnsTypeA, 1}}},
+		},
+		{
+			name: "A response",
+			data: slices.Concat(dnsHeader(0x8180, 1, 2), question,
+				dnsRecord(pointer, dnsTypeA, 300, []byte{192, 0, 2, 1}),
+				dnsRecord(pointer, dnsTypeA, 300, []byte{192, 0, 2, 2})),
+			want: dnsMessage{
+				Response:  true,
+				Questions: []dnsQuestion{{"example.com", dnsTypeA, 1}},
+				Answers: []dnsAnswer{
+					{Name: "example.com", Type: dnsTypeA, TTL: 300, Data: "192.0.2.1", Size: 4},
+					{Name: "example.com", Type: dnsTypeA, TTL: 300, Data: "192.0.2.2", Size: 4},
+				},
+			},
+		},
+		{
+			name: "name records",
+			data: slices.Concat(dnsHeader(0x8180, 1, 3), question,
+				dnsRecord(pointer, dnsTypeCNAME, 60, slices.Concat([]byte{3, 'w', 'w', 'w'}, pointer)),
+				dnsRecord(pointer, dnsTypeMX, 60, slices.Concat([]byte{0, 10, 4, 'm', 'a', 'i', 'l'}, pointer)),
+				dnsRecord(pointer, dnsTypeTXT, 60, []byte{3, 'a', 'b', 'c', 2, 'd', 'e'})),
+			want: dnsMessage{
+				Response:  true,
+				Questions: []dnsQuestion{{"example.com", dnsTypeA, 1}},
+				Answers: []dnsAnswer{
+					{Name: "example.com", Type: dnsTypeCNAME, TTL: 60, Data: "www.example.com", Size: 6},
+					{Name: "example.com", Type: dnsTypeMX, TTL: 60, Data: "mail.example.com", Size: 9},
+					{Name: "example.com", Type: dnsTypeTXT, TTL: 60, Data: "abcde", Size: 7},
+				},
+			},
+		},
+		{
+			name: "NXDOMAIN",
+			data: slices.Concat(dnsHeader(0x8183, 1, 0), question),
+			want: dnsMessage{Response: true, Rcode: dnsRcodeNXDomain, Questions: []dnsQuestion{{"example.com", dnsTypeA, 1}}},
+		},
+		{
+			name: "truncated flag",
+			data: slices.Concat(dnsHeader(0x8380, 1, 0), question),
+			want: dnsMessage{Response: true, Truncated: true, Questions: []dnsQuestion{{"example.com", dnsTypeA, 1}}},
+		},
+		{
+			name: "opcode",
+			data: dnsHeader(0x2800, 0, 0),
+			want: dnsMessage{Opcode: 5},
+		},
+		{name: "short header", data: dnsHeader(0, 0, 0)[:11], wantErr: true},
+		{name: "implausible question count", data: dnsHeader(0, maxDNSRecords+1, 0), wantErr: true},
+		{name: "missing question", data: dnsHeader(0, 1, 0), wantErr: true},
+		{name: "question cut off", data: slices.Concat(dnsHeader(0, 1, 0), question[:len(question)-1]), wantErr: true},
+		{
+			name:    "answer data beyond the message",
+			data:    slices.Concat(dnsHeader(0x8180, 1, 1), question, dnsRecord(pointer, dnsTypeA, 300, []byte{192, 0, 2, 1})[:13]),
+			wantErr: true,
+		},
+		{
+			name:    "answer name loop",
+			data:    slices.Concat(dnsHeader(0x8180, 1, 1), question, dnsRecord([]byte{0xC0, 29}, dnsTypeA, 300, []byte{192, 0, 2, 1})),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDNS(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			tt.want.ID = 0x1234
+			tt.want.Size = len(tt.data)
+			if got.ID != tt.want.ID || got.Response != tt.want.Response || got.Opcode != tt.want.Opcode ||
+				got.Truncated != tt.want.Truncated || got.Rcode != tt.want.Rcode || got.Size != tt.want.Size {
+				t.Errorf("header = %+v, want %+v", *got, tt.want)
+			}
+			if !slices.Equal(got.Questions, tt.want.Questions) {
+				t.Errorf("questions = %+v, want %+v", got.Questions, tt.want.Questions)
+			}
+			if !slices.Equal(got.Answers, tt.want.Answers) {
+				t.Errorf("answers = %+v, want %+v", got.Answers, tt.want.Answers)
+			}
+		})
+	}
+}
+
+func TestParseDNSManyAnswers(t *testing.T) {
+	data := slices.Concat(dnsHeader(0x8180, 1, 0), dnsQuery("example.com", dnsTypeANY))
+	binary.BigEndian.PutUint16(data[6:8], maxDNSRecords+10)
+	for range maxDNSRecords + 10 {
+		data = append(data, dnsRecord([]byte{0xC0, 12}, dnsTypeA, 300, []byte{192, 0, 2, 1})...)
+	}
+
+	m, err := parseDNS(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Answers) != maxDNSRecords {
+		t.Errorf("%d answers kept, want %d", len(m.Answers), maxDNSRecords)
+	}
+}
+
+func TestDNSOverTCP(t *testing.T) {
+	framed := func(messages ...string) []byte {
+		var b []byte
+		for _, m := range messages {
+			b = binary.BigEndian.AppendUint16(b, uint16(len(m)))
+			b = append(b, m...)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name   string
+		stream []byte
+		want   []string
+		rest   []byte
+	}{
+		{"one message", framed("abc"), []string{"abc"}, nil},
+		{"pipelined", framed("abc", "de"), []string{"abc", "de"}, nil},
+		{"partial message", framed("abc", "defg")[:8], []string{"abc"}, framed("defg")[:3]},
+		{"partial length", framed("abc")[:1], nil, framed("abc")[:1]},
+		{"empty message skipped", framed("", "abc"), []string{"abc"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, rest := dnsOverTCP(tt.stream)
+			var got []string
+			for _, m := range messages {
+				got = append(got, string(m))
+			}
+			if !slices.Equal(got, tt.want) || !bytes.Equal(rest, tt.rest) {
+				t.Errorf("dnsOverTCP = %q, rest %q, want %q, rest %q", got, rest, tt.want, tt.rest)
+			}
+		})
+	}
+}
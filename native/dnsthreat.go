@@ -0,0 +1,436 @@
+package native
+
+import (
+	"bytes"
+	"fmt"
+	"main/model"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNS signatures
+const (
+	SigDNSTunnel        = GID + ":1201" // many long or high-entropy names under one domain
+	SigDNSTXTVolume     = GID + ":1202" // many TXT lookups or much TXT data for one domain
+	SigDNSNXDomainStorm = GID + ":1203" // many names that don't exist, typical of a DGA
+	SigDNSReflection    = GID + ":1204" // responses flooding a host that never asked for them
+	SigDNSAmplification = GID + ":1205" // large ANY answers sent to one host
+)
+
+// maxOutstanding bounds the queries remembered to tell answers from reflected responses
+const maxOutstanding = 65536
+
+// sampleNames is how many names a detection lists
+const sampleNames = 10
+
+type DNSConfig struct {
+	Enabled                bool    `json:"enabled"`
+	Window                 int     `json:"window"`                  // seconds
+	LongLabel              int     `json:"long_label"`              // label length that looks like encoded data
+	EntropyLength          int     `json:"entropy_length"`          // subdomain length from which its entropy is checked
+	MinEntropy             float64 `json:"min_entropy"`             // bits per character of a subdomain that looks like encoded data
+	TunnelQueries          int     `json:"tunnel_queries"`          // distinct suspicious names under one domain within Window
+	TXTQueries             int     `json:"txt_queries"`             // TXT lookups of one domain by one host within Window
+	TXTBytes               int     `json:"txt_bytes"`               // TXT answer bytes of one domain to one host within Window
+	NXDomain               int     `json:"nxdomain"`                // distinct names answered NXDOMAIN to one host within Window
+	ReflectionResponses    int     `json:"reflection_responses"`    // responses to one host without a matching query within Window
+	AmplificationSize      int     `json:"amplification_size"`      // bytes from which an ANY answer counts as amplified
+	AmplificationResponses int     `json:"amplification_responses"` // amplified answers to one host within Window
+	Cooldown               int     `json:"cooldown"`                // seconds before the same finding is reported again
+}
+
+func DefaultDNSConfig() DNSConfig {
+	return DNSConfig{
+		Enabled:                true,
+		Window:                 60,
+		LongLabel:              50,
+		EntropyLength:          24,
+		MinEntropy:             3.5,
+		TunnelQueries:          20,
+		TXTQueries:             50,
+		TXTBytes:               65536,
+		NXDomain:               50,
+		ReflectionResponses:    100,
+		AmplificationSize:      1024,
+		AmplificationResponses: 20,
+		Cooldown:               300,
+	}
+}
+
+func (c DNSConfig) validate() error {
+	if c.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	if c.LongLabel <= 0 || c.EntropyLength <= 0 || c.MinEntropy <= 0 {
+		return fmt.Errorf("long_label, entropy_length and min_entropy must be positive")
+	}
+	if c.TunnelQueries <= 0 || c.TXTQueries <= 0 || c.TXTBytes <= 0 || c.NXDomain <= 0 ||
+		c.ReflectionResponses <= 0 || c.AmplificationSize <= 0 || c.AmplificationResponses <= 0 {
+		return fmt.Errorf("thresholds must be positive")
+	}
+	if c.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative")
+	}
+	return nil
+}
+
+// dnsThreats decodes DNS over UDP and TCP port 53 and looks for tunneling,
+// DGA lookups and reflection
+type dnsThreats struct {
+	mu     sync.Mutex
+	config DNSConfig
+	emit   func(model.Detection)
+
+	outstanding recent     // "client|server|id" of queries waiting for an answer
+	tunnelNames recentSets // suspicious names, by "client|domain"
+	txt         tallies    // TXT lookups and answer bytes, by "client|domain"
+	nxNames     recentSets // names answered NXDOMAIN, by client
+	reflected   tallies    // responses without a query, by client
+	amplified   tallies    // large ANY answers, by client
+	reported    cooldown
+	lastSweep   time.Time
+}
+
+func newDNSThreats(config DNSConfig, emit func(model.Detection)) *dnsThreats {
+	return &dnsThreats{
+		config:      config,
+		emit:        emit,
+		outstanding: make(recent),
+		tunnelNames: make(recentSets),
+		txt:         make(tallies),
+		nxNames:     make(recentSets),
+		reflected:   make(tallies),
+		amplified:   make(tallies),
+		reported:    make(cooldown),
+	}
+}
+
+func (d *dnsThreats) udp(p *model.PacketAnalysisUDP, now time.Time) {
+	udp := p.UDP
+	if udp.SourcePort != dnsPort && udp.DestinationPort != dnsPort {
+		return
+	}
+	m, err := parseDNS(udp.Payload)
+	if err != nil {
+		packetLog.Debug("undecodable DNS message", "protocol", "udp", "source", p.IPv4.SourceIP, "error", err)
+		return
+	}
+	d.observe(m, "UDP", p.IPv4.SourceIP, p.IPv4.DestinationIP, now)
+}
+
+// dnsStream splits both directions of a DNS over TCP connection into messages
+type dnsStream struct {
+	detector *dnsThreats
+	id       streamID
+	buf      [2][]byte
+	lost     [2]bool // a gap put the direction out of step with the length prefixes
+}
+
+func (d *dnsThreats) newStream(id streamID, now time.Time) streamHandler {
+	if id.ServerPort != dnsPort {
+		return nil
+	}
+	return &dnsStream{detector: d, id: id}
+}
+
+func (s *dnsStream) data(dir direction, data []byte, now time.Time) {
+	if s.lost[dir] {
+		return
+	}
+	src, dst := s.id.Client, s.id.Server
+	if dir == toClient {
+		src, dst = dst, src
+	}
+
+	messages, rest := dnsOverTCP(append(s.buf[dir], data...))
+	for _, message := range messages {
+		m, err := parseDNS(message)
+		if err != nil {
+			packetLog.Debug("undecodable DNS message", "protocol", "tcp", "source", src, "error", err)
+			continue
+		}
+		s.detector.observe(m, "TCP", src, dst, now)
+	}
+	s.buf[dir] = bytes.Clone(rest)
+}
+
+func (s *dnsStream) gap(dir direction, n int) {
+	s.buf[dir] = nil
+	s.lost[dir] = true
+}
+
+func (s *dnsStream) close(now time.Time) {}
+
+func (d *dnsThreats) observe(m *dnsMessage, protocol, src, dst string, now time.Time) {
+	q, ok := d.question(m)
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sweep(now)
+	if m.Response {
+		d.response(m, q, protocol, dst, src, now)
+	} else {
+		d.query(q, protocol, src, dst, m.ID, now)
+	}
+}
+
+// question returns the question of a standard query or its response
+func (d *dnsThreats) question(m *dnsMessage) (dnsQuestion, bool) {
+	if m.Opcode != 0 {
+		return dnsQuestion{}, false
+	}
+	return m.question()
+}
+
+// query must be called with d.mu held
+func (d *dnsThreats) query(q dnsQuestion, protocol, client, server string, id uint16, now time.Time) {
+	window := seconds(d.config.Window)
+	if len(d.outstanding) < maxOutstanding {
+		d.outstanding.add(outstandingKey(client, server, id), now, window)
+	}
+
+	domain := registeredDomain(q.Name)
+	if label, entropy, suspicious := d.encoded(q.Name, domain); suspicious {
+		names := d.tunnelNames.get(client + "|" + domain)
+		if names.add(q.Name, now, window) && names.count(now, window) >= d.config.TunnelQueries {
+			seen := names.values(now, window)
+			d.report("tunnel|"+client+"|"+domain, now, model.Detection{
+				Protocol:    protocol,
+				AttackerIP:  client,
+				TargetIP:    server,
+				TargetPort:  strconv.Itoa(dnsPort),
+				Message:     fmt.Sprintf("DNS tunneling via %s: %d encoded-looking names in %s", domain, len(seen), window),
+				SignatureID: SigDNSTunnel,
+				Severity:    model.SeverityHigh,
+				Evidence: map[string]any{
+					"domain":        domain,
+					"names":         limit(seen, sampleNames),
+					"query_type":    dnsTypeName(q.Type),
+					"longest_label": label,
+					"entropy":       math.Round(entropy*100) / 100,
+					"window":        window.String(),
+				},
+			})
+		}
+	}
+
+	if q.Type == dnsTypeTXT {
+		t := d.txt.get(client+"|"+domain, now, window)
+		t.add(server, 0)
+		d.checkTXT(t, protocol, client, server, domain, now)
+	}
+}
+
+// response must be called with d.mu held
+func (d *dnsThreats) response(m *dnsMessage, q dnsQuestion, protocol, client, server string, now time.Time) {
+	window := seconds(d.config.Window)
+
+	key := outstandingKey(client, server, m.ID)
+	asked, ok := d.outstanding[key]
+	delete(d.outstanding, key)
+	if !ok || now.Sub(asked) > window {
+		t := d.reflected.get(client, now, window)
+		t.add(server, m.Size)
+		if t.count >= d.config.ReflectionResponses {
+			reflectors := topKeys(t.peers, topPeers)
+			d.report("reflection|"+client, now, model.Detection{
+				Protocol:    protocol,
+				AttackerIP:  reflectors[0],
+				TargetIP:    client,
+				Message:     fmt.Sprintf("DNS reflection against %s: %d unsolicited responses (%d bytes) from %d servers", client, t.count, t.bytes, len(t.peers)),
+				SignatureID: SigDNSReflection,
+				Severity:    model.SeverityHigh,
+				Evidence: map[string]any{
+					"responses":  t.count,
+					"bytes":      t.bytes,
+					"reflectors": reflectors,
+					"query_name": q.Name,
+					"query_type": dnsTypeName(q.Type),
+					"window":     window.String(),
+				},
+			})
+		}
+	}
+
+	if q.Type == dnsTypeANY && m.Size >= d.config.AmplificationSize {
+		t := d.amplified.get(client, now, window)
+		t.add(server, m.Size)
+		if t.count >= d.config.AmplificationResponses {
+			// The query came from the victim's spoofed address, there is nobody to block
+			d.report("amplification|"+client, now, model.Detection{
+				Protocol:    protocol,
+				TargetIP:    client,
+				Message:     fmt.Sprintf("DNS amplification against %s: %d ANY answers of %d bytes on average", client, t.count, t.bytes/t.count),
+				SignatureID: SigDNSAmplification,
+				Severity:    model.SeverityHigh,
+				Evidence: map[string]any{
+					"responses":  t.count,
+					"bytes":      t.bytes,
+					"resolvers":  topKeys(t.peers, topPeers),
+					"query_name": q.Name,
+					"window":     window.String(),
+				},
+			})
+		}
+	}
+
+	domain := registeredDomain(q.Name)
+	if m.Rcode == dnsRcodeNXDomain {
+		names := d.nxNames.get(client)
+		if names.add(q.Name, now, window) && names.count(now, window) >= d.config.NXDomain {
+			seen := names.values(now, window)
+			d.report("nxdomain|"+client, now, model.Detection{
+				Protocol:    protocol,
+				AttackerIP:  client,
+				TargetIP:    server,
+				TargetPort:  strconv.Itoa(dnsPort),
+				Message:     fmt.Sprintf("NXDOMAIN storm from %s: %d nonexistent names in %s, possible DGA", client, len(seen), window),
+				SignatureID: SigDNSNXDomainStorm,
+				Severity:    model.SeverityMedium,
+				Evidence: map[string]any{
+					"names":   limit(seen, sampleNames),
+					"entropy": math.Round(averageEntropy(seen)*100) / 100,
+					"rcode":   dnsRcodeName(m.Rcode),
+					"window":  window.String(),
+				},
+			})
+		}
+	}
+
+	txtBytes := 0
+	for _, answer := range m.Answers {
+		if answer.Type == dnsTypeTXT {
+			txtBytes += answer.Size
+		}
+	}
+	if txtBytes > 0 {
+		t := d.txt.get(client+"|"+domain, now, window)
+		t.bytes += txtBytes
+		d.checkTXT(t, protocol, client, server, domain, now)
+	}
+}
+
+func (d *dnsThreats) checkTXT(t *tally, protocol, client, server, domain string, now time.Time) {
+	if t.count < d.config.TXTQueries && t.bytes < d.config.TXTBytes {
+		return
+	}
+	window := seconds(d.config.Window)
+	d.report("txt|"+client+"|"+domain, now, model.Detection{
+		Protocol:    protocol,
+		AttackerIP:  client,
+		TargetIP:    server,
+		TargetPort:  strconv.Itoa(dnsPort),
+		Message:     fmt.Sprintf("High TXT volume for %s: %d lookups, %d answer bytes in %s", domain, t.count, t.bytes, window),
+		SignatureID: SigDNSTXTVolume,
+		Severity:    model.SeverityMedium,
+		Evidence: map[string]any{
+			"domain":  domain,
+			"queries": t.count,
+			"bytes":   t.bytes,
+			"window":  window.String(),
+		},
+	})
+}
+
+// encoded reports whether the part of name below domain looks like encoded
+// data, with its longest label and entropy
+func (d *dnsThreats) encoded(name, domain string) (int, float64, bool) {
+	sub := strings.TrimSuffix(strings.TrimSuffix(name, domain), ".")
+	if sub == "" {
+		return 0, 0, false
+	}
+
+	longest := 0
+	for _, label := range strings.Split(sub, ".") {
+		longest = max(longest, len(label))
+	}
+	data := strings.ReplaceAll(sub, ".", "")
+	entropy := shannonEntropy(data)
+	if longest >= d.config.LongLabel {
+		return longest, entropy, true
+	}
+	return longest, entropy, len(data) >= d.config.EntropyLength && entropy >= d.config.MinEntropy
+}
+
+func (d *dnsThreats) report(key string, now time.Time, alert model.Detection) {
+	if d.reported.ready(key, now, seconds(d.config.Cooldown)) {
+		d.emit(alert)
+	}
+}
+
+// sweep must be called with d.mu held
+func (d *dnsThreats) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < sweepInterval {
+		return
+	}
+	d.lastSweep = now
+
+	window := seconds(d.config.Window)
+	d.outstanding.prune(now, window)
+	d.tunnelNames.prune(now, window)
+	d.txt.prune(now, window)
+	d.nxNames.prune(now, window)
+	d.reflected.prune(now, window)
+	d.amplified.prune(now, window)
+	d.reported.prune(now)
+}
+
+func outstandingKey(client, server string, id uint16) string {
+	return client + "|" + server + "|" + strconv.Itoa(int(id))
+}
+
+// secondLevel are labels under a country code TLD that are registries
+// themselves, as in co.uk or com.au
+var secondLevel = map[string]bool{"co": true, "com": true, "net": true, "org": true, "gov": true, "ac": true, "edu": true}
+
+// registeredDomain approximates the domain a name was registered under,
+// e.g. "example.com" for "a.b.example.com" and "example.co.uk" for "x.example.co.uk"
+func registeredDomain(name string) string {
+	labels := strings.Split(name, ".")
+	n := 2
+	if len(labels) >= 3 && len(labels[len(labels)-1]) == 2 && secondLevel[labels[len(labels)-2]] {
+		n = 3
+	}
+	if len(labels) <= n {
+		return name
+	}
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+// shannonEntropy returns the bits per byte of s
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+func averageEntropy(names []string) float64 {
+	if len(names) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, name := range names {
+		total += shannonEntropy(strings.Split(name, ".")[0])
+	}
+	return total / float64(len(names))
+}
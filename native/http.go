@@ -0,0 +1,142 @@
+package native
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxHTTPBuffer bounds the bytes buffered per connection while a request is incomplete
+const maxHTTPBuffer = 64 * 1024
+
+// httpRequest is a decoded HTTP/1.x request
+type httpRequest struct {
+	Method  string
+	URI     string
+	Path    string
+	Query   string // raw, without the "?"
+	Version string
+	Host    string
+	Header  http.Header
+	Body    []byte // at most the configured body limit
+}
+
+// httpParser splits the client side of a connection into requests. It is fed
+// the reassembled stream and keeps what doesn't form a complete request yet.
+type httpParser struct {
+	buf      []byte
+	maxBody  int
+	disabled bool // the stream isn't HTTP or can't be followed
+	resync   bool // bytes were lost, wait for the next request line
+}
+
+// feed appends data and returns the requests it completes
+func (p *httpParser) feed(data []byte) []*httpRequest {
+	if p.disabled || len(data) == 0 {
+		return nil
+	}
+	if p.resync {
+		// Only a segment starting a request gets the parser back in step
+		if !looksLikeHTTP(data) {
+			return nil
+		}
+		p.resync = false
+	}
+	if len(p.buf) == 0 && !looksLikeHTTP(data) {
+		p.disabled = true
+		return nil
+	}
+	p.buf = append(p.buf, data...)
+
+	var requests []*httpRequest
+	for len(p.buf) > 0 {
+		request, consumed, err := parseHTTPRequest(p.buf, p.maxBody)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			if len(p.buf) > maxHTTPBuffer {
+				if request != nil {
+					// Inspect the part of the body we have
+					requests = append(requests, request)
+				}
+				p.disabled = true
+				p.buf = nil
+			}
+			break
+		}
+		if err != nil {
+			p.disabled = true
+			p.buf = nil
+			break
+		}
+		requests = append(requests, request)
+		p.buf = p.buf[consumed:]
+	}
+	if len(p.buf) == 0 {
+		p.buf = nil
+	}
+	return requests
+}
+
+// gap drops the partial request lost bytes belong to
+func (p *httpParser) gap() {
+	p.buf = nil
+	p.resync = true
+}
+
+// parseHTTPRequest decodes the request at the start of data and returns how many
+// bytes it took. io.ErrUnexpectedEOF means data ends before the request does, in
+// which case the request is returned if its headers were complete.
+func parseHTTPRequest(data []byte, maxBody int) (*httpRequest, int, error) {
+	if !bytes.Contains(data, []byte("\r\n\r\n")) && !bytes.Contains(data, []byte("\n\n")) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	input := bytes.NewReader(data)
+	reader := bufio.NewReader(input)
+	r, err := http.ReadRequest(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	request := &httpRequest{
+		Method:  r.Method,
+		URI:     r.RequestURI,
+		Query:   r.URL.RawQuery,
+		Version: r.Proto,
+		Host:    r.Host,
+		Header:  r.Header,
+	}
+	// The path as sent, URL.EscapedPath would re-encode what the client decoded
+	request.Path, _, _ = strings.Cut(r.RequestURI, "?")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		request.Body = limitBytes(body, maxBody)
+		return request, 0, io.ErrUnexpectedEOF
+	}
+	request.Body = limitBytes(body, maxBody)
+	return request, len(data) - input.Len() - reader.Buffered(), nil
+}
+
+// looksLikeHTTP reports whether data starts with an HTTP request line
+func looksLikeHTTP(data []byte) bool {
+	method, _, found := bytes.Cut(data, []byte(" "))
+	if !found || len(method) > 16 {
+		return false
+	}
+	for _, c := range method {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func limitBytes(data []byte, n int) []byte {
+	if len(data) > n {
+		return data[:n]
+	}
+	return data
+}
@@ -0,0 +1,159 @@
+package native
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseHTTPRequest(t *testing.T) {
+	get := "GET /search?q=1 HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	post := "POST /login HTTP/1.1\r\nHost: example.com\r\nContent-Length: 11\r\n\r\nuser=alice&"
+	chunked := "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\n\r\n"
+
+	tests := []struct {
+		name     string
+		data     string
+		maxBody  int
+		want     httpRequest
+		consumed int
+		wantErr  error // io.ErrUnexpectedEOF, or any other error as errMalformed
+	}{
+		{
+			name:     "GET",
+			data:     get,
+			want:     httpRequest{Method: "GET", URI: "/search?q=1", Path: "/search", Query: "q=1", Version: "HTTP/1.1", Host: "example.com"},
+			consumed: len(get),
+		},
+		{
+			name:     "path kept as sent",
+			data:     "GET /a%2Fb/../c HTTP/1.0\r\n\r\n",
+			want:     httpRequest{Method: "GET", URI: "/a%2Fb/../c", Path: "/a%2Fb/../c", Version: "HTTP/1.0"},
+			consumed: 28,
+		},
+		{
+			name:     "bare line feeds",
+			data:     "GET / HTTP/1.1\nHost: example.com\n\n",
+			want:     httpRequest{Method: "GET", URI: "/", Path: "/", Version: "HTTP/1.1", Host: "example.com"},
+			consumed: 34,
+		},
+		{
+			name:     "body",
+			data:     post,
+			maxBody:  1024,
+			want:     httpRequest{Method: "POST", URI: "/login", Path: "/login", Version: "HTTP/1.1", Host: "example.com", Body: []byte("user=alice&")},
+			consumed: len(post),
+		},
+		{
+			name:     "body limited",
+			data:     post,
+			maxBody:  4,
+			want:     httpRequest{Method: "POST", URI: "/login", Path: "/login", Version: "HTTP/1.1", Host: "example.com", Body: []byte("user")},
+			consumed: len(post),
+		},
+		{
+			name:     "chunked body",
+			data:     chunked + get,
+			maxBody:  1024,
+			want:     httpRequest{Method: "POST", URI: "/", Path: "/", Version: "HTTP/1.1", Body: []byte("abcde")},
+			consumed: len(chunked),
+		},
+		{
+			name:     "pipelined",
+			data:     get + get,
+			want:     httpRequest{Method: "GET", URI: "/search?q=1", Path: "/search", Query: "q=1", Version: "HTTP/1.1", Host: "example.com"},
+			consumed: len(get),
+		},
+		{
+			name:    "headers cut off",
+			data:    get[:len(get)-2],
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "body cut off",
+			data:    post[:len(post)-3],
+			maxBody: 1024,
+			want:    httpRequest{Method: "POST", URI: "/login", Path: "/login", Version: "HTTP/1.1", Host: "example.com", Body: []byte("user=ali")},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{name: "no request line", data: "\r\n\r\n", wantErr: errMalformed},
+		{name: "no version", data: "GET /\r\n\r\n", wantErr: errMalformed},
+		{name: "bad version", data: "GET / HTTP/x\r\n\r\n", wantErr: errMalformed},
+		{name: "bad header", data: "GET / HTTP/1.1\r\nno colon\r\n\r\n", wantErr: errMalformed},
+		{name: "bad content length", data: "POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n", wantErr: errMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, consumed, err := parseHTTPRequest([]byte(tt.data), tt.maxBody)
+			switch {
+			case tt.wantErr == nil && err != nil,
+				tt.wantErr == io.ErrUnexpectedEOF && !errors.Is(err, io.ErrUnexpectedEOF),
+				tt.wantErr == errMalformed && (err == nil || errors.Is(err, io.ErrUnexpectedEOF)):
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.want.Method == "" {
+				if got != nil {
+					t.Errorf("request = %+v, want none", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("no request")
+			}
+			if got.Method != tt.want.Method || got.URI != tt.want.URI || got.Path != tt.want.Path || got.Query != tt.want.Query ||
+				got.Version != tt.want.Version || got.Host != tt.want.Host || string(got.Body) != string(tt.want.Body) {
+				t.Errorf("request = %+v, want %+v", got, tt.want)
+			}
+			if consumed != tt.consumed {
+				t.Errorf("consumed %d bytes, want %d", consumed, tt.consumed)
+			}
+		})
+	}
+}
+
+// errMalformed stands for any error other than io.ErrUnexpectedEOF in the tests
+var errMalformed = errors.New("malformed")
+
+func TestHTTPParser(t *testing.T) {
+	get := func(path string) string {
+		return "GET " + path + " HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	}
+
+	tests := []struct {
+		name     string
+		feeds    []string // "" is a gap
+		want     []string // paths of the requests
+		disabled bool
+	}{
+		{name: "one request", feeds: []string{get("/a")}, want: []string{"/a"}},
+		{name: "pipelined", feeds: []string{get("/a") + get("/b")}, want: []string{"/a", "/b"}},
+		{name: "split across segments", feeds: []string{get("/a")[:10], get("/a")[10:] + get("/b")[:5], get("/b")[5:]}, want: []string{"/a", "/b"}},
+		{name: "not HTTP", feeds: []string{"\x16\x03\x01\x02\x00", get("/a")}, disabled: true},
+		{name: "malformed", feeds: []string{"GET / HTTP/x\r\n\r\n", get("/a")}, disabled: true},
+		{name: "gap resyncs on the next request", feeds: []string{get("/a")[:10], "", "st: example.com\r\n\r\n", get("/b")}, want: []string{"/b"}},
+		{name: "oversized headers", feeds: []string{"GET / HTTP/1.1\r\nX: " + strings.Repeat("a", maxHTTPBuffer)}, disabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &httpParser{maxBody: 1024}
+			var got []string
+			for _, data := range tt.feeds {
+				if data == "" {
+					p.gap()
+					continue
+				}
+				for _, r := range p.feed([]byte(data)) {
+					got = append(got, r.Path)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("requests = %q, want %q", got, tt.want)
+			}
+			if p.disabled != tt.disabled {
+				t.Errorf("disabled = %v, want %v", p.disabled, tt.disabled)
+			}
+		})
+	}
+}
@@ -0,0 +1,483 @@
+package native
+
+import (
+	"fmt"
+	"hash/fnv"
+	"main/model"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ICMP signatures
+const (
+	SigICMPTunnel      = GID + ":1501" // data carried in echo requests and replies
+	SigPingSweep       = GID + ":1502" // one source probing many hosts
+	SigICMPRedirect    = GID + ":1503" // redirects rerouting a host's traffic
+	SigICMPUnreachable = GID + ":1504" // forged unreachables tearing down connections or shrinking the path MTU
+)
+
+// ICMP types the detectors look at
+const (
+	icmpEchoReply   = 0
+	icmpUnreachable = 3
+	icmpRedirect    = 5
+	icmpEchoRequest = 8
+	icmpTimestamp   = 13
+	icmpAddressMask = 17
+)
+
+// icmpFragNeeded is the unreachable code carrying a next-hop MTU
+const icmpFragNeeded = 4
+
+const (
+	icmpEntropyLength = 64    // echo data bytes from which entropy is meaningful
+	maxEchoRequests   = 65536 // echo requests remembered to compare with their replies
+)
+
+var icmpTypeNames = map[uint64]string{
+	icmpEchoReply:   "echo_reply",
+	icmpUnreachable: "destination_unreachable",
+	icmpRedirect:    "redirect",
+	icmpEchoRequest: "echo_request",
+	icmpTimestamp:   "timestamp",
+	icmpAddressMask: "address_mask",
+}
+
+var unreachableCodeNames = map[uint64]string{
+	0:  "network_unreachable",
+	1:  "host_unreachable",
+	2:  "protocol_unreachable",
+	3:  "port_unreachable",
+	4:  "fragmentation_needed",
+	9:  "network_prohibited",
+	10: "host_prohibited",
+	13: "communication_prohibited",
+}
+
+var redirectCodeNames = map[uint64]string{
+	0: "network",
+	1: "host",
+	2: "tos_network",
+	3: "tos_host",
+}
+
+type ICMPConfig struct {
+	Enabled       bool    `json:"enabled"`
+	Window        int     `json:"window"`         // seconds packets are counted over
+	MaxPayload    int     `json:"max_payload"`    // echo data bytes beyond which a packet is oversized
+	MinEntropy    float64 `json:"min_entropy"`    // bits per byte from which echo data of 64 bytes or more looks encrypted or compressed
+	TunnelPackets int     `json:"tunnel_packets"` // suspicious echo packets between two hosts
+	SweepHosts    int     `json:"sweep_hosts"`    // hosts probed by one source
+	Redirects     int     `json:"redirects"`      // redirects from one source to one host
+	Unreachable   int     `json:"unreachable"`    // forged or abusive unreachables from one source to one host
+	MinMTU        int     `json:"min_mtu"`        // next-hop MTU below which fragmentation needed is abusive
+	Cooldown      int     `json:"cooldown"`       // seconds before the same finding is reported again
+}
+
+func DefaultICMPConfig() ICMPConfig {
+	return ICMPConfig{
+		Enabled:       true,
+		Window:        60,
+		MaxPayload:    512,
+		MinEntropy:    5.0,
+		TunnelPackets: 10,
+		SweepHosts:    20,
+		Redirects:     1,
+		Unreachable:   10,
+		MinMTU:        552,
+		Cooldown:      300,
+	}
+}
+
+func (c ICMPConfig) validate() error {
+	if c.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	if c.MaxPayload <= 0 || c.TunnelPackets <= 0 || c.SweepHosts <= 0 || c.Redirects <= 0 || c.Unreachable <= 0 {
+		return fmt.Errorf("thresholds must be positive")
+	}
+	if c.MinEntropy <= 0 || c.MinEntropy > 8 {
+		return fmt.Errorf("min_entropy must be between 0 and 8")
+	}
+	if c.MinMTU < 0 {
+		return fmt.Errorf("min_mtu must not be negative")
+	}
+	if c.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative")
+	}
+	return nil
+}
+
+// echoRequest is what a reply to the request should carry back
+type echoRequest struct {
+	at   time.Time
+	size int
+	sum  uint64
+}
+
+// echoStats counts the echo traffic between a pinging host and its peer in a
+// tumbling window
+type echoStats struct {
+	start      time.Time
+	packets    int
+	suspicious int
+	bytes      int
+	largest    int
+	reasons    map[string]int
+}
+
+// icmpThreats looks for tunnels in echo traffic, ping sweeps, and redirects
+// and unreachables that manipulate how a host routes or keeps its connections
+type icmpThreats struct {
+	mu     sync.Mutex
+	config ICMPConfig
+	emit   func(model.Detection)
+
+	requests     map[string]echoRequest // by "client|server|id|seq"
+	echoes       map[string]*echoStats  // by "client|server"
+	sweeps       recentSets             // hosts probed, by source
+	redirects    tallies                // by "source|destination", peers are gateways
+	unreachables tallies                // suspicious unreachables by "source|destination", peers are their reasons
+	reported     cooldown
+	lastSweep    time.Time
+}
+
+func newICMPThreats(config ICMPConfig, emit func(model.Detection)) *icmpThreats {
+	return &icmpThreats{
+		config:       config,
+		emit:         emit,
+		requests:     make(map[string]echoRequest),
+		echoes:       make(map[string]*echoStats),
+		sweeps:       make(recentSets),
+		redirects:    make(tallies),
+		unreachables: make(tallies),
+		reported:     make(cooldown),
+	}
+}
+
+func (d *icmpThreats) icmp(p *model.PacketAnalysisICMP, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sweep(now)
+	switch p.ICMP.Type {
+	case icmpEchoRequest, icmpEchoReply:
+		d.echo(p, now)
+	case icmpUnreachable:
+		d.unreachable(p, now)
+	case icmpRedirect:
+		d.redirect(p, now)
+	}
+	switch p.ICMP.Type {
+	case icmpEchoRequest, icmpTimestamp, icmpAddressMask:
+		d.probe(p, now)
+	}
+}
+
+// echo checks the data of an echo request or reply and compares replies with
+// their request, which they normally mirror
+func (d *icmpThreats) echo(p *model.PacketAnalysisICMP, now time.Time) {
+	icmp := p.ICMP
+	request := icmp.Type == icmpEchoRequest
+	client, server := p.IPv4.SourceIP, p.IPv4.DestinationIP
+	if !request {
+		client, server = server, client
+	}
+
+	var data []byte
+	if len(icmp.Payload) >= 4 {
+		// The payload starts with the identifier and sequence number
+		data = icmp.Payload[4:]
+	}
+
+	var reasons []string
+	if len(data) > d.config.MaxPayload {
+		reasons = append(reasons, "oversized")
+	}
+	if !standardEchoData(data) {
+		reasons = append(reasons, "non_standard")
+		if len(data) >= icmpEntropyLength && shannonEntropy(string(data)) >= d.config.MinEntropy {
+			reasons = append(reasons, "high_entropy")
+		}
+	}
+
+	sum := fnv.New64a()
+	sum.Write(data)
+	key := fmt.Sprintf("%s|%s|%d|%d", client, server, icmp.Identifier, icmp.Sequence)
+	if request {
+		if len(d.requests) < maxEchoRequests {
+			d.requests[key] = echoRequest{at: now, size: len(data), sum: sum.Sum64()}
+		}
+	} else if req, ok := d.requests[key]; ok {
+		delete(d.requests, key)
+		if req.size != len(data) || req.sum != sum.Sum64() {
+			reasons = append(reasons, "asymmetric")
+		}
+	}
+
+	window := seconds(d.config.Window)
+	pair := client + "|" + server
+	stats, ok := d.echoes[pair]
+	if !ok || now.Sub(stats.start) >= window {
+		stats = &echoStats{start: now, reasons: make(map[string]int)}
+		d.echoes[pair] = stats
+	}
+	stats.packets++
+	stats.bytes += len(data)
+	stats.largest = max(stats.largest, len(data))
+	if len(reasons) == 0 {
+		return
+	}
+	stats.suspicious++
+	for _, reason := range reasons {
+		stats.reasons[reason]++
+	}
+
+	if stats.suspicious < d.config.TunnelPackets || !d.reported.ready("tunnel|"+pair, now, seconds(d.config.Cooldown)) {
+		return
+	}
+	found := topKeys(stats.reasons, len(stats.reasons))
+	d.emit(model.Detection{
+		Protocol:    "ICMP",
+		AttackerIP:  client,
+		TargetIP:    server,
+		Message:     fmt.Sprintf("ICMP tunnel between %s and %s: %d suspicious echo packets (%s)", client, server, stats.suspicious, strings.Join(found, ", ")),
+		SignatureID: SigICMPTunnel,
+		Severity:    model.SeverityHigh,
+		Evidence: map[string]any{
+			"type":         icmp.Type,
+			"code":         icmp.Code,
+			"packets":      stats.packets,
+			"suspicious":   stats.suspicious,
+			"reasons":      maps.Clone(stats.reasons),
+			"average_size": stats.bytes / stats.packets,
+			"largest_size": stats.largest,
+			"entropy":      math.Round(shannonEntropy(string(data))*100) / 100,
+			"window":       window.String(),
+		},
+	})
+}
+
+// standardEchoData reports whether echo data looks like what ping tools send:
+// nothing, one repeated byte, Windows' alphabet, or the incrementing bytes of
+// Unix pings after an optional 8 or 16 byte timestamp
+func standardEchoData(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	repeated, alphabet := true, true
+	for i, b := range data {
+		repeated = repeated && b == data[0]
+		alphabet = alphabet && b == 'a'+byte(i%23)
+	}
+	if repeated || alphabet {
+		return true
+	}
+
+	for _, start := range []int{0, 8, 16} {
+		if len(data)-start < 8 {
+			break
+		}
+		incrementing := true
+		for i := start + 1; i < len(data) && incrementing; i++ {
+			incrementing = data[i] == data[i-1]+1
+		}
+		if incrementing {
+			return true
+		}
+	}
+	return false
+}
+
+// probe counts the hosts a source sends echo, timestamp or address mask requests to
+func (d *icmpThreats) probe(p *model.PacketAnalysisICMP, now time.Time) {
+	src, dst := p.IPv4.SourceIP, p.IPv4.DestinationIP
+	window := seconds(d.config.Window)
+
+	hosts := d.sweeps.get(src)
+	hosts.add(dst, now, window)
+	count := hosts.count(now, window)
+	if count < d.config.SweepHosts || !d.reported.ready("sweep|"+src, now, seconds(d.config.Cooldown)) {
+		return
+	}
+
+	probed := hosts.values(now, window)
+	d.emit(model.Detection{
+		Protocol:    "ICMP",
+		AttackerIP:  src,
+		TargetIP:    dst,
+		Message:     fmt.Sprintf("Ping sweep from %s: %d hosts in %s", src, count, window),
+		SignatureID: SigPingSweep,
+		Severity:    model.SeverityMedium,
+		Evidence: map[string]any{
+			"type":    p.ICMP.Type,
+			"code":    p.ICMP.Code,
+			"request": icmpTypeName(p.ICMP.Type),
+			"hosts":   count,
+			"subnets": subnets(probed),
+			"sample":  limit(probed, topPeers),
+			"window":  window.String(),
+		},
+	})
+}
+
+// redirect reports redirects telling a host to send its traffic elsewhere
+func (d *icmpThreats) redirect(p *model.PacketAnalysisICMP, now time.Time) {
+	icmp := p.ICMP
+	src, dst := p.IPv4.SourceIP, p.IPv4.DestinationIP
+
+	t := d.redirects.get(src+"|"+dst, now, seconds(d.config.Window))
+	t.add(icmp.Gateway, 0)
+	if t.count < d.config.Redirects || !d.reported.ready("redirect|"+src+"|"+dst+"|"+icmp.Gateway, now, seconds(d.config.Cooldown)) {
+		return
+	}
+
+	evidence := map[string]any{
+		"type":      icmp.Type,
+		"code":      icmp.Code,
+		"redirect":  codeName(redirectCodeNames, icmp.Code),
+		"gateway":   icmp.Gateway,
+		"redirects": t.count,
+		"gateways":  topKeys(t.peers, topPeers),
+	}
+	if original, ok := originalDatagram(icmp.Original); ok {
+		evidence["original_destination"] = original.destination
+	}
+	d.emit(model.Detection{
+		Protocol:    "ICMP",
+		AttackerIP:  src,
+		TargetIP:    dst,
+		Message:     fmt.Sprintf("ICMP redirect from %s tells %s to route via %s", src, dst, icmp.Gateway),
+		SignatureID: SigICMPRedirect,
+		Severity:    model.SeverityHigh,
+		Evidence:    evidence,
+	})
+}
+
+// unreachable counts unreachables that can't be genuine: ones about datagrams
+// the recipient never sent, and path MTUs too small to be real
+func (d *icmpThreats) unreachable(p *model.PacketAnalysisICMP, now time.Time) {
+	icmp := p.ICMP
+	src, dst := p.IPv4.SourceIP, p.IPv4.DestinationIP
+
+	var reasons []string
+	original, ok := originalDatagram(icmp.Original)
+	if !ok || original.source != dst {
+		reasons = append(reasons, "forged")
+	}
+	if icmp.Code == icmpFragNeeded && int(icmp.MTU) < d.config.MinMTU {
+		reasons = append(reasons, "low_mtu")
+	}
+	if len(reasons) == 0 {
+		return
+	}
+
+	t := d.unreachables.get(src+"|"+dst, now, seconds(d.config.Window))
+	t.add(strings.Join(reasons, "+"), 0)
+	if t.count < d.config.Unreachable || !d.reported.ready("unreachable|"+src+"|"+dst, now, seconds(d.config.Cooldown)) {
+		return
+	}
+
+	evidence := map[string]any{
+		"type":        icmp.Type,
+		"code":        icmp.Code,
+		"unreachable": codeName(unreachableCodeNames, icmp.Code),
+		"messages":    t.count,
+		"reasons":     maps.Clone(t.peers),
+		"window":      seconds(d.config.Window).String(),
+	}
+	if icmp.Code == icmpFragNeeded {
+		evidence["mtu"] = icmp.MTU
+	}
+	if ok {
+		evidence["original_source"] = original.source
+		evidence["original_destination"] = original.destination
+		evidence["original_protocol"] = original.protocol
+	}
+	d.emit(model.Detection{
+		Protocol:    "ICMP",
+		AttackerIP:  src,
+		TargetIP:    dst,
+		Message:     fmt.Sprintf("ICMP unreachable abuse from %s against %s: %d suspicious messages (%s)", src, dst, t.count, strings.Join(topKeys(t.peers, len(t.peers)), ", ")),
+		SignatureID: SigICMPUnreachable,
+		Severity:    model.SeverityMedium,
+		Evidence:    evidence,
+	})
+}
+
+// datagram is the IP header quoted by an ICMP error
+type datagram struct {
+	source, destination string
+	protocol            uint8
+}
+
+func originalDatagram(data []byte) (datagram, bool) {
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return datagram{}, false
+	}
+	return datagram{
+		source:      net.IP(data[12:16]).String(),
+		destination: net.IP(data[16:20]).String(),
+		protocol:    data[9],
+	}, true
+}
+
+func icmpTypeName(t uint64) string {
+	if name, ok := icmpTypeNames[t]; ok {
+		return name
+	}
+	return "type_" + strconv.FormatUint(t, 10)
+}
+
+func codeName(names map[uint64]string, code uint64) string {
+	if name, ok := names[code]; ok {
+		return name
+	}
+	return "code_" + strconv.FormatUint(code, 10)
+}
+
+// subnets returns the /24 networks of the given IPv4 addresses
+func subnets(hosts []string) []string {
+	var networks []string
+	for _, host := range hosts {
+		ip := net.ParseIP(host).To4()
+		if ip == nil {
+			continue
+		}
+		network := fmt.Sprintf("%d.%d.%d.0/24", ip[0], ip[1], ip[2])
+		if !slices.Contains(networks, network) {
+			networks = append(networks, network)
+		}
+	}
+	return limit(networks, topPeers)
+}
+
+// sweep must be called with d.mu held
+func (d *icmpThreats) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < sweepInterval {
+		return
+	}
+	d.lastSweep = now
+
+	window := seconds(d.config.Window)
+	for key, req := range d.requests {
+		if now.Sub(req.at) > window {
+			delete(d.requests, key)
+		}
+	}
+	for pair, stats := range d.echoes {
+		if now.Sub(stats.start) >= window {
+			delete(d.echoes, pair)
+		}
+	}
+	d.sweeps.prune(now, window)
+	d.redirects.prune(now, window)
+	d.unreachables.prune(now, window)
+	d.reported.prune(now)
+}
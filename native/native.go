@@ -0,0 +1,287 @@
+package native
+
+import (
+	"encoding/json"
+	"fmt"
+	"main/logging"
+	"main/metrics"
+	"main/model"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// GID is the generator id of native signatures, e.g. "9000:1001"
+const GID = "9000"
+
+// sweepInterval is how often idle tracking state is dropped
+const sweepInterval = 30 * time.Second
+
+var logger = logging.For("native")
+
+// packetLog reports undecodable packets at most once per message every 10 seconds
+var packetLog = logging.Limited(logger, 10*time.Second)
+
+// dropLog reports detections the engine had no room for at most every 30 seconds
+var dropLog = logging.Limited(logger, 30*time.Second)
+
+type Config struct {
+	TCP           TCPConfig           `json:"tcp"`
+	Reassembly    ReassemblyConfig    `json:"reassembly"`
+	PortScan      PortScanConfig      `json:"port_scan"`
+	SynFlood      SynFloodConfig      `json:"syn_flood"`
+	DNS           DNSConfig           `json:"dns"`
+	HTTP          HTTPConfig          `json:"http"`
+	BruteForce    BruteForceConfig    `json:"brute_force"`
+	ICMP          ICMPConfig          `json:"icmp"`
+	SlowHTTP      SlowHTTPConfig      `json:"slow_http"`
+	Amplification AmplificationConfig `json:"amplification"`
+	TLS           TLSConfig           `json:"tls"`
+}
+
+// DefaultConfig enables every detector with conservative thresholds
+func DefaultConfig() Config {
+	return Config{
+		TCP:           DefaultTCPConfig(),
+		Reassembly:    DefaultReassemblyConfig(),
+		PortScan:      DefaultPortScanConfig(),
+		SynFlood:      DefaultSynFloodConfig(),
+		DNS:           DefaultDNSConfig(),
+		HTTP:          DefaultHTTPConfig(),
+		BruteForce:    DefaultBruteForceConfig(),
+		ICMP:          DefaultICMPConfig(),
+		SlowHTTP:      DefaultSlowHTTPConfig(),
+		Amplification: DefaultAmplificationConfig(),
+		TLS:           DefaultTLSConfig(),
+	}
+}
+
+// Engine runs the native detectors over the packets decoded by the TCP, UDP
+// and ICMP analyzers and sends what they find to alert
+type Engine struct {
+	mu     sync.RWMutex
+	path   string
+	config Config
+	alert  chan<- model.Detection
+
+	tcp           *tcpTracker
+	portScan      *portScan
+	synFlood      *synFlood
+	dns           *dnsThreats
+	web           *webAttackDetector
+	brute         *bruteForce
+	icmp          *icmpThreats
+	slowHTTP      *slowHTTP
+	amplification *amplification
+	tls           *tlsThreats
+
+	reassembly *reassembler
+}
+
+// Load reads the native detector config from path, creating it with defaults if it doesn't exist
+func Load(path string, alert chan<- model.Detection) (*Engine, error) {
+	e := &Engine{path: path, alert: alert}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := e.SetConfig(DefaultConfig()); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read native detector config: %w", err)
+	}
+
+	config := DefaultConfig()
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse native detector config: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.apply(config); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Config returns the active configuration
+func (e *Engine) Config() Config {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.config
+}
+
+// SetConfig validates and activates config, then persists it. Detector state is reset.
+func (e *Engine) SetConfig(config Config) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.apply(config); err != nil {
+		return err
+	}
+	return e.save()
+}
+
+// apply must be called with e.mu held
+func (e *Engine) apply(config Config) error {
+	if err := config.TCP.validate(); err != nil {
+		return fmt.Errorf("tcp: %w", err)
+	}
+	if err := config.Reassembly.validate(); err != nil {
+		return fmt.Errorf("reassembly: %w", err)
+	}
+	if err := config.PortScan.validate(); err != nil {
+		return fmt.Errorf("port_scan: %w", err)
+	}
+	if err := config.SynFlood.validate(); err != nil {
+		return fmt.Errorf("syn_flood: %w", err)
+	}
+	if err := config.DNS.validate(); err != nil {
+		return fmt.Errorf("dns: %w", err)
+	}
+	if err := config.HTTP.validate(); err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+	if err := config.BruteForce.validate(); err != nil {
+		return fmt.Errorf("brute_force: %w", err)
+	}
+	if err := config.ICMP.validate(); err != nil {
+		return fmt.Errorf("icmp: %w", err)
+	}
+	if err := config.SlowHTTP.validate(); err != nil {
+		return fmt.Errorf("slow_http: %w", err)
+	}
+	if err := config.Amplification.validate(); err != nil {
+		return fmt.Errorf("amplification: %w", err)
+	}
+	if err := config.TLS.validate(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+
+	e.config = config
+	e.tcp = newTCPTracker(config.TCP)
+	e.portScan = newPortScan(config.PortScan, e.emit)
+	e.synFlood = newSynFlood(config.SynFlood, e.emit)
+	e.dns = newDNSThreats(config.DNS, e.emit)
+	e.web = newWebAttackDetector(config.HTTP, e.emit)
+	e.brute = newBruteForce(config.BruteForce, e.emit)
+	e.icmp = newICMPThreats(config.ICMP, e.emit)
+	e.slowHTTP = newSlowHTTP(config.SlowHTTP, e.emit)
+	e.amplification = newAmplification(config.Amplification, e.emit)
+	e.tls = newTLSThreats(config.TLS, e.emit)
+
+	e.reassembly = newReassembler(config.Reassembly)
+	if config.DNS.Enabled {
+		e.reassembly.register(e.dns)
+	}
+	if config.HTTP.Enabled {
+		e.reassembly.register(e.web)
+	}
+	if config.BruteForce.Enabled {
+		e.reassembly.register(e.brute)
+	}
+	if config.SlowHTTP.Enabled {
+		e.reassembly.register(e.slowHTTP)
+	}
+	if config.TLS.Enabled {
+		e.reassembly.register(e.tls)
+	}
+	return nil
+}
+
+func (e *Engine) save() error {
+	if e.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(e.config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(e.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write native detector config: %w", err)
+	}
+	return nil
+}
+
+// InspectTCP runs the TCP detectors over one decoded packet
+func (e *Engine) InspectTCP(packet *model.PacketAnalysisTCP) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	now := time.Now()
+	t := e.tcp.update(packet, now)
+	if e.config.PortScan.Enabled {
+		e.portScan.tcp(packet, now)
+	}
+	if e.config.SynFlood.Enabled {
+		e.synFlood.observe(packet, t, now)
+	}
+	e.reassembly.segment(packet, now)
+}
+
+// InspectUDP runs the UDP detectors over one decoded packet
+func (e *Engine) InspectUDP(packet *model.PacketAnalysisUDP) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	now := time.Now()
+	if e.config.PortScan.Enabled {
+		e.portScan.udp(packet, now)
+	}
+	if e.config.DNS.Enabled {
+		e.dns.udp(packet, now)
+	}
+	if e.config.Amplification.Enabled {
+		e.amplification.udp(packet, now)
+	}
+}
+
+// InspectICMP runs the ICMP detectors over one decoded packet
+func (e *Engine) InspectICMP(packet *model.PacketAnalysisICMP) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.config.ICMP.Enabled {
+		e.icmp.icmp(packet, time.Now())
+	}
+}
+
+// TLSHandshake removes and returns the TLS handshake of the connection a TCP
+// flow record started with, for the record
+func (e *Engine) TLSHandshake(flow model.Flow) (model.TLSHandshake, bool) {
+	sourcePort, err := strconv.ParseUint(flow.SourcePort, 10, 16)
+	if err != nil {
+		return model.TLSHandshake{}, false
+	}
+	destinationPort, err := strconv.ParseUint(flow.DestinationPort, 10, 16)
+	if err != nil {
+		return model.TLSHandshake{}, false
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.tls.take(streamID{Client: flow.SourceIP, ClientPort: sourcePort, Server: flow.DestinationIP, ServerPort: destinationPort})
+}
+
+// emit hands a detection to the engine. It runs on the packet path with
+// detector locks held, so when the engine falls behind the detection is
+// dropped rather than holding up NFQUEUE verdicts.
+func (e *Engine) emit(alert model.Detection) {
+	alert.Method = model.MethodNative
+	select {
+	case e.alert <- alert:
+	default:
+		metrics.NativeAlertsDropped.Inc(alert.SignatureID)
+		dropLog.Warn("engine busy, dropped native detection", "signature", alert.SignatureID, "attacker", alert.AttackerIP, "target", alert.TargetIP)
+	}
+}
@@ -0,0 +1,381 @@
+package native
+
+import (
+	"fmt"
+	"main/model"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Port scan signatures
+const (
+	SigVerticalScan    = GID + ":1001" // many ports of one host
+	SigHorizontalScan  = GID + ":1002" // one port across many hosts
+	SigDistributedScan = GID + ":1003" // many sources splitting the ports of one host
+	SigSlowScan        = GID + ":1004" // many ports of one host over a long window
+	SigStealthScan     = GID + ":1005" // NULL, FIN or Xmas probes
+)
+
+// Probe types, named after the nmap scan they come from
+const (
+	probeSYN  = "SYN"
+	probeACK  = "ACK"
+	probeFIN  = "FIN"
+	probeNULL = "NULL"
+	probeXmas = "Xmas"
+	probeUDP  = "UDP"
+)
+
+// evidenceLimit bounds the ports, hosts and sources listed in a detection
+const evidenceLimit = 100
+
+type PortScanConfig struct {
+	Enabled            bool `json:"enabled"`
+	Window             int  `json:"window"`              // seconds
+	Ports              int  `json:"ports"`               // distinct ports of one host within Window
+	Hosts              int  `json:"hosts"`               // distinct hosts probed on one port within Window
+	StealthPorts       int  `json:"stealth_ports"`       // distinct ports probed with NULL, FIN or Xmas within Window
+	DistributedSources int  `json:"distributed_sources"` // distinct sources sharing Ports ports of one host within Window
+	SlowWindow         int  `json:"slow_window"`         // seconds
+	SlowPorts          int  `json:"slow_ports"`          // distinct ports of one host within SlowWindow
+	Cooldown           int  `json:"cooldown"`            // seconds before the same scan is reported again
+	MaxTracked         int  `json:"max_tracked"`         // connections, and sources or targets per count, followed at once; new ones are ignored beyond
+}
+
+func DefaultPortScanConfig() PortScanConfig {
+	return PortScanConfig{
+		Enabled:            true,
+		Window:             60,
+		Ports:              20,
+		Hosts:              20,
+		StealthPorts:       5,
+		DistributedSources: 10,
+		SlowWindow:         3600,
+		SlowPorts:          50,
+		Cooldown:           300,
+		MaxTracked:         100000,
+	}
+}
+
+func (c PortScanConfig) validate() error {
+	if c.Window <= 0 || c.SlowWindow < c.Window {
+		return fmt.Errorf("window must be positive and slow_window at least window")
+	}
+	if c.Ports <= 0 || c.Hosts <= 0 || c.StealthPorts <= 0 || c.DistributedSources <= 0 || c.SlowPorts <= 0 {
+		return fmt.Errorf("thresholds must be positive")
+	}
+	if c.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative")
+	}
+	if c.MaxTracked <= 0 {
+		return fmt.Errorf("max_tracked must be positive")
+	}
+	return nil
+}
+
+// portScan counts probes: TCP packets opening or poking at a connection, and
+// the first packet of a UDP conversation. Replies of the probed host are not
+// probes, so busy servers don't look like scanners. Every table counts in
+// tumbling windows, so a probe only updates counts and a scan is only looked
+// at closer once it crosses a threshold.
+type portScan struct {
+	mu     sync.Mutex
+	config PortScanConfig
+	emit   func(model.Detection)
+
+	connections recent  // TCP connections and UDP conversations seen, by normalized 5-tuple
+	ports       tallies // "proto/port" probed within window, by "source|target"
+	slowPorts   tallies // "proto/port" probed within slow_window, by "source|target"
+	stealth     tallies // "proto/port" probed with NULL/FIN/Xmas, by "source|target"
+	probeTypes  tallies // probe types, by "source|target"
+	hosts       tallies // targets probed, by "source|proto/port"
+	sources     tallies // probing sources, by target
+	services    tallies // "proto/port" probed by any source, by target
+	reported    cooldown
+	lastSweep   time.Time
+}
+
+func newPortScan(config PortScanConfig, emit func(model.Detection)) *portScan {
+	return &portScan{
+		config:      config,
+		emit:        emit,
+		connections: make(recent),
+		ports:       make(tallies),
+		slowPorts:   make(tallies),
+		stealth:     make(tallies),
+		probeTypes:  make(tallies),
+		hosts:       make(tallies),
+		sources:     make(tallies),
+		services:    make(tallies),
+		reported:    make(cooldown),
+	}
+}
+
+func (s *portScan) tcp(p *model.PacketAnalysisTCP, now time.Time) {
+	tcp := p.TCP
+	src, dst := p.IPv4.SourceIP, p.IPv4.DestinationIP
+	conn := connectionKey("tcp", src, tcp.SourcePort, dst, tcp.DestinationPort)
+
+	var probe string
+	switch {
+	case tcp.SYN && !tcp.ACK:
+		probe = probeSYN
+	case tcp.FIN && tcp.PSH && tcp.URG && !tcp.ACK:
+		probe = probeXmas
+	case tcp.FIN && !tcp.ACK:
+		probe = probeFIN
+	case !tcp.SYN && !tcp.ACK && !tcp.FIN && !tcp.RST && !tcp.PSH && !tcp.URG:
+		probe = probeNULL
+	case tcp.ACK && !tcp.SYN && !tcp.FIN && !tcp.RST && len(tcp.Payload) == 0:
+		probe = probeACK
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+	added, tracked := s.track(conn, now)
+	// A bare ACK only probes when it doesn't belong to a connection we've seen,
+	// which can't be told once the table is full
+	if probe == "" || (probe == probeACK && (!added || !tracked)) {
+		return
+	}
+	s.probe(probe, "tcp", src, dst, tcp.DestinationPort, now)
+}
+
+func (s *portScan) udp(p *model.PacketAnalysisUDP, now time.Time) {
+	udp := p.UDP
+	src, dst := p.IPv4.SourceIP, p.IPv4.DestinationIP
+	conn := connectionKey("udp", src, udp.SourcePort, dst, udp.DestinationPort)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+	if added, _ := s.track(conn, now); !added {
+		return
+	}
+	s.probe(probeUDP, "udp", src, dst, udp.DestinationPort, now)
+}
+
+// track records a connection and reports whether it is new, and whether there
+// was room to remember it. Must be called with s.mu held.
+func (s *portScan) track(conn string, now time.Time) (added, tracked bool) {
+	if _, ok := s.connections[conn]; !ok && len(s.connections) >= s.config.MaxTracked {
+		return true, false
+	}
+	return s.connections.add(conn, now, seconds(s.config.Window)), true
+}
+
+// probe must be called with s.mu held
+func (s *portScan) probe(probe, proto, src, dst string, port uint64, now time.Time) {
+	window := seconds(s.config.Window)
+	tracked := s.config.MaxTracked
+	service := proto + "/" + strconv.FormatUint(port, 10)
+	pair := src + "|" + dst
+
+	if t := s.probeTypes.bounded(pair, now, window, tracked); t != nil {
+		t.add(probe, 0)
+	}
+
+	if t := s.ports.bounded(pair, now, window, tracked); t != nil && t.addPeer(service) {
+		if len(t.peers) >= s.config.Ports {
+			s.reportVertical(src, dst, proto, t, now)
+		}
+	}
+	if t := s.slowPorts.bounded(pair, now, seconds(s.config.SlowWindow), tracked); t != nil && t.addPeer(service) {
+		if len(t.peers) >= s.config.SlowPorts {
+			s.reportSlow(src, dst, proto, t, now)
+		}
+	}
+
+	if probe == probeFIN || probe == probeNULL || probe == probeXmas {
+		t := s.stealth.bounded(pair, now, window, tracked)
+		if t != nil && t.addPeer(service) && len(t.peers) >= s.config.StealthPorts && s.ready("stealth|"+pair, now) {
+			s.emit(model.Detection{
+				Protocol:    strings.ToUpper(proto),
+				AttackerIP:  src,
+				TargetIP:    dst,
+				Message:     fmt.Sprintf("%s scan of %s", probe, dst),
+				SignatureID: SigStealthScan,
+				Severity:    model.SeverityHigh,
+				Evidence: map[string]any{
+					"scan_type": probe,
+					"ports":     portList(slices.Collect(maps.Keys(t.peers))),
+					"window":    window.String(),
+				},
+			})
+		}
+	}
+
+	hosts := s.hosts.bounded(src+"|"+service, now, window, tracked)
+	if hosts != nil && hosts.addPeer(dst) && len(hosts.peers) >= s.config.Hosts && s.ready("horizontal|"+src+"|"+service, now) {
+		s.emit(model.Detection{
+			Protocol:    strings.ToUpper(proto),
+			AttackerIP:  src,
+			TargetPort:  strconv.FormatUint(port, 10),
+			Message:     fmt.Sprintf("Horizontal %s scan of port %d across %d hosts", s.scanType(pair, now), port, len(hosts.peers)),
+			SignatureID: SigHorizontalScan,
+			Severity:    model.SeverityMedium,
+			Evidence: map[string]any{
+				"scan_type": s.scanType(pair, now),
+				"hosts":     limit(slices.Sorted(maps.Keys(hosts.peers)), evidenceLimit),
+				"window":    window.String(),
+			},
+		})
+	}
+
+	s.countDistributed(src, dst, service, now)
+}
+
+func (s *portScan) reportVertical(src, dst, proto string, ports *tally, now time.Time) {
+	pair := src + "|" + dst
+	if !s.ready("vertical|"+pair, now) {
+		return
+	}
+	window := seconds(s.config.Window)
+	s.emit(model.Detection{
+		Protocol:    strings.ToUpper(proto),
+		AttackerIP:  src,
+		TargetIP:    dst,
+		Message:     fmt.Sprintf("Vertical %s port scan of %s: %d ports in %s", s.scanType(pair, now), dst, len(ports.peers), window),
+		SignatureID: SigVerticalScan,
+		Severity:    model.SeverityMedium,
+		Evidence: map[string]any{
+			"scan_type": s.scanType(pair, now),
+			"ports":     portList(slices.Collect(maps.Keys(ports.peers))),
+			"window":    window.String(),
+		},
+	})
+}
+
+// reportSlow reports many ports of one host in the slow window, unless the
+// short window already did
+func (s *portScan) reportSlow(src, dst, proto string, ports *tally, now time.Time) {
+	pair := src + "|" + dst
+	if until, ok := s.reported["vertical|"+pair]; ok && now.Before(until) {
+		return
+	}
+	if !s.ready("slow|"+pair, now) {
+		return
+	}
+	slowWindow := seconds(s.config.SlowWindow)
+	s.emit(model.Detection{
+		Protocol:    strings.ToUpper(proto),
+		AttackerIP:  src,
+		TargetIP:    dst,
+		Message:     fmt.Sprintf("Slow port scan of %s: %d ports in %s", dst, len(ports.peers), slowWindow),
+		SignatureID: SigSlowScan,
+		Severity:    model.SeverityLow,
+		Evidence: map[string]any{
+			"scan_type": s.scanType(pair, now),
+			"ports":     portList(slices.Collect(maps.Keys(ports.peers))),
+			"window":    slowWindow.String(),
+		},
+	})
+}
+
+// countDistributed counts the sources and ports probing dst and reports many
+// sources that together probe many of its ports, attributed to the source
+// that probed the most
+func (s *portScan) countDistributed(src, dst, service string, now time.Time) {
+	window := seconds(s.config.Window)
+	tracked := s.config.MaxTracked
+
+	sources := s.sources.bounded(dst, now, window, tracked)
+	services := s.services.bounded(dst, now, window, tracked)
+	if sources == nil || services == nil {
+		return
+	}
+	sources.add(src, 0)
+	services.add(service, 0)
+
+	if len(sources.peers) < s.config.DistributedSources || len(services.peers) < s.config.Ports {
+		return
+	}
+	if !s.ready("distributed|"+dst, now) {
+		return
+	}
+
+	top := topKeys(sources.peers, evidenceLimit)
+	s.emit(model.Detection{
+		AttackerIP:  top[0],
+		TargetIP:    dst,
+		Message:     fmt.Sprintf("Distributed port scan of %s: %d ports from %d sources", dst, len(services.peers), len(sources.peers)),
+		SignatureID: SigDistributedScan,
+		Severity:    model.SeverityHigh,
+		Evidence: map[string]any{
+			"sources": top,
+			"ports":   portList(slices.Collect(maps.Keys(services.peers))),
+			"window":  window.String(),
+		},
+	})
+}
+
+// ready reports whether key may be reported now, starting its cooldown
+func (s *portScan) ready(key string, now time.Time) bool {
+	return s.reported.ready(key, now, seconds(s.config.Cooldown))
+}
+
+// scanType names the probes seen from a source against a target, e.g. "SYN" or "FIN/NULL"
+func (s *portScan) scanType(pair string, now time.Time) string {
+	t, ok := s.probeTypes[pair]
+	if !ok {
+		return ""
+	}
+	return strings.Join(slices.Sorted(maps.Keys(t.peers)), "/")
+}
+
+func (s *portScan) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	window := seconds(s.config.Window)
+	s.connections.prune(now, window)
+	s.ports.prune(now, window)
+	s.slowPorts.prune(now, seconds(s.config.SlowWindow))
+	s.stealth.prune(now, window)
+	s.probeTypes.prune(now, window)
+	s.hosts.prune(now, window)
+	s.sources.prune(now, window)
+	s.services.prune(now, window)
+	s.reported.prune(now)
+}
+
+// connectionKey identifies a connection the same way in both directions
+func connectionKey(proto, a string, aPort uint64, b string, bPort uint64) string {
+	x := a + ":" + strconv.FormatUint(aPort, 10)
+	y := b + ":" + strconv.FormatUint(bPort, 10)
+	if x > y {
+		x, y = y, x
+	}
+	return proto + "|" + x + "|" + y
+}
+
+// portList turns "proto/port" values into "tcp/22"-style evidence, sorted by port
+func portList(services []string) []string {
+	slices.SortFunc(services, func(a, b string) int {
+		_, pa, _ := strings.Cut(a, "/")
+		_, pb, _ := strings.Cut(b, "/")
+		na, _ := strconv.Atoi(pa)
+		nb, _ := strconv.Atoi(pb)
+		if na != nb {
+			return na - nb
+		}
+		return strings.Compare(a, b)
+	})
+	return limit(services, evidenceLimit)
+}
+
+func limit(values []string, n int) []string {
+	if len(values) > n {
+		return values[:n]
+	}
+	return values
+}
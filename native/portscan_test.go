@@ -0,0 +1,205 @@
+package native
+
+import (
+	"fmt"
+	"main/model"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// start is the time packets of the tests are seen from
+var start = time.Date(2025, 6, 17, 22, 0, 0, 0, time.UTC)
+
+// tcpPacket builds a TCP segment, flags holds the letters S, A, F, R, P and U
+func tcpPacket(src string, srcPort uint64, dst string, dstPort uint64, flags string, seq uint32, payload string) *model.PacketAnalysisTCP {
+	return &model.PacketAnalysisTCP{
+		IPv4: &model.IPv4Info{SourceIP: src, DestinationIP: dst, Protocol: 6},
+		TCP: &model.TCPInfo{
+			SourcePort:      srcPort,
+			DestinationPort: dstPort,
+			Seq:             seq,
+			SYN:             strings.Contains(flags, "S"),
+			ACK:             strings.Contains(flags, "A"),
+			FIN:             strings.Contains(flags, "F"),
+			RST:             strings.Contains(flags, "R"),
+			PSH:             strings.Contains(flags, "P"),
+			URG:             strings.Contains(flags, "U"),
+			Payload:         []byte(payload),
+		},
+	}
+}
+
+func udpPacket(src string, srcPort uint64, dst string, dstPort uint64, payload []byte) *model.PacketAnalysisUDP {
+	return &model.PacketAnalysisUDP{
+		IPv4: &model.IPv4Info{SourceIP: src, DestinationIP: dst, Protocol: 17},
+		UDP:  &model.UDPInfo{SourcePort: srcPort, DestinationPort: dstPort, Payload: payload},
+	}
+}
+
+// detections collects what a detector emits
+type detections []model.Detection
+
+func (d *detections) emit(alert model.Detection) {
+	*d = append(*d, alert)
+}
+
+// signatures returns the signature of every detection, in order
+func (d detections) signatures() []string {
+	var signatures []string
+	for _, alert := range d {
+		signatures = append(signatures, alert.SignatureID)
+	}
+	return signatures
+}
+
+func TestPortScan(t *testing.T) {
+	const scanner, target = "192.0.2.66", "10.0.0.1"
+
+	// probes sends one packet per port from the scanner, a second apart
+	probes := func(flags string, ports int) func(s *portScan) {
+		return func(s *portScan) {
+			for i := range ports {
+				s.tcp(tcpPacket(scanner, 40000, target, uint64(1000+i), flags, 0, ""), start.Add(time.Duration(i)*time.Second))
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		run  func(s *portScan)
+		want []string
+	}{
+		{"SYN probes below the threshold", probes("S", 19), nil},
+		{"vertical SYN scan", probes("S", 20), []string{SigVerticalScan}},
+		{"reported once per cooldown", probes("S", 40), []string{SigVerticalScan}},
+		{"FIN scan", probes("F", 20), []string{SigStealthScan, SigVerticalScan}},
+		{"NULL scan", probes("", 5), []string{SigStealthScan}},
+		{"Xmas scan", probes("FPU", 5), []string{SigStealthScan}},
+		{"ACK scan", probes("A", 20), []string{SigVerticalScan}},
+		{"replies aren't probes", probes("SA", 30), nil},
+		{"resets aren't probes", probes("R", 30), nil},
+		{
+			name: "ACKs of open connections aren't probes",
+			run: func(s *portScan) {
+				for i := range 30 {
+					port := uint64(1000 + i)
+					s.tcp(tcpPacket(target, port, scanner, 40000, "SA", 0, ""), start)
+					s.tcp(tcpPacket(scanner, 40000, target, port, "A", 0, ""), start)
+				}
+			},
+		},
+		{
+			name: "UDP scan",
+			run: func(s *portScan) {
+				for i := range 20 {
+					s.udp(udpPacket(scanner, 40000, target, uint64(1000+i), nil), start)
+				}
+			},
+			want: []string{SigVerticalScan},
+		},
+		{
+			name: "UDP conversations count once",
+			run: func(s *portScan) {
+				for range 30 {
+					s.udp(udpPacket(scanner, 40000, target, 53, nil), start)
+				}
+			},
+		},
+		{
+			name: "horizontal scan",
+			run: func(s *portScan) {
+				for i := range 20 {
+					s.tcp(tcpPacket(scanner, 40000, fmt.Sprintf("10.0.1.%d", i), 445, "S", 0, ""), start)
+				}
+			},
+			want: []string{SigHorizontalScan},
+		},
+		{
+			name: "distributed scan",
+			run: func(s *portScan) {
+				for i := range 20 {
+					s.tcp(tcpPacket(fmt.Sprintf("192.0.2.%d", 100+i%10), 40000, target, uint64(1000+i), "S", 0, ""), start)
+				}
+			},
+			want: []string{SigDistributedScan},
+		},
+		{
+			name: "ports spread over windows",
+			run: func(s *portScan) {
+				for i := range 30 {
+					s.tcp(tcpPacket(scanner, 40000, target, uint64(1000+i), "S", 0, ""), start.Add(time.Duration(i)*10*time.Second))
+				}
+			},
+		},
+		{
+			name: "slow scan",
+			run: func(s *portScan) {
+				for i := range 50 {
+					s.tcp(tcpPacket(scanner, 40000, target, uint64(1000+i), "S", 0, ""), start.Add(time.Duration(i)*time.Minute))
+				}
+			},
+			want: []string{SigSlowScan},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got detections
+			tt.run(newPortScan(DefaultPortScanConfig(), got.emit))
+			if !slices.Equal(got.signatures(), tt.want) {
+				t.Errorf("detections = %v, want %v", got.signatures(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPortScanEvidence(t *testing.T) {
+	var got detections
+	s := newPortScan(DefaultPortScanConfig(), got.emit)
+	for _, port := range []uint64{8080, 22, 443, 80, 25, 21, 23, 53, 110, 111, 135, 139, 143, 445, 993, 995, 1723, 3306, 3389, 5900} {
+		s.tcp(tcpPacket("192.0.2.66", 40000, "10.0.0.1", port, "S", 0, ""), start)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("detections = %v, want one vertical scan", got.signatures())
+	}
+	d := got[0]
+	if d.AttackerIP != "192.0.2.66" || d.TargetIP != "10.0.0.1" || d.Protocol != "TCP" {
+		t.Errorf("detection of %s against %s over %s", d.AttackerIP, d.TargetIP, d.Protocol)
+	}
+	if d.Evidence["scan_type"] != probeSYN {
+		t.Errorf("scan_type = %v, want SYN", d.Evidence["scan_type"])
+	}
+	ports := d.Evidence["ports"].([]string)
+	if len(ports) != 20 || ports[0] != "tcp/21" || ports[19] != "tcp/8080" {
+		t.Errorf("ports = %v, want the 20 ports sorted by number", ports)
+	}
+}
+
+func TestPortScanMaxTracked(t *testing.T) {
+	config := DefaultPortScanConfig()
+	config.MaxTracked = 10
+
+	var got detections
+	s := newPortScan(config, got.emit)
+	for i := range 100 {
+		s.tcp(tcpPacket(fmt.Sprintf("192.0.2.%d", i), 40000, fmt.Sprintf("10.0.0.%d", i), 22, "S", 0, ""), start)
+	}
+	if len(s.connections) > config.MaxTracked {
+		t.Errorf("%d connections tracked, want at most %d", len(s.connections), config.MaxTracked)
+	}
+	for name, table := range map[string]tallies{"ports": s.ports, "probe types": s.probeTypes, "hosts": s.hosts, "sources": s.sources} {
+		if len(table) > config.MaxTracked {
+			t.Errorf("%d %s entries tracked, want at most %d", len(table), name, config.MaxTracked)
+		}
+	}
+
+	// ACKs beyond the full table can't be told from ACKs of connections, so they don't count
+	for i := range 30 {
+		s.tcp(tcpPacket("198.51.100.1", 40000, "10.0.1.1", uint64(1000+i), "A", 0, ""), start)
+	}
+	if len(got) != 0 {
+		t.Errorf("detections = %v, want none", got.signatures())
+	}
+}
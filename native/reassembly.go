@@ -0,0 +1,397 @@
+package native
+
+import (
+	"bytes"
+	"fmt"
+	"main/metrics"
+	"main/model"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Overlap policies, deciding which copy of retransmitted bytes is kept when
+// segments overlap with different data
+const (
+	OverlapFirst = "first" // keep the bytes that arrived first, like BSD and Windows stacks
+	OverlapLast  = "last"  // let later segments overwrite, like old Linux stacks
+)
+
+type ReassemblyConfig struct {
+	MaxStreams   int    `json:"max_streams"`   // connections reassembled at once, new ones are ignored beyond
+	StreamBuffer int    `json:"stream_buffer"` // bytes of out-of-order data buffered per direction before skipping the gap
+	TotalBuffer  int    `json:"total_buffer"`  // bytes of out-of-order data buffered across all streams
+	Overlap      string `json:"overlap"`       // "first" or "last"
+	Timeout      int    `json:"timeout"`       // seconds an idle stream is kept
+}
+
+func DefaultReassemblyConfig() ReassemblyConfig {
+	return ReassemblyConfig{
+		MaxStreams:   50000,
+		StreamBuffer: 256 * 1024,
+		TotalBuffer:  64 * 1024 * 1024,
+		Overlap:      OverlapFirst,
+		Timeout:      120,
+	}
+}
+
+func (c ReassemblyConfig) validate() error {
+	if c.MaxStreams <= 0 {
+		return fmt.Errorf("max_streams must be positive")
+	}
+	if c.StreamBuffer <= 0 || c.TotalBuffer < c.StreamBuffer {
+		return fmt.Errorf("stream_buffer must be positive and total_buffer at least as large")
+	}
+	if c.Overlap != OverlapFirst && c.Overlap != OverlapLast {
+		return fmt.Errorf("overlap must be %q or %q", OverlapFirst, OverlapLast)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
+
+// direction of the bytes in a stream
+type direction int
+
+const (
+	toServer direction = iota
+	toClient
+)
+
+// streamID identifies a reassembled connection by its endpoints
+type streamID struct {
+	Client, Server         string
+	ClientPort, ServerPort uint64
+}
+
+// streamDecoder is implemented by the protocol decoders that want the ordered
+// bytes of TCP connections
+type streamDecoder interface {
+	// newStream returns the handler for a new connection, or nil to ignore it
+	newStream(id streamID, now time.Time) streamHandler
+}
+
+// streamHandler receives the bytes of one connection in order. Calls for a
+// stream are never concurrent.
+type streamHandler interface {
+	// data delivers the next bytes sent in dir, they are only valid during the call
+	data(dir direction, data []byte, now time.Time)
+	// gap reports that n bytes sent in dir were lost, data continues after them
+	gap(dir direction, n int)
+	// close is called once when the connection ends or is dropped
+	close(now time.Time)
+}
+
+// segment is out-of-order data at an offset of its half of the stream
+type segment struct {
+	offset int64
+	data   []byte
+}
+
+func (s segment) end() int64 {
+	return s.offset + int64(len(s.data))
+}
+
+// half is one direction of a stream. Offsets count the bytes sent after the
+// SYN, or after the first segment seen for streams picked up mid-way.
+type half struct {
+	started  bool
+	next     uint32 // sequence number of the next byte to deliver
+	offset   int64  // offset of next
+	pending  []segment
+	buffered int
+	fin      bool
+}
+
+// stream is one reassembled connection and the handlers following it
+type stream struct {
+	id       streamID
+	halves   [2]half
+	handlers []streamHandler
+	lastSeen time.Time
+}
+
+// reassembler puts TCP segments back in order per connection and direction and
+// hands the bytes to the stream handlers of the registered decoders
+type reassembler struct {
+	mu     sync.Mutex
+	config ReassemblyConfig
+
+	decoders  []streamDecoder
+	streams   map[string]*stream
+	buffered  int // out-of-order bytes across all streams
+	lastSweep time.Time
+}
+
+func newReassembler(config ReassemblyConfig) *reassembler {
+	metrics.ReassemblyStreams.Set(0)
+	metrics.ReassemblyBuffered.Set(0)
+	return &reassembler{
+		config:  config,
+		streams: make(map[string]*stream),
+	}
+}
+
+// register adds a decoder, it is offered the connections opened from then on
+func (r *reassembler) register(decoder streamDecoder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.decoders = append(r.decoders, decoder)
+}
+
+func (r *reassembler) segment(p *model.PacketAnalysisTCP, now time.Time) {
+	tcp := p.TCP
+	src, dst := p.IPv4.SourceIP, p.IPv4.DestinationIP
+	key := connectionKey("tcp", src, tcp.SourcePort, dst, tcp.DestinationPort)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sweep(now)
+
+	s, ok := r.streams[key]
+	if !ok {
+		if tcp.RST || tcp.FIN && len(tcp.Payload) == 0 || len(r.decoders) == 0 {
+			return
+		}
+		if s = r.open(p, now); s == nil {
+			return
+		}
+		r.streams[key] = s
+		metrics.ReassemblyStreams.Add(1)
+	}
+	s.lastSeen = now
+
+	dir := toServer
+	if src == s.id.Server && tcp.SourcePort == s.id.ServerPort {
+		dir = toClient
+	}
+	h := &s.halves[dir]
+
+	if tcp.SYN && !h.started {
+		// The payload starts after the sequence number the SYN takes
+		h.started = true
+		h.next = tcp.Seq + 1
+	} else if !h.started && len(tcp.Payload) > 0 {
+		h.started = true
+		h.next = tcp.Seq
+	}
+
+	if len(tcp.Payload) > 0 && h.started {
+		seq := tcp.Seq
+		if tcp.SYN {
+			seq++
+		}
+		r.add(s, dir, seq, tcp.Payload, now)
+	}
+
+	if tcp.RST {
+		r.close(key, s, now)
+		return
+	}
+	if tcp.FIN {
+		h.fin = true
+		if s.halves[toServer].fin && s.halves[toClient].fin {
+			r.close(key, s, now)
+		}
+	}
+}
+
+// open starts a stream if a decoder wants it, must be called with r.mu held
+func (r *reassembler) open(p *model.PacketAnalysisTCP, now time.Time) *stream {
+	if len(r.streams) >= r.config.MaxStreams {
+		metrics.ReassemblyEvents.Inc("stream_limit")
+		return nil
+	}
+
+	tcp := p.TCP
+	id := streamID{Client: p.IPv4.SourceIP, ClientPort: tcp.SourcePort, Server: p.IPv4.DestinationIP, ServerPort: tcp.DestinationPort}
+	if tcp.SYN && tcp.ACK || !tcp.SYN && tcp.SourcePort < tcp.DestinationPort {
+		// A SYN-ACK comes from the server, otherwise the lower port is most likely the server's
+		id = streamID{Client: id.Server, ClientPort: id.ServerPort, Server: id.Client, ServerPort: id.ClientPort}
+	}
+
+	s := &stream{id: id}
+	for _, decoder := range r.decoders {
+		if handler := decoder.newStream(id, now); handler != nil {
+			s.handlers = append(s.handlers, handler)
+		}
+	}
+	if len(s.handlers) == 0 {
+		return nil
+	}
+	return s
+}
+
+// add places the payload of one segment and delivers what became contiguous,
+// must be called with r.mu held
+func (r *reassembler) add(s *stream, dir direction, seq uint32, payload []byte, now time.Time) {
+	h := &s.halves[dir]
+	offset := h.offset + int64(int32(seq-h.next))
+	end := offset + int64(len(payload))
+
+	if offset < h.offset {
+		// A retransmission of bytes already delivered, those stay as they were
+		metrics.ReassemblyEvents.Inc("overlap")
+		if end <= h.offset {
+			return
+		}
+		payload = payload[h.offset-offset:]
+		offset = h.offset
+	}
+
+	if offset == h.offset && len(h.pending) == 0 {
+		r.deliver(s, dir, payload, now)
+		return
+	}
+
+	r.buffer(h, segment{offset: offset, data: slices.Clone(payload)})
+	r.flush(s, dir, now)
+
+	// Skip the gap when the stream or all streams together buffer too much
+	for len(h.pending) > 0 && (h.buffered > r.config.StreamBuffer || r.buffered > r.config.TotalBuffer) {
+		r.skip(s, dir, now)
+	}
+}
+
+// buffer inserts seg into the pending segments of h, resolving overlaps with
+// the configured policy, must be called with r.mu held
+func (r *reassembler) buffer(h *half, seg segment) {
+	var pending []segment
+	added := []segment{seg}
+	overlapped := false
+
+	for _, p := range h.pending {
+		if p.end() <= seg.offset || p.offset >= seg.end() {
+			pending = append(pending, p)
+			continue
+		}
+
+		overlapped = true
+		start, end := max(p.offset, seg.offset), min(p.end(), seg.end())
+		if !bytes.Equal(p.data[start-p.offset:end-p.offset], seg.data[start-seg.offset:end-seg.offset]) {
+			metrics.ReassemblyEvents.Inc("conflict")
+		}
+
+		if r.config.Overlap == OverlapLast {
+			// Keep the parts of p the new segment doesn't cover
+			if p.offset < seg.offset {
+				pending = append(pending, segment{offset: p.offset, data: p.data[:seg.offset-p.offset]})
+			}
+			if p.end() > seg.end() {
+				pending = append(pending, segment{offset: seg.end(), data: p.data[seg.end()-p.offset:]})
+			}
+			continue
+		}
+
+		// Keep p and only the parts of the new segment around it
+		pending = append(pending, p)
+		var rest []segment
+		for _, a := range added {
+			if p.end() <= a.offset || p.offset >= a.end() {
+				rest = append(rest, a)
+				continue
+			}
+			if a.offset < p.offset {
+				rest = append(rest, segment{offset: a.offset, data: a.data[:p.offset-a.offset]})
+			}
+			if a.end() > p.end() {
+				rest = append(rest, segment{offset: p.end(), data: a.data[p.end()-a.offset:]})
+			}
+		}
+		added = rest
+	}
+	if overlapped {
+		metrics.ReassemblyEvents.Inc("overlap")
+	}
+
+	h.pending = append(pending, added...)
+	slices.SortFunc(h.pending, func(a, b segment) int {
+		return int(a.offset - b.offset)
+	})
+
+	buffered := 0
+	for _, p := range h.pending {
+		buffered += len(p.data)
+	}
+	r.account(buffered - h.buffered)
+	h.buffered = buffered
+}
+
+// flush delivers the pending segments that continue the stream, must be
+// called with r.mu held
+func (r *reassembler) flush(s *stream, dir direction, now time.Time) {
+	h := &s.halves[dir]
+	for len(h.pending) > 0 && h.pending[0].offset <= h.offset {
+		p := h.pending[0]
+		h.pending = h.pending[1:]
+		h.buffered -= len(p.data)
+		r.account(-len(p.data))
+
+		if p.end() > h.offset {
+			r.deliver(s, dir, p.data[h.offset-p.offset:], now)
+		}
+	}
+}
+
+// skip gives up on the bytes missing before the first pending segment, must be
+// called with r.mu held
+func (r *reassembler) skip(s *stream, dir direction, now time.Time) {
+	h := &s.halves[dir]
+	n := h.pending[0].offset - h.offset
+	metrics.ReassemblyEvents.Inc("gap")
+	for _, handler := range s.handlers {
+		handler.gap(dir, int(n))
+	}
+	h.next += uint32(n)
+	h.offset += n
+	r.flush(s, dir, now)
+}
+
+// deliver hands data at the current offset of dir to the handlers, must be
+// called with r.mu held
+func (r *reassembler) deliver(s *stream, dir direction, data []byte, now time.Time) {
+	h := &s.halves[dir]
+	h.next += uint32(len(data))
+	h.offset += int64(len(data))
+	for _, handler := range s.handlers {
+		handler.data(dir, data, now)
+	}
+}
+
+// close delivers what is still pending across its gaps and ends the stream,
+// must be called with r.mu held
+func (r *reassembler) close(key string, s *stream, now time.Time) {
+	for dir := range s.halves {
+		for len(s.halves[dir].pending) > 0 {
+			r.skip(s, direction(dir), now)
+		}
+	}
+	for _, handler := range s.handlers {
+		handler.close(now)
+	}
+	delete(r.streams, key)
+	metrics.ReassemblyStreams.Add(-1)
+}
+
+func (r *reassembler) account(delta int) {
+	r.buffered += delta
+	metrics.ReassemblyBuffered.Add(float64(delta))
+}
+
+// sweep closes streams idle for longer than the timeout, must be called with r.mu held
+func (r *reassembler) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < sweepInterval {
+		return
+	}
+	r.lastSweep = now
+
+	for key, s := range r.streams {
+		if now.Sub(s.lastSeen) > seconds(r.config.Timeout) {
+			metrics.ReassemblyEvents.Inc("timeout")
+			r.close(key, s, now)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package native
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamRecorder follows every stream and writes what it is handed per
+// direction, with gaps as <gap n>
+type streamRecorder struct {
+	id     streamID
+	out    [2]strings.Builder
+	closed bool
+}
+
+func (r *streamRecorder) newStream(id streamID, now time.Time) streamHandler {
+	r.id = id
+	return r
+}
+
+func (r *streamRecorder) data(dir direction, data []byte, now time.Time) {
+	r.out[dir].Write(data)
+}
+
+func (r *streamRecorder) gap(dir direction, n int) {
+	fmt.Fprintf(&r.out[dir], "<gap %d>", n)
+}
+
+func (r *streamRecorder) close(now time.Time) {
+	r.closed = true
+}
+
+// chunk is payload sent by the client at an offset of its stream
+type chunk struct {
+	offset uint32
+	data   string
+}
+
+func TestReassembly(t *testing.T) {
+	tests := []struct {
+		name    string
+		overlap string
+		buffer  int // stream_buffer, the default if 0
+		isn     uint32
+		chunks  []chunk
+		want    string // delivered before the connection closes
+		closed  string // delivered once it closed
+	}{
+		{name: "in order", chunks: []chunk{{0, "abc"}, {3, "def"}}, want: "abcdef"},
+		{name: "out of order", chunks: []chunk{{6, "ghi"}, {3, "def"}, {0, "abc"}}, want: "abcdefghi"},
+		{name: "retransmitted", chunks: []chunk{{0, "abc"}, {0, "abc"}, {3, "def"}}, want: "abcdef"},
+		{name: "partly retransmitted", chunks: []chunk{{0, "abc"}, {1, "bcdef"}}, want: "abcdef"},
+		{name: "delivered bytes stay", chunks: []chunk{{0, "abc"}, {0, "XYZdef"}}, want: "abcdef"},
+		{name: "pending duplicate", chunks: []chunk{{3, "def"}, {3, "def"}, {0, "abc"}}, want: "abcdef"},
+		{name: "first copy kept", overlap: OverlapFirst, chunks: []chunk{{3, "def"}, {3, "XYZ"}, {0, "abc"}}, want: "abcdef"},
+		{name: "last copy kept", overlap: OverlapLast, chunks: []chunk{{3, "def"}, {3, "XYZ"}, {0, "abc"}}, want: "abcXYZ"},
+		{name: "first copy around a partial overlap", overlap: OverlapFirst, chunks: []chunk{{4, "efg"}, {3, "DEFGH"}, {0, "abc"}}, want: "abcDefgH"},
+		{name: "last copy in a partial overlap", overlap: OverlapLast, chunks: []chunk{{4, "efg"}, {3, "DE"}, {0, "abc"}}, want: "abcDEfg"},
+		{name: "new segment inside a pending one", overlap: OverlapLast, chunks: []chunk{{3, "defgh"}, {4, "E"}, {0, "abc"}}, want: "abcdEfgh"},
+		{name: "gap held until the close", chunks: []chunk{{0, "abc"}, {6, "ghi"}}, want: "abc", closed: "abc<gap 3>ghi"},
+		{name: "gaps held until the close", chunks: []chunk{{2, "c"}, {5, "f"}}, want: "", closed: "<gap 2>c<gap 2>f"},
+		{name: "gap skipped once the buffer is full", buffer: 4, chunks: []chunk{{0, "abc"}, {6, "ghij"}, {10, "k"}}, want: "abc<gap 3>ghijk"},
+		{name: "sequence numbers wrapping", isn: 0xFFFFFFFD, chunks: []chunk{{3, "def"}, {0, "abc"}, {6, "ghi"}}, want: "abcdefghi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultReassemblyConfig()
+			if tt.overlap != "" {
+				config.Overlap = tt.overlap
+			}
+			if tt.buffer != 0 {
+				config.StreamBuffer = tt.buffer
+			}
+			r := newReassembler(config)
+			rec := &streamRecorder{}
+			r.register(rec)
+
+			r.segment(tcpPacket("192.0.2.10", 40000, "10.0.0.1", 80, "S", tt.isn, ""), start)
+			for _, c := range tt.chunks {
+				r.segment(tcpPacket("192.0.2.10", 40000, "10.0.0.1", 80, "PA", tt.isn+1+c.offset, c.data), start)
+			}
+			if got := rec.out[toServer].String(); got != tt.want {
+				t.Errorf("delivered %q, want %q", got, tt.want)
+			}
+			h := r.streams[connectionKey("tcp", "192.0.2.10", 40000, "10.0.0.1", 80)].halves[toServer]
+			if r.buffered != h.buffered {
+				t.Errorf("%d bytes buffered in all, but %d in the stream", r.buffered, h.buffered)
+			}
+
+			r.segment(tcpPacket("192.0.2.10", 40000, "10.0.0.1", 80, "RA", 0, ""), start)
+			closed := tt.closed
+			if closed == "" {
+				closed = tt.want
+			}
+			if got := rec.out[toServer].String(); got != closed || !rec.closed {
+				t.Errorf("delivered %q and closed %v after the reset, want %q", got, rec.closed, closed)
+			}
+			if r.buffered != 0 || len(r.streams) != 0 {
+				t.Errorf("%d bytes of %d streams still buffered after the close", r.buffered, len(r.streams))
+			}
+		})
+	}
+}
+
+func TestReassemblyDirections(t *testing.T) {
+	tests := []struct {
+		name    string
+		first   string // flags of the first packet, sent from 192.0.2.10:40000 to 10.0.0.1:80
+		reverse bool   // the first packet is sent the other way
+	}{
+		{name: "SYN", first: "S"},
+		{name: "SYN-ACK", first: "SA", reverse: true},
+		{name: "mid-stream to the lower port", first: "PA"},
+		{name: "mid-stream from the lower port", first: "PA", reverse: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReassembler(DefaultReassemblyConfig())
+			rec := &streamRecorder{}
+			r.register(rec)
+
+			// The client's bytes start at sequence number 100, the server's at 200
+			clientSeq, serverSeq := uint32(100), uint32(200)
+			src, srcPort, dst, dstPort, seq := "192.0.2.10", uint64(40000), "10.0.0.1", uint64(80), &clientSeq
+			if tt.reverse {
+				src, srcPort, dst, dstPort, seq = dst, dstPort, src, srcPort, &serverSeq
+			}
+			if tt.first == "PA" {
+				r.segment(tcpPacket(src, srcPort, dst, dstPort, tt.first, *seq, "first"), start)
+				*seq += 5
+			} else {
+				r.segment(tcpPacket(src, srcPort, dst, dstPort, tt.first, *seq-1, ""), start)
+			}
+			r.segment(tcpPacket("192.0.2.10", 40000, "10.0.0.1", 80, "PA", clientSeq, "request"), start)
+			r.segment(tcpPacket("10.0.0.1", 80, "192.0.2.10", 40000, "PA", serverSeq, "response"), start)
+
+			want := streamID{Client: "192.0.2.10", ClientPort: 40000, Server: "10.0.0.1", ServerPort: 80}
+			if rec.id != want {
+				t.Errorf("stream %+v, want %+v", rec.id, want)
+			}
+			if !strings.HasSuffix(rec.out[toServer].String(), "request") || !strings.HasSuffix(rec.out[toClient].String(), "response") ||
+				strings.Contains(rec.out[toServer].String(), "gap") || strings.Contains(rec.out[toClient].String(), "gap") {
+				t.Errorf("delivered %q to the server and %q to the client", rec.out[toServer].String(), rec.out[toClient].String())
+			}
+		})
+	}
+}
+
+func TestReassemblyClose(t *testing.T) {
+	config := DefaultReassemblyConfig()
+	config.MaxStreams = 1
+
+	tests := []struct {
+		name   string
+		run    func(r *reassembler)
+		closed bool
+	}{
+		{
+			name: "FIN from one side",
+			run: func(r *reassembler) {
+				r.segment(tcpPacket("192.0.2.10", 40000, "10.0.0.1", 80, "FA", 1, ""), start)
+			},
+		},
+		{
+			name: "FIN from both sides",
+			run: func(r *reassembler) {
+				r.segment(tcpPacket("192.0.2.10", 40000, "10.0.0.1", 80, "FA", 1, ""), start)
+				r.segment(tcpPacket("10.0.0.1", 80, "192.0.2.10", 40000, "FA", 1, ""), start)
+			},
+			closed: true,
+		},
+		{
+			name: "reset",
+			run: func(r *reassembler) {
+				r.segment(tcpPacket("10.0.0.1", 80, "192.0.2.10", 40000, "R", 1, ""), start)
+			},
+			closed: true,
+		},
+		{
+			name: "idle",
+			run: func(r *reassembler) {
+				later := start.Add(seconds(config.Timeout) + sweepInterval)
+				r.segment(tcpPacket("192.0.2.20", 40000, "10.0.0.1", 80, "S", 1, ""), later)
+			},
+			closed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReassembler(config)
+			rec := &streamRecorder{}
+			r.register(rec)
+			r.segment(tcpPacket("192.0.2.10", 40000, "10.0.0.1", 80, "S", 0, ""), start)
+
+			// Beyond max_streams nothing is followed
+			other := &streamRecorder{}
+			r.register(other)
+			r.segment(tcpPacket("192.0.2.30", 40000, "10.0.0.1", 80, "S", 0, ""), start)
+			if other.id != (streamID{}) {
+				t.Errorf("stream %+v opened beyond max_streams", other.id)
+			}
+
+			tt.run(r)
+			if rec.closed != tt.closed {
+				t.Errorf("closed = %v, want %v", rec.closed, tt.closed)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package native
+
+import (
+	"bytes"
+	"fmt"
+	"main/model"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Slow HTTP signatures
+const (
+	SigSlowloris = GID + ":1601" // connections kept open by never finishing the request headers
+	SigSlowBody  = GID + ":1602" // connections kept open by sending the request body a few bytes at a time (RUDY)
+)
+
+// maxSlowHeader bounds the bytes of incomplete request headers kept per connection
+const maxSlowHeader = 16 * 1024
+
+type SlowHTTPConfig struct {
+	Enabled       bool  `json:"enabled"`
+	Ports         []int `json:"ports"`          // server ports carrying HTTP
+	HeaderTimeout int   `json:"header_timeout"` // seconds after which unfinished request headers are slow
+	BodyTimeout   int   `json:"body_timeout"`   // seconds after which a request body's rate is judged
+	MinBodyRate   int   `json:"min_body_rate"`  // bytes per second below which a body is slow
+	Connections   int   `json:"connections"`    // slow connections held by one source to one host
+	Cooldown      int   `json:"cooldown"`       // seconds before the same source is reported again
+}
+
+func DefaultSlowHTTPConfig() SlowHTTPConfig {
+	return SlowHTTPConfig{
+		Enabled:       true,
+		Ports:         []int{80, 8000, 8080, 8888},
+		HeaderTimeout: 30,
+		BodyTimeout:   30,
+		MinBodyRate:   50,
+		Connections:   20,
+		Cooldown:      300,
+	}
+}
+
+func (c SlowHTTPConfig) validate() error {
+	for _, port := range c.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	}
+	if c.HeaderTimeout <= 0 || c.BodyTimeout <= 0 {
+		return fmt.Errorf("timeouts must be positive")
+	}
+	if c.MinBodyRate <= 0 || c.Connections <= 0 {
+		return fmt.Errorf("thresholds must be positive")
+	}
+	if c.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative")
+	}
+	return nil
+}
+
+// Where a connection is in its current request
+const (
+	requestIdle    = iota // between requests
+	requestHeaders        // headers started but not finished
+	requestBody           // headers done, body still expected
+	requestIgnored        // not HTTP, or a body of unknown length
+)
+
+// slowConn follows the requests on one connection to see how long they take
+type slowConn struct {
+	detector *slowHTTP
+	id       streamID
+	opened   time.Time
+
+	state    int
+	started  time.Time // when the current request's headers or body started
+	header   []byte
+	expected int // body bytes announced by Content-Length
+	received int // body bytes sent so far
+}
+
+// slowSource is the open connections of one source to one host
+type slowSource struct {
+	conns   map[*slowConn]struct{}
+	checked time.Time
+}
+
+// slowHTTP counts, per source and host, the connections whose request headers
+// or body have been trickling in for too long
+type slowHTTP struct {
+	mu     sync.Mutex
+	config SlowHTTPConfig
+	emit   func(model.Detection)
+
+	sources   map[string]*slowSource // by "source|host"
+	reported  cooldown
+	lastSweep time.Time
+}
+
+func newSlowHTTP(config SlowHTTPConfig, emit func(model.Detection)) *slowHTTP {
+	return &slowHTTP{
+		config:   config,
+		emit:     emit,
+		sources:  make(map[string]*slowSource),
+		reported: make(cooldown),
+	}
+}
+
+func (d *slowHTTP) newStream(id streamID, now time.Time) streamHandler {
+	if !slices.Contains(d.config.Ports, int(id.ServerPort)) {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := id.Client + "|" + id.Server
+	source, ok := d.sources[key]
+	if !ok {
+		source = &slowSource{conns: make(map[*slowConn]struct{})}
+		d.sources[key] = source
+	}
+	c := &slowConn{detector: d, id: id, opened: now}
+	source.conns[c] = struct{}{}
+	return c
+}
+
+func (c *slowConn) data(dir direction, data []byte, now time.Time) {
+	if dir != toServer || c.state == requestIgnored {
+		return
+	}
+
+	d := c.detector
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c.feed(data, now)
+	d.check(c.id, now)
+}
+
+// feed advances the request state over data, must be called with d.mu held
+func (c *slowConn) feed(data []byte, now time.Time) {
+	for len(data) > 0 {
+		switch c.state {
+		case requestIdle:
+			if !looksLikeHTTP(data) {
+				c.state = requestIgnored
+				return
+			}
+			c.state = requestHeaders
+			c.started = now
+			c.header = c.header[:0]
+
+		case requestHeaders:
+			c.header = append(c.header, data...)
+			data = nil
+			end, size := bytes.Index(c.header, []byte("\r\n\r\n")), 4
+			if end < 0 {
+				end, size = bytes.Index(c.header, []byte("\n\n")), 2
+			}
+			if end < 0 {
+				if len(c.header) > maxSlowHeader {
+					// Keep what could be the start of the blank line
+					c.header = append(c.header[:0], c.header[len(c.header)-3:]...)
+				}
+				continue
+			}
+
+			data = bytes.Clone(c.header[end+size:])
+			c.expected, c.received = bodyLength(c.header[:end]), 0
+			c.header = c.header[:0]
+			switch {
+			case c.expected < 0:
+				c.state = requestIgnored
+				return
+			case c.expected > 0:
+				c.state = requestBody
+				c.started = now
+			default:
+				c.state = requestIdle
+			}
+
+		case requestBody:
+			n := min(len(data), c.expected-c.received)
+			c.received += n
+			data = data[n:]
+			if c.received >= c.expected {
+				c.state = requestIdle
+			}
+
+		default:
+			return
+		}
+	}
+}
+
+// bodyLength returns the Content-Length of request headers, or -1 for bodies
+// whose end can't be told without decoding them
+func bodyLength(header []byte) int {
+	for _, line := range strings.Split(string(header), "\n") {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-length":
+			if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+				return n
+			}
+			return -1
+		case "transfer-encoding":
+			return -1
+		}
+	}
+	return 0
+}
+
+func (c *slowConn) gap(dir direction, n int) {
+	if dir == toServer {
+		c.state = requestIgnored
+	}
+}
+
+func (c *slowConn) close(now time.Time) {
+	d := c.detector
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := c.id.Client + "|" + c.id.Server
+	if source, ok := d.sources[key]; ok {
+		delete(source.conns, c)
+		if len(source.conns) == 0 {
+			delete(d.sources, key)
+		}
+	}
+}
+
+// slow reports how the connection is being held open, if it is
+func (c *slowConn) slow(now time.Time, config SlowHTTPConfig) (string, bool) {
+	elapsed := now.Sub(c.started)
+	switch c.state {
+	case requestHeaders:
+		return "incomplete_headers", elapsed >= seconds(config.HeaderTimeout)
+	case requestBody:
+		rate := float64(c.received) / elapsed.Seconds()
+		return "slow_body", elapsed >= seconds(config.BodyTimeout) && rate < float64(config.MinBodyRate)
+	}
+	return "", false
+}
+
+// check counts the slow connections of a source to a host at most once per
+// second, must be called with d.mu held
+func (d *slowHTTP) check(id streamID, now time.Time) {
+	d.sweep(now)
+
+	source, ok := d.sources[id.Client+"|"+id.Server]
+	if !ok || len(source.conns) < d.config.Connections || now.Sub(source.checked) < time.Second {
+		return
+	}
+	source.checked = now
+
+	counts := make(map[string]int)
+	var oldest time.Time
+	body, sent := 0, 0
+	for c := range source.conns {
+		kind, slow := c.slow(now, d.config)
+		if !slow {
+			continue
+		}
+		counts[kind]++
+		if oldest.IsZero() || c.opened.Before(oldest) {
+			oldest = c.opened
+		}
+		if kind == "slow_body" {
+			body += c.expected
+			sent += c.received
+		}
+	}
+
+	headers, bodies := counts["incomplete_headers"], counts["slow_body"]
+	if headers+bodies < d.config.Connections || !d.reported.ready(id.Client+"|"+id.Server, now, seconds(d.config.Cooldown)) {
+		return
+	}
+
+	evidence := map[string]any{
+		"connections":        len(source.conns),
+		"incomplete_headers": headers,
+		"slow_bodies":        bodies,
+		"oldest":             now.Sub(oldest).Round(time.Second).String(),
+	}
+	alert := model.Detection{
+		Protocol:   "TCP",
+		AttackerIP: id.Client,
+		TargetIP:   id.Server,
+		TargetPort: strconv.FormatUint(id.ServerPort, 10),
+		Severity:   model.SeverityHigh,
+		Evidence:   evidence,
+	}
+	if headers >= bodies {
+		alert.SignatureID = SigSlowloris
+		alert.Message = fmt.Sprintf("Slowloris from %s: %d connections to %s with unfinished headers", id.Client, headers, id.Server)
+	} else {
+		alert.SignatureID = SigSlowBody
+		alert.Message = fmt.Sprintf("Slow HTTP body attack from %s: %d connections to %s sending bodies at a trickle", id.Client, bodies, id.Server)
+		evidence["body_announced"] = body
+		evidence["body_sent"] = sent
+	}
+	d.emit(alert)
+}
+
+// sweep must be called with d.mu held
+func (d *slowHTTP) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < sweepInterval {
+		return
+	}
+	d.lastSweep = now
+
+	d.reported.prune(now)
+}
@@ -0,0 +1,287 @@
+package native
+
+import (
+	"fmt"
+	"main/model"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SYN flood signatures
+const (
+	SigSYNFlood        = GID + ":1101" // SYNs or half-open connections piling up at one host
+	SigSpoofedSYNFlood = GID + ":1102" // the same from many sources sending a few SYNs each
+	SigSourceSYNFlood  = GID + ":1103" // one source opening connections it never completes
+)
+
+// Bounds on the state kept during a flood from spoofed sources
+const (
+	maxPeers   = 10000 // sources remembered per target, and targets per source
+	maxSources = 65536 // sources whose SYN rate is tracked
+)
+
+// topPeers is how many sources a detection lists
+const topPeers = 10
+
+type SynFloodConfig struct {
+	Enabled              bool    `json:"enabled"`
+	Window               int     `json:"window"`                  // seconds SYN rates are measured over
+	SynRate              int     `json:"syn_rate"`                // SYNs per second to one host
+	HalfOpen             int     `json:"half_open"`               // half-open connections to one host
+	SourceSynRate        int     `json:"source_syn_rate"`         // SYNs per second from one source
+	SourceHalfOpen       int     `json:"source_half_open"`        // half-open connections from one source
+	MaxCompletion        float64 `json:"max_completion"`          // share of completed handshakes below which a SYN rate is a flood
+	SpoofedSources       int     `json:"spoofed_sources"`         // distinct sources within Window that make a flood spoofed...
+	SpoofedSynsPerSource float64 `json:"spoofed_syns_per_source"` // ...when they send at most this many SYNs each on average
+	Cooldown             int     `json:"cooldown"`                // seconds before the same flood is reported again
+}
+
+func DefaultSynFloodConfig() SynFloodConfig {
+	return SynFloodConfig{
+		Enabled:              true,
+		Window:               10,
+		SynRate:              200,
+		HalfOpen:             512,
+		SourceSynRate:        50,
+		SourceHalfOpen:       128,
+		MaxCompletion:        0.2,
+		SpoofedSources:       100,
+		SpoofedSynsPerSource: 3,
+		Cooldown:             60,
+	}
+}
+
+func (c SynFloodConfig) validate() error {
+	if c.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	if c.SynRate <= 0 || c.HalfOpen <= 0 || c.SourceSynRate <= 0 || c.SourceHalfOpen <= 0 || c.SpoofedSources <= 0 {
+		return fmt.Errorf("thresholds must be positive")
+	}
+	if c.MaxCompletion < 0 || c.MaxCompletion > 1 {
+		return fmt.Errorf("max_completion must be between 0 and 1")
+	}
+	if c.SpoofedSynsPerSource <= 0 {
+		return fmt.Errorf("spoofed_syns_per_source must be positive")
+	}
+	if c.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative")
+	}
+	return nil
+}
+
+// synCounter counts the SYNs and completed handshakes of one host in a
+// tumbling window, with the peers and ports involved
+type synCounter struct {
+	start     time.Time
+	syns      int
+	completed int
+	peers     map[string]int // SYNs per source of a target, or per target of a source
+	ports     map[uint64]int // SYNs per destination port
+}
+
+func (c *synCounter) roll(now time.Time, window time.Duration) {
+	if now.Sub(c.start) < window {
+		return
+	}
+	c.start = now
+	c.syns = 0
+	c.completed = 0
+	c.peers = make(map[string]int)
+	c.ports = make(map[uint64]int)
+}
+
+func (c *synCounter) add(peer string, port uint64) {
+	c.syns++
+	if _, ok := c.peers[peer]; ok || len(c.peers) < maxPeers {
+		c.peers[peer]++
+	}
+	if _, ok := c.ports[port]; ok || len(c.ports) < maxPeers {
+		c.ports[port]++
+	}
+}
+
+// completion is the share of SYNs that finished their handshake
+func (c *synCounter) completion() float64 {
+	if c.syns == 0 {
+		return 1
+	}
+	return math.Min(float64(c.completed)/float64(c.syns), 1)
+}
+
+// topPeers returns the peers with the most SYNs
+func (c *synCounter) topPeers(n int) []string {
+	return topKeys(c.peers, n)
+}
+
+// port returns the destination port that got most of the SYNs, if any did
+func (c *synCounter) port() string {
+	for port, syns := range c.ports {
+		if syns*2 > c.syns {
+			return strconv.FormatUint(port, 10)
+		}
+	}
+	return ""
+}
+
+// synFlood measures SYN rates and handshake completion per target and per
+// source, together with the half-open counts of the connection tracker
+type synFlood struct {
+	mu     sync.Mutex
+	config SynFloodConfig
+	emit   func(model.Detection)
+
+	targets   map[string]*synCounter
+	sources   map[string]*synCounter
+	reported  cooldown
+	lastSweep time.Time
+}
+
+func newSynFlood(config SynFloodConfig, emit func(model.Detection)) *synFlood {
+	return &synFlood{
+		config:   config,
+		emit:     emit,
+		targets:  make(map[string]*synCounter),
+		sources:  make(map[string]*synCounter),
+		reported: make(cooldown),
+	}
+}
+
+func (s *synFlood) observe(p *model.PacketAnalysisTCP, t transition, now time.Time) {
+	tcp := p.TCP
+	src, dst := p.IPv4.SourceIP, p.IPv4.DestinationIP
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+	window := seconds(s.config.Window)
+
+	if t.completed() {
+		// The client acknowledges the server's SYN-ACK
+		if target, ok := s.targets[dst]; ok {
+			target.completed++
+		}
+		if source, ok := s.sources[src]; ok {
+			source.completed++
+		}
+		return
+	}
+	if !tcp.SYN || tcp.ACK {
+		return
+	}
+
+	target := s.counter(s.targets, dst, now, window)
+	target.add(src, tcp.DestinationPort)
+	s.checkTarget(dst, target, t.DestinationHalfOpen, now)
+
+	if _, ok := s.sources[src]; ok || len(s.sources) < maxSources {
+		source := s.counter(s.sources, src, now, window)
+		source.add(dst, tcp.DestinationPort)
+		s.checkSource(src, source, t.SourceHalfOpen, now)
+	}
+}
+
+func (s *synFlood) counter(counters map[string]*synCounter, host string, now time.Time, window time.Duration) *synCounter {
+	c, ok := counters[host]
+	if !ok {
+		c = &synCounter{}
+		counters[host] = c
+	}
+	c.roll(now, window)
+	return c
+}
+
+// checkTarget reports a flood against dst, from spoofed sources when there are
+// many of them sending a few SYNs each
+func (s *synFlood) checkTarget(dst string, c *synCounter, halfOpen int, now time.Time) {
+	window := seconds(s.config.Window)
+	rate := float64(c.syns) / window.Seconds()
+	flooding := rate >= float64(s.config.SynRate) && c.completion() < s.config.MaxCompletion
+	if !flooding && halfOpen < s.config.HalfOpen {
+		return
+	}
+	if !s.reported.ready("target|"+dst, now, seconds(s.config.Cooldown)) {
+		return
+	}
+
+	sources := len(c.peers)
+	evidence := map[string]any{
+		"syn_rate":    math.Round(rate),
+		"half_open":   halfOpen,
+		"completion":  math.Round(c.completion()*100) / 100,
+		"sources":     sources,
+		"top_sources": c.topPeers(topPeers),
+		"window":      window.String(),
+	}
+
+	alert := model.Detection{
+		Protocol:   "TCP",
+		TargetIP:   dst,
+		TargetPort: c.port(),
+		Severity:   model.SeverityHigh,
+		Evidence:   evidence,
+	}
+	if sources >= s.config.SpoofedSources && float64(c.syns)/float64(sources) <= s.config.SpoofedSynsPerSource {
+		// Blocking spoofed addresses would only hurt whoever owns them
+		alert.Message = fmt.Sprintf("Spoofed-source SYN flood against %s: %.0f SYN/s from %d sources", dst, rate, sources)
+		alert.SignatureID = SigSpoofedSYNFlood
+	} else {
+		alert.AttackerIP = c.topPeers(1)[0]
+		alert.Message = fmt.Sprintf("SYN flood against %s: %.0f SYN/s, %d half-open, %.0f%% completed", dst, rate, halfOpen, c.completion()*100)
+		alert.SignatureID = SigSYNFlood
+	}
+	s.emit(alert)
+}
+
+// checkSource reports a source that opens connections it doesn't complete
+func (s *synFlood) checkSource(src string, c *synCounter, halfOpen int, now time.Time) {
+	window := seconds(s.config.Window)
+	rate := float64(c.syns) / window.Seconds()
+	flooding := rate >= float64(s.config.SourceSynRate) && c.completion() < s.config.MaxCompletion
+	if !flooding && halfOpen < s.config.SourceHalfOpen {
+		return
+	}
+	if !s.reported.ready("source|"+src, now, seconds(s.config.Cooldown)) {
+		return
+	}
+
+	targets := c.topPeers(topPeers)
+	s.emit(model.Detection{
+		Protocol:    "TCP",
+		AttackerIP:  src,
+		TargetIP:    targets[0],
+		TargetPort:  c.port(),
+		Message:     fmt.Sprintf("SYN flood from %s: %.0f SYN/s, %d half-open, %.0f%% completed", src, rate, halfOpen, c.completion()*100),
+		SignatureID: SigSourceSYNFlood,
+		Severity:    model.SeverityHigh,
+		Evidence: map[string]any{
+			"syn_rate":    math.Round(rate),
+			"half_open":   halfOpen,
+			"completion":  math.Round(c.completion()*100) / 100,
+			"targets":     len(c.peers),
+			"top_targets": targets,
+			"window":      window.String(),
+		},
+	})
+}
+
+// sweep must be called with s.mu held
+func (s *synFlood) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	window := seconds(s.config.Window)
+	for _, counters := range []map[string]*synCounter{s.targets, s.sources} {
+		for host, c := range counters {
+			if now.Sub(c.start) >= window {
+				delete(counters, host)
+			}
+		}
+	}
+	s.reported.prune(now)
+}
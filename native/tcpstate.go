@@ -0,0 +1,249 @@
+package native
+
+import (
+	"fmt"
+	"main/metrics"
+	"main/model"
+	"sync"
+	"time"
+)
+
+// TCP connection states as seen from the middle of the path
+const (
+	StateSynSent     = "SYN_SENT"     // the client sent a SYN
+	StateSynReceived = "SYN_RECEIVED" // the server answered with a SYN-ACK
+	StateEstablished = "ESTABLISHED"  // the client acknowledged the SYN-ACK, or the connection was picked up mid-stream
+	StateFinWait     = "FIN_WAIT"     // one side sent a FIN
+	StateClosing     = "CLOSING"      // both sides sent a FIN
+	StateClosed      = "CLOSED"       // reset, or acknowledged after both FINs; no longer tracked
+)
+
+var trackedStates = []string{StateSynSent, StateSynReceived, StateEstablished, StateFinWait, StateClosing}
+
+// maxMidstreamShare is the share of max_connections that connections picked up
+// mid-stream and not answered yet may take, so a flood of unsolicited ACKs
+// can't crowd out the connections that really exist
+const maxMidstreamShare = 4
+
+type TCPConfig struct {
+	MaxConnections     int `json:"max_connections"`     // connections tracked at once, new ones are ignored beyond
+	HalfOpenTimeout    int `json:"half_open_timeout"`   // seconds before an unfinished handshake is dropped
+	EstablishedTimeout int `json:"established_timeout"` // seconds an idle connection is kept
+	ClosingTimeout     int `json:"closing_timeout"`     // seconds a closing connection is kept
+	MidstreamTimeout   int `json:"midstream_timeout"`   // seconds a connection picked up mid-stream is kept until the other side answers
+}
+
+func DefaultTCPConfig() TCPConfig {
+	return TCPConfig{
+		MaxConnections:     200000,
+		HalfOpenTimeout:    30,
+		EstablishedTimeout: 600,
+		ClosingTimeout:     30,
+		MidstreamTimeout:   30,
+	}
+}
+
+func (c TCPConfig) validate() error {
+	if c.MaxConnections <= 0 {
+		return fmt.Errorf("max_connections must be positive")
+	}
+	if c.HalfOpenTimeout <= 0 || c.EstablishedTimeout <= 0 || c.ClosingTimeout <= 0 || c.MidstreamTimeout <= 0 {
+		return fmt.Errorf("timeouts must be positive")
+	}
+	return nil
+}
+
+type connection struct {
+	Client, Server         string
+	ClientPort, ServerPort uint64
+	State                  string
+	Opened, LastSeen       time.Time
+	finFrom                string // address of the side that sent the first FIN
+	pickedUpBy             string // address of the side that was seen mid-stream, until the other side answers
+}
+
+func (c *connection) halfOpen() bool {
+	return c.State == StateSynSent || c.State == StateSynReceived
+}
+
+// transition is what one packet did to its connection
+type transition struct {
+	From, To string // From is empty for new connections, both are empty for untracked packets
+
+	// Half-open connections of the packet's destination and source after the packet
+	DestinationHalfOpen, SourceHalfOpen int
+}
+
+// completed reports whether the packet finished a handshake
+func (t transition) completed() bool {
+	return (t.From == StateSynSent || t.From == StateSynReceived) && t.To == StateEstablished
+}
+
+// tcpTracker follows the handshake and teardown of TCP connections and counts
+// the half-open ones per server and client
+type tcpTracker struct {
+	mu     sync.Mutex
+	config TCPConfig
+
+	connections      map[string]*connection
+	halfOpenByServer map[string]int
+	halfOpenByClient map[string]int
+	midstream        int // connections picked up mid-stream that weren't answered yet
+	lastSweep        time.Time
+}
+
+func newTCPTracker(config TCPConfig) *tcpTracker {
+	for _, state := range trackedStates {
+		metrics.TCPConnections.Set(0, state)
+	}
+	return &tcpTracker{
+		config:           config,
+		connections:      make(map[string]*connection),
+		halfOpenByServer: make(map[string]int),
+		halfOpenByClient: make(map[string]int),
+	}
+}
+
+func (t *tcpTracker) update(p *model.PacketAnalysisTCP, now time.Time) transition {
+	tcp := p.TCP
+	src, dst := p.IPv4.SourceIP, p.IPv4.DestinationIP
+	key := connectionKey("tcp", src, tcp.SourcePort, dst, tcp.DestinationPort)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+
+	c, ok := t.connections[key]
+	switch {
+	case !ok && tcp.SYN && !tcp.ACK && !tcp.RST:
+		c = &connection{Client: src, Server: dst, ClientPort: tcp.SourcePort, ServerPort: tcp.DestinationPort, Opened: now}
+	case !ok && tcp.ACK && !tcp.SYN && !tcp.RST:
+		// Picked up mid-stream, the lower port is most likely the server's. An
+		// ACK alone proves nothing, the connection is only kept for long once
+		// the other side answers.
+		if t.midstream >= t.config.MaxConnections/maxMidstreamShare {
+			return t.counts(transition{}, src, dst)
+		}
+		c = &connection{Client: src, Server: dst, ClientPort: tcp.SourcePort, ServerPort: tcp.DestinationPort, Opened: now, pickedUpBy: src}
+		if tcp.SourcePort < tcp.DestinationPort {
+			c.Client, c.Server, c.ClientPort, c.ServerPort = dst, src, tcp.DestinationPort, tcp.SourcePort
+		}
+	case !ok:
+		// Stray SYN-ACKs, resets and probes don't open anything
+		return t.counts(transition{}, src, dst)
+	}
+
+	if !ok && len(t.connections) >= t.config.MaxConnections {
+		return t.counts(transition{}, src, dst)
+	}
+
+	if !ok && c.pickedUpBy != "" {
+		t.midstream++
+	} else if c.pickedUpBy != "" && src != c.pickedUpBy {
+		c.pickedUpBy = ""
+		t.midstream--
+	}
+
+	from := c.State
+	to := nextState(c, src, tcp)
+	c.LastSeen = now
+	if from != to {
+		t.move(key, c, to)
+	}
+	return t.counts(transition{From: from, To: to}, src, dst)
+}
+
+// nextState applies one packet from src to the state of c
+func nextState(c *connection, src string, tcp *model.TCPInfo) string {
+	fromClient := src == c.Client
+	switch {
+	case tcp.RST:
+		return StateClosed
+	case c.State == "" && tcp.SYN:
+		return StateSynSent
+	case c.State == "":
+		return StateEstablished
+	case c.State == StateSynSent && tcp.SYN && tcp.ACK && !fromClient:
+		return StateSynReceived
+	case c.State == StateSynSent && tcp.ACK && !tcp.SYN && fromClient:
+		// The SYN-ACK took another path
+		return StateEstablished
+	case c.State == StateSynReceived && tcp.ACK && !tcp.SYN && fromClient:
+		return StateEstablished
+	case c.State == StateEstablished && tcp.FIN:
+		c.finFrom = src
+		return StateFinWait
+	case c.State == StateFinWait && tcp.FIN && src != c.finFrom:
+		return StateClosing
+	case c.State == StateClosing && tcp.ACK && !tcp.FIN:
+		return StateClosed
+	}
+	return c.State
+}
+
+// move changes the state of c, must be called with t.mu held
+func (t *tcpTracker) move(key string, c *connection, to string) {
+	if c.State != "" {
+		metrics.TCPConnections.Add(-1, c.State)
+		if c.halfOpen() {
+			t.release(c)
+		}
+	}
+
+	c.State = to
+	if to == StateClosed {
+		if c.pickedUpBy != "" {
+			t.midstream--
+		}
+		delete(t.connections, key)
+		return
+	}
+
+	t.connections[key] = c
+	metrics.TCPConnections.Add(1, to)
+	if c.halfOpen() {
+		t.halfOpenByServer[c.Server]++
+		t.halfOpenByClient[c.Client]++
+	}
+}
+
+// release forgets a half-open connection in the counts, must be called with t.mu held
+func (t *tcpTracker) release(c *connection) {
+	if t.halfOpenByServer[c.Server]--; t.halfOpenByServer[c.Server] <= 0 {
+		delete(t.halfOpenByServer, c.Server)
+	}
+	if t.halfOpenByClient[c.Client]--; t.halfOpenByClient[c.Client] <= 0 {
+		delete(t.halfOpenByClient, c.Client)
+	}
+}
+
+func (t *tcpTracker) counts(tr transition, src, dst string) transition {
+	tr.DestinationHalfOpen = t.halfOpenByServer[dst]
+	tr.SourceHalfOpen = t.halfOpenByClient[src]
+	return tr
+}
+
+// sweep drops connections idle for longer than the timeout of their state,
+// must be called with t.mu held
+func (t *tcpTracker) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < sweepInterval {
+		return
+	}
+	t.lastSweep = now
+
+	for key, c := range t.connections {
+		timeout := seconds(t.config.EstablishedTimeout)
+		switch {
+		case c.halfOpen():
+			timeout = seconds(t.config.HalfOpenTimeout)
+		case c.pickedUpBy != "":
+			timeout = seconds(t.config.MidstreamTimeout)
+		case c.State == StateFinWait || c.State == StateClosing:
+			timeout = seconds(t.config.ClosingTimeout)
+		}
+		if now.Sub(c.LastSeen) > timeout {
+			t.move(key, c, StateClosed)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package native
+
+import (
+	"fmt"
+	"testing"
+)
+
+const clientIP, serverIP = "192.0.2.10", "10.0.0.1"
+
+// step is one packet of a connection between the client on port 40000 and the server on port 80
+type step struct {
+	fromClient bool
+	flags      string
+	from, to   string // transition wanted
+}
+
+func (s step) packet() (src string, srcPort uint64, dst string, dstPort uint64) {
+	if s.fromClient {
+		return clientIP, 40000, serverIP, 80
+	}
+	return serverIP, 80, clientIP, 40000
+}
+
+var handshake = []step{
+	{true, "S", "", StateSynSent},
+	{false, "SA", StateSynSent, StateSynReceived},
+	{true, "A", StateSynReceived, StateEstablished},
+}
+
+func TestTCPTransitions(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"handshake", handshake},
+		{
+			name: "teardown",
+			steps: append(handshake[:len(handshake):len(handshake)],
+				step{true, "FA", StateEstablished, StateFinWait},
+				step{false, "A", StateFinWait, StateFinWait},
+				step{false, "FA", StateFinWait, StateClosing},
+				step{true, "A", StateClosing, StateClosed},
+			),
+		},
+		{
+			name: "FIN repeated by one side",
+			steps: append(handshake[:len(handshake):len(handshake)],
+				step{false, "FA", StateEstablished, StateFinWait},
+				step{false, "FA", StateFinWait, StateFinWait},
+			),
+		},
+		{
+			name: "data keeps it established",
+			steps: append(handshake[:len(handshake):len(handshake)],
+				step{true, "PA", StateEstablished, StateEstablished},
+				step{false, "A", StateEstablished, StateEstablished},
+			),
+		},
+		{
+			name: "refused",
+			steps: []step{
+				{true, "S", "", StateSynSent},
+				{false, "RA", StateSynSent, StateClosed},
+			},
+		},
+		{
+			name: "reset while established",
+			steps: append(handshake[:len(handshake):len(handshake)],
+				step{true, "R", StateEstablished, StateClosed},
+			),
+		},
+		{
+			name: "SYN-ACK on another path",
+			steps: []step{
+				{true, "S", "", StateSynSent},
+				{true, "A", StateSynSent, StateEstablished},
+			},
+		},
+		{
+			name: "SYN-ACK from the client",
+			steps: []step{
+				{true, "S", "", StateSynSent},
+				{true, "SA", StateSynSent, StateSynSent},
+			},
+		},
+		{
+			name: "picked up mid-stream",
+			steps: []step{
+				{true, "PA", "", StateEstablished},
+				{false, "A", StateEstablished, StateEstablished},
+			},
+		},
+		{
+			name: "stray packets aren't tracked",
+			steps: []step{
+				{false, "SA", "", ""},
+				{true, "R", "", ""},
+				{true, "F", "", ""},
+				{true, "", "", ""},
+			},
+		},
+		{
+			name: "reopened after the close",
+			steps: []step{
+				{true, "S", "", StateSynSent},
+				{false, "R", StateSynSent, StateClosed},
+				{true, "S", "", StateSynSent},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newTCPTracker(DefaultTCPConfig())
+			for i, s := range tt.steps {
+				src, srcPort, dst, dstPort := s.packet()
+				got := tracker.update(tcpPacket(src, srcPort, dst, dstPort, s.flags, 0, ""), start)
+				if got.From != s.from || got.To != s.to {
+					t.Errorf("step %d (%s): %q -> %q, want %q -> %q", i, s.flags, got.From, got.To, s.from, s.to)
+				}
+			}
+		})
+	}
+}
+
+func TestTCPHalfOpen(t *testing.T) {
+	tracker := newTCPTracker(DefaultTCPConfig())
+	syn := func(port uint64) transition {
+		return tracker.update(tcpPacket(clientIP, port, serverIP, 80, "S", 0, ""), start)
+	}
+
+	for port := uint64(40000); port < 40003; port++ {
+		got := syn(port)
+		if want := int(port - 40000 + 1); got.DestinationHalfOpen != want || got.SourceHalfOpen != want {
+			t.Errorf("SYN from port %d: %d half-open at the server, %d from the client, want %d", port, got.DestinationHalfOpen, got.SourceHalfOpen, want)
+		}
+	}
+
+	tracker.update(tcpPacket(serverIP, 80, clientIP, 40000, "SA", 0, ""), start)
+	completed := tracker.update(tcpPacket(clientIP, 40000, serverIP, 80, "A", 0, ""), start)
+	if !completed.completed() || completed.DestinationHalfOpen != 2 {
+		t.Errorf("completing a handshake: %+v, want it completed with 2 left half-open", completed)
+	}
+	tracker.update(tcpPacket(serverIP, 80, clientIP, 40001, "R", 0, ""), start)
+	if n := tracker.halfOpenByServer[serverIP]; n != 1 {
+		t.Errorf("%d half-open after a reset, want 1", n)
+	}
+
+	// Unfinished handshakes are dropped after half_open_timeout
+	later := start.Add(seconds(DefaultTCPConfig().HalfOpenTimeout) + sweepInterval)
+	if got := tracker.update(tcpPacket(clientIP, 50000, serverIP, 80, "S", 0, ""), later); got.DestinationHalfOpen != 1 {
+		t.Errorf("%d half-open after the timeout, want only the new one", got.DestinationHalfOpen)
+	}
+	if n := len(tracker.connections); n != 2 {
+		t.Errorf("%d connections tracked, want the established one and the new one", n)
+	}
+}
+
+func TestTCPMaxConnections(t *testing.T) {
+	config := DefaultTCPConfig()
+	config.MaxConnections = 8
+	tracker := newTCPTracker(config)
+	packet := func(src string, srcPort uint64, flags string) transition {
+		return tracker.update(tcpPacket(src, srcPort, serverIP, 80, flags, 0, ""), start)
+	}
+
+	// Unanswered mid-stream pickups get a quarter of the table
+	for i := range 3 {
+		got := packet(fmt.Sprintf("192.0.2.%d", i), 40000, "A")
+		if tracked := got.To != ""; tracked != (i < 2) {
+			t.Errorf("mid-stream ACK %d tracked = %v", i, tracked)
+		}
+	}
+	// An answer frees the slot
+	tracker.update(tcpPacket(serverIP, 80, "192.0.2.0", 40000, "A", 0, ""), start)
+	if got := packet("192.0.2.2", 40000, "A"); got.To != StateEstablished {
+		t.Errorf("mid-stream ACK after an answer: %+v, want it tracked", got)
+	}
+
+	for port := uint64(1); port <= 6; port++ {
+		got := packet(clientIP, port, "S")
+		if tracked := got.To != ""; tracked != (port <= 5) {
+			t.Errorf("SYN %d tracked = %v with %d connections", port, tracked, len(tracker.connections))
+		}
+	}
+	if n := len(tracker.connections); n != config.MaxConnections {
+		t.Errorf("%d connections tracked, want %d", n, config.MaxConnections)
+	}
+
+	// Known connections still move once the table is full
+	if got := tracker.update(tcpPacket(serverIP, 80, clientIP, 1, "SA", 0, ""), start); got.To != StateSynReceived {
+		t.Errorf("SYN-ACK of a tracked connection: %+v", got)
+	}
+}
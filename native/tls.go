@@ -0,0 +1,387 @@
+package native
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TLS record and handshake types
+const (
+	tlsRecordHandshake = 22
+	tlsClientHello     = 1
+	tlsServerHello     = 2
+)
+
+// TLS extensions the fingerprints look into
+const (
+	tlsExtServerName          = 0x0000
+	tlsExtSupportedGroups     = 0x000a
+	tlsExtECPointFormats      = 0x000b
+	tlsExtSignatureAlgorithms = 0x000d
+	tlsExtALPN                = 0x0010
+	tlsExtSupportedVersions   = 0x002b
+)
+
+var tlsVersionNames = map[uint16]string{
+	0x0300: "SSL 3.0",
+	0x0301: "TLS 1.0",
+	0x0302: "TLS 1.1",
+	0x0303: "TLS 1.2",
+	0x0304: "TLS 1.3",
+}
+
+// maxTLSHandshake bounds the bytes of one hello message, larger ones aren't TLS
+const maxTLSHandshake = 64 * 1024
+
+var errTLSTruncated = errors.New("truncated TLS hello")
+
+// tlsHello is the part of a ClientHello or ServerHello the fingerprints need
+type tlsHello struct {
+	version    uint16   // legacy version field
+	ciphers    []uint16 // offered by the client, or the one chosen by the server
+	extensions []uint16 // in the order sent
+	groups     []uint16
+	points     []uint8
+	sigAlgs    []uint16
+	versions   []uint16 // supported_versions, the server's choice in a ServerHello
+	sni        string
+	alpn       []string
+}
+
+// isGREASE reports whether v is one of the reserved values clients send to
+// keep servers tolerant of unknown ones (RFC 8701), which fingerprints ignore
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// tlsRecords collects the handshake messages of one direction of a
+// connection across TLS records and TCP segments
+type tlsRecords struct {
+	buf       []byte // unparsed record bytes
+	handshake []byte // handshake bytes taken out of the records
+	failed    bool   // not TLS, or out of sync
+}
+
+// feed adds data and returns the first handshake message once complete, with
+// its type
+func (r *tlsRecords) feed(data []byte) (uint8, []byte, bool) {
+	if r.failed {
+		return 0, nil, false
+	}
+	r.buf = append(r.buf, data...)
+
+	for len(r.buf) >= 5 {
+		if r.buf[0] != tlsRecordHandshake || r.buf[1] != 3 {
+			r.fail()
+			return 0, nil, false
+		}
+		size := int(binary.BigEndian.Uint16(r.buf[3:5]))
+		if len(r.buf) < 5+size {
+			break
+		}
+		r.handshake = append(r.handshake, r.buf[5:5+size]...)
+		r.buf = r.buf[5+size:]
+
+		if len(r.handshake) < 4 {
+			continue
+		}
+		size = int(r.handshake[1])<<16 | int(r.handshake[2])<<8 | int(r.handshake[3])
+		if size > maxTLSHandshake {
+			r.fail()
+			return 0, nil, false
+		}
+		if len(r.handshake) >= 4+size {
+			// Only the first message is wanted, the records after it may not even be handshakes
+			msg := r.handshake[:4+size]
+			r.fail()
+			return msg[0], msg[4:], true
+		}
+	}
+	if len(r.buf)+len(r.handshake) > maxTLSHandshake+5 {
+		r.fail()
+	}
+	return 0, nil, false
+}
+
+func (r *tlsRecords) fail() {
+	r.failed = true
+	r.buf, r.handshake = nil, nil
+}
+
+// tlsReader reads the big-endian fields of a hello
+type tlsReader struct {
+	data []byte
+	err  error
+}
+
+func (r *tlsReader) bytes(n int) []byte {
+	if r.err != nil || len(r.data) < n {
+		r.err = errTLSTruncated
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *tlsReader) uint8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *tlsReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+// vector reads a length-prefixed field whose length takes size bytes
+func (r *tlsReader) vector(size int) []byte {
+	var n int
+	switch size {
+	case 1:
+		n = int(r.uint8())
+	case 2:
+		n = int(r.uint16())
+	}
+	return r.bytes(n)
+}
+
+func uint16s(data []byte) []uint16 {
+	values := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		values = append(values, binary.BigEndian.Uint16(data[i:]))
+	}
+	return values
+}
+
+// parseClientHello decodes the body of a ClientHello handshake message
+func parseClientHello(body []byte) (*tlsHello, error) {
+	r := &tlsReader{data: body}
+	h := &tlsHello{version: r.uint16()}
+	r.bytes(32) // random
+	r.vector(1) // session id
+	h.ciphers = uint16s(r.vector(2))
+	r.vector(1) // compression methods
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) == 0 {
+		return h, nil // no extensions
+	}
+	if err := h.parseExtensions(r.vector(2), true); err != nil {
+		return nil, err
+	}
+	return h, r.err
+}
+
+// parseServerHello decodes the body of a ServerHello handshake message
+func parseServerHello(body []byte) (*tlsHello, error) {
+	r := &tlsReader{data: body}
+	h := &tlsHello{version: r.uint16()}
+	r.bytes(32) // random
+	r.vector(1) // session id
+	h.ciphers = []uint16{r.uint16()}
+	r.uint8() // compression method
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) == 0 {
+		return h, nil
+	}
+	if err := h.parseExtensions(r.vector(2), false); err != nil {
+		return nil, err
+	}
+	return h, r.err
+}
+
+func (h *tlsHello) parseExtensions(data []byte, client bool) error {
+	r := &tlsReader{data: data}
+	for len(r.data) > 0 && r.err == nil {
+		kind := r.uint16()
+		ext := r.vector(2)
+		if r.err != nil {
+			break
+		}
+		h.extensions = append(h.extensions, kind)
+
+		e := &tlsReader{data: ext}
+		switch kind {
+		case tlsExtServerName:
+			// A list of names, the first host name is the one used
+			names := &tlsReader{data: e.vector(2)}
+			for len(names.data) > 0 && names.err == nil {
+				nameType, name := names.uint8(), names.vector(2)
+				if nameType == 0 && h.sni == "" {
+					h.sni = string(name)
+				}
+			}
+		case tlsExtSupportedGroups:
+			h.groups = uint16s(e.vector(2))
+		case tlsExtECPointFormats:
+			h.points = e.vector(1)
+		case tlsExtSignatureAlgorithms:
+			h.sigAlgs = uint16s(e.vector(2))
+		case tlsExtALPN:
+			protocols := &tlsReader{data: e.vector(2)}
+			for len(protocols.data) > 0 && protocols.err == nil {
+				if p := protocols.vector(1); len(p) > 0 {
+					h.alpn = append(h.alpn, string(p))
+				}
+			}
+		case tlsExtSupportedVersions:
+			if client {
+				h.versions = uint16s(e.vector(1))
+			} else {
+				h.versions = []uint16{e.uint16()}
+			}
+		}
+	}
+	return r.err
+}
+
+// negotiated returns the version of a ServerHello, or the highest offered by a ClientHello
+func (h *tlsHello) negotiated() uint16 {
+	version := h.version
+	for _, v := range h.versions {
+		if !isGREASE(v) && v > version && v < 0x0400 {
+			version = v
+		}
+	}
+	return version
+}
+
+func tlsVersionName(v uint16) string {
+	if name, ok := tlsVersionNames[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// joinDecimal joins values as JA3 does, dropping GREASE
+func joinDecimal[T uint8 | uint16](values []T) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(uint16(v)) {
+			parts = append(parts, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// ja3 returns the JA3 string of a ClientHello and its MD5
+func (h *tlsHello) ja3() (string, string) {
+	s := strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		joinDecimal(h.ciphers),
+		joinDecimal(h.extensions),
+		joinDecimal(h.groups),
+		joinDecimal(h.points),
+	}, ",")
+	sum := md5.Sum([]byte(s))
+	return s, hex.EncodeToString(sum[:])
+}
+
+// ja3s returns the JA3S string of a ServerHello and its MD5
+func (h *tlsHello) ja3s() (string, string) {
+	s := strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		joinDecimal(h.ciphers),
+		joinDecimal(h.extensions),
+	}, ",")
+	sum := md5.Sum([]byte(s))
+	return s, hex.EncodeToString(sum[:])
+}
+
+// ja4 returns the JA4 fingerprint of a ClientHello sent over TCP
+func (h *tlsHello) ja4() string {
+	version := "00"
+	switch h.negotiated() {
+	case 0x0304:
+		version = "13"
+	case 0x0303:
+		version = "12"
+	case 0x0302:
+		version = "11"
+	case 0x0301:
+		version = "10"
+	case 0x0300:
+		version = "s3"
+	}
+
+	sni := "i"
+	if slices.Contains(h.extensions, tlsExtServerName) {
+		sni = "d"
+	}
+
+	ciphers := hexList(h.ciphers, nil)
+	extensions := hexList(h.extensions, []uint16{tlsExtServerName, tlsExtALPN})
+	extensionCount := 0
+	for _, e := range h.extensions {
+		if !isGREASE(e) {
+			extensionCount++
+		}
+	}
+
+	alpn := "00"
+	if len(h.alpn) > 0 {
+		alpn = ja4ALPN(h.alpn[0])
+	}
+
+	a := fmt.Sprintf("t%s%s%02d%02d%s", version, sni, min(len(ciphers), 99), min(extensionCount, 99), alpn)
+
+	slices.Sort(ciphers)
+	b := ja4Hash(strings.Join(ciphers, ","), len(ciphers) == 0)
+
+	slices.Sort(extensions)
+	c := strings.Join(extensions, ",")
+	if algs := hexList(h.sigAlgs, nil); len(algs) > 0 {
+		c += "_" + strings.Join(algs, ",")
+	}
+	return a + "_" + b + "_" + ja4Hash(c, len(extensions) == 0)
+}
+
+// hexList formats values as 4 digit hex, dropping GREASE and skip
+func hexList(values, skip []uint16) []string {
+	list := make([]string, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) && !slices.Contains(skip, v) {
+			list = append(list, fmt.Sprintf("%04x", v))
+		}
+	}
+	return list
+}
+
+// ja4Hash is the first 12 hex digits of the SHA-256 of s, zeros when empty
+func ja4Hash(s string, empty bool) string {
+	if empty {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// ja4ALPN is the first and last character of the first ALPN protocol, or of
+// its hex form when those aren't alphanumeric
+func ja4ALPN(protocol string) string {
+	first, last := protocol[0], protocol[len(protocol)-1]
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
+	}
+	h := hex.EncodeToString([]byte(protocol))
+	return string([]byte{h[0], h[len(h)-1]})
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,275 @@
+package native
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+func vector1(data []byte) []byte {
+	return append([]byte{byte(len(data))}, data...)
+}
+
+func vector2(data []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(data))), data...)
+}
+
+func uint16Bytes(values ...uint16) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+func tlsExtension(kind uint16, data []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, kind), vector2(data)...)
+}
+
+// helloBody encodes a ClientHello body with an empty session id
+func helloBody(version uint16, ciphers []uint16, extensions ...[]byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, version)
+	b = append(b, make([]byte, 32)...)
+	b = append(b, vector1(nil)...)
+	b = append(b, vector2(uint16Bytes(ciphers...))...)
+	b = append(b, vector1([]byte{0})...)
+	if extensions != nil {
+		b = append(b, vector2(slices.Concat(extensions...))...)
+	}
+	return b
+}
+
+// handshakeRecord wraps a handshake message of the given type in one record
+func handshakeRecord(msgType uint8, body []byte) []byte {
+	msg := append([]byte{msgType, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{tlsRecordHandshake, 3, 1, byte(len(msg) >> 8), byte(len(msg))}, msg...)
+}
+
+// splitRecords spreads the handshake message of record over records of at
+// most n bytes of payload
+func splitRecords(record []byte, n int) []byte {
+	var out []byte
+	for msg := record[5:]; len(msg) > 0; {
+		part := msg[:min(n, len(msg))]
+		msg = msg[len(part):]
+		out = append(out, tlsRecordHandshake, 3, 1, byte(len(part)>>8), byte(len(part)))
+		out = append(out, part...)
+	}
+	return out
+}
+
+func TestTLSRecords(t *testing.T) {
+	body := helloBody(0x0303, []uint16{0x1301}, tlsExtension(tlsExtServerName, nil))
+	record := handshakeRecord(tlsClientHello, body)
+	appData := []byte{23, 3, 3, 0, 2, 'h', 'i'}
+
+	tests := []struct {
+		name   string
+		feeds  [][]byte
+		want   bool // the hello comes out of the last feed
+		failed bool
+	}{
+		{name: "one record", feeds: [][]byte{record}, want: true},
+		{name: "record and more", feeds: [][]byte{slices.Concat(record, appData)}, want: true},
+		{name: "record header split", feeds: [][]byte{record[:3], record[3:]}, want: true},
+		{name: "record split", feeds: [][]byte{record[:20], record[20:40], record[40:]}, want: true},
+		{name: "message across records", feeds: [][]byte{splitRecords(record, 16)}, want: true},
+		{name: "message header across records", feeds: [][]byte{splitRecords(record, 2)}, want: true},
+		{name: "message across records and segments", feeds: [][]byte{splitRecords(record, 16)[:30], splitRecords(record, 16)[30:]}, want: true},
+		{name: "incomplete", feeds: [][]byte{record[:len(record)-1]}},
+		{name: "not a handshake", feeds: [][]byte{appData}, failed: true},
+		{name: "not TLS", feeds: [][]byte{[]byte("GET / HTTP/1.1\r\n\r\n")}, failed: true},
+		{name: "oversized message", feeds: [][]byte{{tlsRecordHandshake, 3, 1, 0, 4, tlsClientHello, 0x01, 0x00, 0x01}}, failed: true},
+		{name: "only the first message", feeds: [][]byte{record, record}, failed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &tlsRecords{}
+			var msgType uint8
+			var got []byte
+			var ok bool
+			for _, data := range tt.feeds {
+				msgType, got, ok = r.feed(data)
+			}
+			if ok != tt.want {
+				t.Fatalf("complete = %v, want %v", ok, tt.want)
+			}
+			if ok && (msgType != tlsClientHello || !bytes.Equal(got, body)) {
+				t.Errorf("message type %d of %d bytes, want the ClientHello of %d", msgType, len(got), len(body))
+			}
+			if r.failed != (tt.failed || tt.want) {
+				t.Errorf("failed = %v", r.failed)
+			}
+		})
+	}
+}
+
+func TestTLSRecordsByteByByte(t *testing.T) {
+	record := splitRecords(handshakeRecord(tlsServerHello, helloBody(0x0303, []uint16{0x1301})), 10)
+	r := &tlsRecords{}
+	for i := range record {
+		msgType, _, ok := r.feed(record[i : i+1])
+		if ok != (i == len(record)-1) {
+			t.Fatalf("byte %d of %d: complete = %v", i, len(record), ok)
+		}
+		if ok && msgType != tlsServerHello {
+			t.Errorf("message type %d, want the ServerHello", msgType)
+		}
+	}
+}
+
+// chromeHello is the ClientHello the JA4 reference uses for
+// t13d1516h2_8daaf6152771_e5627efa2ab1
+func chromeHello() []byte {
+	ciphers := []uint16{0x0a0a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035}
+	return helloBody(0x0303, ciphers,
+		tlsExtension(0x1a1a, nil),
+		tlsExtension(tlsExtServerName, vector2(append([]byte{0}, vector2([]byte("example.com"))...))),
+		tlsExtension(0x0017, nil),
+		tlsExtension(0xff01, []byte{0}),
+		tlsExtension(tlsExtSupportedGroups, vector2(uint16Bytes(0x2a2a, 0x001d, 0x0017, 0x0018))),
+		tlsExtension(tlsExtECPointFormats, vector1([]byte{0})),
+		tlsExtension(0x0023, nil),
+		tlsExtension(tlsExtALPN, vector2(slices.Concat(vector1([]byte("h2")), vector1([]byte("http/1.1"))))),
+		tlsExtension(0x0005, []byte{1, 0, 0, 0, 0}),
+		tlsExtension(tlsExtSignatureAlgorithms, vector2(uint16Bytes(0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601))),
+		tlsExtension(0x0012, nil),
+		tlsExtension(0x0033, nil),
+		tlsExtension(0x002d, vector1([]byte{1})),
+		tlsExtension(tlsExtSupportedVersions, vector1(uint16Bytes(0x3a3a, 0x0304, 0x0303))),
+		tlsExtension(0x001b, nil),
+		tlsExtension(0x0015, nil),
+		tlsExtension(0x4469, nil),
+		tlsExtension(0x4a4a, []byte{0}),
+	)
+}
+
+func TestParseClientHello(t *testing.T) {
+	h, err := parseClientHello(chromeHello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.sni != "example.com" {
+		t.Errorf("sni = %q", h.sni)
+	}
+	if !slices.Equal(h.alpn, []string{"h2", "http/1.1"}) {
+		t.Errorf("alpn = %q", h.alpn)
+	}
+	if h.negotiated() != 0x0304 {
+		t.Errorf("negotiated %#04x, want TLS 1.3", h.negotiated())
+	}
+	if len(h.ciphers) != 16 || len(h.extensions) != 18 {
+		t.Errorf("%d ciphers and %d extensions, want GREASE kept in the hello", len(h.ciphers), len(h.extensions))
+	}
+
+	for _, body := range [][]byte{
+		chromeHello()[:34],
+		chromeHello()[:len(chromeHello())-1],
+		helloBody(0x0303, []uint16{0x1301}, tlsExtension(tlsExtServerName, nil))[:45],
+	} {
+		if _, err := parseClientHello(body); err == nil {
+			t.Errorf("hello truncated to %d bytes accepted", len(body))
+		}
+	}
+}
+
+func TestJA4(t *testing.T) {
+	chrome, err := parseClientHello(chromeHello())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		hello *tlsHello
+		want  string
+	}{
+		{"reference", chrome, "t13d1516h2_8daaf6152771_e5627efa2ab1"},
+		{"no extensions", &tlsHello{version: 0x0303, ciphers: []uint16{0x002f}}, "t12i010000_ba72b8082249_000000000000"},
+		{"nothing", &tlsHello{version: 0x0301}, "t10i000000_000000000000_000000000000"},
+		{"unknown version", &tlsHello{version: 0x0200}, "t00i000000_000000000000_000000000000"},
+		{"IP address", &tlsHello{version: 0x0303, extensions: []uint16{tlsExtALPN}, alpn: []string{"http/1.1"}}, "t12i0001h1_000000000000_000000000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hello.ja4(); got != tt.want {
+				t.Errorf("ja4 = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJA4ALPN(t *testing.T) {
+	tests := []struct {
+		protocol, want string
+	}{
+		{"h2", "h2"},
+		{"http/1.1", "h1"},
+		{"h", "hh"},
+		{"\xab", "ab"},
+		{"h2\x00", "60"},
+	}
+	for _, tt := range tests {
+		if got := ja4ALPN(tt.protocol); got != tt.want {
+			t.Errorf("ja4ALPN(%q) = %q, want %q", tt.protocol, got, tt.want)
+		}
+	}
+}
+
+func TestJA3(t *testing.T) {
+	// The example of the JA3 reference, with GREASE added that JA3 ignores
+	body := helloBody(0x0301, []uint16{0x0a0a, 47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+		tlsExtension(tlsExtServerName, vector2(append([]byte{0}, vector2([]byte("example.com"))...))),
+		tlsExtension(0x2a2a, nil),
+		tlsExtension(tlsExtSupportedGroups, vector2(uint16Bytes(0x3a3a, 23, 24, 25))),
+		tlsExtension(tlsExtECPointFormats, vector1([]byte{0})),
+	)
+	h, err := parseClientHello(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, hash := h.ja3()
+	if want := "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0"; s != want {
+		t.Errorf("ja3 = %s, want %s", s, want)
+	}
+	if want := "ada70206e40642a3e4461f35503241d5"; hash != want {
+		t.Errorf("ja3 hash = %s, want %s", hash, want)
+	}
+
+	// Without extensions the last three fields stay empty
+	h, err = parseClientHello(helloBody(0x0303, []uint16{0x002f}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := h.ja3(); s != "771,47,,," {
+		t.Errorf("ja3 = %s, want 771,47,,,", s)
+	}
+}
+
+func TestParseServerHello(t *testing.T) {
+	body := binary.BigEndian.AppendUint16(nil, 0x0303)
+	body = append(body, make([]byte, 32)...)
+	body = append(body, vector1(make([]byte, 32))...)
+	body = append(body, 0x13, 0x01, 0)
+	body = append(body, vector2(slices.Concat(
+		tlsExtension(tlsExtSupportedVersions, uint16Bytes(0x0304)),
+		tlsExtension(0x0033, make([]byte, 36)),
+	))...)
+
+	h, err := parseServerHello(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.negotiated() != 0x0304 || tlsVersionName(h.negotiated()) != "TLS 1.3" {
+		t.Errorf("negotiated %s, want TLS 1.3", tlsVersionName(h.negotiated()))
+	}
+	if s, _ := h.ja3s(); s != "771,4865,43-51" {
+		t.Errorf("ja3s = %s, want 771,4865,43-51", s)
+	}
+
+	if _, err := parseServerHello(body[:36]); err == nil {
+		t.Error("truncated ServerHello accepted")
+	}
+}
@@ -0,0 +1,310 @@
+package native
+
+import (
+	"fmt"
+	"main/model"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS signatures
+const (
+	SigTLSClientFingerprint = GID + ":1801" // a ClientHello matching a blocklisted JA3 or JA4
+	SigTLSServerFingerprint = GID + ":1802" // a ServerHello matching a blocklisted JA3S
+)
+
+// Handshakes are kept for the flow record of their connection for handshakeTTL,
+// at most maxHandshakes of them
+const (
+	handshakeTTL  = 5 * time.Minute
+	maxHandshakes = 65536
+)
+
+type TLSConfig struct {
+	Enabled   bool             `json:"enabled"`
+	Ports     []int            `json:"ports"`     // server ports carrying TLS
+	Blocklist []TLSFingerprint `json:"blocklist"` // fingerprints of known malicious clients and servers
+	Cooldown  int              `json:"cooldown"`  // seconds before the same host and fingerprint are reported again
+}
+
+// TLSFingerprint is a blocklisted fingerprint and what it belongs to
+type TLSFingerprint struct {
+	Fingerprint string `json:"fingerprint"` // JA3 or JA3S MD5, or JA4
+	Name        string `json:"name"`        // e.g. the tool or malware family
+}
+
+func DefaultTLSConfig() TLSConfig {
+	return TLSConfig{
+		Enabled:   true,
+		Ports:     []int{443, 465, 636, 853, 993, 995, 8443},
+		Blocklist: []TLSFingerprint{},
+		Cooldown:  300,
+	}
+}
+
+func (c TLSConfig) validate() error {
+	for _, port := range c.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	}
+	for _, entry := range c.Blocklist {
+		if entry.Fingerprint == "" || entry.Name == "" {
+			return fmt.Errorf("blocklist entries need a fingerprint and a name")
+		}
+	}
+	if c.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative")
+	}
+	return nil
+}
+
+// keptHandshake is the handshake of a connection waiting for its flow record
+type keptHandshake struct {
+	handshake model.TLSHandshake
+	kept      time.Time
+}
+
+// tlsThreats fingerprints the TLS hellos of connections to the ports, keeps
+// the handshakes for the flow records and reports blocklisted fingerprints
+type tlsThreats struct {
+	mu     sync.Mutex
+	config TLSConfig
+	emit   func(model.Detection)
+
+	blocklist  map[string]string          // names by lower case fingerprint
+	handshakes map[streamID]keptHandshake // by connection
+	reported   cooldown
+	lastSweep  time.Time
+}
+
+func newTLSThreats(config TLSConfig, emit func(model.Detection)) *tlsThreats {
+	blocklist := make(map[string]string)
+	for _, entry := range config.Blocklist {
+		blocklist[strings.ToLower(entry.Fingerprint)] = entry.Name
+	}
+	return &tlsThreats{
+		config:     config,
+		emit:       emit,
+		blocklist:  blocklist,
+		handshakes: make(map[streamID]keptHandshake),
+		reported:   make(cooldown),
+	}
+}
+
+// tlsConn fingerprints the hellos of one connection
+type tlsConn struct {
+	detector  *tlsThreats
+	records   [2]tlsRecords
+	handshake model.TLSHandshake
+	client    bool // ClientHello seen
+	done      bool // handshake kept
+}
+
+func (d *tlsThreats) newStream(id streamID, now time.Time) streamHandler {
+	if !slices.Contains(d.config.Ports, int(id.ServerPort)) {
+		return nil
+	}
+	return &tlsConn{
+		detector: d,
+		handshake: model.TLSHandshake{
+			Client:     id.Client,
+			ClientPort: id.ClientPort,
+			Server:     id.Server,
+			ServerPort: id.ServerPort,
+		},
+	}
+}
+
+func (c *tlsConn) data(dir direction, data []byte, now time.Time) {
+	kind, body, ok := c.records[dir].feed(data)
+	if !ok {
+		return
+	}
+
+	h := &c.handshake
+	switch {
+	case dir == toServer && kind == tlsClientHello:
+		hello, err := parseClientHello(body)
+		if err != nil {
+			packetLog.Debug("undecodable TLS ClientHello", "source", h.Client, "error", err)
+			return
+		}
+		h.SNI = hello.sni
+		h.ALPN = hello.alpn
+		h.Version = tlsVersionName(hello.negotiated())
+		h.JA3String, h.JA3 = hello.ja3()
+		h.JA4 = hello.ja4()
+		c.client = true
+		c.detector.clientHello(h, now)
+
+	case dir == toClient && kind == tlsServerHello && c.client:
+		hello, err := parseServerHello(body)
+		if err != nil {
+			packetLog.Debug("undecodable TLS ServerHello", "source", h.Server, "error", err)
+			return
+		}
+		h.Version = tlsVersionName(hello.negotiated())
+		h.Cipher = fmt.Sprintf("0x%04x", hello.ciphers[0])
+		h.JA3SString, h.JA3S = hello.ja3s()
+		c.detector.serverHello(h, now)
+		c.keep(now)
+	}
+}
+
+func (c *tlsConn) gap(dir direction, n int) {
+	c.records[dir].fail()
+}
+
+func (c *tlsConn) close(now time.Time) {
+	// Keep what the client offered even if the server never answered
+	if c.client {
+		c.keep(now)
+	}
+}
+
+func (c *tlsConn) keep(now time.Time) {
+	if c.done {
+		return
+	}
+	c.done = true
+
+	d := c.detector
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sweep(now)
+	h := c.handshake
+	id := streamID{Client: h.Client, ClientPort: h.ClientPort, Server: h.Server, ServerPort: h.ServerPort}
+	if _, ok := d.handshakes[id]; !ok && len(d.handshakes) >= maxHandshakes {
+		return
+	}
+	d.handshakes[id] = keptHandshake{handshake: h, kept: now}
+}
+
+// clientHello reports a client whose JA3 or JA4 is blocklisted
+func (d *tlsThreats) clientHello(h *model.TLSHandshake, now time.Time) {
+	fingerprint, kind := h.JA3, "ja3"
+	name, ok := d.blocklist[h.JA3]
+	if !ok {
+		fingerprint, kind = h.JA4, "ja4"
+		if name, ok = d.blocklist[h.JA4]; !ok {
+			return
+		}
+	}
+	h.Blocklisted = name
+
+	evidence := map[string]any{
+		"name":        name,
+		"fingerprint": fingerprint,
+		"match":       kind,
+		"ja3":         h.JA3,
+		"ja3_string":  h.JA3String,
+		"ja4":         h.JA4,
+		"version":     h.Version,
+	}
+	if h.SNI != "" {
+		evidence["sni"] = h.SNI
+	}
+	if len(h.ALPN) > 0 {
+		evidence["alpn"] = h.ALPN
+	}
+	d.report(h.Client+"|"+fingerprint, now, model.Detection{
+		Protocol:    "TCP",
+		AttackerIP:  h.Client,
+		TargetIP:    h.Server,
+		TargetPort:  strconv.FormatUint(h.ServerPort, 10),
+		Message:     fmt.Sprintf("TLS client fingerprint of %s from %s to %s", name, h.Client, serverName(h)),
+		SignatureID: SigTLSClientFingerprint,
+		Severity:    model.SeverityHigh,
+		Evidence:    evidence,
+	})
+}
+
+// serverHello reports a server whose JA3S is blocklisted
+func (d *tlsThreats) serverHello(h *model.TLSHandshake, now time.Time) {
+	name, ok := d.blocklist[h.JA3S]
+	if !ok {
+		return
+	}
+	if h.Blocklisted == "" {
+		h.Blocklisted = name
+	}
+
+	evidence := map[string]any{
+		"name":        name,
+		"fingerprint": h.JA3S,
+		"match":       "ja3s",
+		"ja3s_string": h.JA3SString,
+		"ja3":         h.JA3,
+		"ja4":         h.JA4,
+		"version":     h.Version,
+		"cipher":      h.Cipher,
+		"client":      h.Client,
+	}
+	if h.SNI != "" {
+		evidence["sni"] = h.SNI
+	}
+	d.report(h.Server+"|"+h.JA3S, now, model.Detection{
+		Protocol:    "TCP",
+		AttackerIP:  h.Server,
+		TargetIP:    h.Client,
+		TargetPort:  strconv.FormatUint(h.ServerPort, 10),
+		Message:     fmt.Sprintf("TLS server fingerprint of %s on %s answering %s", name, serverName(h), h.Client),
+		SignatureID: SigTLSServerFingerprint,
+		Severity:    model.SeverityHigh,
+		Evidence:    evidence,
+	})
+}
+
+// serverName is the server with the name the client asked for, if any
+func serverName(h *model.TLSHandshake) string {
+	if h.SNI == "" {
+		return h.Server
+	}
+	return fmt.Sprintf("%s (%s)", h.Server, h.SNI)
+}
+
+func (d *tlsThreats) report(key string, now time.Time, alert model.Detection) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sweep(now)
+	if d.reported.ready(key, now, seconds(d.config.Cooldown)) {
+		d.emit(alert)
+	}
+}
+
+// take removes and returns the handshake of the connection id, whose first
+// packet may also have come from the server
+func (d *tlsThreats) take(id streamID) (model.TLSHandshake, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	reversed := streamID{Client: id.Server, ClientPort: id.ServerPort, Server: id.Client, ServerPort: id.ClientPort}
+	for _, key := range []streamID{id, reversed} {
+		if kept, ok := d.handshakes[key]; ok {
+			delete(d.handshakes, key)
+			return kept.handshake, true
+		}
+	}
+	return model.TLSHandshake{}, false
+}
+
+// sweep must be called with d.mu held
+func (d *tlsThreats) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < sweepInterval {
+		return
+	}
+	d.lastSweep = now
+
+	for id, kept := range d.handshakes {
+		if now.Sub(kept.kept) > handshakeTTL {
+			delete(d.handshakes, id)
+		}
+	}
+	d.reported.prune(now)
+}
@@ -0,0 +1,367 @@
+package native
+
+import (
+	"fmt"
+	"html"
+	"main/model"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Web attack signatures
+const (
+	SigSQLInjection     = GID + ":1301"
+	SigXSS              = GID + ":1302"
+	SigPathTraversal    = GID + ":1303"
+	SigCommandInjection = GID + ":1304"
+)
+
+// evidenceValue is how many characters of the offending value a detection carries
+const evidenceValue = 200
+
+type HTTPConfig struct {
+	Enabled          bool  `json:"enabled"`
+	Ports            []int `json:"ports"`             // server ports carrying HTTP
+	MaxBody          int   `json:"max_body"`          // bytes of a request body inspected
+	SQLInjection     bool  `json:"sql_injection"`     // SQL injection patterns
+	XSS              bool  `json:"xss"`               // cross-site scripting patterns
+	PathTraversal    bool  `json:"path_traversal"`    // path traversal patterns
+	CommandInjection bool  `json:"command_injection"` // shell command injection patterns
+	Cooldown         int   `json:"cooldown"`          // seconds before the same attack on the same parameter is reported again
+}
+
+func DefaultHTTPConfig() HTTPConfig {
+	return HTTPConfig{
+		Enabled:          true,
+		Ports:            []int{80, 8000, 8080, 8888},
+		MaxBody:          64 * 1024,
+		SQLInjection:     true,
+		XSS:              true,
+		PathTraversal:    true,
+		CommandInjection: true,
+		Cooldown:         60,
+	}
+}
+
+func (c HTTPConfig) validate() error {
+	for _, port := range c.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	}
+	if c.MaxBody < 0 {
+		return fmt.Errorf("max_body must not be negative")
+	}
+	if c.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative")
+	}
+	return nil
+}
+
+// webRule is one pattern of an attack class, matched against normalized values
+type webRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+type webAttack struct {
+	signature string
+	name      string
+	severity  int
+	rules     []webRule
+	enabled   func(HTTPConfig) bool
+}
+
+var webAttacks = []webAttack{
+	{
+		signature: SigSQLInjection,
+		name:      "SQL injection",
+		severity:  model.SeverityHigh,
+		enabled:   func(c HTTPConfig) bool { return c.SQLInjection },
+		rules: []webRule{
+			{"union_select", regexp.MustCompile(`\bunion\b[\s(]+(all\s+|distinct\s+)?select\b`)},
+			{"tautology", regexp.MustCompile(`['"]\s*(or|and|\|\||&&)\s+['"]?\w+['"]?\s*(=|<|>|like\b)\s*['"]?\w+`)},
+			{"numeric_tautology", regexp.MustCompile(`\b(or|and)\s+(\d+)\s*=\s*(\d+)\b`)},
+			{"comment_terminator", regexp.MustCompile(`['"]\s*(--|#|;\s*--)`)},
+			{"stacked_query", regexp.MustCompile(`;\s*(drop|delete|insert|update|create|alter|truncate|exec|execute|shutdown)\s`)},
+			{"time_based", regexp.MustCompile(`\b(sleep|benchmark|pg_sleep)\s*\(|\bwaitfor\s+delay\s+'`)},
+			{"schema_probe", regexp.MustCompile(`\b(information_schema|sysobjects|syscolumns|pg_catalog|sqlite_master)\b`)},
+			{"file_access", regexp.MustCompile(`\b(load_file\s*\(|into\s+(out|dump)file\b|xp_cmdshell\b)`)},
+		},
+	},
+	{
+		signature: SigXSS,
+		name:      "Cross-site scripting",
+		severity:  model.SeverityMedium,
+		enabled:   func(c HTTPConfig) bool { return c.XSS },
+		rules: []webRule{
+			{"script_tag", regexp.MustCompile(`<\s*/?\s*script\b`)},
+			{"event_handler", regexp.MustCompile(`<[^>]*\bon[a-z]{3,}\s*=`)},
+			{"javascript_uri", regexp.MustCompile(`(javascript|vbscript)\s*:`)},
+			{"dangerous_tag", regexp.MustCompile(`<\s*(iframe|object|embed|applet|meta|base|svg)\b`)},
+			{"dom_access", regexp.MustCompile(`\bdocument\s*\.\s*(cookie|location|write|domain)\b|\bwindow\s*\.\s*location\b|\b(alert|prompt|confirm|eval)\s*\(`)},
+		},
+	},
+	{
+		signature: SigPathTraversal,
+		name:      "Path traversal",
+		severity:  model.SeverityHigh,
+		enabled:   func(c HTTPConfig) bool { return c.PathTraversal },
+		rules: []webRule{
+			{"dot_dot", regexp.MustCompile(`(^|[/\\=])\.\.[/\\]`)},
+			{"sensitive_file", regexp.MustCompile(`/etc/(passwd|shadow|group|hosts)\b|/proc/self/|\b(boot|win|system)\.ini\b|\bweb\.config\b`)},
+			{"null_byte", regexp.MustCompile(`\x00`)},
+		},
+	},
+	{
+		signature: SigCommandInjection,
+		name:      "Command injection",
+		severity:  model.SeverityHigh,
+		enabled:   func(c HTTPConfig) bool { return c.CommandInjection },
+		rules: []webRule{
+			{"shell_chain", regexp.MustCompile(`(;|\||&&|\n)\s*(cat|id|whoami|uname|ls|wget|curl|nc|ncat|bash|sh|zsh|python3?|perl|php|ruby|ping|nslookup|rm|chmod|echo)\b`)},
+			{"substitution", regexp.MustCompile("\\$\\(\\s*[a-z]+|`\\s*[a-z]+[^`]*`")},
+			{"ifs_evasion", regexp.MustCompile(`\$\{?ifs\}?`)},
+			{"shellshock", regexp.MustCompile(`\(\)\s*\{\s*:?\s*;\s*\}`)},
+		},
+	},
+}
+
+// sqlComment and whitespace are folded by normalize, e.g. "UNION/**/SELECT"
+var (
+	sqlComment = regexp.MustCompile(`/\*.*?\*/`)
+	whitespace = regexp.MustCompile(`\s+`)
+)
+
+// webParam is one value of a request an attack can hide in
+type webParam struct {
+	location string // "path", "query", "body", "cookie" or "header"
+	name     string
+	value    string
+}
+
+// webAttackDetector decodes the HTTP requests of the reassembled connections
+// to the configured ports and matches their parameters against the attack patterns
+type webAttackDetector struct {
+	mu     sync.Mutex
+	config HTTPConfig
+	emit   func(model.Detection)
+
+	reported  cooldown
+	lastSweep time.Time
+}
+
+// httpStream follows the requests of one HTTP connection, responses are ignored
+type httpStream struct {
+	detector *webAttackDetector
+	id       streamID
+	parser   httpParser
+}
+
+func newWebAttackDetector(config HTTPConfig, emit func(model.Detection)) *webAttackDetector {
+	return &webAttackDetector{
+		config:   config,
+		emit:     emit,
+		reported: make(cooldown),
+	}
+}
+
+func (w *webAttackDetector) newStream(id streamID, now time.Time) streamHandler {
+	if !slices.Contains(w.config.Ports, int(id.ServerPort)) {
+		return nil
+	}
+	return &httpStream{detector: w, id: id, parser: httpParser{maxBody: w.config.MaxBody}}
+}
+
+func (s *httpStream) data(dir direction, data []byte, now time.Time) {
+	if dir != toServer {
+		return
+	}
+	requests := s.parser.feed(data)
+	if len(requests) == 0 {
+		return
+	}
+
+	w := s.detector
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.sweep(now)
+	for _, request := range requests {
+		w.inspect(request, s.id.Client, s.id.Server, strconv.FormatUint(s.id.ServerPort, 10), now)
+	}
+}
+
+func (s *httpStream) gap(dir direction, n int) {
+	if dir == toServer {
+		s.parser.gap()
+	}
+}
+
+func (s *httpStream) close(now time.Time) {}
+
+// inspect reports the first attack found in each parameter of a request
+func (w *webAttackDetector) inspect(r *httpRequest, src, dst, port string, now time.Time) {
+	for _, param := range requestParams(r) {
+		value := normalize(param.value)
+		for _, attack := range webAttacks {
+			if !attack.enabled(w.config) {
+				continue
+			}
+			rule, ok := attack.match(value)
+			if !ok {
+				continue
+			}
+
+			key := strings.Join([]string{src, attack.signature, r.Path, param.location, param.name}, "|")
+			if w.reported.ready(key, now, seconds(w.config.Cooldown)) {
+				w.emit(model.Detection{
+					Protocol:    "TCP",
+					AttackerIP:  src,
+					TargetIP:    dst,
+					TargetPort:  port,
+					Message:     fmt.Sprintf("%s in %s %s of %s %s", attack.name, param.location, param.name, r.Method, r.Path),
+					SignatureID: attack.signature,
+					Severity:    attack.severity,
+					Evidence: map[string]any{
+						"method":    r.Method,
+						"uri":       truncate(r.URI, evidenceValue),
+						"host":      r.Host,
+						"location":  param.location,
+						"parameter": param.name,
+						"value":     truncate(param.value, evidenceValue),
+						"rule":      rule,
+					},
+				})
+			}
+			break
+		}
+	}
+}
+
+func (a webAttack) match(value string) (string, bool) {
+	for _, rule := range a.rules {
+		if rule.pattern.MatchString(value) {
+			return rule.name, true
+		}
+	}
+	return "", false
+}
+
+// requestParams lists the values of a request an attack can hide in: the path,
+// the query and form parameters, the other bodies whole, cookies and the
+// headers that applications commonly log or echo
+func requestParams(r *httpRequest) []webParam {
+	params := []webParam{{location: "path", name: "path", value: r.Path}}
+	params = append(params, splitParams("query", r.Query, "&")...)
+
+	if len(r.Body) > 0 {
+		contentType := strings.ToLower(r.Header.Get("Content-Type"))
+		if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+			params = append(params, splitParams("body", string(r.Body), "&")...)
+		} else if !strings.HasPrefix(contentType, "multipart/") || len(r.Body) <= evidenceValue*10 {
+			params = append(params, webParam{location: "body", name: "body", value: string(r.Body)})
+		}
+	}
+
+	for _, cookie := range r.Header.Values("Cookie") {
+		params = append(params, splitParams("cookie", cookie, ";")...)
+	}
+	for _, header := range []string{"User-Agent", "Referer", "X-Forwarded-For"} {
+		if value := r.Header.Get(header); value != "" {
+			params = append(params, webParam{location: "header", name: header, value: value})
+		}
+	}
+	return params
+}
+
+// splitParams splits "a=1&b=2" style data into parameters, undecoded
+func splitParams(location, data, separator string) []webParam {
+	var params []webParam
+	for _, pair := range strings.Split(data, separator) {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, found := strings.Cut(pair, "=")
+		if !found {
+			// A bare value, e.g. "?<script>"
+			name, value = "", pair
+		}
+		params = append(params, webParam{location: location, name: percentDecode(name, false), value: value})
+	}
+	return params
+}
+
+// normalize undoes the encodings attacks hide behind: URL encoding (repeated,
+// for double encoding), HTML entities, case, SQL comments and whitespace runs
+func normalize(value string) string {
+	for i := 0; i < 3; i++ {
+		decoded := percentDecode(value, true)
+		if decoded == value {
+			break
+		}
+		value = decoded
+	}
+	value = html.UnescapeString(value)
+	value = strings.ToLower(value)
+	value = sqlComment.ReplaceAllString(value, " ")
+	return whitespace.ReplaceAllString(value, " ")
+}
+
+// percentDecode decodes %XX and, in query values, "+" as a space. Unlike
+// url.QueryUnescape it keeps invalid escapes instead of failing.
+func percentDecode(s string, plus bool) string {
+	if !strings.ContainsAny(s, "%+") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case s[i] == '+' && plus:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n] + "..."
+	}
+	return s
+}
+
+// sweep must be called with w.mu held
+func (w *webAttackDetector) sweep(now time.Time) {
+	if now.Sub(w.lastSweep) < sweepInterval {
+		return
+	}
+	w.lastSweep = now
+
+	w.reported.prune(now)
+}
@@ -0,0 +1,163 @@
+package native
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// recent remembers when each distinct value was last seen
+type recent map[string]time.Time
+
+// add records v and reports whether it was not seen within window before
+func (r recent) add(v string, now time.Time, window time.Duration) bool {
+	last, ok := r[v]
+	r[v] = now
+	return !ok || now.Sub(last) > window
+}
+
+// count returns how many distinct values were seen within window
+func (r recent) count(now time.Time, window time.Duration) int {
+	n := 0
+	for _, seen := range r {
+		if now.Sub(seen) <= window {
+			n++
+		}
+	}
+	return n
+}
+
+// values returns the distinct values seen within window, sorted
+func (r recent) values(now time.Time, window time.Duration) []string {
+	values := make([]string, 0, len(r))
+	for v, seen := range r {
+		if now.Sub(seen) <= window {
+			values = append(values, v)
+		}
+	}
+	slices.Sort(values)
+	return values
+}
+
+// prune forgets values older than retention
+func (r recent) prune(now time.Time, retention time.Duration) {
+	for v, seen := range r {
+		if now.Sub(seen) > retention {
+			delete(r, v)
+		}
+	}
+}
+
+// recentSets keeps one recent set per key, e.g. the ports per source and target
+type recentSets map[string]recent
+
+func (s recentSets) get(key string) recent {
+	r, ok := s[key]
+	if !ok {
+		r = make(recent)
+		s[key] = r
+	}
+	return r
+}
+
+func (s recentSets) prune(now time.Time, retention time.Duration) {
+	for key, r := range s {
+		r.prune(now, retention)
+		if len(r) == 0 {
+			delete(s, key)
+		}
+	}
+}
+
+// cooldown limits how often the same finding is reported
+type cooldown map[string]time.Time
+
+// ready reports whether key may be reported now and, if so, starts its cooldown
+func (c cooldown) ready(key string, now time.Time, period time.Duration) bool {
+	if until, ok := c[key]; ok && now.Before(until) {
+		return false
+	}
+	c[key] = now.Add(period)
+	return true
+}
+
+func (c cooldown) prune(now time.Time) {
+	for key, until := range c {
+		if now.After(until) {
+			delete(c, key)
+		}
+	}
+}
+
+func seconds(s int) time.Duration {
+	return time.Duration(s) * time.Second
+}
+
+// tally counts the events and bytes of one key in a tumbling window, with the
+// peers involved, e.g. the responses a host got and who sent them
+type tally struct {
+	start time.Time
+	count int
+	bytes int
+	peers map[string]int
+}
+
+func (t *tally) add(peer string, bytes int) {
+	t.count++
+	t.bytes += bytes
+	if _, ok := t.peers[peer]; ok || len(t.peers) < maxPeers {
+		t.peers[peer]++
+	}
+}
+
+// addPeer counts one event of peer and reports whether it is a new peer
+func (t *tally) addPeer(peer string) bool {
+	n := len(t.peers)
+	t.add(peer, 0)
+	return len(t.peers) > n
+}
+
+// tallies keeps one tally per key
+type tallies map[string]*tally
+
+// get returns the tally of key, starting a new window when the last one is over
+func (m tallies) get(key string, now time.Time, window time.Duration) *tally {
+	t, ok := m[key]
+	if !ok || now.Sub(t.start) >= window {
+		t = &tally{start: now, peers: make(map[string]int)}
+		m[key] = t
+	}
+	return t
+}
+
+// bounded returns the tally of key like get, or nil when key is new and max
+// keys are kept already
+func (m tallies) bounded(key string, now time.Time, window time.Duration, max int) *tally {
+	if _, ok := m[key]; !ok && len(m) >= max {
+		return nil
+	}
+	return m.get(key, now, window)
+}
+
+func (m tallies) prune(now time.Time, window time.Duration) {
+	for key, t := range m {
+		if now.Sub(t.start) >= window {
+			delete(m, key)
+		}
+	}
+}
+
+// topKeys returns the n keys with the highest counts
+func topKeys(counts map[string]int, n int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+	return limit(keys, n)
+}
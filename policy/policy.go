@@ -0,0 +1,308 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"main/model"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// Response actions a policy chain can contain
+const (
+	ActionLog        = "log"         // print the detection
+	ActionAlert      = "alert"       // raise it in the GUI as part of an incident
+	ActionRateLimit  = "rate_limit"  // drop the attacker's packets above Rate
+	ActionConnLimit  = "conn_limit"  // reset the attacker's TCP connections above MaxConns
+	ActionTarpit     = "tarpit"      // hold the attacker's TCP connections open with a zero window
+	ActionReject     = "reject"      // refuse the attacker with RejectWith instead of dropping
+	ActionSYNLimit   = "syn_limit"   // drop the attacker's new TCP connections above Rate
+	ActionSYNCookies = "syn_cookies" // turn on the kernel's SYN cookies, for floods from spoofed sources
+	ActionTempBlock  = "temp_block"  // block for TTL seconds
+	ActionBlock      = "block"       // block until unblocked manually
+	ActionHook       = "hook"        // run an external command
+)
+
+// Scopes of blocking and throttling actions, taken from the triggering detection
+const (
+	ScopeAddress  = "address"  // all traffic of the attacker
+	ScopeProtocol = "protocol" // only the detection's protocol
+	ScopePort     = "port"     // only the detection's protocol and target port
+)
+
+type Action struct {
+	Type       string   `json:"type"`
+	TTL        int      `json:"ttl,omitempty"`         // seconds, required for temp_block, optional for throttles and syn_cookies
+	Rate       string   `json:"rate,omitempty"`        // e.g. "10/second", rate_limit and syn_limit only
+	Burst      int      `json:"burst,omitempty"`       // rate_limit and syn_limit only
+	MaxConns   int      `json:"max_conns,omitempty"`   // conn_limit only
+	RejectWith string   `json:"reject_with,omitempty"` // reject only, e.g. "tcp-reset"
+	Command    []string `json:"command,omitempty"`     // hook only
+	Scope      string   `json:"scope,omitempty"`       // "address" (default), "protocol" or "port"
+	Direction  string   `json:"direction,omitempty"`   // "both" (default), "in" or "out"
+}
+
+// Policy maps detections matching all of its non-empty fields onto a response chain
+type Policy struct {
+	ID          string   `json:"id"`
+	Detector    string   `json:"detector,omitempty"` // "snort", "own", "unswb" or "native"
+	SignatureID string   `json:"signature_id,omitempty"`
+	Message     string   `json:"message,omitempty"` // regular expression on the detection message
+	MinSeverity int      `json:"min_severity,omitempty"`
+	Actions     []Action `json:"actions"`
+}
+
+// Config holds the ordered policies, the first match wins
+type Config struct {
+	Policies []Policy `json:"policies"`
+	Default  []Action `json:"default"`
+}
+
+// Result is the response chain selected for one detection
+type Result struct {
+	Detection model.Detection `json:"detection"`
+	PolicyID  string          `json:"policy_id"` // empty when the default chain applied
+	Actions   []Action        `json:"actions"`
+}
+
+type compiledPolicy struct {
+	Policy
+	message *regexp.Regexp
+}
+
+type Engine struct {
+	mu       sync.Mutex
+	path     string
+	config   Config
+	policies []compiledPolicy
+}
+
+// DefaultConfig reproduces the hardcoded decisions of listenAttack: Snort and
+// own AI detections block, UNSW detections only alert. The blocks are scoped to
+// the attacked port, so a flood against one service doesn't cut the others.
+func DefaultConfig() Config {
+	return Config{
+		Policies: []Policy{
+			{ID: "snort", Detector: "snort", Actions: []Action{{Type: ActionAlert}, {Type: ActionBlock, Scope: ScopePort}}},
+			{ID: "own", Detector: "own", Actions: []Action{{Type: ActionAlert}, {Type: ActionBlock, Scope: ScopePort}}},
+			{ID: "unswb", Detector: "unswb", Actions: []Action{{Type: ActionAlert}}},
+		},
+		Default: []Action{{Type: ActionAlert}},
+	}
+}
+
+// Load reads the policy config from path, creating it with defaults if it doesn't exist
+func Load(path string) (*Engine, error) {
+	e := &Engine{path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := e.SetConfig(DefaultConfig()); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse policy config: %w", err)
+	}
+
+	policies, err := compile(config)
+	if err != nil {
+		return nil, err
+	}
+	e.config = config
+	e.policies = policies
+	return e, nil
+}
+
+// Config returns a copy of the active configuration
+func (e *Engine) Config() Config {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return Config{
+		Policies: append([]Policy{}, e.config.Policies...),
+		Default:  append([]Action{}, e.config.Default...),
+	}
+}
+
+// SetConfig validates and activates config, then persists it
+func (e *Engine) SetConfig(config Config) error {
+	policies, err := compile(config)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.config = config
+	e.policies = policies
+	return e.save()
+}
+
+// Evaluate selects the response chain for a detection
+func (e *Engine) Evaluate(d model.Detection) Result {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return evaluate(e.policies, e.config.Default, d)
+}
+
+// DryRun evaluates config (or the active config when nil) against detections
+// without executing anything
+func (e *Engine) DryRun(config *Config, detections []model.Detection) ([]Result, error) {
+	e.mu.Lock()
+	policies, defaults := e.policies, e.config.Default
+	e.mu.Unlock()
+
+	if config != nil {
+		var err error
+		if policies, err = compile(*config); err != nil {
+			return nil, err
+		}
+		defaults = config.Default
+	}
+
+	results := make([]Result, 0, len(detections))
+	for _, d := range detections {
+		results = append(results, evaluate(policies, defaults, d))
+	}
+	return results, nil
+}
+
+// IsBlocking reports whether an action cuts the attacker off completely
+func (a Action) IsBlocking() bool {
+	return a.Type == ActionBlock || a.Type == ActionTempBlock
+}
+
+// IsThrottling reports whether an action restricts the attacker without blocking
+func (a Action) IsThrottling() bool {
+	switch a.Type {
+	case ActionRateLimit, ActionConnLimit, ActionTarpit, ActionReject, ActionSYNLimit:
+		return true
+	}
+	return false
+}
+
+// Has reports whether a chain contains an action of the given type
+func Has(actions []Action, actionType string) bool {
+	for _, a := range actions {
+		if a.Type == actionType {
+			return true
+		}
+	}
+	return false
+}
+
+func evaluate(policies []compiledPolicy, defaults []Action, d model.Detection) Result {
+	for _, p := range policies {
+		if p.matches(d) {
+			return Result{Detection: d, PolicyID: p.ID, Actions: p.Actions}
+		}
+	}
+	return Result{Detection: d, Actions: defaults}
+}
+
+func (p compiledPolicy) matches(d model.Detection) bool {
+	switch {
+	case p.Detector != "" && p.Detector != d.Detector():
+		return false
+	case !d.MatchesSignature(p.SignatureID):
+		return false
+	case p.message != nil && !p.message.MatchString(d.Message):
+		return false
+	case d.Severity < p.MinSeverity:
+		return false
+	}
+	return true
+}
+
+func compile(config Config) ([]compiledPolicy, error) {
+	if err := validateActions("default", config.Default); err != nil {
+		return nil, err
+	}
+
+	var policies []compiledPolicy
+	for _, p := range config.Policies {
+		if p.ID == "" {
+			return nil, fmt.Errorf("policy without id")
+		}
+		if err := validateActions(p.ID, p.Actions); err != nil {
+			return nil, err
+		}
+
+		compiled := compiledPolicy{Policy: p}
+		if p.Message != "" {
+			var err error
+			if compiled.message, err = regexp.Compile(p.Message); err != nil {
+				return nil, fmt.Errorf("policy %q: invalid message pattern: %w", p.ID, err)
+			}
+		}
+		policies = append(policies, compiled)
+	}
+	return policies, nil
+}
+
+func validateActions(id string, actions []Action) error {
+	for _, a := range actions {
+		switch a.Scope {
+		case "", ScopeAddress, ScopeProtocol, ScopePort:
+		default:
+			return fmt.Errorf("policy %q: unknown scope %q", id, a.Scope)
+		}
+		switch a.Direction {
+		case "", "both", "in", "out":
+		default:
+			return fmt.Errorf("policy %q: unknown direction %q", id, a.Direction)
+		}
+
+		switch a.Type {
+		case ActionLog, ActionAlert, ActionBlock, ActionTarpit, ActionReject, ActionSYNCookies:
+		case ActionTempBlock:
+			if a.TTL <= 0 {
+				return fmt.Errorf("policy %q: temp_block needs a positive ttl", id)
+			}
+		case ActionRateLimit, ActionSYNLimit:
+			if a.Rate == "" {
+				return fmt.Errorf("policy %q: %s needs a rate", id, a.Type)
+			}
+		case ActionConnLimit:
+			if a.MaxConns <= 0 {
+				return fmt.Errorf("policy %q: conn_limit needs a positive max_conns", id)
+			}
+		case ActionHook:
+			if len(a.Command) == 0 {
+				return fmt.Errorf("policy %q: hook needs a command", id)
+			}
+		default:
+			return fmt.Errorf("policy %q: unknown action %q", id, a.Type)
+		}
+	}
+	return nil
+}
+
+func (e *Engine) save() error {
+	if e.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(e.config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(e.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write policy config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package policy
+
+import (
+	"main/model"
+	"testing"
+)
+
+func detection(method, signatureID, message string, severity int) model.Detection {
+	return model.Detection{
+		Method:      method,
+		Protocol:    "TCP",
+		AttackerIP:  "192.0.2.7",
+		TargetIP:    "10.0.0.1",
+		TargetPort:  "22",
+		Message:     message,
+		SignatureID: signatureID,
+		Severity:    severity,
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	config := Config{
+		Policies: []Policy{
+			{ID: "critical", MinSeverity: model.SeverityCritical, Actions: []Action{{Type: ActionBlock}}},
+			{ID: "ssh", Detector: "native", SignatureID: "1301", Actions: []Action{{Type: ActionTempBlock, TTL: 600}}},
+			{ID: "sqli", Detector: "snort", Message: "(?i)sql injection", Actions: []Action{{Type: ActionAlert}, {Type: ActionBlock, Scope: ScopePort}}},
+			{ID: "scan", SignatureID: "9000:1001", Actions: []Action{{Type: ActionRateLimit, Rate: "10/second"}}},
+		},
+		Default: []Action{{Type: ActionLog}},
+	}
+
+	tests := []struct {
+		name   string
+		d      model.Detection
+		policy string
+	}{
+		{"severity before everything", detection(model.MethodRule, "1:1", "SQL injection", model.SeverityCritical), "critical"},
+		{"bare sid of the native gid", detection(model.MethodNative, "9000:1301", "SSH brute force", model.SeverityHigh), "ssh"},
+		{"detector must match too", detection(model.MethodRule, "1:1301", "SSH brute force", model.SeverityHigh), "default"},
+		{"message pattern", detection(model.MethodRule, "1:2", "SERVER-WEBAPP SQL Injection attempt", model.SeverityHigh), "sqli"},
+		{"message of another detector", detection(model.MethodAI, "", "SQL injection", model.SeverityHigh), "default"},
+		{"full signature", detection(model.MethodNative, "9000:1001", "port scan", model.SeverityMedium), "scan"},
+		{"full signature of another gid", detection(model.MethodRule, "1:1001", "port scan", model.SeverityMedium), "default"},
+		{"nothing matches", detection(model.MethodUNSW, "", "Exploits", model.SeverityLow), "default"},
+	}
+
+	e := &Engine{}
+	if err := e.SetConfig(DefaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+	detections := make([]model.Detection, 0, len(tests))
+	for _, tt := range tests {
+		detections = append(detections, tt.d)
+	}
+	results, err := e.DryRun(&config, detections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.policy
+			if want == "default" {
+				want = ""
+			}
+			if results[i].PolicyID != want {
+				t.Errorf("policy = %q, want %q", results[i].PolicyID, want)
+			}
+		})
+	}
+
+	// The dry run leaves the active config alone
+	if got := e.Evaluate(detections[0]).PolicyID; got != "snort" {
+		t.Errorf("active policy = %q after the dry run, want snort", got)
+	}
+}
+
+func TestDryRunActiveConfig(t *testing.T) {
+	e := &Engine{}
+	if err := e.SetConfig(DefaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := e.DryRun(nil, []model.Detection{
+		detection(model.MethodRule, "1:1", "probe", model.SeverityMedium),
+		detection(model.MethodUNSW, "", "Exploits", model.SeverityLow),
+		detection(model.MethodNative, "9000:1001", "port scan", model.SeverityMedium),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		policy   string
+		blocking bool
+	}{
+		{"snort", true},
+		{"unswb", false},
+		{"", false},
+	}
+	for i, w := range want {
+		if results[i].PolicyID != w.policy {
+			t.Errorf("result %d: policy = %q, want %q", i, results[i].PolicyID, w.policy)
+		}
+		if got := Has(results[i].Actions, ActionBlock); got != w.blocking {
+			t.Errorf("result %d: blocks = %v, want %v", i, got, w.blocking)
+		}
+	}
+}
+
+func TestDryRunInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"policy without id", Config{Policies: []Policy{{Actions: []Action{{Type: ActionAlert}}}}}},
+		{"bad message pattern", Config{Policies: []Policy{{ID: "p", Message: "(", Actions: []Action{{Type: ActionAlert}}}}}},
+		{"temp_block without ttl", Config{Policies: []Policy{{ID: "p", Actions: []Action{{Type: ActionTempBlock}}}}}},
+		{"rate_limit without rate", Config{Policies: []Policy{{ID: "p", Actions: []Action{{Type: ActionRateLimit}}}}}},
+		{"conn_limit without max_conns", Config{Policies: []Policy{{ID: "p", Actions: []Action{{Type: ActionConnLimit}}}}}},
+		{"hook without command", Config{Policies: []Policy{{ID: "p", Actions: []Action{{Type: ActionHook}}}}}},
+		{"unknown action", Config{Policies: []Policy{{ID: "p", Actions: []Action{{Type: "drop"}}}}}},
+		{"unknown scope", Config{Policies: []Policy{{ID: "p", Actions: []Action{{Type: ActionBlock, Scope: "subnet"}}}}}},
+		{"unknown direction", Config{Policies: []Policy{{ID: "p", Actions: []Action{{Type: ActionBlock, Direction: "up"}}}}}},
+		{"bad default", Config{Default: []Action{{Type: ActionTempBlock}}}},
+	}
+	e := &Engine{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := e.DryRun(&tt.config, nil); err == nil {
+				t.Error("config accepted")
+			}
+		})
+	}
+}
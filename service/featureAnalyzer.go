@@ -42,8 +42,10 @@ type FeatureAnalyzer struct {
 
 	forwardKey    string
 	timeoutSignal chan string
+	done          <-chan struct{}
 
 	port         string
+	sourcePort   string // of the first packet, with port the connection the flow started with
 	multiplePort bool
 }
 
@@ -60,14 +62,17 @@ func (f *FeatureAnalyzer) analyzerTimeoutChecks() {
 		f.subflowMutex.Unlock()
 		// check the timeout for the flow analysis
 		if time.Since(f.lastPacketTime) > 6*time.Second {
-			f.timeoutSignal <- f.forwardKey
-
-			break
+			// Nobody collects the flow once its analyzer is stopped
+			select {
+			case f.timeoutSignal <- f.forwardKey:
+			case <-f.done:
+			}
+			return
 		}
 	}
 }
 
-func GetFeatureAnalyzerInstance(packetAnalysis *model.PacketAnalysisTCP, forwardKey string, timeoutSignal chan string) *FeatureAnalyzer {
+func GetFeatureAnalyzerInstance(packetAnalysis *model.PacketAnalysisTCP, forwardKey string, timeoutSignal chan string, done <-chan struct{}) *FeatureAnalyzer {
 	tcpHeaderLen := packetAnalysis.TCP.HeaderLength
 
 	packetLength := uint64(len(packetAnalysis.TCP.Payload))
@@ -80,6 +85,7 @@ func GetFeatureAnalyzerInstance(packetAnalysis *model.PacketAnalysisTCP, forward
 		isSubflow:      false,
 		forwardKey:     forwardKey,
 		timeoutSignal:  timeoutSignal,
+		done:           done,
 
 		features: &model.FlowFeatures{
 			Protocol: uint64(packetAnalysis.IPv4.Protocol),
@@ -167,13 +173,14 @@ func GetFeatureAnalyzerInstance(packetAnalysis *model.PacketAnalysisTCP, forward
 	}
 
 	featureAnalyzer.port = fmt.Sprint(packetAnalysis.TCP.DestinationPort)
+	featureAnalyzer.sourcePort = fmt.Sprint(packetAnalysis.TCP.SourcePort)
 
 	go featureAnalyzer.analyzerTimeoutChecks()
 
 	return featureAnalyzer
 }
 
-func GetFeatureAnalyzerInstanceUDP(packetAnalysis *model.PacketAnalysisUDP, forwardKey string, timeoutSignal chan string) *FeatureAnalyzer {
+func GetFeatureAnalyzerInstanceUDP(packetAnalysis *model.PacketAnalysisUDP, forwardKey string, timeoutSignal chan string, done <-chan struct{}) *FeatureAnalyzer {
 	packetLength := uint64(len(packetAnalysis.UDP.Payload))
 
 	featureAnalyzer := &FeatureAnalyzer{
@@ -184,6 +191,7 @@ func GetFeatureAnalyzerInstanceUDP(packetAnalysis *model.PacketAnalysisUDP, forw
 		isSubflow:      false,
 		forwardKey:     forwardKey,
 		timeoutSignal:  timeoutSignal,
+		done:           done,
 
 		features: &model.FlowFeatures{
 			Protocol: uint64(packetAnalysis.IPv4.Protocol),
@@ -271,13 +279,14 @@ func GetFeatureAnalyzerInstanceUDP(packetAnalysis *model.PacketAnalysisUDP, forw
 	}
 
 	featureAnalyzer.port = fmt.Sprint(packetAnalysis.UDP.DestinationPort)
+	featureAnalyzer.sourcePort = fmt.Sprint(packetAnalysis.UDP.SourcePort)
 
 	go featureAnalyzer.analyzerTimeoutChecks()
 
 	return featureAnalyzer
 }
 
-func GetFeatureAnalyzerInstanceICMP(packetAnalysis *model.PacketAnalysisICMP, forwardKey string, timeoutSignal chan string) *FeatureAnalyzer {
+func GetFeatureAnalyzerInstanceICMP(packetAnalysis *model.PacketAnalysisICMP, forwardKey string, timeoutSignal chan string, done <-chan struct{}) *FeatureAnalyzer {
 	packetLength := uint64(len(packetAnalysis.ICMP.Payload))
 
 	featureAnalyzer := &FeatureAnalyzer{
@@ -288,6 +297,7 @@ func GetFeatureAnalyzerInstanceICMP(packetAnalysis *model.PacketAnalysisICMP, fo
 		isSubflow:      false,
 		forwardKey:     forwardKey,
 		timeoutSignal:  timeoutSignal,
+		done:           done,
 
 		features: &model.FlowFeatures{
 			Protocol: uint64(packetAnalysis.IPv4.Protocol),
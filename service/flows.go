@@ -0,0 +1,32 @@
+package service
+
+import (
+	"main/model"
+	"strings"
+)
+
+// OnFlowExpired is called with every flow evicted from a flow table, e.g. to log it
+var OnFlowExpired func(model.Flow)
+
+// expireFlow reports an evicted flow, keyed "source-destination"
+func expireFlow(protocol, key string, f *FeatureAnalyzer) {
+	if OnFlowExpired == nil || f == nil {
+		return
+	}
+
+	source, destination, _ := strings.Cut(key, "-")
+	f.mu.Lock()
+	flow := model.Flow{
+		Protocol:        protocol,
+		SourceIP:        source,
+		SourcePort:      f.sourcePort,
+		DestinationIP:   destination,
+		DestinationPort: f.port,
+		Start:           f.startTime,
+		End:             f.lastPacketTime,
+		Features:        *f.features,
+	}
+	f.mu.Unlock()
+
+	OnFlowExpired(flow)
+}
@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
+	"main/metrics"
 	"main/model"
 	"net"
 	"strings"
@@ -10,23 +12,26 @@ import (
 	"time"
 )
 
-var csvToggleIcmp = false
-
 type ICMP struct {
 	FeatureAnalyzer  map[string]*FeatureAnalyzer
 	timeoutSignal    chan string
+	done             <-chan struct{}
 	mutexLock        sync.Mutex
 	lastPredictionTS map[string]time.Time
 	alert            chan model.Detection
+	inspector        Inspector
 }
 
-func NewICMP(alert chan model.Detection) *ICMP {
+// NewICMP creates the ICMP analyzer, which runs until ctx is cancelled. inspector may be nil.
+func NewICMP(ctx context.Context, alert chan model.Detection, inspector Inspector) *ICMP {
 
 	icmp := &ICMP{
 		FeatureAnalyzer:  make(map[string]*FeatureAnalyzer),
 		timeoutSignal:    make(chan string),
+		done:             ctx.Done(),
 		lastPredictionTS: make(map[string]time.Time),
 		alert:            alert,
+		inspector:        inspector,
 	}
 
 	go icmp.FlowMapTimeout()
@@ -34,17 +39,9 @@ func NewICMP(alert chan model.Detection) *ICMP {
 	return icmp
 }
 
-func CsvToggleICMP() {
-	if csvToggleIcmp {
-		csvToggleIcmp = false
-	} else {
-		csvToggleIcmp = true
-	}
-}
-
 func (i *ICMP) AnalyzeICMP(payload []byte) {
 	if len(payload) < 20 { // Ensure packet is large enough for analysis
-		fmt.Println("[ERROR] Payload size is too small to analyze.")
+		packetLog.Warn("payload too small to analyze", "protocol", "icmp", "size", len(payload))
 		return
 	}
 
@@ -57,10 +54,17 @@ func (i *ICMP) AnalyzeICMP(payload []byte) {
 	case 4:
 		i.analyzeIPv4(payload, &packetAnalysis)
 	default:
-		fmt.Printf("[WARNING] Unsupported IP version %d\n", version)
+		packetLog.Warn("unsupported IP version", "protocol", "icmp", "version", version)
+		return
+	}
+	if packetAnalysis.IPv4 == nil || packetAnalysis.ICMP == nil {
 		return
 	}
 
+	if i.inspector != nil {
+		i.inspector.InspectICMP(&packetAnalysis)
+	}
+
 	forwardKey := fmt.Sprintf("%s-%s", packetAnalysis.IPv4.SourceIP, packetAnalysis.IPv4.DestinationIP)
 	backwardKey := fmt.Sprintf("%s-%s", packetAnalysis.IPv4.DestinationIP, packetAnalysis.IPv4.SourceIP)
 
@@ -82,7 +86,8 @@ func (i *ICMP) AnalyzeICMP(payload []byte) {
 		key = backwardKey
 		direction = "backward"
 	} else {
-		i.FeatureAnalyzer[forwardKey] = GetFeatureAnalyzerInstanceICMP(&packetAnalysis, forwardKey, i.timeoutSignal)
+		i.FeatureAnalyzer[forwardKey] = GetFeatureAnalyzerInstanceICMP(&packetAnalysis, forwardKey, i.timeoutSignal, i.done)
+		metrics.ActiveFlows.Add(1, "icmp")
 		return
 	}
 
@@ -105,9 +110,9 @@ func (i *ICMP) AnalyzeICMP(payload []byte) {
 }
 
 func (i *ICMP) PredictAndAlert(dataString []string, key string){ 
-	pred, err := getPrediction(dataString)
+	pred, err := getPrediction("icmp", dataString)
 	if err != nil {
-		fmt.Println("Error getting prediction:", err)
+		logger.Error("prediction failed", "protocol", "icmp", "flow", key, "error", err)
 	}
 
 	splitted := strings.Split(key, "-")
@@ -117,11 +122,13 @@ func (i *ICMP) PredictAndAlert(dataString []string, key string){
 
 	if count > 5 {
 		attack_alert := model.Detection{
-			Method:      "AI Detection",
+			Method:      model.MethodAI,
 			Protocol:    "ICMP",
 			AttackerIP: attackerIp,
+			TargetIP:    splitted[1],
 			TargetPort: "",
 			Message:     "DDOS Attack Detected",
+			Severity:    model.SeverityMedium,
 		}
 
 		i.alert <- attack_alert
@@ -133,13 +140,19 @@ func (i *ICMP) FlowMapTimeout() {
 	var key string
 	for {
 		select {
+		case <-i.done:
+			// The flows still being analyzed are dropped with the analyzer
+			i.mutexLock.Lock()
+			metrics.ActiveFlows.Add(-float64(len(i.FeatureAnalyzer)), "icmp")
+			i.mutexLock.Unlock()
+			return
 		case key = <-i.timeoutSignal:
 			i.mutexLock.Lock()
 
-			if csvToggleIcmp {
+			if CSVCapturing("icmp") {
 				err := WriteToCSV("icmp", i.FeatureAnalyzer[key])
 				if err != nil {
-					fmt.Println("Error writing to CSV file: ", err)
+					logger.Error("failed to write flow to CSV", "protocol", "icmp", "error", err)
 				}
 			}
 
@@ -147,7 +160,10 @@ func (i *ICMP) FlowMapTimeout() {
 
 			i.PredictAndAlert(dataString, key)
 
+			expireFlow("icmp", key, i.FeatureAnalyzer[key])
 			delete(i.FeatureAnalyzer, key)
+			metrics.ActiveFlows.Add(-1, "icmp")
+			metrics.FlowEvictions.Inc("icmp")
 			i.mutexLock.Unlock()
 		case <-time.After(10 * time.Second): // Prevent blocking forever
 			// PASS
@@ -158,7 +174,7 @@ func (i *ICMP) FlowMapTimeout() {
 func (i *ICMP) analyzeIPv4(payload []byte, packetAnalysis *model.PacketAnalysisICMP) {
 	ihl := int((payload[0] & 0x0F) * 4)
 	if len(payload) < ihl+4 {
-		fmt.Println("[ERROR] Invalid IPv4 header length")
+		packetLog.Warn("invalid IPv4 header length", "protocol", "icmp")
 		return
 	}
 
@@ -174,7 +190,7 @@ func (i *ICMP) analyzeIPv4(payload []byte, packetAnalysis *model.PacketAnalysisI
 
 func (i *ICMP) analyzeHeader(payload []byte, packetAnalysis *model.PacketAnalysisICMP) {
 	if len(payload) < 4 { // Ensure there is enough data for the ICMP header
-		fmt.Println("[ERROR] Invalid ICMP header length")
+		packetLog.Warn("invalid ICMP header length", "protocol", "icmp")
 		return
 	}
 
@@ -187,4 +203,23 @@ func (i *ICMP) analyzeHeader(payload []byte, packetAnalysis *model.PacketAnalysi
 	if packetAnalysis.ICMP.Type == 8 || packetAnalysis.ICMP.Type == 0 {
 		packetAnalysis.ICMP.Payload = payload[4:] // ICMP data starts after header
 	}
+	if len(payload) < 8 {
+		return
+	}
+
+	switch packetAnalysis.ICMP.Type {
+	case 0, 8: // Echo reply, echo request
+		packetAnalysis.ICMP.Identifier = binary.BigEndian.Uint16(payload[4:6])
+		packetAnalysis.ICMP.Sequence = binary.BigEndian.Uint16(payload[6:8])
+	case 3: // Destination unreachable
+		if packetAnalysis.ICMP.Code == 4 {
+			packetAnalysis.ICMP.MTU = binary.BigEndian.Uint16(payload[6:8])
+		}
+		packetAnalysis.ICMP.Original = payload[8:]
+	case 5: // Redirect
+		packetAnalysis.ICMP.Gateway = net.IP(payload[4:8]).String()
+		packetAnalysis.ICMP.Original = payload[8:]
+	case 11, 12: // Time exceeded, parameter problem
+		packetAnalysis.ICMP.Original = payload[8:]
+	}
 }
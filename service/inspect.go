@@ -0,0 +1,11 @@
+package service
+
+import "main/model"
+
+// Inspector sees every decoded packet before it is added to its flow, e.g. the
+// native detectors. It must not keep the packet after returning.
+type Inspector interface {
+	InspectTCP(packet *model.PacketAnalysisTCP)
+	InspectUDP(packet *model.PacketAnalysisUDP)
+	InspectICMP(packet *model.PacketAnalysisICMP)
+}
@@ -0,0 +1,15 @@
+package service
+
+import (
+	"main/logging"
+	"time"
+)
+
+var (
+	logger      = logging.For("service")
+	snortLogger = logging.For("snort")
+	unswLogger  = logging.For("unswb")
+)
+
+// packetLog reports malformed packets at most once per message every 10 seconds
+var packetLog = logging.Limited(logger, 10*time.Second)
@@ -4,16 +4,26 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"main/metrics"
 	"main/model"
 	"os/exec"
+	"sync"
 	"syscall"
 )
 
 var cmd *exec.Cmd
+var cmdMutex sync.Mutex
 
 // Run Python unsw_runner.py and read prediction JSON lines
 func StartUNSWRunnable(alert chan<- model.Detection) error {
+	cmdMutex.Lock()
+	defer cmdMutex.Unlock()
+
+	if cmd != nil {
+		return fmt.Errorf("unsw runner is already running")
+	}
 	cmd = exec.Command("python3", "/app/service/unsw_runner.py")
+	proc := cmd
 
 	// Create pipe to capture stdout
 	stdout, err := cmd.StdoutPipe()
@@ -23,8 +33,10 @@ func StartUNSWRunnable(alert chan<- model.Detection) error {
 	cmd.Stderr = cmd.Stdout // merge stderr to stdout
 
 	if err := cmd.Start(); err != nil {
+		cmd = nil
 		return fmt.Errorf("failed to start python script: %w", err)
 	}
+	metrics.ProcessUp.Set(1, "unswb")
 
 	scanner := bufio.NewScanner(stdout)
 	go func() {
@@ -38,7 +50,7 @@ func StartUNSWRunnable(alert chan<- model.Detection) error {
 			var preds []model.Detection
 			err := json.Unmarshal([]byte(line), &preds)
 			if err != nil {
-				fmt.Println("Failed to parse prediction JSON:", err, "line:", line)
+				unswLogger.Warn("failed to parse prediction JSON", "line", line, "error", err)
 				continue
 			}
 
@@ -46,10 +58,11 @@ func StartUNSWRunnable(alert chan<- model.Detection) error {
 				if p.Message != "Benign" { 
 					alert <- model.Detection{
 						AttackerIP:  p.AttackerIP,
-						Method:      "AI Detection ( UNSWB )",
+						Method:      model.MethodUNSW,
 						Protocol:    "TCP",
 						TargetPort: "",
 						Message:     string(p.Message),
+						Severity:    model.SeverityLow,
 					}
 				}
 				
@@ -57,19 +70,27 @@ func StartUNSWRunnable(alert chan<- model.Detection) error {
 		}
 
 		if err := scanner.Err(); err != nil {
-			fmt.Println("Error reading python output:", err)
+			unswLogger.Error("failed to read runner output", "error", err)
 		}
 	}()
 
 
 	// Optional: wait for process to finish in background (or handle termination elsewhere)
 	go func() {
-		err := cmd.Wait()
+		err := proc.Wait()
 		if err != nil {
-			fmt.Println("Python script exited with error:", err)
+			unswLogger.Error("runner exited with error", "error", err)
 		} else {
-			fmt.Println("Python script exited cleanly")
+			unswLogger.Info("runner exited")
+		}
+
+		metrics.ProcessUp.Set(0, "unswb")
+
+		cmdMutex.Lock()
+		if cmd == proc {
+			cmd = nil
 		}
+		cmdMutex.Unlock()
 	}()
 
 	return nil
@@ -78,6 +99,9 @@ func StartUNSWRunnable(alert chan<- model.Detection) error {
 
 // StopUNSWRunnable sends SIGTERM to the Python subprocess
 func StopUNSWRunnable() error {
+	cmdMutex.Lock()
+	defer cmdMutex.Unlock()
+
 	if cmd == nil || cmd.Process == nil {
 		return fmt.Errorf("invalid command or process")
 	}
@@ -87,6 +111,7 @@ func StopUNSWRunnable() error {
 		return fmt.Errorf("failed to send SIGTERM: %w", err)
 	}
 
-	fmt.Println("stoppign the unsw")
+	unswLogger.Info("stopping runner")
+	cmd = nil
 	return nil
 }
@@ -3,11 +3,26 @@ package service
 import (
 	"encoding/json"
 	"fmt"
+	"main/metrics"
 	"net"
 	"time"
 )
 
-func getPrediction(dataString []string) (string, error) {
+// getPrediction asks the model server to classify a flow and records the outcome
+func getPrediction(protocol string, dataString []string) (string, error) {
+	metrics.Predictions.Inc(protocol)
+	start := time.Now()
+
+	prediction, err := requestPrediction(dataString)
+
+	metrics.PredictionLatency.Observe(time.Since(start).Seconds(), protocol)
+	if err != nil {
+		metrics.PredictionFailures.Inc(protocol)
+	}
+	return prediction, err
+}
+
+func requestPrediction(dataString []string) (string, error) {
 	// Connect to the Python server over TCP
 	conn, err := net.Dial("tcp", "172.30.0.11:50051")
 	if err != nil {
@@ -50,9 +65,19 @@ func getPrediction(dataString []string) (string, error) {
 		"svm", "knn", "naïve_bayes", "catboost", "nn",
 	}
 
+	if len(predictions) < len(model_names) {
+		return "", fmt.Errorf("expected %d votes, got %d", len(model_names), len(predictions))
+	}
+
 	var predictionString string
 	for i, v := range model_names {
 		predictionString += fmt.Sprintf("%s: %d  ", v, predictions[i])
+
+		vote := "benign"
+		if predictions[i] == 1 {
+			vote = "attack"
+		}
+		metrics.ModelVotes.Inc(v, vote)
 	}
 
 	return predictionString, nil
@@ -4,17 +4,34 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"main/metrics"
 	"main/model"
 	"os"
 	"os/exec"
 	"os/signal"
 	"regexp"
+	"strings"
+	"sync"
 	"syscall"
 )
 
 var snortCmd *exec.Cmd
+var snortMutex sync.Mutex
+var snortSignals sync.Once
+
+var snortPriorityRe = regexp.MustCompile(`\[Priority: (\d+)\]`)
+
+var snortAlertRe = regexp.MustCompile(`\[\*\*\] \[(.*?)\] "(.*?)" \[\*\*\].*?\{(\w+)\} (\d+\.\d+\.\d+\.\d+):(\d+)(?: ->|,) (\d+\.\d+\.\d+\.\d+):(\d+)`)
+
+// StartSnort launches Snort and forwards its alerts until StopSnort is called
+func StartSnort(alert chan<- model.Detection) error {
+	snortMutex.Lock()
+	defer snortMutex.Unlock()
+
+	if snortCmd != nil {
+		return fmt.Errorf("snort is already running")
+	}
 
-func StartSnort(alert chan<- model.Detection) {
 	// Define the Snort command with stdbuf to disable buffering
 	snortCmd = exec.Command(
 		"stdbuf", "-oL", "-eL", "snort",
@@ -27,53 +44,87 @@ func StartSnort(alert chan<- model.Detection) {
 
 	stdoutPipe, err := snortCmd.StdoutPipe()
 	if err != nil {
-		fmt.Println("❌ Failed to create stdout pipe:", err)
-		return
+		snortCmd = nil
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
 	stderrPipe, err := snortCmd.StderrPipe()
 	if err != nil {
-		fmt.Println("❌ Failed to create stderr pipe:", err)
-		return
+		snortCmd = nil
+		return fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
 
 	if err := snortCmd.Start(); err != nil {
-		fmt.Println("❌ Failed to start Snort:", err)
-		return
+		snortCmd = nil
+		return fmt.Errorf("failed to start snort: %w", err)
 	}
 
 	pid := snortCmd.Process.Pid
-	fmt.Println("🚀 Snort started with PID:", pid)
+	snortLogger.Info("snort started", "pid", pid)
+	metrics.ProcessUp.Set(1, "snort")
 
 	// Goroutines to handle output
-	go printAfterLine("STDOUT", stdoutPipe, 315, alert)
-	go printAfterLine("STDERR", stderrPipe, 145, alert)
-
-	// Handle interrupt signals in a separate goroutine
-	go func(pid int) {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-		<-sigChan
-
-		fmt.Println("🛑 Interrupt received. Stopping Snort...")
-		StopSnort()
-	}(pid)
-}
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		printAfterLine("STDOUT", stdoutPipe, 315, alert)
+	}()
+	go func() {
+		defer readers.Done()
+		printAfterLine("STDERR", stderrPipe, 145, alert)
+	}()
+
+	// Reap the process once its output is drained, however it ended
+	proc := snortCmd
+	go func() {
+		readers.Wait()
+		if err := proc.Wait(); err != nil {
+			snortLogger.Info("snort exited", "pid", pid, "error", err)
+		} else {
+			snortLogger.Info("snort exited", "pid", pid)
+		}
+
+		snortMutex.Lock()
+		defer snortMutex.Unlock()
+		if snortCmd == proc {
+			snortCmd = nil
+		}
+		// A restarted snort owns the metric already
+		if snortCmd == nil {
+			metrics.ProcessUp.Set(0, "snort")
+		}
+	}()
+
+	// Handle interrupt signals in a separate goroutine, registered for the first start only
+	snortSignals.Do(func() {
+		go func() {
+			sigChan := make(chan os.Signal, 1)
+			signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+			for range sigChan {
+				snortLogger.Info("interrupt received, stopping snort")
+				StopSnort()
+			}
+		}()
+	})
 
+	return nil
+}
 
+// StopSnort kills the running Snort process, which is reaped in the background
+func StopSnort() error {
+	snortMutex.Lock()
+	defer snortMutex.Unlock()
 
-func StopSnort() bool {
 	if snortCmd != nil && snortCmd.Process != nil {
 		pid := snortCmd.Process.Pid
 		if err := snortCmd.Process.Kill(); err != nil {
-			fmt.Printf("❌ Failed to stop Snort (PID %d): %v\n", pid, err)
-			return false
+			return fmt.Errorf("failed to stop snort (PID %d): %w", pid, err)
 		}
-		fmt.Printf("✅ Snort (PID %d) successfully stopped.\n", pid)
+		snortLogger.Info("snort stopped", "pid", pid)
 		snortCmd = nil
-		return true
+		return nil
 	}
-	fmt.Println("⚠️ No Snort process is currently running.")
-	return false
+	return fmt.Errorf("no snort process is running")
 }
 
 func printAfterLine(prefix string, pipe io.Reader, threshold int, alert chan<- model.Detection) {
@@ -89,26 +140,28 @@ func printAfterLine(prefix string, pipe io.Reader, threshold int, alert chan<- m
 			continue
 		}
 
-		re := regexp.MustCompile(`\[\*\*\] \[.*?\] "(.*?)" \[\*\*\].*?\{(\w+)\} (\d+\.\d+\.\d+\.\d+):(\d+)(?: ->|,) (\d+\.\d+\.\d+\.\d+):(\d+)`)
-
 		// Find matches
-		matches := re.FindStringSubmatch(line)
+		matches := snortAlertRe.FindStringSubmatch(line)
 
-		if len(matches) >= 7 { // We now expect 7 groups (including protocol)
-			alertMessage := matches[1]
-			protocol := matches[2] // Protocol from between {}
-			srcIP := matches[3]
-			// srcPort := matches[4]
-			// destIP := matches[5]
-			destPort := matches[6]
+		if len(matches) >= 8 { // We now expect 8 groups (including protocol and signature)
+			signatureID := snortSignatureID(matches[1])
+			alertMessage := matches[2]
+			protocol := matches[3] // Protocol from between {}
+			srcIP := matches[4]
+			// srcPort := matches[5]
+			destIP := matches[6]
+			destPort := matches[7]
 
 			if srcIP != "172.30.0.2" {
 				attack_alert := model.Detection{
-					Method:      "Rule Detection",
+					Method:      model.MethodRule,
 					Protocol:    protocol,
-					AttackerIP: srcIP,
-					TargetPort: destPort,
+					AttackerIP:  srcIP,
+					TargetIP:    destIP,
+					TargetPort:  destPort,
 					Message:     alertMessage,
+					SignatureID: signatureID,
+					Severity:    snortSeverity(line),
 				}
 				alert <- attack_alert
 			}
@@ -116,6 +169,31 @@ func printAfterLine(prefix string, pipe io.Reader, threshold int, alert chan<- m
 	}
 
 	if err := scanner.Err(); err != nil {
-		fmt.Printf("[%s] Error reading output: %v\n", prefix, err)
+		snortLogger.Error("failed to read snort output", "stream", prefix, "error", err)
+	}
+}
+
+// snortSignatureID turns the "gid:sid:rev" triple of an alert_fast line into "gid:sid"
+func snortSignatureID(triple string) string {
+	parts := strings.Split(triple, ":")
+	if len(parts) < 2 {
+		return triple
+	}
+	return parts[0] + ":" + parts[1]
+}
+
+// snortSeverity maps the alert priority (1 is the most severe) onto a detection severity
+func snortSeverity(line string) int {
+	matches := snortPriorityRe.FindStringSubmatch(line)
+	if len(matches) < 2 {
+		return model.SeverityMedium
+	}
+
+	switch matches[1] {
+	case "1":
+		return model.SeverityHigh
+	case "2":
+		return model.SeverityMedium
 	}
+	return model.SeverityLow
 }
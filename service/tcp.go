@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
+	"main/metrics"
 	"main/model"
 	"net"
 	"strings"
@@ -10,23 +12,26 @@ import (
 	"time"
 )
 
-var csvToggleTcp = false
-
 type TCP struct {
 	FeatureAnalyzer  map[string]*FeatureAnalyzer
 	timeoutSignal    chan string
+	done             <-chan struct{}
 	mutexLock        sync.Mutex
 	lastPredictionTS map[string]time.Time
 	alert            chan<- model.Detection
+	inspector        Inspector
 }
 
-func NewTCP(alert chan model.Detection) *TCP {
+// NewTCP creates the TCP analyzer, which runs until ctx is cancelled. inspector may be nil.
+func NewTCP(ctx context.Context, alert chan model.Detection, inspector Inspector) *TCP {
 
 	tcp := &TCP{
 		FeatureAnalyzer:  make(map[string]*FeatureAnalyzer),
 		timeoutSignal:    make(chan string),
+		done:             ctx.Done(),
 		lastPredictionTS: make(map[string]time.Time),
 		alert:            alert,
+		inspector:        inspector,
 	}
 
 	go tcp.FlowMapTimeout()
@@ -34,17 +39,9 @@ func NewTCP(alert chan model.Detection) *TCP {
 	return tcp
 }
 
-func CsvToggleTCP() {
-	if csvToggleTcp {
-		csvToggleTcp = false
-	} else {
-		csvToggleTcp = true
-	}
-}
-
 func (t *TCP) AnalyzeTCP(payload []byte) {
 	if len(payload) < 40 { // Ensure packet is large enough for analysis
-		fmt.Println("[ERROR] Payload size is too small to analyze.")
+		packetLog.Warn("payload too small to analyze", "protocol", "tcp", "size", len(payload))
 		return
 	}
 
@@ -57,9 +54,16 @@ func (t *TCP) AnalyzeTCP(payload []byte) {
 	case 4:
 		t.analyzeIPv4(payload, &packetAnalysis)
 	default:
-		fmt.Printf("[WARNING] Unsupported IP version %d\n", version)
+		packetLog.Warn("unsupported IP version", "protocol", "tcp", "version", version)
 		return
 	}
+	if packetAnalysis.IPv4 == nil || packetAnalysis.TCP == nil {
+		return
+	}
+
+	if t.inspector != nil {
+		t.inspector.InspectTCP(&packetAnalysis)
+	}
 
 	forwardKey := fmt.Sprintf("%s-%s", packetAnalysis.IPv4.SourceIP, packetAnalysis.IPv4.DestinationIP)
 	backwardKey := fmt.Sprintf("%s-%s", packetAnalysis.IPv4.DestinationIP, packetAnalysis.IPv4.SourceIP)
@@ -82,7 +86,8 @@ func (t *TCP) AnalyzeTCP(payload []byte) {
 		key = backwardKey
 		direction = "backward"
 	} else {
-		t.FeatureAnalyzer[forwardKey] = GetFeatureAnalyzerInstance(&packetAnalysis, forwardKey, t.timeoutSignal)
+		t.FeatureAnalyzer[forwardKey] = GetFeatureAnalyzerInstance(&packetAnalysis, forwardKey, t.timeoutSignal, t.done)
+		metrics.ActiveFlows.Add(1, "tcp")
 		return
 	}
 
@@ -111,20 +116,29 @@ func (t *TCP) FlowMapTimeout() {
 	var key string
 	for {
 		select {
+		case <-t.done:
+			// The flows still being analyzed are dropped with the analyzer
+			t.mutexLock.Lock()
+			metrics.ActiveFlows.Add(-float64(len(t.FeatureAnalyzer)), "tcp")
+			t.mutexLock.Unlock()
+			return
 		case key = <-t.timeoutSignal:
 			t.mutexLock.Lock()
 
-			if csvToggleTcp {
+			if CSVCapturing("tcp") {
 				err := WriteToCSV("tcp", t.FeatureAnalyzer[key])
 				if err != nil {
-					fmt.Println("Error writing to CSV file: ", err)
+					logger.Error("failed to write flow to CSV", "protocol", "tcp", "error", err)
 				}
 			}
 			dataString := returnDataIntoString(t.FeatureAnalyzer[key])
 
 			t.PredictAndAlert(dataString, key)
 
+			expireFlow("tcp", key, t.FeatureAnalyzer[key])
 			delete(t.FeatureAnalyzer, key)
+			metrics.ActiveFlows.Add(-1, "tcp")
+			metrics.FlowEvictions.Inc("tcp")
 			t.mutexLock.Unlock()
 		case <-time.After(3 * time.Second): // Prevent blocking forever
 			// PASS
@@ -134,9 +148,9 @@ func (t *TCP) FlowMapTimeout() {
 
 func (t *TCP) PredictAndAlert(dataString []string, key string){
 	// AI Prediction
-	pred, err := getPrediction(dataString)
+	pred, err := getPrediction("tcp", dataString)
 	if err != nil {
-		fmt.Println("Error getting prediction: ", err)
+		logger.Error("prediction failed", "protocol", "tcp", "flow", key, "error", err)
 	}
 
 	splitted := strings.Split(key, "-")
@@ -144,11 +158,13 @@ func (t *TCP) PredictAndAlert(dataString []string, key string){
 
 	if strings.Count(pred, "1") > 5 {
 		attack_alert := model.Detection{
-			Method:      "AI Detection",
+			Method:      model.MethodAI,
 			Protocol:    "TCP",
 			AttackerIP: attackerIp,
+			TargetIP:    splitted[1],
 			TargetPort: t.FeatureAnalyzer[key].port,
 			Message:     "DDOS Attack Detected",
+			Severity:    model.SeverityMedium,
 		}
 
 		if t.FeatureAnalyzer[key].multiplePort {
@@ -163,7 +179,7 @@ func (t *TCP) PredictAndAlert(dataString []string, key string){
 func (t *TCP) analyzeIPv4(payload []byte, packetAnalysis *model.PacketAnalysisTCP) {
 	ihl := int((payload[0] & 0x0F) * 4)
 	if len(payload) < ihl+20 {
-		fmt.Println("[ERROR] Invalid IPv4 header length")
+		packetLog.Warn("invalid IPv4 header length", "protocol", "tcp")
 		return
 	}
 
@@ -180,7 +196,7 @@ func (t *TCP) analyzeIPv4(payload []byte, packetAnalysis *model.PacketAnalysisTC
 
 func (t *TCP) analyzeHeader(payload []byte, packetAnalysis *model.PacketAnalysisTCP) {
 	if len(payload) < 20 { // Ensure that there is enough data for the TCP header
-		fmt.Println("[ERROR] Invalid TCP header length")
+		packetLog.Warn("invalid TCP header length", "protocol", "tcp")
 		return
 	}
 
@@ -190,6 +206,8 @@ func (t *TCP) analyzeHeader(payload []byte, packetAnalysis *model.PacketAnalysis
 	packetAnalysis.TCP = &model.TCPInfo{
 		SourcePort:      uint64(sourcePort),
 		DestinationPort: uint64(destinationPort),
+		Seq:             binary.BigEndian.Uint32(payload[4:8]),
+		Ack:             binary.BigEndian.Uint32(payload[8:12]),
 		SYN:             payload[13]&0x02 != 0,
 		ACK:             payload[13]&0x10 != 0,
 		FIN:             payload[13]&0x01 != 0,
@@ -202,7 +220,7 @@ func (t *TCP) analyzeHeader(payload []byte, packetAnalysis *model.PacketAnalysis
 
 	tcpHeaderLength := (payload[12] >> 4) * 4 // Header length in 32-bit words
 	if len(payload) < int(tcpHeaderLength) {
-		fmt.Println("[ERROR] Invalid TCP header length")
+		packetLog.Warn("invalid TCP header length", "protocol", "tcp")
 		return
 	}
 
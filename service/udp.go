@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
+	"main/metrics"
 	"main/model"
 	"net"
 	"strings"
@@ -10,22 +12,25 @@ import (
 	"time"
 )
 
-var csvToggleUdp = false
-
 type UDP struct {
 	FeatureAnalyzer  map[string]*FeatureAnalyzer
 	timeoutSignal    chan string
+	done             <-chan struct{}
 	mutexLock        sync.Mutex
 	lastPredictionTS map[string]time.Time
 	alert            chan model.Detection
+	inspector        Inspector
 }
 
-func NewUDP(alert chan model.Detection) *UDP {
+// NewUDP creates the UDP analyzer, which runs until ctx is cancelled. inspector may be nil.
+func NewUDP(ctx context.Context, alert chan model.Detection, inspector Inspector) *UDP {
 	udp := &UDP{
 		FeatureAnalyzer:  make(map[string]*FeatureAnalyzer),
 		timeoutSignal:    make(chan string),
+		done:             ctx.Done(),
 		lastPredictionTS: make(map[string]time.Time),
 		alert:            alert,
+		inspector:        inspector,
 	}
 
 	go udp.FlowMapTimeout()
@@ -33,17 +38,9 @@ func NewUDP(alert chan model.Detection) *UDP {
 	return udp
 }
 
-func CsvToggleUDP() {
-	if csvToggleUdp {
-		csvToggleUdp = false
-	} else {
-		csvToggleUdp = true
-	}
-}
-
 func (u *UDP) AnalyzeUDP(payload []byte) {
 	if len(payload) < 28 { // Ensure packet is large enough for analysis
-		fmt.Println("[ERROR] Payload size is too small to analyze.")
+		packetLog.Warn("payload too small to analyze", "protocol", "udp", "size", len(payload))
 		return
 	}
 
@@ -57,9 +54,16 @@ func (u *UDP) AnalyzeUDP(payload []byte) {
 		u.analyzeIPv4(payload, &packetAnalysis)
 
 	default:
-		fmt.Printf("[WARNING] Unsupported IP version %d\n", version)
+		packetLog.Warn("unsupported IP version", "protocol", "udp", "version", version)
 		return
 	}
+	if packetAnalysis.IPv4 == nil || packetAnalysis.UDP == nil {
+		return
+	}
+
+	if u.inspector != nil {
+		u.inspector.InspectUDP(&packetAnalysis)
+	}
 
 	forwardKey := fmt.Sprintf("%s-%s", packetAnalysis.IPv4.SourceIP, packetAnalysis.IPv4.DestinationIP)
 	backwardKey := fmt.Sprintf("%s-%s", packetAnalysis.IPv4.DestinationIP, packetAnalysis.IPv4.SourceIP)
@@ -82,7 +86,8 @@ func (u *UDP) AnalyzeUDP(payload []byte) {
 		key = backwardKey
 		direction = "backward"
 	} else {
-		u.FeatureAnalyzer[forwardKey] = GetFeatureAnalyzerInstanceUDP(&packetAnalysis, forwardKey, u.timeoutSignal)
+		u.FeatureAnalyzer[forwardKey] = GetFeatureAnalyzerInstanceUDP(&packetAnalysis, forwardKey, u.timeoutSignal, u.done)
+		metrics.ActiveFlows.Add(1, "udp")
 		return
 	}
 
@@ -110,20 +115,29 @@ func (u *UDP) FlowMapTimeout() {
 	var key string
 	for {
 		select {
+		case <-u.done:
+			// The flows still being analyzed are dropped with the analyzer
+			u.mutexLock.Lock()
+			metrics.ActiveFlows.Add(-float64(len(u.FeatureAnalyzer)), "udp")
+			u.mutexLock.Unlock()
+			return
 		case key = <-u.timeoutSignal:
 			u.mutexLock.Lock()
 
-			if csvToggleUdp {
+			if CSVCapturing("udp") {
 				err := WriteToCSV("udp", u.FeatureAnalyzer[key])
 				if err != nil {
-					fmt.Println("Error writing to CSV file: ", err)
+					logger.Error("failed to write flow to CSV", "protocol", "udp", "error", err)
 				}
 			}
 
 			dataString  := returnDataIntoString(u.FeatureAnalyzer[key])
 			u.PredictAndAlert(dataString, key)
 
+			expireFlow("udp", key, u.FeatureAnalyzer[key])
 			delete(u.FeatureAnalyzer, key)
+			metrics.ActiveFlows.Add(-1, "udp")
+			metrics.FlowEvictions.Inc("udp")
 
 			u.mutexLock.Unlock()
 
@@ -135,9 +149,9 @@ func (u *UDP) FlowMapTimeout() {
 
 func (u *UDP) PredictAndAlert(dataString []string , key string){
 	// AI Prediction
-	pred, err := getPrediction(dataString)
+	pred, err := getPrediction("udp", dataString)
 	if err != nil {
-		fmt.Println("Error getting prediction: ", err)
+		logger.Error("prediction failed", "protocol", "udp", "flow", key, "error", err)
 	}
 
 	// fmt.Println(key, " : ", pred)
@@ -146,11 +160,13 @@ func (u *UDP) PredictAndAlert(dataString []string , key string){
 
 	if strings.Count(pred, "1") > 5 {
 		attack_alert := model.Detection{
-			Method:      "AI Detection",
+			Method:      model.MethodAI,
 			Protocol:    "UDP",
 			AttackerIP: attackerIp,
+			TargetIP:    splitted[1],
 			TargetPort: u.FeatureAnalyzer[key].port,
 			Message:     "DDOS Attack Detected",
+			Severity:    model.SeverityMedium,
 		}
 
 		if u.FeatureAnalyzer[key].multiplePort {
@@ -166,7 +182,7 @@ func (u *UDP) PredictAndAlert(dataString []string , key string){
 func (u *UDP) analyzeIPv4(payload []byte, packetAnalysis *model.PacketAnalysisUDP) {
 	ihl := int((payload[0] & 0x0F) * 4)
 	if len(payload) < ihl+8 {
-		fmt.Println("[ERROR] Invalid IPv4 header length")
+		packetLog.Warn("invalid IPv4 header length", "protocol", "udp")
 		return
 	}
 
@@ -183,7 +199,7 @@ func (u *UDP) analyzeIPv4(payload []byte, packetAnalysis *model.PacketAnalysisUD
 
 func (u *UDP) analyzeHeader(payload []byte, packetAnalysis *model.PacketAnalysisUDP) {
 	if len(payload) < 8 { // Ensure that there is enough data for the UDP header
-		fmt.Println("[ERROR] Invalid UDP header length")
+		packetLog.Warn("invalid UDP header length", "protocol", "udp")
 		return
 	}
 
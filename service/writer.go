@@ -2,10 +2,48 @@ package service
 
 import (
 	"encoding/csv"
+	"fmt"
 	"os"
 	"strconv"
+	"sync"
 )
 
+// csvCapture records per protocol whether finished flows are written to datasets/
+var csvCapture = map[string]bool{"tcp": false, "udp": false, "icmp": false}
+var csvCaptureMutex sync.Mutex
+
+// SetCSVCapture turns dataset capture for "tcp", "udp" or "icmp" on or off
+func SetCSVCapture(proto string, enabled bool) error {
+	csvCaptureMutex.Lock()
+	defer csvCaptureMutex.Unlock()
+
+	if _, ok := csvCapture[proto]; !ok {
+		return fmt.Errorf("unknown capture protocol %q", proto)
+	}
+	csvCapture[proto] = enabled
+	return nil
+}
+
+// CSVCapturing reports whether flows of proto are being captured
+func CSVCapturing(proto string) bool {
+	csvCaptureMutex.Lock()
+	defer csvCaptureMutex.Unlock()
+
+	return csvCapture[proto]
+}
+
+// CSVCapture returns the capture state of every protocol
+func CSVCapture() map[string]bool {
+	csvCaptureMutex.Lock()
+	defer csvCaptureMutex.Unlock()
+
+	state := make(map[string]bool, len(csvCapture))
+	for proto, enabled := range csvCapture {
+		state[proto] = enabled
+	}
+	return state
+}
+
 func WriteToCSV(filename string, features *FeatureAnalyzer) error {
 
 	// Create datasets directory if it is not exists
@@ -0,0 +1,225 @@
+package siem
+
+import (
+	"fmt"
+	"main/model"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Identification of this IPS in CEF and LEEF headers
+const (
+	vendor  = "IPS"
+	product = "Hybrid IPS"
+	version = "1.0"
+)
+
+const appName = "ips"
+
+// sdID names the RFC 5424 structured data element, under the IANA example enterprise number
+const sdID = "ips@32473"
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "daemon": 3, "auth": 4, "syslog": 5, "authpriv": 10,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+func facilityName(facility string) string {
+	if facility == "" {
+		return "local0"
+	}
+	return facility
+}
+
+// syslogSeverity maps detection severities onto syslog: critical=2 … low=5 (notice)
+func syslogSeverity(severity int) int {
+	switch {
+	case severity >= model.SeverityCritical:
+		return 2
+	case severity == model.SeverityHigh:
+		return 3
+	case severity == model.SeverityMedium:
+		return 4
+	}
+	return 5
+}
+
+// scaledSeverity maps detection severities onto the 0-10 scale of CEF and LEEF
+func scaledSeverity(severity int) int {
+	switch {
+	case severity >= model.SeverityCritical:
+		return 10
+	case severity == model.SeverityHigh:
+		return 8
+	case severity == model.SeverityMedium:
+		return 5
+	}
+	return 3
+}
+
+// methodIncident is the method of the detections incident events are rendered as
+const methodIncident = "Incident"
+
+// incidentDetection renders an incident event as a detection, so every format
+// carries incidents with the fields it carries detections with
+func incidentDetection(event model.IncidentEvent) model.Detection {
+	inc := event.Incident
+	return model.Detection{
+		Method:      methodIncident,
+		Protocol:    strings.Join(inc.Protocols, ","),
+		AttackerIP:  inc.AttackerIP,
+		TargetIP:    inc.TargetIP,
+		TargetPort:  strings.Join(inc.TargetPorts, ","),
+		Message:     fmt.Sprintf("Incident %s %s: %d detections from %s by %s", inc.ID, event.Type, inc.Hits, inc.AttackerIP, strings.Join(inc.Detectors, ", ")),
+		SignatureID: "incident:" + event.Type,
+		Severity:    inc.Severity,
+		Timestamp:   inc.LastSeen,
+	}
+}
+
+// eventID is the signature of a detection, falling back to the detector
+func eventID(alert model.Detection) string {
+	if alert.SignatureID != "" {
+		return alert.SignatureID
+	}
+	return alert.Detector()
+}
+
+// formatter renders one detection as a complete syslog message
+type formatter struct {
+	format   string
+	facility int
+	hostname string
+	pid      string
+}
+
+func newFormatter(o Output, hostname string) formatter {
+	return formatter{
+		format:   o.Format,
+		facility: facilities[facilityName(o.Facility)],
+		hostname: hostname,
+		pid:      strconv.Itoa(os.Getpid()),
+	}
+}
+
+func (f formatter) message(alert model.Detection) []byte {
+	sd, msg := "-", ""
+	switch f.format {
+	case FormatCEF:
+		msg = cef(alert)
+	case FormatLEEF:
+		msg = leef(alert)
+	default:
+		sd, msg = structuredData(alert), alert.Message
+	}
+
+	msgID := "detection"
+	if alert.Method == methodIncident {
+		msgID = "incident"
+	}
+	pri := f.facility*8 + syslogSeverity(alert.Severity)
+	header := fmt.Sprintf("<%d>1 %s %s %s %s %s %s",
+		pri, alert.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00"), f.hostname, appName, f.pid, msgID, sd)
+	if msg == "" {
+		return []byte(header)
+	}
+	return []byte(header + " " + msg)
+}
+
+// structuredData renders the detection as an RFC 5424 SD-ELEMENT
+func structuredData(alert model.Detection) string {
+	var b strings.Builder
+	b.WriteString("[" + sdID)
+	param := func(name, value string) {
+		if value == "" {
+			return
+		}
+		b.WriteString(" " + name + `="` + sdEscaper.Replace(value) + `"`)
+	}
+	param("detector", alert.Detector())
+	param("method", alert.Method)
+	param("signature", alert.SignatureID)
+	param("severity", model.SeverityName(alert.Severity))
+	param("protocol", alert.Protocol)
+	param("src", alert.AttackerIP)
+	param("dst", alert.TargetIP)
+	param("dport", alert.TargetPort)
+	b.WriteString("]")
+	return b.String()
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// cef renders CEF:Version|Vendor|Product|Version|SignatureID|Name|Severity|Extension
+func cef(alert model.Detection) string {
+	header := []string{"CEF:0", vendor, product, version, eventID(alert), alert.Message, strconv.Itoa(scaledSeverity(alert.Severity))}
+	for i := 1; i < len(header); i++ {
+		header[i] = cefHeaderEscaper.Replace(header[i])
+	}
+
+	var ext []string
+	field := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefValueEscaper.Replace(value))
+		}
+	}
+	field("rt", strconv.FormatInt(alert.Timestamp.UnixMilli(), 10))
+	field("src", alert.AttackerIP)
+	field("dst", alert.TargetIP)
+	if _, err := strconv.Atoi(alert.TargetPort); err == nil {
+		field("dpt", alert.TargetPort)
+	}
+	field("proto", alert.Protocol)
+	field("act", "alert")
+	field("cs1Label", "detector")
+	field("cs1", alert.Detector())
+	field("cs2Label", "method")
+	field("cs2", alert.Method)
+	field("msg", alert.Message)
+
+	return strings.Join(header, "|") + "|" + strings.Join(ext, " ")
+}
+
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+
+// leef renders LEEF:2.0|Vendor|Product|Version|EventID|Delimiter|Attributes with tab delimited attributes
+func leef(alert model.Detection) string {
+	header := []string{"LEEF:2.0", vendor, product, version, eventID(alert), "x09"}
+	for i := 1; i < len(header); i++ {
+		header[i] = leefHeaderEscaper.Replace(header[i])
+	}
+
+	var attrs []string
+	attr := func(key, value string) {
+		if value != "" {
+			attrs = append(attrs, key+"="+leefValueEscaper.Replace(value))
+		}
+	}
+	attr("devTime", strconv.FormatInt(alert.Timestamp.UnixMilli(), 10))
+	attr("cat", alert.Detector())
+	attr("sev", strconv.Itoa(scaledSeverity(alert.Severity)))
+	attr("src", alert.AttackerIP)
+	attr("dst", alert.TargetIP)
+	if _, err := strconv.Atoi(alert.TargetPort); err == nil {
+		attr("dstPort", alert.TargetPort)
+	}
+	attr("proto", alert.Protocol)
+	attr("method", alert.Method)
+	attr("msg", alert.Message)
+
+	return strings.Join(header, "|") + "|" + strings.Join(attrs, "\t")
+}
+
+var leefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+var leefValueEscaper = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// frame prepares a message for the transport: one datagram for UDP, octet counting for streams
+func frame(transport string, msg []byte) []byte {
+	if transport == TransportUDP {
+		return msg
+	}
+	return append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+}
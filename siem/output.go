@@ -0,0 +1,182 @@
+package siem
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"main/logging"
+	"main/metrics"
+	"main/model"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	dialTimeout  = 10 * time.Second
+	writeTimeout = 10 * time.Second
+	minBackoff   = time.Second
+	maxBackoff   = time.Minute
+)
+
+// output keeps one collector connection and drains its queue into it
+type output struct {
+	config    Output
+	formatter formatter
+	tls       *tls.Config
+	queue     chan []byte
+	ctx       context.Context
+	cancel    context.CancelFunc
+	dropLog   *slog.Logger // reports a full buffer at most every 30 seconds
+}
+
+func newOutput(o Output, hostname string) (*output, error) {
+	out := &output{
+		config:    o,
+		formatter: newFormatter(o, hostname),
+		dropLog:   logging.Limited(logger.With("output", o.Name), 30*time.Second),
+	}
+
+	if o.Transport == TransportTLS {
+		config, err := tlsConfig(o)
+		if err != nil {
+			return nil, err
+		}
+		out.tls = config
+	}
+
+	buffer := o.Buffer
+	if buffer == 0 {
+		buffer = defaultBuffer
+	}
+	out.queue = make(chan []byte, buffer)
+	out.ctx, out.cancel = context.WithCancel(context.Background())
+	metrics.ExportConnected.Set(0, o.Name)
+	return out, nil
+}
+
+func (o *output) format(alert model.Detection) []byte {
+	return frame(o.config.Transport, o.formatter.message(alert))
+}
+
+func (o *output) enqueue(msg []byte) {
+	select {
+	case o.queue <- msg:
+	default:
+		metrics.ExportMessages.Inc(o.config.Name, "dropped")
+		o.dropLog.Warn("export buffer full, dropping detections")
+	}
+}
+
+func (o *output) close() {
+	o.cancel()
+}
+
+// run connects, writes queued messages and reconnects with exponential backoff.
+// A message whose write failed is retried on the next connection.
+func (o *output) run() {
+	var pending []byte
+	backoff := minBackoff
+
+	for {
+		conn, err := o.dial()
+		if err != nil {
+			logger.Warn("failed to connect to collector", "output", o.config.Name, "address", o.config.Address, "retry_in", backoff.String(), "error", err)
+			select {
+			case <-o.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+
+		logger.Info("connected to collector", "output", o.config.Name, "address", o.config.Address, "transport", o.config.Transport)
+		metrics.ExportConnected.Set(1, o.config.Name)
+		backoff = minBackoff
+
+		pending, err = o.drain(conn, pending)
+		conn.Close()
+		metrics.ExportConnected.Set(0, o.config.Name)
+		if err == nil {
+			return
+		}
+		logger.Warn("lost connection to collector", "output", o.config.Name, "address", o.config.Address, "error", err)
+	}
+}
+
+// drain writes messages until the output is closed (nil error) or a write fails,
+// returning the message that could not be written
+func (o *output) drain(conn net.Conn, pending []byte) ([]byte, error) {
+	for {
+		if pending == nil {
+			select {
+			case <-o.ctx.Done():
+				return nil, nil
+			case pending = <-o.queue:
+			}
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := conn.Write(pending); err != nil {
+			metrics.ExportMessages.Inc(o.config.Name, "failed")
+			return pending, err
+		}
+		metrics.ExportMessages.Inc(o.config.Name, "sent")
+		pending = nil
+	}
+}
+
+func (o *output) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	switch o.config.Transport {
+	case TransportUDP:
+		return dialer.DialContext(o.ctx, "udp", o.config.Address)
+	case TransportTLS:
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: o.tls}
+		return tlsDialer.DialContext(o.ctx, "tcp", o.config.Address)
+	}
+	return dialer.DialContext(o.ctx, "tcp", o.config.Address)
+}
+
+func tlsConfig(o Output) (*tls.Config, error) {
+	settings := TLS{}
+	if o.TLS != nil {
+		settings = *o.TLS
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         settings.ServerName,
+		InsecureSkipVerify: settings.InsecureSkipVerify,
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(o.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address: %w", err)
+		}
+		config.ServerName = host
+	}
+
+	if settings.CAFile != "" {
+		pem, err := os.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", settings.CAFile)
+		}
+	}
+
+	if settings.CertFile != "" || settings.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,193 @@
+package siem
+
+import (
+	"encoding/json"
+	"fmt"
+	"main/logging"
+	"main/model"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Message formats
+const (
+	FormatSyslog = "syslog" // RFC 5424 with the detection or incident as structured data
+	FormatCEF    = "cef"    // ArcSight Common Event Format
+	FormatLEEF   = "leef"   // QRadar Log Event Extended Format 2.0
+)
+
+// Transports
+const (
+	TransportUDP = "udp"
+	TransportTCP = "tcp" // RFC 6587 octet counting
+	TransportTLS = "tls" // RFC 5425
+)
+
+const defaultBuffer = 1024
+
+var logger = logging.For("siem")
+
+// TLS configures the client side of a syslog TLS connection
+type TLS struct {
+	CAFile             string `json:"ca_file,omitempty"`   // trusted CAs, the system pool when empty
+	CertFile           string `json:"cert_file,omitempty"` // client certificate for mutual TLS
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"` // defaults to the host of the address
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// Output ships detections in one format to one collector
+type Output struct {
+	Name        string `json:"name"`
+	Enabled     bool   `json:"enabled"`
+	Format      string `json:"format"`                 // "syslog", "cef" or "leef"
+	Transport   string `json:"transport"`              // "udp", "tcp" or "tls"
+	Address     string `json:"address"`                // host:port
+	Facility    string `json:"facility,omitempty"`     // syslog facility, "local0" by default
+	MinSeverity int    `json:"min_severity,omitempty"` // detections and incidents below are not exported
+	Buffer      int    `json:"buffer,omitempty"`       // messages kept while disconnected, 1024 by default
+	TLS         *TLS   `json:"tls,omitempty"`
+}
+
+type Config struct {
+	Outputs []Output `json:"outputs"`
+}
+
+// DefaultConfig has a disabled local syslog output as an example
+func DefaultConfig() Config {
+	return Config{
+		Outputs: []Output{
+			{Name: "local-syslog", Format: FormatSyslog, Transport: TransportUDP, Address: "127.0.0.1:514", Facility: "local0"},
+		},
+	}
+}
+
+// Exporter formats detections and queues them on every enabled output
+type Exporter struct {
+	outputs []*output
+}
+
+// Load reads the export config from path, creating it with defaults if it
+// doesn't exist, and starts connecting the enabled outputs
+func Load(path string) (*Exporter, error) {
+	config := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if err := save(path, config); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to read SIEM config: %w", err)
+	default:
+		config = Config{}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse SIEM config: %w", err)
+		}
+	}
+
+	return New(config)
+}
+
+// New validates config and starts connecting the enabled outputs
+func New(config Config) (*Exporter, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+
+	e := &Exporter{}
+	names := make(map[string]bool)
+	for _, o := range config.Outputs {
+		if err := validate(o); err != nil {
+			return nil, fmt.Errorf("output %q: %w", o.Name, err)
+		}
+		if names[o.Name] {
+			return nil, fmt.Errorf("duplicate output %q", o.Name)
+		}
+		names[o.Name] = true
+
+		if !o.Enabled {
+			continue
+		}
+		out, err := newOutput(o, hostname)
+		if err != nil {
+			return nil, fmt.Errorf("output %q: %w", o.Name, err)
+		}
+		e.outputs = append(e.outputs, out)
+	}
+
+	for _, out := range e.outputs {
+		go out.run()
+	}
+	return e, nil
+}
+
+// Export queues a detection on every output whose severity threshold it meets.
+// It never blocks; messages are dropped when an output's buffer is full.
+func (e *Exporter) Export(alert model.Detection) {
+	if alert.Timestamp.IsZero() {
+		alert.Timestamp = time.Now()
+	}
+	for _, out := range e.outputs {
+		if alert.Severity < out.config.MinSeverity {
+			continue
+		}
+		out.enqueue(out.format(alert))
+	}
+}
+
+// ExportIncident queues an incident open, update or close event like Export
+// does a detection
+func (e *Exporter) ExportIncident(event model.IncidentEvent) {
+	e.Export(incidentDetection(event))
+}
+
+// Close stops every output, discarding what is still buffered
+func (e *Exporter) Close() {
+	for _, out := range e.outputs {
+		out.close()
+	}
+}
+
+func validate(o Output) error {
+	if o.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch o.Format {
+	case FormatSyslog, FormatCEF, FormatLEEF:
+	default:
+		return fmt.Errorf("unknown format %q", o.Format)
+	}
+	switch o.Transport {
+	case TransportUDP, TransportTCP, TransportTLS:
+	default:
+		return fmt.Errorf("unknown transport %q", o.Transport)
+	}
+	if o.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	if _, ok := facilities[facilityName(o.Facility)]; !ok {
+		return fmt.Errorf("unknown facility %q", o.Facility)
+	}
+	if o.Buffer < 0 {
+		return fmt.Errorf("buffer must not be negative")
+	}
+	return nil
+}
+
+func save(path string, config Config) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write SIEM config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"main/model"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Filter selects stored alerts, zero values match everything
+type Filter struct {
+	AttackerIP  string    `json:"attacker_ip,omitempty"`
+	TargetIP    string    `json:"target_ip,omitempty"`
+	Detector    string    `json:"detector,omitempty"`
+	SignatureID string    `json:"signature_id,omitempty"`
+	MinSeverity int       `json:"min_severity,omitempty"`
+	Since       time.Time `json:"since,omitempty"`
+	Until       time.Time `json:"until,omitempty"`
+	Limit       int       `json:"limit,omitempty"` // newest N matches
+}
+
+// Store keeps the most recent detections in memory and appends every detection
+// to a JSON lines file, which is renamed to path.1, path.2, … once it reaches
+// maxSize, keeping at most maxFiles old files
+type Store struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+	alerts   []model.Detection
+	capacity int
+}
+
+// Open loads the newest capacity alerts of path and keeps appending new ones to
+// it. Rotated files stay on disk only. A maxSize of 0 never rotates.
+func Open(path string, capacity int, maxSize int64, maxFiles int) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create alert store directory: %w", err)
+	}
+
+	s := &Store{path: path, maxSize: maxSize, maxFiles: maxFiles, capacity: capacity}
+	if err := s.load(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the alerts of one file into memory
+func (s *Store) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var d model.Detection
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			continue
+		}
+		s.push(d)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read alert store: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open alert store: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// Add records a detection
+func (s *Store) Add(d model.Detection) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	line := append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.push(d)
+
+	// A failed rotation is reported but the alert still goes to the current file
+	var rotateErr error
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		rotateErr = s.rotate()
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to append alert: %w", err)
+	}
+	return rotateErr
+}
+
+// rotate moves the open file aside and only then replaces it, so that a
+// failure at any step leaves a file to write to
+func (s *Store) rotate() error {
+	if s.maxFiles > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxFiles))
+		for i := s.maxFiles - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate alert store: %w", err)
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("failed to rotate alert store: %w", err)
+	}
+
+	old := s.file
+	if err := s.open(); err != nil {
+		return err
+	}
+	old.Close()
+	return nil
+}
+
+// Query returns the stored alerts matching f, oldest first
+func (s *Store) Query(f Filter) []model.Detection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matches []model.Detection
+	for i := len(s.alerts) - 1; i >= 0; i-- {
+		if f.Limit > 0 && len(matches) >= f.Limit {
+			break
+		}
+		if f.Match(s.alerts[i]) {
+			matches = append(matches, s.alerts[i])
+		}
+	}
+
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return matches
+}
+
+func (s *Store) Close() error {
+	return s.file.Close()
+}
+
+// Match reports whether a detection passes the filter
+func (f Filter) Match(d model.Detection) bool {
+	switch {
+	case f.AttackerIP != "" && d.AttackerIP != f.AttackerIP:
+		return false
+	case f.TargetIP != "" && d.TargetIP != f.TargetIP:
+		return false
+	case f.Detector != "" && d.Detector() != f.Detector:
+		return false
+	case f.SignatureID != "" && d.SignatureID != f.SignatureID:
+		return false
+	case d.Severity < f.MinSeverity:
+		return false
+	case !f.Since.IsZero() && d.Timestamp.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && d.Timestamp.After(f.Until):
+		return false
+	}
+	return true
+}
+
+func (s *Store) push(d model.Detection) {
+	if len(s.alerts) >= s.capacity {
+		s.alerts = s.alerts[1:]
+	}
+	s.alerts = append(s.alerts, d)
+}
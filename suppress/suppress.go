@@ -0,0 +1,388 @@
+package suppress
+
+import (
+	"encoding/json"
+	"fmt"
+	"main/model"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Actions a signature override can force
+const (
+	ActionAlert = "alert" // alert only, never block
+	ActionBlock = "block" // alert and block
+)
+
+// Threshold tracking modes
+const (
+	TrackBySrc = "by_src"
+	TrackByDst = "by_dst"
+)
+
+// Rule drops every detection matching all of its non-empty fields
+type Rule struct {
+	ID          string `json:"id"`
+	SignatureID string `json:"signature_id,omitempty"` // "gid:sid" or just "sid"
+	Message     string `json:"message,omitempty"`      // regular expression on the detection message
+	SourceCIDR  string `json:"source_cidr,omitempty"`
+	TargetCIDR  string `json:"target_cidr,omitempty"`
+	Detector    string `json:"detector,omitempty"` // "snort", "own", "unswb" or "native"
+}
+
+// Threshold lets a detection through only after Count hits in Seconds
+type Threshold struct {
+	ID          string `json:"id"`
+	SignatureID string `json:"signature_id,omitempty"`
+	Detector    string `json:"detector,omitempty"`
+	Count       int    `json:"count"`
+	Seconds     int    `json:"seconds"`
+	Track       string `json:"track"` // "by_src" or "by_dst"
+}
+
+// Override forces the action taken for a signature
+type Override struct {
+	SignatureID string `json:"signature_id"`
+	Action      string `json:"action"` // "alert" or "block"
+}
+
+type Config struct {
+	Suppress   []Rule      `json:"suppress"`
+	Thresholds []Threshold `json:"thresholds"`
+	Overrides  []Override  `json:"overrides"`
+}
+
+// Decision is the outcome of evaluating a detection
+type Decision struct {
+	Suppressed bool   `json:"suppressed"`
+	Reason     string `json:"reason,omitempty"`
+	Action     string `json:"action,omitempty"` // empty when no override applies
+}
+
+// maxTrackedKeys bounds the threshold state before stale sources are pruned
+const maxTrackedKeys = 10000
+
+type compiledRule struct {
+	Rule
+	message *regexp.Regexp
+	source  *net.IPNet
+	target  *net.IPNet
+}
+
+type Engine struct {
+	mu     sync.Mutex
+	path   string
+	config Config
+	rules  []compiledRule
+	hits   map[string][]time.Time
+}
+
+// DefaultConfig reproduces the filtering that used to be hardcoded in listenAttack
+func DefaultConfig() Config {
+	return Config{
+		Suppress: []Rule{
+			{ID: "policy-http-ipv4", Detector: "snort", Message: `^POLICY-OTHER HTTP request by IPv4 address attempt$`},
+			{ID: "docker-gateway", SourceCIDR: "172.30.0.1/32"},
+			{ID: "docker-host", SourceCIDR: "172.30.0.2/32"},
+			{ID: "loopback", SourceCIDR: "127.0.0.0/8"},
+		},
+		Thresholds: []Threshold{},
+		Overrides:  []Override{},
+	}
+}
+
+// Load reads the suppression config from path, creating it with defaults if it doesn't exist
+func Load(path string) (*Engine, error) {
+	e := &Engine{
+		path: path,
+		hits: make(map[string][]time.Time),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := e.SetConfig(DefaultConfig()); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read suppression config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse suppression config: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.apply(config); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Config returns a copy of the active configuration
+func (e *Engine) Config() Config {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return Config{
+		Suppress:   append([]Rule{}, e.config.Suppress...),
+		Thresholds: append([]Threshold{}, e.config.Thresholds...),
+		Overrides:  append([]Override{}, e.config.Overrides...),
+	}
+}
+
+// SetConfig validates and activates config, then persists it
+func (e *Engine) SetConfig(config Config) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.apply(config); err != nil {
+		return err
+	}
+	return e.save()
+}
+
+// AddRule adds or replaces (by ID) a suppression rule
+func (e *Engine) AddRule(rule Rule) error {
+	config := e.Config()
+	config.Suppress = replaceByID(config.Suppress, rule, func(r Rule) string { return r.ID })
+	return e.SetConfig(config)
+}
+
+// RemoveRule deletes the suppression rule with the given ID
+func (e *Engine) RemoveRule(id string) error {
+	config := e.Config()
+	config.Suppress = removeByID(config.Suppress, id, func(r Rule) string { return r.ID })
+	return e.SetConfig(config)
+}
+
+// SetThreshold adds or replaces (by ID) a threshold
+func (e *Engine) SetThreshold(threshold Threshold) error {
+	config := e.Config()
+	config.Thresholds = replaceByID(config.Thresholds, threshold, func(t Threshold) string { return t.ID })
+	return e.SetConfig(config)
+}
+
+// RemoveThreshold deletes the threshold with the given ID
+func (e *Engine) RemoveThreshold(id string) error {
+	config := e.Config()
+	config.Thresholds = removeByID(config.Thresholds, id, func(t Threshold) string { return t.ID })
+	return e.SetConfig(config)
+}
+
+// SetOverride forces the action for a signature, an empty action removes the override
+func (e *Engine) SetOverride(signatureID, action string) error {
+	config := e.Config()
+	config.Overrides = removeByID(config.Overrides, signatureID, func(o Override) string { return o.SignatureID })
+	if action != "" {
+		config.Overrides = append(config.Overrides, Override{SignatureID: signatureID, Action: action})
+	}
+	return e.SetConfig(config)
+}
+
+// Evaluate decides whether a detection is suppressed and which action override applies
+func (e *Engine) Evaluate(d model.Detection) Decision {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rule := range e.rules {
+		if rule.matches(d) {
+			return Decision{Suppressed: true, Reason: "suppressed by " + rule.ID}
+		}
+	}
+
+	now := time.Now()
+	for _, threshold := range e.config.Thresholds {
+		if !matchField(threshold.Detector, d.Detector()) || !d.MatchesSignature(threshold.SignatureID) {
+			continue
+		}
+
+		tracked := d.AttackerIP
+		if threshold.Track == TrackByDst {
+			tracked = d.TargetIP
+		}
+		key := threshold.ID + "|" + tracked
+		window := time.Duration(threshold.Seconds) * time.Second
+
+		hits := e.hits[key][:0]
+		for _, ts := range e.hits[key] {
+			if now.Sub(ts) < window {
+				hits = append(hits, ts)
+			}
+		}
+		hits = append(hits, now)
+		e.hits[key] = hits
+		e.pruneHits(now)
+
+		if len(hits) < threshold.Count {
+			return Decision{
+				Suppressed: true,
+				Reason:     fmt.Sprintf("threshold %s: %d/%d hits in %ds", threshold.ID, len(hits), threshold.Count, threshold.Seconds),
+			}
+		}
+	}
+
+	for _, override := range e.config.Overrides {
+		if d.MatchesSignature(override.SignatureID) {
+			return Decision{Action: override.Action}
+		}
+	}
+
+	return Decision{}
+}
+
+// pruneHits forgets sources whose hits have all aged out of the longest window
+func (e *Engine) pruneHits(now time.Time) {
+	if len(e.hits) < maxTrackedKeys {
+		return
+	}
+
+	var longest time.Duration
+	for _, threshold := range e.config.Thresholds {
+		longest = max(longest, time.Duration(threshold.Seconds)*time.Second)
+	}
+	for key, hits := range e.hits {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) >= longest {
+			delete(e.hits, key)
+		}
+	}
+}
+
+func (e *Engine) apply(config Config) error {
+	var rules []compiledRule
+	for _, rule := range config.Suppress {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, compiled)
+	}
+
+	for _, threshold := range config.Thresholds {
+		if threshold.ID == "" || threshold.Count < 1 || threshold.Seconds < 1 {
+			return fmt.Errorf("invalid threshold %q: id, count and seconds are required", threshold.ID)
+		}
+		if threshold.Track != TrackBySrc && threshold.Track != TrackByDst {
+			return fmt.Errorf("invalid threshold %q: unknown track %q", threshold.ID, threshold.Track)
+		}
+	}
+
+	for _, override := range config.Overrides {
+		if override.SignatureID == "" {
+			return fmt.Errorf("override without signature id")
+		}
+		if override.Action != ActionAlert && override.Action != ActionBlock {
+			return fmt.Errorf("invalid action %q for signature %s", override.Action, override.SignatureID)
+		}
+	}
+
+	if config.Suppress == nil {
+		config.Suppress = []Rule{}
+	}
+	if config.Thresholds == nil {
+		config.Thresholds = []Threshold{}
+	}
+	if config.Overrides == nil {
+		config.Overrides = []Override{}
+	}
+
+	e.config = config
+	e.rules = rules
+	e.hits = make(map[string][]time.Time)
+	return nil
+}
+
+func (e *Engine) save() error {
+	if e.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(e.config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(e.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write suppression config: %w", err)
+	}
+	return nil
+}
+
+func compileRule(rule Rule) (compiledRule, error) {
+	compiled := compiledRule{Rule: rule}
+
+	if rule.ID == "" {
+		return compiled, fmt.Errorf("suppression rule without id")
+	}
+	if rule.SignatureID == "" && rule.Message == "" && rule.SourceCIDR == "" && rule.TargetCIDR == "" && rule.Detector == "" {
+		return compiled, fmt.Errorf("suppression rule %q matches everything", rule.ID)
+	}
+
+	var err error
+	if rule.Message != "" {
+		if compiled.message, err = regexp.Compile(rule.Message); err != nil {
+			return compiled, fmt.Errorf("suppression rule %q: invalid message pattern: %w", rule.ID, err)
+		}
+	}
+	if rule.SourceCIDR != "" {
+		if _, compiled.source, err = net.ParseCIDR(rule.SourceCIDR); err != nil {
+			return compiled, fmt.Errorf("suppression rule %q: %w", rule.ID, err)
+		}
+	}
+	if rule.TargetCIDR != "" {
+		if _, compiled.target, err = net.ParseCIDR(rule.TargetCIDR); err != nil {
+			return compiled, fmt.Errorf("suppression rule %q: %w", rule.ID, err)
+		}
+	}
+
+	return compiled, nil
+}
+
+func (r compiledRule) matches(d model.Detection) bool {
+	if !matchField(r.Detector, d.Detector()) || !d.MatchesSignature(r.SignatureID) {
+		return false
+	}
+	if r.message != nil && !r.message.MatchString(d.Message) {
+		return false
+	}
+	if r.source != nil && !containsIP(r.source, d.AttackerIP) {
+		return false
+	}
+	if r.target != nil && !containsIP(r.target, d.TargetIP) {
+		return false
+	}
+	return true
+}
+
+func matchField(want, got string) bool {
+	return want == "" || want == got
+}
+
+func containsIP(network *net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && network.Contains(parsed)
+}
+
+func replaceByID[T any](items []T, item T, id func(T) string) []T {
+	items = removeByID(items, id(item), id)
+	return append(items, item)
+}
+
+func removeByID[T any](items []T, key string, id func(T) string) []T {
+	kept := items[:0]
+	for _, it := range items {
+		if id(it) != key {
+			kept = append(kept, it)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,181 @@
+package suppress
+
+import (
+	"main/model"
+	"testing"
+	"time"
+)
+
+func newEngine(t *testing.T, config Config) *Engine {
+	t.Helper()
+	e := &Engine{hits: make(map[string][]time.Time)}
+	if err := e.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func snort(signatureID, attacker, target string) model.Detection {
+	return model.Detection{
+		Method:      model.MethodRule,
+		Protocol:    "TCP",
+		AttackerIP:  attacker,
+		TargetIP:    target,
+		Message:     "SERVER-WEBAPP probe",
+		SignatureID: signatureID,
+		Severity:    model.SeverityMedium,
+	}
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		d    model.Detection
+		want bool
+	}{
+		{"gid:sid", Rule{ID: "r", SignatureID: "1:2000"}, snort("1:2000", "192.0.2.1", "10.0.0.1"), true},
+		{"gid:sid of another gid", Rule{ID: "r", SignatureID: "1:2000"}, snort("3:2000", "192.0.2.1", "10.0.0.1"), false},
+		{"bare sid of any gid", Rule{ID: "r", SignatureID: "2000"}, snort("3:2000", "192.0.2.1", "10.0.0.1"), true},
+		{"bare sid without signature", Rule{ID: "r", SignatureID: "2000"}, snort("", "192.0.2.1", "10.0.0.1"), false},
+		{"message", Rule{ID: "r", Message: "^SERVER-WEBAPP"}, snort("1:1", "192.0.2.1", "10.0.0.1"), true},
+		{"other message", Rule{ID: "r", Message: "^POLICY"}, snort("1:1", "192.0.2.1", "10.0.0.1"), false},
+		{"source", Rule{ID: "r", SourceCIDR: "192.0.2.0/24"}, snort("1:1", "192.0.2.1", "10.0.0.1"), true},
+		{"other source", Rule{ID: "r", SourceCIDR: "198.51.100.0/24"}, snort("1:1", "192.0.2.1", "10.0.0.1"), false},
+		{"target", Rule{ID: "r", TargetCIDR: "10.0.0.0/8"}, snort("1:1", "192.0.2.1", "10.0.0.1"), true},
+		{"no target", Rule{ID: "r", TargetCIDR: "10.0.0.0/8"}, snort("1:1", "192.0.2.1", ""), false},
+		{"detector", Rule{ID: "r", Detector: "snort"}, snort("1:1", "192.0.2.1", "10.0.0.1"), true},
+		{"other detector", Rule{ID: "r", Detector: "native"}, snort("1:1", "192.0.2.1", "10.0.0.1"), false},
+		{"all fields", Rule{ID: "r", SignatureID: "1", Detector: "snort", SourceCIDR: "192.0.2.1/32"}, snort("1:1", "192.0.2.1", "10.0.0.1"), true},
+		{"one field off", Rule{ID: "r", SignatureID: "1", Detector: "snort", SourceCIDR: "192.0.2.2/32"}, snort("1:1", "192.0.2.1", "10.0.0.1"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEngine(t, Config{Suppress: []Rule{tt.rule}})
+			if got := e.Evaluate(tt.d).Suppressed; got != tt.want {
+				t.Errorf("suppressed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	bySrc := Threshold{ID: "t", SignatureID: "1:2000", Count: 3, Seconds: 60, Track: TrackBySrc}
+	byDst := bySrc
+	byDst.Track = TrackByDst
+
+	tests := []struct {
+		name       string
+		threshold  Threshold
+		detections []model.Detection
+		want       []bool // suppressed, per detection
+	}{
+		{
+			name:       "passes from the count on",
+			threshold:  bySrc,
+			detections: []model.Detection{snort("1:2000", "192.0.2.1", "10.0.0.1"), snort("1:2000", "192.0.2.1", "10.0.0.1"), snort("1:2000", "192.0.2.1", "10.0.0.1"), snort("1:2000", "192.0.2.1", "10.0.0.1")},
+			want:       []bool{true, true, false, false},
+		},
+		{
+			name:       "sources counted apart",
+			threshold:  bySrc,
+			detections: []model.Detection{snort("1:2000", "192.0.2.1", "10.0.0.1"), snort("1:2000", "192.0.2.2", "10.0.0.1"), snort("1:2000", "192.0.2.3", "10.0.0.1")},
+			want:       []bool{true, true, true},
+		},
+		{
+			name:       "targets counted together by_dst",
+			threshold:  byDst,
+			detections: []model.Detection{snort("1:2000", "192.0.2.1", "10.0.0.1"), snort("1:2000", "192.0.2.2", "10.0.0.1"), snort("1:2000", "192.0.2.3", "10.0.0.1")},
+			want:       []bool{true, true, false},
+		},
+		{
+			name:       "other signatures untouched",
+			threshold:  bySrc,
+			detections: []model.Detection{snort("1:2001", "192.0.2.1", "10.0.0.1")},
+			want:       []bool{false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEngine(t, Config{Thresholds: []Threshold{tt.threshold}})
+			for i, d := range tt.detections {
+				if got := e.Evaluate(d).Suppressed; got != tt.want[i] {
+					t.Errorf("detection %d: suppressed = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestThresholdWindow(t *testing.T) {
+	e := newEngine(t, Config{Thresholds: []Threshold{{ID: "t", Count: 2, Seconds: 60, Track: TrackBySrc}}})
+	d := snort("1:2000", "192.0.2.1", "10.0.0.1")
+
+	if !e.Evaluate(d).Suppressed {
+		t.Fatal("first hit passed")
+	}
+	// Age the first hit out of the window
+	e.hits["t|192.0.2.1"][0] = time.Now().Add(-61 * time.Second)
+	if !e.Evaluate(d).Suppressed {
+		t.Error("hit outside the window was counted")
+	}
+	if e.Evaluate(d).Suppressed {
+		t.Error("second hit within the window was suppressed")
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	config := Config{
+		Thresholds: []Threshold{{ID: "t", SignatureID: "1:3000", Count: 2, Seconds: 60, Track: TrackBySrc}},
+		Overrides: []Override{
+			{SignatureID: "1:2000", Action: ActionAlert},
+			{SignatureID: "2001", Action: ActionBlock},
+			{SignatureID: "1:3000", Action: ActionBlock},
+		},
+	}
+	tests := []struct {
+		name string
+		d    model.Detection
+		want Decision
+	}{
+		{"alert", snort("1:2000", "192.0.2.1", "10.0.0.1"), Decision{Action: ActionAlert}},
+		{"block by bare sid", snort("3:2001", "192.0.2.1", "10.0.0.1"), Decision{Action: ActionBlock}},
+		{"no override", snort("1:2002", "192.0.2.1", "10.0.0.1"), Decision{}},
+		{"threshold first", snort("1:3000", "192.0.2.1", "10.0.0.1"), Decision{Suppressed: true, Reason: "threshold t: 1/2 hits in 60s"}},
+	}
+	e := newEngine(t, config)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.Evaluate(tt.d); got != tt.want {
+				t.Errorf("decision = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if got := e.Evaluate(snort("1:3000", "192.0.2.1", "10.0.0.1")); got != (Decision{Action: ActionBlock}) {
+		t.Errorf("decision past the threshold = %+v, want the override", got)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"rule without id", Config{Suppress: []Rule{{SignatureID: "1"}}}},
+		{"rule matching everything", Config{Suppress: []Rule{{ID: "r"}}}},
+		{"bad message pattern", Config{Suppress: []Rule{{ID: "r", Message: "("}}}},
+		{"bad cidr", Config{Suppress: []Rule{{ID: "r", SourceCIDR: "192.0.2.1"}}}},
+		{"threshold without count", Config{Thresholds: []Threshold{{ID: "t", Seconds: 60, Track: TrackBySrc}}}},
+		{"threshold with unknown track", Config{Thresholds: []Threshold{{ID: "t", Count: 1, Seconds: 60, Track: "by_port"}}}},
+		{"override without signature", Config{Overrides: []Override{{Action: ActionAlert}}}},
+		{"override with unknown action", Config{Overrides: []Override{{SignatureID: "1", Action: "drop"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Engine{}
+			if err := e.SetConfig(tt.config); err == nil {
+				t.Error("config accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DeadLetter is a delivery that failed every attempt
+type DeadLetter struct {
+	ID       string    `json:"id"`
+	Endpoint string    `json:"endpoint"`
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Body     string    `json:"body"`
+}
+
+// deadLetterQueue persists dead letters as JSON lines so they survive restarts
+type deadLetterQueue struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (q *deadLetterQueue) add(letters ...DeadLetter) error {
+	if q.path == "" || len(letters) == 0 {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		return fmt.Errorf("failed to create dead-letter directory: %w", err)
+	}
+	file, err := os.OpenFile(q.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter queue: %w", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, letter := range letters {
+		if err := encoder.Encode(letter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *deadLetterQueue) list() ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.read()
+}
+
+// take returns every dead letter and empties the queue
+func (q *deadLetterQueue) take() ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letters, err := q.read()
+	if err != nil || len(letters) == 0 {
+		return letters, err
+	}
+	if err := os.Truncate(q.path, 0); err != nil {
+		return nil, fmt.Errorf("failed to empty dead-letter queue: %w", err)
+	}
+	return letters, nil
+}
+
+func (q *deadLetterQueue) read() ([]DeadLetter, error) {
+	letters := []DeadLetter{}
+	if q.path == "" {
+		return letters, nil
+	}
+
+	file, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return letters, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead-letter queue: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var letter DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			logger.Warn("skipping corrupt dead letter", "path", q.path, "error", err)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters, scanner.Err()
+}
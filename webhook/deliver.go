@@ -0,0 +1,233 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"main/logging"
+	"main/metrics"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+const maxBackoff = 5 * time.Minute
+
+// backoffUnit is what Backoff counts, shortened by the tests
+var backoffUnit = time.Second
+
+// Result is how an endpoint answered one request
+type Result struct {
+	Status   int    `json:"status,omitempty"`
+	Body     string `json:"body,omitempty"` // the first KiB of the response
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+func (r Result) ok() bool {
+	return r.Error == "" && r.Status >= 200 && r.Status < 300
+}
+
+// retryable reports whether another attempt may succeed: network errors, 429 and 5xx
+func (r Result) retryable() bool {
+	return r.Status == 0 || r.Status == http.StatusTooManyRequests || r.Status >= 500
+}
+
+func (r Result) String() string {
+	if r.Error != "" {
+		return r.Error
+	}
+	return fmt.Sprintf("HTTP %d", r.Status)
+}
+
+type delivery struct {
+	id    string
+	event string
+	body  []byte
+}
+
+// endpoint delivers its queue one request at a time, in order
+type endpoint struct {
+	config     Endpoint
+	template   *template.Template
+	client     *http.Client
+	queue      chan delivery
+	deadLetter *deadLetterQueue
+	ctx        context.Context
+	cancel     context.CancelFunc
+	dropLog    *slog.Logger // reports a full queue at most every 30 seconds
+}
+
+func newEndpoint(config Endpoint, deadLetter *deadLetterQueue) (*endpoint, error) {
+	tmpl, err := validate(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Method == "" {
+		config.Method = http.MethodPost
+	}
+	if config.ContentType == "" {
+		config.ContentType = "application/json"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = defaultMaxAttempts
+	}
+	if config.Backoff == 0 {
+		config.Backoff = defaultBackoff
+	}
+
+	ep := &endpoint{
+		config:     config,
+		template:   tmpl,
+		client:     &http.Client{Timeout: time.Duration(config.Timeout) * time.Second},
+		queue:      make(chan delivery, queueSize),
+		deadLetter: deadLetter,
+		dropLog:    logging.Limited(logger.With("endpoint", config.Name), 30*time.Second),
+	}
+	ep.ctx, ep.cancel = context.WithCancel(context.Background())
+	return ep, nil
+}
+
+func (e *endpoint) enqueue(p Payload) {
+	body, err := e.render(p)
+	if err != nil {
+		logger.Error("failed to render webhook body", "endpoint", e.config.Name, "error", err)
+		return
+	}
+	e.enqueueBody(body, p.Event)
+}
+
+// enqueueBody queues a rendered body. When the queue is full it goes straight
+// to the dead-letter queue so it can be retried later.
+func (e *endpoint) enqueueBody(body []byte, event string) {
+	d := delivery{id: newID(), event: event, body: body}
+	select {
+	case e.queue <- d:
+	default:
+		metrics.WebhookDeliveries.Inc(e.config.Name, "dropped")
+		e.dropLog.Warn("webhook queue full, moving deliveries to the dead-letter queue")
+		e.bury(d, 0, "queue full")
+	}
+}
+
+func (e *endpoint) run() {
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case d := <-e.queue:
+			e.deliver(d)
+		}
+	}
+}
+
+// deliver tries a delivery up to MaxAttempts times with exponential backoff
+func (e *endpoint) deliver(d delivery) {
+	backoff := time.Duration(e.config.Backoff) * backoffUnit
+
+	var result Result
+	attempt := 1
+	for ; ; attempt++ {
+		result = e.sendDelivery(d)
+		if result.ok() {
+			metrics.WebhookDeliveries.Inc(e.config.Name, "delivered")
+			return
+		}
+		if !result.retryable() || attempt >= e.config.MaxAttempts {
+			break
+		}
+
+		metrics.WebhookDeliveries.Inc(e.config.Name, "retried")
+		logger.Debug("webhook delivery failed, retrying", "endpoint", e.config.Name, "delivery", d.id, "attempt", attempt, "retry_in", backoff.String(), "result", result.String())
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+
+	metrics.WebhookDeliveries.Inc(e.config.Name, "failed")
+	logger.Warn("webhook delivery failed", "endpoint", e.config.Name, "delivery", d.id, "attempts", attempt, "result", result.String())
+	e.bury(d, attempt, result.String())
+}
+
+func (e *endpoint) bury(d delivery, attempts int, reason string) {
+	err := e.deadLetter.add(DeadLetter{
+		ID:       d.id,
+		Endpoint: e.config.Name,
+		Event:    d.event,
+		Time:     time.Now(),
+		Attempts: attempts,
+		Error:    reason,
+		Body:     string(d.body),
+	})
+	if err != nil {
+		logger.Error("failed to write dead letter", "endpoint", e.config.Name, "delivery", d.id, "error", err)
+	}
+}
+
+// send makes a single request, e.g. for a test
+func (e *endpoint) send(body []byte, event string) Result {
+	return e.sendDelivery(delivery{id: newID(), event: event, body: body})
+}
+
+func (e *endpoint) sendDelivery(d delivery) Result {
+	start := time.Now()
+	result := func(r Result) Result {
+		r.Duration = time.Since(start).Round(time.Millisecond).String()
+		return r
+	}
+
+	req, err := http.NewRequestWithContext(e.ctx, e.config.Method, e.config.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return result(Result{Error: err.Error()})
+	}
+	req.Header.Set("Content-Type", e.config.ContentType)
+	req.Header.Set("User-Agent", "ips-webhook/1.0")
+	req.Header.Set("X-IPS-Event", d.event)
+	req.Header.Set("X-IPS-Delivery", d.id)
+	if e.config.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-IPS-Timestamp", timestamp)
+		req.Header.Set("X-IPS-Signature", "sha256="+sign(e.config.Secret, timestamp, d.body))
+	}
+	for key, value := range e.config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return result(Result{Error: err.Error()})
+	}
+	defer resp.Body.Close()
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	io.Copy(io.Discard, resp.Body)
+	return result(Result{Status: resp.StatusCode, Body: string(snippet)})
+}
+
+// sign is the hex HMAC-SHA256 of "timestamp.body", which receivers recompute
+// with the shared secret; the timestamp lets them reject replays
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
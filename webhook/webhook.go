@@ -0,0 +1,376 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/engine"
+	"main/iptables"
+	"main/logging"
+	"main/model"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Defaults for endpoints that leave the setting out
+const (
+	defaultTimeout     = 10 // seconds
+	defaultMaxAttempts = 5
+	defaultBackoff     = 2 // seconds before the first retry, doubled on each further one
+	queueSize          = 256
+)
+
+var logger = logging.For("webhook")
+
+var ErrUnknownEndpoint = errors.New("unknown webhook endpoint")
+
+// Endpoint receives the events that pass its filters as HTTP requests
+type Endpoint struct {
+	Name        string            `json:"name"`
+	Enabled     bool              `json:"enabled"`
+	URL         string            `json:"url"`
+	Method      string            `json:"method,omitempty"` // POST by default
+	Headers     map[string]string `json:"headers,omitempty"`
+	Events      []string          `json:"events,omitempty"`       // "incident", "detection", "block", "unblocked"; incidents only by default
+	MinSeverity int               `json:"min_severity,omitempty"` // incidents and detections below are not sent
+	Detectors   []string          `json:"detectors,omitempty"`    // "snort", "own", "unswb", "native"; all when empty
+	Template    string            `json:"template,omitempty"`     // text/template for the body, the JSON payload when empty
+	ContentType string            `json:"content_type,omitempty"` // application/json by default
+	Secret      string            `json:"secret,omitempty"`       // signs the body with HMAC-SHA256 when set
+	Timeout     int               `json:"timeout,omitempty"`      // seconds per attempt
+	MaxAttempts int               `json:"max_attempts,omitempty"`
+	Backoff     int               `json:"backoff,omitempty"` // seconds
+}
+
+type Config struct {
+	Endpoints  []Endpoint `json:"endpoints"`
+	DeadLetter string     `json:"dead_letter"` // JSONL file of deliveries that failed every attempt
+}
+
+// Payload is the default JSON body and the data templates are executed with
+type Payload struct {
+	Event     string             `json:"event"`
+	Timestamp time.Time          `json:"timestamp"`
+	Detection *model.Detection   `json:"detection,omitempty"`
+	Incident  *model.Incident    `json:"incident,omitempty"`
+	Change    string             `json:"change,omitempty"` // "open", "update" or "close" for incidents
+	Block     *iptables.Response `json:"block,omitempty"`
+	Test      bool               `json:"test,omitempty"`
+}
+
+// DefaultConfig has a disabled chat-ops example
+func DefaultConfig() Config {
+	return Config{
+		Endpoints: []Endpoint{
+			{
+				Name:        "chatops",
+				URL:         "http://127.0.0.1:8080/hooks/ips",
+				Events:      []string{engine.EventIncident},
+				MinSeverity: model.SeverityHigh,
+				Template:    `{"text": {{json (printf "%s incident %s from %s: %d detections by %s" (severity .Incident.Severity) .Change .Incident.AttackerIP .Incident.Hits (join .Incident.Detectors ", "))}}}`,
+			},
+		},
+		DeadLetter: "logs/webhooks-dead.jsonl",
+	}
+}
+
+var templateFuncs = template.FuncMap{
+	// json encodes a value, e.g. a message inside a JSON template
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"severity": model.SeverityName,
+	"join":     strings.Join,
+}
+
+// Manager filters engine events and queues them on the matching endpoints
+type Manager struct {
+	endpoints  map[string]*endpoint
+	order      []string
+	deadLetter *deadLetterQueue
+}
+
+// Load reads the webhook config from path, creating it with defaults if it
+// doesn't exist, and starts the delivery workers of the enabled endpoints
+func Load(path string) (*Manager, error) {
+	config := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if err := save(path, config); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to read webhook config: %w", err)
+	default:
+		config = Config{}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse webhook config: %w", err)
+		}
+	}
+
+	return New(config)
+}
+
+// New validates config and starts the delivery workers of the enabled endpoints
+func New(config Config) (*Manager, error) {
+	m := &Manager{
+		endpoints:  make(map[string]*endpoint),
+		deadLetter: &deadLetterQueue{path: config.DeadLetter},
+	}
+
+	for _, e := range config.Endpoints {
+		if e.Name == "" {
+			return nil, fmt.Errorf("webhook endpoint without a name")
+		}
+		if _, ok := m.endpoints[e.Name]; ok {
+			return nil, fmt.Errorf("duplicate webhook endpoint %q", e.Name)
+		}
+		ep, err := newEndpoint(e, m.deadLetter)
+		if err != nil {
+			return nil, fmt.Errorf("webhook endpoint %q: %w", e.Name, err)
+		}
+		m.endpoints[e.Name] = ep
+		m.order = append(m.order, e.Name)
+	}
+
+	for _, name := range m.order {
+		if ep := m.endpoints[name]; ep.config.Enabled {
+			go ep.run()
+		}
+	}
+	return m, nil
+}
+
+// Run dispatches engine events until events is closed
+func (m *Manager) Run(events <-chan engine.Event) {
+	for event := range events {
+		m.Dispatch(event)
+	}
+}
+
+// Dispatch queues an event on every enabled endpoint whose filters it passes
+func (m *Manager) Dispatch(event engine.Event) {
+	payload, ok := newPayload(event)
+	if !ok {
+		return
+	}
+	for _, name := range m.order {
+		ep := m.endpoints[name]
+		if ep.config.Enabled && ep.matches(payload) {
+			ep.enqueue(payload)
+		}
+	}
+}
+
+// Endpoints returns the configured endpoints, secrets redacted
+func (m *Manager) Endpoints() []Endpoint {
+	endpoints := make([]Endpoint, 0, len(m.order))
+	for _, name := range m.order {
+		e := m.endpoints[name].config
+		if e.Secret != "" {
+			e.Secret = "redacted"
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints
+}
+
+// Test sends a sample incident to an endpoint once, bypassing its filters and
+// the dead-letter queue, and reports how the endpoint answered
+func (m *Manager) Test(name string) (Result, error) {
+	ep, ok := m.endpoints[name]
+	if !ok {
+		return Result{}, fmt.Errorf("%w %q", ErrUnknownEndpoint, name)
+	}
+
+	now := time.Now()
+	detection := model.Detection{
+		Method:     model.MethodRule,
+		Protocol:   "TCP",
+		AttackerIP: "192.0.2.1",
+		TargetIP:   "198.51.100.1",
+		TargetPort: "80",
+		Message:    "IPS webhook test",
+		Severity:   model.SeverityHigh,
+		Timestamp:  now,
+	}
+	payload := Payload{
+		Event:     engine.EventIncident,
+		Timestamp: now,
+		Incident: &model.Incident{
+			ID:          "test",
+			AttackerIP:  detection.AttackerIP,
+			TargetIP:    detection.TargetIP,
+			TargetPorts: []string{detection.TargetPort},
+			Protocols:   []string{detection.Protocol},
+			Detectors:   []string{detection.Detector()},
+			Severity:    detection.Severity,
+			Status:      "open",
+			Hits:        1,
+			FirstSeen:   now,
+			LastSeen:    now,
+			Evidence:    []model.Evidence{{Detection: detection, Count: 1, FirstSeen: now, LastSeen: now}},
+		},
+		Change: model.IncidentOpen,
+		Test:   true,
+	}
+	body, err := ep.render(payload)
+	if err != nil {
+		return Result{}, err
+	}
+	return ep.send(body, payload.Event), nil
+}
+
+// DeadLetters returns the deliveries that failed every attempt
+func (m *Manager) DeadLetters() ([]DeadLetter, error) {
+	return m.deadLetter.list()
+}
+
+// RetryDeadLetters queues every dead letter on its endpoint again and returns
+// how many were queued. Letters of endpoints that no longer exist or are
+// disabled stay in the queue.
+func (m *Manager) RetryDeadLetters() (int, error) {
+	letters, err := m.deadLetter.take()
+	if err != nil {
+		return 0, err
+	}
+
+	var kept []DeadLetter
+	queued := 0
+	for _, letter := range letters {
+		ep, ok := m.endpoints[letter.Endpoint]
+		if !ok || !ep.config.Enabled {
+			kept = append(kept, letter)
+			continue
+		}
+		ep.enqueueBody([]byte(letter.Body), letter.Event)
+		queued++
+	}
+	if err := m.deadLetter.add(kept...); err != nil {
+		return queued, err
+	}
+	return queued, nil
+}
+
+// Close stops the delivery workers, discarding what they have queued
+func (m *Manager) Close() {
+	for _, ep := range m.endpoints {
+		ep.cancel()
+	}
+}
+
+func newPayload(event engine.Event) (Payload, bool) {
+	payload := Payload{Event: event.Type, Timestamp: time.Now()}
+	switch data := event.Data.(type) {
+	case model.Detection:
+		payload.Detection = &data
+		if !data.Timestamp.IsZero() {
+			payload.Timestamp = data.Timestamp
+		}
+	case model.IncidentEvent:
+		payload.Incident = &data.Incident
+		payload.Change = data.Type
+		payload.Timestamp = data.Incident.LastSeen
+	case iptables.Response:
+		payload.Block = &data
+	default:
+		return payload, false
+	}
+	return payload, true
+}
+
+func (e *endpoint) matches(p Payload) bool {
+	events := e.config.Events
+	if len(events) == 0 {
+		events = []string{engine.EventIncident}
+	}
+	if !slices.Contains(events, p.Event) {
+		return false
+	}
+
+	var severity int
+	var detectors []string
+	switch {
+	case p.Incident != nil:
+		severity, detectors = p.Incident.Severity, p.Incident.Detectors
+	case p.Detection != nil:
+		severity, detectors = p.Detection.Severity, []string{p.Detection.Detector()}
+	default:
+		return true
+	}
+	if severity < e.config.MinSeverity {
+		return false
+	}
+	return len(e.config.Detectors) == 0 || slices.ContainsFunc(detectors, func(d string) bool {
+		return slices.Contains(e.config.Detectors, d)
+	})
+}
+
+// render executes the endpoint's template, or encodes the payload as JSON
+func (e *endpoint) render(p Payload) ([]byte, error) {
+	if e.template == nil {
+		return json.Marshal(p)
+	}
+	// Templates may reference .Detection, .Incident and .Block whichever event it is
+	if p.Detection == nil {
+		p.Detection = &model.Detection{}
+	}
+	if p.Incident == nil {
+		p.Incident = &model.Incident{}
+	}
+	if p.Block == nil {
+		p.Block = &iptables.Response{}
+	}
+
+	var body bytes.Buffer
+	if err := e.template.Execute(&body, p); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return body.Bytes(), nil
+}
+
+func validate(e Endpoint) (*template.Template, error) {
+	if !strings.HasPrefix(e.URL, "http://") && !strings.HasPrefix(e.URL, "https://") {
+		return nil, fmt.Errorf("url must be http or https")
+	}
+	for _, event := range e.Events {
+		switch event {
+		case engine.EventIncident, engine.EventDetection, engine.EventBlock, engine.EventUnblock:
+		default:
+			return nil, fmt.Errorf("unsupported event %q", event)
+		}
+	}
+	if e.Timeout < 0 || e.MaxAttempts < 0 || e.Backoff < 0 {
+		return nil, fmt.Errorf("timeout, max_attempts and backoff must not be negative")
+	}
+	if e.Template == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(e.Name).Funcs(templateFuncs).Parse(e.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return tmpl, nil
+}
+
+func save(path string, config Config) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write webhook config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package webhook
+
+import (
+	"io"
+	"main/engine"
+	"main/metrics"
+	"main/model"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a stand-in receiver that answers with the statuses given, then 200
+type recorder struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	times    []time.Time
+	received chan struct{}
+}
+
+func newRecorder(statuses ...int) (*recorder, *httptest.Server) {
+	rec := &recorder{statuses: statuses, received: make(chan struct{}, 64)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rec.mu.Lock()
+		status := http.StatusOK
+		if n := len(rec.requests); n < len(rec.statuses) {
+			status = rec.statuses[n]
+		}
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, body)
+		rec.times = append(rec.times, time.Now())
+		rec.mu.Unlock()
+
+		w.WriteHeader(status)
+		rec.received <- struct{}{}
+	}))
+	return rec, server
+}
+
+// wait blocks until the receiver got n requests
+func (rec *recorder) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-rec.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d requests", i, n)
+		}
+	}
+}
+
+// deliveries is how many deliveries of endpoint ended with result so far
+func deliveries(endpoint, result string) float64 {
+	for _, s := range metrics.WebhookDeliveries.Samples() {
+		if s.Labels[0] == endpoint && s.Labels[1] == result {
+			return s.Value
+		}
+	}
+	return 0
+}
+
+// eventually polls until done returns true
+func eventually(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if done() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func newManager(t *testing.T, endpoint Endpoint) (*Manager, string) {
+	t.Helper()
+	deadLetter := filepath.Join(t.TempDir(), "dead.jsonl")
+	m, err := New(Config{Endpoints: []Endpoint{endpoint}, DeadLetter: deadLetter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	return m, deadLetter
+}
+
+func detection() model.Detection {
+	return model.Detection{
+		Method:     model.MethodNative,
+		Protocol:   "TCP",
+		AttackerIP: "192.0.2.7",
+		TargetIP:   "198.51.100.1",
+		TargetPort: "22",
+		Message:    "SSH brute force",
+		Severity:   model.SeverityHigh,
+	}
+}
+
+func incident() engine.Event {
+	d := detection()
+	return engine.Event{Type: engine.EventIncident, Data: model.IncidentEvent{
+		Type: model.IncidentOpen,
+		Incident: model.Incident{
+			ID:         "inc-1",
+			AttackerIP: d.AttackerIP,
+			TargetIP:   d.TargetIP,
+			Detectors:  []string{d.Detector()},
+			Severity:   d.Severity,
+			Status:     "open",
+			Hits:       3,
+			Evidence:   []model.Evidence{{Detection: d, Count: 3}},
+		},
+	}}
+}
+
+func TestTemplateBody(t *testing.T) {
+	rec, server := newRecorder()
+	defer server.Close()
+
+	m, _ := newManager(t, Endpoint{
+		Name:     "chat",
+		Enabled:  true,
+		URL:      server.URL,
+		Template: `{"text": {{json (printf "%s incident %s from %s: %d detections by %s" (severity .Incident.Severity) .Change .Incident.AttackerIP .Incident.Hits (join .Incident.Detectors ", "))}}}`,
+	})
+	m.Dispatch(incident())
+	rec.wait(t, 1)
+
+	want := `{"text": "high incident open from 192.0.2.7: 3 detections by native"}`
+	if got := string(rec.bodies[0]); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+	if got := rec.requests[0].Header.Get("X-IPS-Event"); got != engine.EventIncident {
+		t.Errorf("X-IPS-Event = %q, want %q", got, engine.EventIncident)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	low := incident()
+	data := low.Data.(model.IncidentEvent)
+	data.Incident.Severity = model.SeverityLow
+	low.Data = data
+
+	tests := []struct {
+		name     string
+		endpoint Endpoint
+		event    engine.Event
+		want     bool
+	}{
+		{"incidents by default", Endpoint{}, incident(), true},
+		{"no detections by default", Endpoint{}, engine.Event{Type: engine.EventDetection, Data: detection()}, false},
+		{"detections when listed", Endpoint{Events: []string{engine.EventDetection}}, engine.Event{Type: engine.EventDetection, Data: detection()}, true},
+		{"below min severity", Endpoint{MinSeverity: model.SeverityMedium}, low, false},
+		{"detector of the incident", Endpoint{Detectors: []string{"snort", "native"}}, incident(), true},
+		{"other detectors", Endpoint{Detectors: []string{"snort"}}, incident(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, ok := newPayload(tt.event)
+			if !ok {
+				t.Fatal("no payload")
+			}
+			e := &endpoint{config: tt.endpoint}
+			if got := e.matches(payload); got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignature(t *testing.T) {
+	rec, server := newRecorder()
+	defer server.Close()
+
+	const secret = "s3cret"
+	m, _ := newManager(t, Endpoint{Name: "signed", Enabled: true, URL: server.URL, Secret: secret})
+	m.Dispatch(incident())
+	rec.wait(t, 1)
+
+	header := rec.requests[0].Header
+	timestamp := header.Get("X-IPS-Timestamp")
+	if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+		t.Fatalf("X-IPS-Timestamp = %q: %v", timestamp, err)
+	}
+	want := "sha256=" + sign(secret, timestamp, rec.bodies[0])
+	if got := header.Get("X-IPS-Signature"); got != want {
+		t.Errorf("X-IPS-Signature = %q, want %q", got, want)
+	}
+	if got := "sha256=" + sign("wrong", timestamp, rec.bodies[0]); got == header.Get("X-IPS-Signature") {
+		t.Error("signature verifies with the wrong secret")
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	defer func(unit time.Duration) { backoffUnit = unit }(backoffUnit)
+	backoffUnit = 20 * time.Millisecond
+
+	rec, server := newRecorder(http.StatusServiceUnavailable, http.StatusBadGateway)
+	defer server.Close()
+
+	m, deadLetter := newManager(t, Endpoint{Name: "flaky", Enabled: true, URL: server.URL, MaxAttempts: 3, Backoff: 1})
+	delivered := deliveries("flaky", "delivered")
+	m.Dispatch(incident())
+	rec.wait(t, 3)
+	eventually(t, "the delivery", func() bool { return deliveries("flaky", "delivered") > delivered })
+
+	first, second := rec.times[1].Sub(rec.times[0]), rec.times[2].Sub(rec.times[1])
+	if first < backoffUnit || second < 2*backoffUnit {
+		t.Errorf("retried after %s and %s, want at least %s and %s", first, second, backoffUnit, 2*backoffUnit)
+	}
+	if string(rec.bodies[0]) != string(rec.bodies[2]) {
+		t.Error("retry sent a different body")
+	}
+
+	letters, err := (&deadLetterQueue{path: deadLetter}).list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 0 {
+		t.Errorf("delivered event was dead-lettered: %+v", letters)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	defer func(unit time.Duration) { backoffUnit = unit }(backoffUnit)
+	backoffUnit = time.Millisecond
+
+	rec, server := newRecorder(http.StatusInternalServerError, http.StatusInternalServerError)
+	defer server.Close()
+
+	m, _ := newManager(t, Endpoint{Name: "down", Enabled: true, URL: server.URL, MaxAttempts: 2, Backoff: 1})
+	m.Dispatch(incident())
+	rec.wait(t, 2)
+
+	// The letter is written right after the last attempt
+	var letters []DeadLetter
+	eventually(t, "the dead letter", func() bool {
+		var err error
+		if letters, err = m.DeadLetters(); err != nil {
+			t.Fatal(err)
+		}
+		return len(letters) > 0
+	})
+	if len(letters) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(letters))
+	}
+	letter := letters[0]
+	if letter.Endpoint != "down" || letter.Event != engine.EventIncident || letter.Attempts != 2 || letter.Error != "HTTP 500" {
+		t.Errorf("dead letter = %+v", letter)
+	}
+	if letter.Body != string(rec.bodies[0]) {
+		t.Errorf("dead letter body = %s, want %s", letter.Body, rec.bodies[0])
+	}
+}